@@ -0,0 +1,287 @@
+// Administrative commands for the password keeper server.
+//
+//	keeper-admin --config=/path/to/config.json rotate-keys [--batch=100]
+//	keeper-admin --config=/path/to/config.json reseal-records [--batch=100]
+//	keeper-admin --config=/path/to/config.json migrate up|down [--steps=1]|status
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/nglmq/password-keeper/internal/config"
+	"github.com/nglmq/password-keeper/internal/lib/crypt"
+	"github.com/nglmq/password-keeper/internal/lib/logger/sl"
+	"github.com/nglmq/password-keeper/internal/services/auth"
+	"github.com/nglmq/password-keeper/internal/services/rotation"
+	"github.com/nglmq/password-keeper/internal/storage/migrate"
+	postgres "github.com/nglmq/password-keeper/internal/storage/pg"
+	"github.com/nglmq/password-keeper/internal/storage/sqlite"
+)
+
+// database is what the commands need from the storage
+type database interface {
+	rotation.KeyStorage
+	auth.KeyStorage
+	auth.ResealStorage
+	Migrator() *migrate.Migrator
+	Close() error
+}
+
+func main() {
+	cfg := config.MustLoad()
+
+	if !flag.Parsed() {
+		flag.Parse()
+	}
+
+	log := slog.New(
+		slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelInfo}),
+	)
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	var err error
+
+	switch cmd := flag.Arg(0); cmd {
+	case "rotate-keys":
+		err = rotateKeys(ctx, log, cfg, flag.Args()[1:])
+	case "reseal-records":
+		err = resealRecords(ctx, log, cfg, flag.Args()[1:])
+	case "migrate":
+		err = migrateSchema(ctx, log, cfg, flag.Args()[1:])
+	default:
+		err = fmt.Errorf("unknown command %q, available commands: rotate-keys, reseal-records, migrate", cmd)
+	}
+
+	if err != nil {
+		log.Error("command failed", sl.Err(err))
+		os.Exit(1)
+	}
+}
+
+// rotateKeys re-wraps data keys of all users with the current master key and reseals legacy records.
+// Before running it the new key is set as master_key and the old one is moved to previous_master_keys,
+// the old key can be removed from the config once the command reports no failures.
+func rotateKeys(ctx context.Context, log *slog.Logger, cfg *config.Config, args []string) error {
+	fs := flag.NewFlagSet("rotate-keys", flag.ExitOnError)
+	batch := fs.Int("batch", 100, "number of keys and records read from the storage at once")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	masterKey, err := crypt.ParseKey(cfg.MasterKey)
+	if err != nil {
+		return fmt.Errorf("failed to parse master key: %w", err)
+	}
+
+	previousKeys, err := crypt.ParseKeys(cfg.PreviousMasterKeys)
+	if err != nil {
+		return fmt.Errorf("failed to parse previous master keys: %w", err)
+	}
+
+	legacy, err := legacyCrypt(cfg)
+	if err != nil {
+		return err
+	}
+
+	storage, err := openDatabase(cfg.DBConnection)
+	if err != nil {
+		return fmt.Errorf("failed to create storage: %w", err)
+	}
+	defer storage.Close()
+
+	if _, err := storage.Migrator().Up(ctx); err != nil {
+		return fmt.Errorf("failed to migrate database: %w", err)
+	}
+
+	rotator, err := rotation.New(log, masterKey, previousKeys, storage, *batch)
+	if err != nil {
+		return err
+	}
+
+	res, err := rotator.Run(ctx)
+	if err != nil {
+		return err
+	}
+
+	log.Info("keys rotated", slog.Int("rewrapped", res.Rewrapped), slog.Int("failed", res.Failed))
+
+	if res.Failed > 0 {
+		return fmt.Errorf("%d keys were not rotated, keep previous master keys and run the command again", res.Failed)
+	}
+
+	// legacy records are sealed with the rotated keys as well, so nothing written before binding outlives the rotation
+	if err := reseal(ctx, log, masterKey, previousKeys, legacy, storage, *batch); err != nil {
+		return fmt.Errorf("keys rotated, but legacy records were not resealed: %w", err)
+	}
+
+	return nil
+}
+
+// resealRecords seals records written before they were bound to their owner, ID and type again,
+// the server does not return such records until they are resealed
+func resealRecords(ctx context.Context, log *slog.Logger, cfg *config.Config, args []string) error {
+	fs := flag.NewFlagSet("reseal-records", flag.ExitOnError)
+	batch := fs.Int("batch", 100, "number of records read from the storage at once")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	masterKey, err := crypt.ParseKey(cfg.MasterKey)
+	if err != nil {
+		return fmt.Errorf("failed to parse master key: %w", err)
+	}
+
+	previousKeys, err := crypt.ParseKeys(cfg.PreviousMasterKeys)
+	if err != nil {
+		return fmt.Errorf("failed to parse previous master keys: %w", err)
+	}
+
+	legacy, err := legacyCrypt(cfg)
+	if err != nil {
+		return err
+	}
+
+	storage, err := openDatabase(cfg.DBConnection)
+	if err != nil {
+		return fmt.Errorf("failed to create storage: %w", err)
+	}
+	defer storage.Close()
+
+	if _, err := storage.Migrator().Up(ctx); err != nil {
+		return fmt.Errorf("failed to migrate database: %w", err)
+	}
+
+	return reseal(ctx, log, masterKey, previousKeys, legacy, storage, *batch)
+}
+
+// reseal seals legacy records again with data keys of their owners
+func reseal(
+	ctx context.Context,
+	log *slog.Logger,
+	masterKey []byte,
+	previousKeys [][]byte,
+	legacy crypt.Crypter,
+	storage database,
+	batch int,
+) error {
+	keys, err := auth.NewKeys(masterKey, previousKeys, storage)
+	if err != nil {
+		return err
+	}
+
+	res, err := auth.NewResealer(log, keys, storage, legacy, batch).Run(ctx)
+	if err != nil {
+		return err
+	}
+
+	log.Info("records resealed", slog.Int("resealed", res.Resealed), slog.Int("failed", res.Failed))
+
+	if res.Failed > 0 {
+		return fmt.Errorf("%d records were not resealed and are not returned to their owners", res.Failed)
+	}
+
+	return nil
+}
+
+// legacyCrypt returns crypt with the key records were sealed with before per-user keys,
+// it is nil when the key is not set in the config since there are no such records
+func legacyCrypt(cfg *config.Config) (crypt.Crypter, error) {
+	if cfg.LegacyDataKey == "" {
+		return nil, nil
+	}
+
+	key, err := crypt.ParseKey(cfg.LegacyDataKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse legacy data key: %w", err)
+	}
+
+	cr, err := crypt.NewCrypt(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create legacy crypt: %w", err)
+	}
+
+	return cr, nil
+}
+
+// migrateSchema applies pending migrations (up), reverts the last ones (down) or prints the status of all migrations.
+// The server applies pending migrations on start as well, down is run with the servers stopped.
+func migrateSchema(ctx context.Context, log *slog.Logger, cfg *config.Config, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("migrate needs a subcommand: up, down or status")
+	}
+
+	fs := flag.NewFlagSet("migrate "+args[0], flag.ExitOnError)
+	steps := fs.Int("steps", 1, "number of migrations to revert")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	storage, err := openDatabase(cfg.DBConnection)
+	if err != nil {
+		return fmt.Errorf("failed to create storage: %w", err)
+	}
+	defer storage.Close()
+
+	migrator := storage.Migrator()
+
+	switch args[0] {
+	case "up":
+		applied, err := migrator.Up(ctx)
+		if err != nil {
+			return err
+		}
+
+		log.Info("migrations applied", slog.Int("applied", applied), slog.Int("version", migrator.Latest()))
+	case "down":
+		if *steps < 1 {
+			return fmt.Errorf("steps must be positive")
+		}
+
+		reverted, err := migrator.Down(ctx, *steps)
+		if err != nil {
+			return err
+		}
+
+		log.Info("migrations reverted", slog.Int("reverted", reverted))
+	case "status":
+		statuses, err := migrator.Status(ctx)
+		if err != nil {
+			return err
+		}
+
+		for _, s := range statuses {
+			state := "pending"
+			if s.Applied() {
+				state = "applied at " + s.AppliedAt.Format(time.RFC3339)
+			}
+
+			if s.Unknown {
+				state += " (unknown, applied by a newer server)"
+			}
+
+			fmt.Printf("%04d_%s\t%s\n", s.Version, s.Name, state)
+		}
+	default:
+		return fmt.Errorf("unknown migrate subcommand %q, available: up, down, status", args[0])
+	}
+
+	return nil
+}
+
+// openDatabase opens the SQLite or Postgres database of the DSN without applying migrations
+func openDatabase(dsn string) (database, error) {
+	if sqlite.IsDSN(dsn) {
+		return sqlite.Open(dsn)
+	}
+
+	return postgres.Open(dsn)
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"github.com/nglmq/password-keeper/internal/app/tui"
 	api "github.com/nglmq/password-keeper/internal/clients/sso"
+	"github.com/nglmq/password-keeper/internal/lib/logger/sl"
 	"log/slog"
 	"os"
 	"os/signal"
@@ -23,22 +24,28 @@ func main() {
 		slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelInfo}),
 	)
 
-	appl := app.New(log, cfg.DBConnection, cfg.Port)
+	appl := app.New(log, cfg)
 
 	go func() {
 		if err := appl.GRPCServer.Run(); err != nil {
-			log.Error("Failed to run grpc server: ", err)
+			log.Error("Failed to run grpc server", sl.Err(err))
 		}
 	}()
 
-	apiClient, err := api.New(log, fmt.Sprintf("localhost:%d", cfg.Port))
+	tlsConfig, err := api.TLSConfig(cfg.TLS.CAFile)
 	if err != nil {
-		log.Error("Failed to create client: ", err)
+		log.Error("Failed to load tls config", sl.Err(err))
+		os.Exit(1)
+	}
+
+	apiClient, err := api.New(log, fmt.Sprintf("localhost:%d", cfg.Port), tlsConfig)
+	if err != nil {
+		log.Error("Failed to create client", sl.Err(err))
 	}
 
 	tui.StartCLI(apiClient)
 
 	<-stop
 
-	appl.GRPCServer.Stop()
+	appl.Stop()
 }
@@ -1,33 +1,262 @@
 package app
 
 import (
+	"context"
+	"github.com/nglmq/password-keeper/internal/config"
+	"github.com/nglmq/password-keeper/internal/lib/crypt"
+	"github.com/nglmq/password-keeper/internal/lib/jwt"
+	"github.com/nglmq/password-keeper/internal/lib/logger/sl"
+	"github.com/nglmq/password-keeper/internal/lib/mailer"
+	"github.com/nglmq/password-keeper/internal/lib/passhash"
+	"github.com/nglmq/password-keeper/internal/lib/password"
 	"github.com/nglmq/password-keeper/internal/services/auth"
+	"github.com/nglmq/password-keeper/internal/storage"
+	"github.com/nglmq/password-keeper/internal/storage/fs"
+	"github.com/nglmq/password-keeper/internal/storage/memory"
 	postgres "github.com/nglmq/password-keeper/internal/storage/pg"
+	"github.com/nglmq/password-keeper/internal/storage/s3"
+	"github.com/nglmq/password-keeper/internal/storage/sqlite"
 	"log/slog"
+	"os"
+	"time"
 
 	grpcapp "github.com/nglmq/password-keeper/internal/app/grpc"
+	"google.golang.org/grpc/credentials"
 )
 
+// Storage is everything the services need from a storage
+type Storage interface {
+	auth.AuthStorage
+	auth.DataStorage
+	auth.KeyStorage
+	jwt.RevocationList
+}
+
 type App struct {
 	GRPCServer  *grpcapp.App
 	AuthService *auth.Auth
 	DataService *auth.Data
+
+	stopBackground context.CancelFunc
 }
 
-func New(log *slog.Logger, db string, port int) *App {
-	storage, err := postgres.New(db)
+func New(log *slog.Logger, cfg *config.Config) *App {
+	storage, storageBlobs, err := newStorage(cfg)
+	if err != nil {
+		log.Error("failed to create storage", sl.Err(err))
+		os.Exit(1)
+	}
+
+	if cfg.Storage == "memory" {
+		log.Warn("using in-memory storage, all data is lost when the server stops")
+	}
+
+	masterKey, err := crypt.ParseKey(cfg.MasterKey)
+	if err != nil {
+		log.Error("failed to parse master key", sl.Err(err))
+		os.Exit(1)
+	}
+
+	previousKeys, err := crypt.ParseKeys(cfg.PreviousMasterKeys)
+	if err != nil {
+		log.Error("failed to parse previous master keys", sl.Err(err))
+		os.Exit(1)
+	}
+
+	tokens, err := newTokenManager(cfg.JWT)
+	if err != nil {
+		log.Error("failed to create token manager", sl.Err(err))
+		os.Exit(1)
+	}
+
+	tokens.SetRevocationList(storage)
+
+	keys, err := auth.NewKeys(masterKey, previousKeys, storage)
+	if err != nil {
+		log.Error("failed to create keys", sl.Err(err))
+		os.Exit(1)
+	}
+
+	mail, err := newMailer(log, cfg.Mail)
+	if err != nil {
+		log.Error("failed to create mailer", sl.Err(err))
+		os.Exit(1)
+	}
+
+	hasher, err := passhash.New(passhash.Params{
+		Memory:      cfg.PasswordHashing.MemoryKiB,
+		Iterations:  cfg.PasswordHashing.Iterations,
+		Parallelism: cfg.PasswordHashing.Parallelism,
+	}, cfg.PasswordHashing.MaxConcurrent)
+	if err != nil {
+		log.Error("failed to create password hasher", sl.Err(err))
+		os.Exit(1)
+	}
+
+	previousParams := make([]passhash.Params, 0, len(cfg.PasswordHashing.PreviousParams))
+	for _, s := range cfg.PasswordHashing.PreviousParams {
+		params, err := passhash.ParseParams(s)
+		if err != nil {
+			log.Error("failed to parse previous password hashing params", sl.Err(err))
+			os.Exit(1)
+		}
+
+		previousParams = append(previousParams, params)
+	}
+
+	authService := auth.NewAuth(
+		log,
+		tokens,
+		keys,
+		time.Duration(cfg.JWT.AccessTokenTTL),
+		time.Duration(cfg.JWT.RefreshTokenTTL),
+		loginLimits(cfg.LoginLimits),
+		password.Policy{
+			MinLength:    cfg.PasswordPolicy.MinLength,
+			MinScore:     cfg.PasswordPolicy.MinScore,
+			RejectCommon: !cfg.PasswordPolicy.AllowCommon,
+		},
+		hasher,
+		previousParams,
+		time.Duration(cfg.DeletionGracePeriod),
+		mail,
+		emailSettings(cfg.Mail),
+		storage,
+	)
+	blobs, err := newBlobStore(cfg.BlobStore, storageBlobs)
+	if err != nil {
+		log.Error("failed to create blob store", sl.Err(err))
+		os.Exit(1)
+	}
+
+	dataService := auth.NewData(log, keys, storage, blobs)
+
+	creds, err := credentials.NewServerTLSFromFile(cfg.TLS.CertFile, cfg.TLS.KeyFile)
 	if err != nil {
-		log.Error("failed to create storage", err)
+		log.Error("failed to load tls certificate", sl.Err(err))
+		os.Exit(1)
 	}
 
-	authService := auth.NewAuth(log, storage, storage)
-	dataService := auth.NewData(log, storage, storage)
+	grpcApp := grpcapp.New(log, authService, dataService, tokens, creds, cfg.Port)
 
-	grpcApp := grpcapp.New(log, authService, dataService, port)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	go authService.RunCleanup(ctx, time.Duration(cfg.JWT.CleanupInterval))
+	go dataService.RunBlobGC(ctx, time.Duration(cfg.BlobStore.GCInterval))
 
 	return &App{
-		GRPCServer:  grpcApp,
-		AuthService: authService,
-		DataService: dataService,
+		GRPCServer:     grpcApp,
+		AuthService:    authService,
+		DataService:    dataService,
+		stopBackground: cancel,
+	}
+}
+
+// Stop stops background jobs and gRPC server
+func (a *App) Stop() {
+	a.stopBackground()
+
+	a.GRPCServer.Stop()
+}
+
+func newTokenManager(cfg config.JWT) (*jwt.Manager, error) {
+	signingKey, err := jwt.LoadKey(cfg.SigningKey.ID, cfg.SigningKey.Algorithm, cfg.SigningKey.File)
+	if err != nil {
+		return nil, err
+	}
+
+	verificationKeys := make([]jwt.Key, 0, len(cfg.VerificationKeys))
+	for _, k := range cfg.VerificationKeys {
+		key, err := jwt.LoadKey(k.ID, k.Algorithm, k.File)
+		if err != nil {
+			return nil, err
+		}
+
+		verificationKeys = append(verificationKeys, key)
+	}
+
+	return jwt.New(cfg.Issuer, cfg.Audience, signingKey, verificationKeys...)
+}
+
+func loginLimits(cfg config.LoginLimits) auth.LoginLimits {
+	return auth.LoginLimits{
+		FreeAttempts:    cfg.FreeAttempts,
+		BaseDelay:       time.Duration(cfg.BaseDelay),
+		MaxDelay:        time.Duration(cfg.MaxDelay),
+		MaxFailures:     cfg.MaxFailures,
+		IPMaxFailures:   cfg.IPMaxFailures,
+		LockoutDuration: time.Duration(cfg.LockoutDuration),
+		ResetAfter:      time.Duration(cfg.ResetAfter),
+	}
+}
+
+func newMailer(log *slog.Logger, cfg config.Mail) (mailer.Mailer, error) {
+	switch cfg.Driver {
+	case "smtp":
+		return mailer.NewSMTP(mailer.SMTPConfig{
+			Host:        cfg.SMTP.Host,
+			Port:        cfg.SMTP.Port,
+			Username:    cfg.SMTP.Username,
+			Password:    cfg.SMTP.Password,
+			From:        cfg.From,
+			ImplicitTLS: cfg.SMTP.ImplicitTLS,
+		}), nil
+	case "file":
+		return mailer.NewFile(cfg.From, cfg.File)
+	default:
+		return mailer.NewLog(log), nil
+	}
+}
+
+// newStorage returns the storage chosen in the config and the blob store keeping blobs in it,
+// the database is SQLite or Postgres depending on the scheme of the DSN
+func newStorage(cfg *config.Config) (Storage, storage.BlobStore, error) {
+	switch {
+	case cfg.Storage == "memory":
+		s := memory.New()
+
+		return s, s.Blobs(), nil
+	case sqlite.IsDSN(cfg.DBConnection):
+		s, err := sqlite.New(cfg.DBConnection)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return s, s.Blobs(), nil
+	default:
+		s, err := postgres.New(cfg.DBConnection)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return s, s.Blobs(), nil
+	}
+}
+
+// newBlobStore returns the blob store chosen in the config, db is the one of the storage
+func newBlobStore(cfg config.BlobStore, db storage.BlobStore) (storage.BlobStore, error) {
+	switch cfg.Driver {
+	case "local":
+		return fs.New(cfg.Dir)
+	case "s3":
+		return s3.New(s3.Config{
+			Endpoint:  cfg.S3.Endpoint,
+			Region:    cfg.S3.Region,
+			Bucket:    cfg.S3.Bucket,
+			AccessKey: cfg.S3.AccessKey,
+			SecretKey: cfg.S3.SecretKey,
+			PathStyle: cfg.S3.PathStyle,
+		}, nil)
+	default:
+		return db, nil
+	}
+}
+
+func emailSettings(cfg config.Mail) auth.EmailSettings {
+	return auth.EmailSettings{
+		VerificationTTL: time.Duration(cfg.VerificationTTL),
+		ResetTTL:        time.Duration(cfg.ResetTTL),
+		ResendInterval:  time.Duration(cfg.ResendInterval),
+		RequireVerified: cfg.RequireVerifiedEmail,
 	}
 }
@@ -7,6 +7,7 @@ import (
 
 	authgrpc "github.com/nglmq/password-keeper/internal/grpc/auth"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 )
 
 // App
@@ -16,9 +17,20 @@ type App struct {
 	port       int
 }
 
-// New create new gRPC server
-func New(log *slog.Logger, authService authgrpc.Auth, dataService authgrpc.Data, port int) *App {
-	gRPCServer := grpc.NewServer()
+// New create new gRPC server served with TLS of creds, calls are authenticated with access tokens parsed by tokens
+func New(
+	log *slog.Logger,
+	authService authgrpc.Auth,
+	dataService authgrpc.Data,
+	tokens authgrpc.TokenParser,
+	creds credentials.TransportCredentials,
+	port int,
+) *App {
+	gRPCServer := grpc.NewServer(
+		grpc.Creds(creds),
+		grpc.ChainUnaryInterceptor(authgrpc.UnaryAuthInterceptor(log, tokens)),
+		grpc.ChainStreamInterceptor(authgrpc.StreamAuthInterceptor(log, tokens)),
+	)
 
 	authgrpc.Register(gRPCServer, authService, dataService)
 
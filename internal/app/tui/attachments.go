@@ -0,0 +1,192 @@
+// Upload and download of files attached to records.
+
+package tui
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/charmbracelet/huh"
+	api "github.com/nglmq/password-keeper/internal/clients/sso"
+	"github.com/nglmq/password-keeper/internal/domain/models"
+	"github.com/nglmq/password-keeper/internal/lib/attachment"
+)
+
+// attachFile asks a record and a local file and uploads the file attached to the record.
+// An interrupted upload of the file with the same name is continued if the user agrees.
+func attachFile(api *api.Client, data []models.Data) error {
+	d, ok, err := chooseRecord(data, "Choose record to attach the file to")
+	if err != nil || !ok {
+		return err
+	}
+
+	var path string
+
+	err = huh.NewInput().
+		Value(&path).
+		Title("Enter path to the file").
+		Validate(func(s string) error {
+			info, err := os.Stat(s)
+			if err != nil {
+				return errors.New("file not found")
+			}
+
+			if !info.Mode().IsRegular() {
+				return errors.New("not a regular file")
+			}
+
+			if info.Size() == 0 {
+				return errors.New("file is empty")
+			}
+
+			return nil
+		}).
+		Run()
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+
+	file, resume, err := chooseUploadToResume(d, filepath.Base(path))
+	if err != nil {
+		return err
+	}
+
+	if resume {
+		file, err = api.ResumeUpload(ctx, file, path)
+		if isFileChanged(err) {
+			fmt.Println("Файл изменился, загружаем его заново.")
+
+			file, err = api.UploadFile(ctx, d.ID, path)
+		}
+	} else {
+		file, err = api.UploadFile(ctx, d.ID, path)
+	}
+	if err != nil {
+		printErrorTable(userError(err))
+
+		if file.ID != 0 && !file.Completed() {
+			fmt.Println("Загрузка прервана, выберите тот же файл ещё раз, чтобы продолжить.")
+		}
+
+		return err
+	}
+
+	fmt.Println("Файл прикреплён:", filepath.Base(path))
+
+	syncData(api)
+
+	return nil
+}
+
+// chooseUploadToResume returns interrupted upload of the file with the name to the record
+// if the user wants to continue it
+func chooseUploadToResume(d models.Data, name string) (models.File, bool, error) {
+	for _, f := range d.Files {
+		if f.Completed() {
+			continue
+		}
+
+		meta, err := attachment.ParseMeta(f.Meta)
+		if err != nil || meta.Name != name {
+			continue
+		}
+
+		var resume bool
+
+		err = huh.NewConfirm().
+			Title(fmt.Sprintf("Continue interrupted upload of %s (%d of %d chunks)?", name, f.UploadedChunks, f.ChunkCount)).
+			Value(&resume).
+			Affirmative("Continue").
+			Negative("Start over").
+			Run()
+
+		return f, resume, err
+	}
+
+	return models.File{}, false, nil
+}
+
+// downloadAttachment asks one of the uploaded files and the path to download it to
+func downloadAttachment(api *api.Client, data []models.Data) error {
+	var files []models.File
+	for _, d := range data {
+		for _, f := range d.Files {
+			if f.Completed() {
+				files = append(files, f)
+			}
+		}
+	}
+
+	if len(files) == 0 {
+		fmt.Println("Нет прикреплённых файлов.")
+		return nil
+	}
+
+	options := make([]huh.Option[int], 0, len(files))
+	for i, f := range files {
+		options = append(options, huh.NewOption(fmt.Sprintf("#%d %s", f.RecordID, fileSummary(f)), i))
+	}
+
+	var i int
+
+	err := huh.NewSelect[int]().
+		Title("Choose file").
+		Options(options...).
+		Value(&i).
+		Run()
+	if err != nil {
+		return err
+	}
+
+	file := files[i]
+
+	meta, err := attachment.ParseMeta(file.Meta)
+	if err != nil {
+		printErrorTable(err)
+		return err
+	}
+
+	path := meta.Name
+
+	err = huh.NewInput().
+		Value(&path).
+		Title("Enter path to save the file to").
+		Validate(requiredField("path")).
+		Run()
+	if err != nil {
+		return err
+	}
+
+	if err := api.DownloadFile(context.Background(), file, path); err != nil {
+		printErrorTable(userError(err))
+		return err
+	}
+
+	fmt.Println("Файл сохранён:", path)
+
+	return nil
+}
+
+// fileSummary returns name and size of the file, for interrupted uploads the number of uploaded chunks
+func fileSummary(f models.File) string {
+	meta, err := attachment.ParseMeta(f.Meta)
+	if err != nil {
+		return fmt.Sprintf("file #%d", f.ID)
+	}
+
+	if !f.Completed() {
+		return fmt.Sprintf("%s (uploaded %d of %d chunks)", meta.Name, f.UploadedChunks, f.ChunkCount)
+	}
+
+	return fmt.Sprintf("%s (%d bytes)", meta.Name, meta.Size)
+}
+
+// isFileChanged reports whether the local file differs from the one of the interrupted upload
+func isFileChanged(err error) bool {
+	return errors.Is(err, api.ErrFileChanged)
+}
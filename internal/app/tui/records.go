@@ -0,0 +1,374 @@
+// Forms of typed records of the vault.
+
+package tui
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/charmbracelet/huh"
+	api "github.com/nglmq/password-keeper/internal/clients/sso"
+	"github.com/nglmq/password-keeper/internal/domain/models"
+	"github.com/nglmq/password-keeper/internal/lib/record"
+)
+
+// addRecord asks type of the new record, its fields and saves it
+func addRecord(api *api.Client) error {
+	var t record.Type
+
+	err := huh.NewSelect[record.Type]().
+		Title("Choose record type").
+		Options(
+			huh.NewOption("Login and password", record.TypeLogin),
+			huh.NewOption("Bank card", record.TypeCard),
+			huh.NewOption("Text note", record.TypeNote),
+			huh.NewOption("File", record.TypeBinary)).
+		Value(&t).
+		Run()
+	if err != nil {
+		return err
+	}
+
+	r, err := record.New(t)
+	if err != nil {
+		return err
+	}
+
+	if err := fillRecord(r); err != nil {
+		return err
+	}
+
+	if _, err := api.SaveRecord(context.Background(), r); err != nil {
+		printErrorTable(userError(err))
+		return err
+	}
+
+	syncData(api)
+
+	return nil
+}
+
+// editData asks a record of the user with its new fields and saves them,
+// records saved before typed records are edited as type and text
+func editData(api *api.Client, data []models.Data) error {
+	d, ok, err := chooseRecord(data, "Choose record")
+	if err != nil || !ok {
+		return err
+	}
+
+	r, err := record.Unmarshal(record.Type(d.DataType), d.Content)
+	if err != nil {
+		// free-form records could have been saved with the name of a type before
+		return editLegacyData(api, d)
+	}
+
+	if err := fillRecord(r); err != nil {
+		return err
+	}
+
+	if err := api.UpdateRecord(context.Background(), d.ID, r); err != nil {
+		printErrorTable(userError(err))
+		return err
+	}
+
+	syncData(api)
+
+	return nil
+}
+
+// editLegacyData asks new type and content of the record without schema
+func editLegacyData(api *api.Client, d models.Data) error {
+	err := huh.NewForm(
+		huh.NewGroup(
+			huh.NewInput().
+				Value(&d.DataType).
+				Title("Enter data type").
+				Validate(requiredField("data type")),
+			huh.NewInput().
+				Value(&d.Content).
+				Title("Enter data").
+				Validate(requiredField("data")),
+		),
+	).Run()
+	if err != nil {
+		return err
+	}
+
+	if err := api.UpdateUserData(context.Background(), d.ID, d.DataType, d.Content); err != nil {
+		printErrorTable(userError(err))
+		return err
+	}
+
+	syncData(api)
+
+	return nil
+}
+
+// deleteData asks a record of the user and deletes it after confirmation
+func deleteData(api *api.Client, data []models.Data) error {
+	d, ok, err := chooseRecord(data, "Choose record")
+	if err != nil || !ok {
+		return err
+	}
+
+	var confirmed bool
+
+	err = huh.NewConfirm().
+		Title(fmt.Sprintf("Delete record #%d?", d.ID)).
+		Value(&confirmed).
+		Affirmative("Delete").
+		Negative("Cancel").
+		Run()
+	if err != nil || !confirmed {
+		return err
+	}
+
+	if err := api.DeleteUserData(context.Background(), d.ID); err != nil {
+		printErrorTable(userError(err))
+		return err
+	}
+
+	syncData(api)
+
+	return nil
+}
+
+// exportFile asks a file record and the path to write the file to
+func exportFile(data []models.Data) error {
+	var files []models.Data
+	for _, d := range data {
+		if record.Type(d.DataType) == record.TypeBinary {
+			files = append(files, d)
+		}
+	}
+
+	d, ok, err := chooseRecord(files, "Choose file")
+	if err != nil || !ok {
+		return err
+	}
+
+	r, err := record.Unmarshal(record.TypeBinary, d.Content)
+	if err != nil {
+		printErrorTable(err)
+		return err
+	}
+
+	file := r.(*record.Binary)
+	path := file.Name
+
+	err = huh.NewInput().
+		Value(&path).
+		Title("Enter path to save the file to").
+		Validate(requiredField("path")).
+		Run()
+	if err != nil {
+		return err
+	}
+
+	// O_EXCL keeps existing files from being overwritten
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		printErrorTable(err)
+		return err
+	}
+
+	if _, err := f.Write(file.Data); err != nil {
+		f.Close()
+		printErrorTable(err)
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		printErrorTable(err)
+		return err
+	}
+
+	fmt.Println("Файл сохранён:", path)
+
+	return nil
+}
+
+// chooseRecord asks one of the records, false is returned if there are none
+func chooseRecord(data []models.Data, title string) (models.Data, bool, error) {
+	if len(data) == 0 {
+		fmt.Println("Нет записей.")
+		return models.Data{}, false, nil
+	}
+
+	options := make([]huh.Option[int], 0, len(data))
+	for i, d := range data {
+		options = append(options, huh.NewOption(fmt.Sprintf("#%d %s", d.ID, d.DataType), i))
+	}
+
+	var i int
+
+	err := huh.NewSelect[int]().
+		Title(title).
+		Options(options...).
+		Value(&i).
+		Run()
+	if err != nil {
+		return models.Data{}, false, err
+	}
+
+	return data[i], true, nil
+}
+
+// fillRecord asks fields of the record with a form of its type, the fields are checked as the record validates them
+func fillRecord(r record.Record) error {
+	switch r := r.(type) {
+	case *record.Login:
+		return huh.NewForm(
+			huh.NewGroup(
+				huh.NewInput().
+					Value(&r.URL).
+					Title("Enter site or application URL").
+					Placeholder("https://example.com").
+					Validate(record.ValidateURL),
+				huh.NewInput().
+					Value(&r.Username).
+					Title("Enter username").
+					Validate(requiredField("username")),
+				huh.NewInput().
+					Value(&r.Password).
+					Title("Enter password").
+					EchoMode(huh.EchoModePassword).
+					Validate(requiredField("password")),
+			),
+		).Run()
+
+	case *record.Card:
+		err := huh.NewForm(
+			huh.NewGroup(
+				huh.NewInput().
+					Value(&r.Number).
+					Title("Enter card number").
+					Placeholder("4242 4242 4242 4242").
+					Validate(record.ValidateCardNumber),
+				huh.NewInput().
+					Value(&r.Holder).
+					Title("Enter card holder").
+					Placeholder("IVAN IVANOV"),
+				huh.NewInput().
+					Value(&r.Expiry).
+					Title("Enter expiry date").
+					Placeholder("MM/YY").
+					Validate(record.ValidateExpiry),
+				huh.NewInput().
+					Value(&r.CVV).
+					Title("Enter CVV").
+					EchoMode(huh.EchoModePassword).
+					Validate(record.ValidateCVV),
+			),
+		).Run()
+
+		r.Number = record.NormalizeCardNumber(r.Number)
+
+		return err
+
+	case *record.Note:
+		return huh.NewText().
+			Value(&r.Text).
+			Title("Enter text").
+			Validate(requiredField("text")).
+			Run()
+
+	case *record.Binary:
+		var path string
+
+		err := huh.NewInput().
+			Value(&path).
+			Title("Enter path to the file").
+			Validate(func(s string) error {
+				info, err := os.Stat(s)
+				if err != nil {
+					return errors.New("file not found")
+				}
+
+				if !info.Mode().IsRegular() {
+					return errors.New("not a regular file")
+				}
+
+				return record.ValidateBinarySize(int(info.Size()))
+			}).
+			Run()
+		if err != nil {
+			return err
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+
+		r.Name = filepath.Base(path)
+		r.Data = data
+
+		return nil
+	}
+
+	return fmt.Errorf("%w: %q", record.ErrUnknownType, r.Type())
+}
+
+// recordSummary returns content of the record shown in the table followed by its files,
+// records without schema are shown as saved
+func recordSummary(d models.Data) string {
+	if d.Untrusted {
+		// the content is shown as the server sent it, the server could have replaced it
+		return strings.Join(append([]string{"NOT VERIFIED, save the record again only if it is yours:", d.Content},
+			filesSummary(d)...), "\n")
+	}
+
+	r, err := record.Unmarshal(record.Type(d.DataType), d.Content)
+	if err != nil {
+		return strings.Join(append([]string{d.Content}, filesSummary(d)...), "\n")
+	}
+
+	var lines []string
+
+	switch r := r.(type) {
+	case *record.Login:
+		if r.URL != "" {
+			lines = append(lines, r.URL)
+		}
+		lines = append(lines, "user: "+r.Username, "password: "+r.Password)
+
+	case *record.Card:
+		lines = append(lines, r.Number)
+		if r.Holder != "" {
+			lines = append(lines, r.Holder)
+		}
+		lines = append(lines, "expires: "+r.Expiry, "cvv: "+r.CVV)
+
+	case *record.Note:
+		lines = append(lines, r.Text)
+
+	case *record.Binary:
+		lines = append(lines, fmt.Sprintf("%s (%d bytes)", r.Name, len(r.Data)))
+	}
+
+	return strings.Join(append(lines, filesSummary(d)...), "\n")
+}
+
+// filesSummary returns lines with files attached to the record
+func filesSummary(d models.Data) []string {
+	lines := make([]string, 0, len(d.Files))
+	for _, f := range d.Files {
+		lines = append(lines, "file: "+fileSummary(f))
+	}
+
+	return lines
+}
+
+func requiredField(name string) func(string) error {
+	return func(s string) error {
+		if strings.TrimSpace(s) == "" {
+			return fmt.Errorf("%s is required", name)
+		}
+		return nil
+	}
+}
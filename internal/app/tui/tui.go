@@ -11,11 +11,17 @@ import (
 	"github.com/charmbracelet/lipgloss/table"
 	api "github.com/nglmq/password-keeper/internal/clients/sso"
 	"github.com/nglmq/password-keeper/internal/domain/models"
+	"github.com/nglmq/password-keeper/internal/lib/apierror"
+	"github.com/nglmq/password-keeper/internal/lib/password"
+	"github.com/skip2/go-qrcode"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"syscall"
+	"time"
 )
 
 type Choice int
@@ -25,11 +31,23 @@ type SecondChoice int
 const (
 	Login Choice = iota + 1
 	Register
+	ResetPassword
 )
 
 const (
 	SaveData SecondChoice = iota + 1
 	SyncData
+	Logout
+	LogoutAll
+	EnableTOTP
+	ChangePassword
+	DeleteAccount
+	VerifyEmail
+	EditData
+	DeleteData
+	ExportFile
+	AttachFile
+	DownloadAttachment
 )
 
 func (c SecondChoice) String() string {
@@ -38,6 +56,28 @@ func (c SecondChoice) String() string {
 		return "SaveData"
 	case SyncData:
 		return "SyncData"
+	case Logout:
+		return "Logout"
+	case LogoutAll:
+		return "LogoutAll"
+	case EnableTOTP:
+		return "EnableTOTP"
+	case ChangePassword:
+		return "ChangePassword"
+	case DeleteAccount:
+		return "DeleteAccount"
+	case VerifyEmail:
+		return "VerifyEmail"
+	case EditData:
+		return "EditData"
+	case DeleteData:
+		return "DeleteData"
+	case ExportFile:
+		return "ExportFile"
+	case AttachFile:
+		return "AttachFile"
+	case DownloadAttachment:
+		return "DownloadAttachment"
 	default:
 		return ""
 	}
@@ -49,6 +89,8 @@ func (c Choice) String() string {
 		return "Login"
 	case Register:
 		return "Register"
+	case ResetPassword:
+		return "ResetPassword"
 	default:
 		return ""
 	}
@@ -64,8 +106,6 @@ type User struct {
 
 func StartCLI(api *api.Client) {
 	var user User
-	var newData models.Data
-	var resp string
 
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
@@ -84,9 +124,9 @@ func StartCLI(api *api.Client) {
 			continue
 		}
 
-		resp, err = sendData(api, user.Choice, user.Email, user.Password)
+		err = sendData(api, user.Choice, user.Email, user.Password)
 		if err != nil {
-			fmt.Println("\n\n\nПопробуйте снова\n\n\n")
+			fmt.Print("\n\n\nПопробуйте снова\n\n\n\n")
 			continue
 		}
 
@@ -94,29 +134,12 @@ func StartCLI(api *api.Client) {
 	}
 
 	for {
-		data, err := api.GetUserData(context.Background(), resp)
+		data, err := api.GetUserData(context.Background())
 		if err != nil {
-			st, ok := status.FromError(err)
-
-			if ok {
-				switch st.Code() {
-				case codes.InvalidArgument:
-					err = fmt.Errorf("not authorized")
-					printErrorTable(err)
-					continue
-
-				case codes.Internal:
-					err = fmt.Errorf("internal error")
-					printErrorTable(err)
-					continue
-
-				default:
-					fmt.Println("Ошибка при входе:", st.Message())
-					printErrorTable(err)
-					continue
-				}
+			printErrorTable(userError(err))
+			if isSessionExpired(err) {
+				return
 			}
-			printErrorTable(err)
 
 			continue
 		}
@@ -125,21 +148,77 @@ func StartCLI(api *api.Client) {
 			continue
 		}
 
-		formToSaveData := renderFormToSaveData(&user, &newData)
+		formToSaveData := renderFormToSaveData(&user)
 		err = formToSaveData.Run()
 		if err != nil {
-			fmt.Println("Uh oh:", err, "\n\n\n")
+			fmt.Print("Uh oh: ", err, "\n\n\n\n")
 			continue
 		}
 
 		switch user.SecondChoice {
 		case SaveData:
 			// Сохраняем новые данные
-			err := saveNewData(api, resp, &newData)
+			if err := addRecord(api); err != nil {
+				continue
+			}
+
+		case EditData:
+			if err := editData(api, data); err != nil {
+				continue
+			}
+
+		case DeleteData:
+			if err := deleteData(api, data); err != nil {
+				continue
+			}
+
+		case ExportFile:
+			if err := exportFile(data); err != nil {
+				continue
+			}
+
+		case AttachFile:
+			if err := attachFile(api, data); err != nil {
+				continue
+			}
+
+		case DownloadAttachment:
+			if err := downloadAttachment(api, data); err != nil {
+				continue
+			}
+
+		case EnableTOTP:
+			if err := enableTOTP(api); err != nil {
+				continue
+			}
+
+		case ChangePassword:
+			if err := changePassword(api); err != nil {
+				continue
+			}
+
+		case VerifyEmail:
+			if err := verifyEmail(api, true); err != nil {
+				continue
+			}
+
+		case DeleteAccount:
+			deleted, err := deleteAccount(api)
+			if err != nil || !deleted {
+				continue
+			}
+
+			return
+
+		case Logout, LogoutAll:
+			err := api.Logout(context.Background(), user.SecondChoice == LogoutAll)
 			if err != nil {
-				fmt.Println("Uh oh:", err)
+				printErrorTable(err)
 				continue
 			}
+
+			fmt.Println("Вы вышли из аккаунта.")
+			return
 		}
 
 		fmt.Println("Хотите продолжить? (yes/no)")
@@ -153,99 +232,36 @@ func StartCLI(api *api.Client) {
 	}
 }
 
-func renderFormToSaveData(user *User, data *models.Data) *huh.Form {
+func renderFormToSaveData(user *User) *huh.Form {
 	form := huh.NewForm(
 		huh.NewGroup(
 			huh.NewSelect[SecondChoice]().
 				Title("Choose option").
 				Options(
-					huh.NewOption("Add new note", SaveData)).
-				//huh.NewOption("Sync data", SyncData)).
+					huh.NewOption("Add new record", SaveData),
+					huh.NewOption("Edit record", EditData),
+					huh.NewOption("Delete record", DeleteData),
+					huh.NewOption("Export file", ExportFile),
+					huh.NewOption("Attach file to record", AttachFile),
+					huh.NewOption("Download attached file", DownloadAttachment),
+					//huh.NewOption("Sync data", SyncData),
+					huh.NewOption("Enable two-factor authentication", EnableTOTP),
+					huh.NewOption("Change password", ChangePassword),
+					huh.NewOption("Verify email", VerifyEmail),
+					huh.NewOption("Delete account", DeleteAccount),
+					huh.NewOption("Log out", Logout),
+					huh.NewOption("Log out from all devices", LogoutAll)).
 				Value(&user.SecondChoice),
 		),
-		huh.NewGroup(
-			huh.NewInput().
-				Value(&data.DataType).
-				Title("Enter data type").
-				Placeholder("Password, login, card, etc.").
-				Validate(func(s string) error {
-					if s == "" {
-						return errors.New("data type is required")
-					}
-					return nil
-				}),
-			huh.NewInput().
-				Value(&data.Content).
-				Title("Enter data").
-				Placeholder("Data").
-				Validate(func(s string) error {
-					if s == "" {
-						return errors.New("data is required")
-					}
-					return nil
-				})),
 	)
 
 	return form
 }
 
-func saveNewData(api *api.Client, token string, data *models.Data) error {
-	err := api.SaveUserData(context.Background(), token, data.DataType, data.Content)
-	if err != nil {
-		st, ok := status.FromError(err)
-
-		if ok {
-			switch st.Code() {
-			case codes.InvalidArgument:
-				err = fmt.Errorf("not authorized")
-				printErrorTable(err)
-				return err
-
-			case codes.Internal:
-				err = fmt.Errorf("internal error")
-				printErrorTable(err)
-				return err
-
-			default:
-				fmt.Println("Ошибка при входе:", st.Message())
-				printErrorTable(err)
-				return err
-			}
-		}
-		printErrorTable(err)
-
-		return err
-	}
-
-	syncData(api, token)
-
-	return nil
-}
-
-func syncData(api *api.Client, token string) {
-	data, err := api.GetUserData(context.Background(), token)
+func syncData(api *api.Client) {
+	data, err := api.GetUserData(context.Background())
 	if err != nil {
-		st, ok := status.FromError(err)
-
-		if ok {
-			switch st.Code() {
-			case codes.InvalidArgument:
-				err = fmt.Errorf("not authorized")
-				printErrorTable(err)
-				return
-
-			case codes.Internal:
-				err = fmt.Errorf("internal error")
-				printErrorTable(err)
-				return
-
-			default:
-				fmt.Println("Ошибка при входе:", st.Message())
-				printErrorTable(err)
-				return
-			}
-		}
-		printErrorTable(err)
+		printErrorTable(userError(err))
 
 		return
 	}
@@ -274,7 +290,8 @@ func renderForm(user *User) *huh.Form {
 				Title("Choose option").
 				Options(
 					huh.NewOption("Login", Login),
-					huh.NewOption("Register", Register)).
+					huh.NewOption("Register", Register),
+					huh.NewOption("Forgot password", ResetPassword)).
 				Value(&user.Choice),
 		),
 
@@ -289,11 +306,19 @@ func renderForm(user *User) *huh.Form {
 					}
 					return nil
 				}),
+		),
 
+		huh.NewGroup(
 			huh.NewInput().
 				Value(&user.Password).
 				Placeholder("Password").
 				Title("Enter password").
+				DescriptionFunc(func() string {
+					if user.Choice != Register {
+						return ""
+					}
+					return strengthMeter(user.Password, user.Email)
+				}, &user.Password).
 				EchoMode(huh.EchoModePassword).
 				Validate(func(s string) error {
 					if s == "" {
@@ -301,7 +326,12 @@ func renderForm(user *User) *huh.Form {
 					}
 					return nil
 				}),
+		).WithHideFunc(func() bool {
+			// the new password is asked after the code from the email
+			return user.Choice == ResetPassword
+		}),
 
+		huh.NewGroup(
 			huh.NewConfirm().
 				Title("Continue?").
 				Value(&user.Continue).
@@ -313,80 +343,450 @@ func renderForm(user *User) *huh.Form {
 	return form
 }
 
-func sendData(api *api.Client, userChoice Choice, userEmail, userPassword string) (string, error) {
+func sendData(api *api.Client, userChoice Choice, userEmail, userPassword string) error {
 	switch {
 	case userChoice == Login:
-		resp, err := api.Login(context.Background(), userEmail, userPassword)
-		if err != nil {
-			st, ok := status.FromError(err)
-
-			if ok {
-				switch st.Code() {
-				case codes.InvalidArgument:
-					err = fmt.Errorf("wrong login or password")
-					printErrorTable(err)
-					return "", err
-
-				case codes.NotFound:
-					err = fmt.Errorf("user not found")
-					printErrorTable(err)
-					return "", err
-
-				case codes.Internal:
-					err = fmt.Errorf("internal error")
-					printErrorTable(err)
-					return "", err
-
-				default:
-					fmt.Println("Ошибка при входе:", st.Message())
-					printErrorTable(err)
-					return "", err
-				}
+		err := api.Login(context.Background(), userEmail, userPassword)
+		if isSecondFactorRequired(err) {
+			err = loginTOTP(api)
+		}
+
+		switch apierror.Reason(err) {
+		case apierror.ReasonAccountDeleted:
+			err = restoreAccount(api, userEmail, userPassword)
+		case apierror.ReasonEmailNotVerified:
+			fmt.Println("Email не подтверждён, мы отправили новое письмо с кодом.")
+			err = verifyEmail(api, false)
+			if err == nil {
+				err = loginWithSecondFactor(api, userEmail, userPassword)
 			}
-			printErrorTable(err)
+		}
 
-			return "", err
+		if err != nil {
+			printErrorTable(userError(err))
+			return err
 		}
 
-		return resp, nil
+		return nil
 
 	case userChoice == Register:
-		token, err := api.Register(context.Background(), userEmail, userPassword)
+		err := api.Register(context.Background(), userEmail, userPassword)
+		if err != nil {
+			printErrorTable(userError(err))
+			return err
+		}
+
+		fmt.Println("Мы отправили письмо с кодом для подтверждения email.")
+
+		return nil
+
+	case userChoice == ResetPassword:
+		err := resetPassword(api, userEmail)
+		if err != nil {
+			printErrorTable(userError(err))
+			return err
+		}
+
+		return nil
+	}
+
+	return nil
+}
+
+// isSessionExpired reports whether user has to log in again
+func isSessionExpired(err error) bool {
+	return errors.Is(err, api.ErrSessionExpired)
+}
+
+// isSecondFactorRequired reports whether login has to be completed with TOTP code
+func isSecondFactorRequired(err error) bool {
+	return errors.Is(err, api.ErrSecondFactorRequired)
+}
+
+// loginRetryAfter returns how long to wait if the server rejected login attempt
+func loginRetryAfter(err error) (time.Duration, bool) {
+	var limitErr *api.LimitError
+	if !errors.As(err, &limitErr) {
+		return 0, false
+	}
+
+	return limitErr.RetryAfter, true
+}
+
+// loginTOTP asks TOTP code or recovery code and completes the login
+func loginTOTP(api *api.Client) error {
+	code, err := askCode("Enter code from authenticator app or recovery code")
+	if err != nil {
+		return err
+	}
+
+	return api.LoginTOTP(context.Background(), code)
+}
+
+// enableTOTP shows new TOTP secret as QR code, asks code to confirm it and shows recovery codes
+func enableTOTP(api *api.Client) error {
+	enrollment, err := api.EnrollTOTP(context.Background())
+	if err != nil {
+		printErrorTable(userError(err))
+		return err
+	}
+
+	qr, err := qrcode.New(enrollment.URI, qrcode.Medium)
+	if err != nil {
+		printErrorTable(err)
+		return err
+	}
+
+	fmt.Println("Отсканируйте QR-код приложением-аутентификатором:")
+	fmt.Println(qr.ToSmallString(false))
+	fmt.Println("или введите секрет вручную:", enrollment.Secret)
+
+	code, err := askCode("Enter code from authenticator app")
+	if err != nil {
+		return err
+	}
+
+	recoveryCodes, err := api.ConfirmTOTP(context.Background(), code)
+	if err != nil {
+		printErrorTable(userError(err))
+		return err
+	}
+
+	fmt.Println("Двухфакторная аутентификация включена.")
+	fmt.Println("Сохраните коды восстановления, каждый можно использовать один раз:")
+	for _, c := range recoveryCodes {
+		fmt.Println("  ", c)
+	}
+
+	return nil
+}
+
+// changePassword asks the current and the new password and re-encrypts the vault
+func changePassword(api *api.Client) error {
+	var oldPassword, newPassword, confirmation string
+
+	err := huh.NewForm(
+		huh.NewGroup(
+			huh.NewInput().
+				Value(&oldPassword).
+				Title("Enter current password").
+				EchoMode(huh.EchoModePassword),
+			huh.NewInput().
+				Value(&newPassword).
+				Title("Enter new password").
+				DescriptionFunc(func() string {
+					return strengthMeter(newPassword, api.Email())
+				}, &newPassword).
+				EchoMode(huh.EchoModePassword).
+				Validate(func(s string) error {
+					if s == "" {
+						return errors.New("password is required")
+					}
+					return nil
+				}),
+			huh.NewInput().
+				Value(&confirmation).
+				Title("Repeat new password").
+				EchoMode(huh.EchoModePassword).
+				Validate(func(s string) error {
+					if s != newPassword {
+						return errors.New("passwords do not match")
+					}
+					return nil
+				}),
+		),
+	).Run()
+	if err != nil {
+		return err
+	}
+
+	err = api.ChangePassword(context.Background(), oldPassword, newPassword)
+	if err != nil {
+		printErrorTable(userError(err))
+		return err
+	}
+
+	fmt.Println("Пароль изменён, сеансы на других устройствах завершены.")
+
+	return nil
+}
+
+// deleteAccount asks the password and deletes the account, false is returned if the user changed the mind
+func deleteAccount(api *api.Client) (bool, error) {
+	var password string
+	var confirmed bool
+
+	err := huh.NewForm(
+		huh.NewGroup(
+			huh.NewConfirm().
+				Title("Delete account with all data?").
+				Description("The account can be restored until it is deleted permanently.").
+				Value(&confirmed).
+				Affirmative("Delete").
+				Negative("Cancel"),
+			huh.NewInput().
+				Value(&password).
+				Title("Enter password").
+				EchoMode(huh.EchoModePassword),
+		),
+	).Run()
+	if err != nil || !confirmed {
+		return false, err
+	}
+
+	purgeAt, err := api.DeleteAccount(context.Background(), password)
+	if err != nil {
+		printErrorTable(userError(err))
+		return false, err
+	}
+
+	fmt.Printf("Аккаунт удалён. Его можно восстановить при входе до %s.\n", purgeAt.Format("02.01.2006 15:04"))
+
+	return true, nil
+}
+
+// restoreAccount offers to restore deleted account and logs in after it
+func restoreAccount(api *api.Client, email, password string) error {
+	var restore bool
+
+	err := huh.NewConfirm().
+		Title("The account is deleted. Restore it?").
+		Value(&restore).
+		Affirmative("Restore").
+		Negative("No").
+		Run()
+	if err != nil {
+		return err
+	}
+
+	if !restore {
+		return errors.New("account is deleted")
+	}
+
+	if err := api.RestoreAccount(context.Background(), email, password); err != nil {
+		return err
+	}
+
+	return loginWithSecondFactor(api, email, password)
+}
+
+// loginWithSecondFactor logs in and asks TOTP code if it is required
+func loginWithSecondFactor(api *api.Client, email, password string) error {
+	err := api.Login(context.Background(), email, password)
+	if isSecondFactorRequired(err) {
+		err = loginTOTP(api)
+	}
+
+	return err
+}
+
+// verifyEmail asks the code from the verification email, with resend a new email is sent first
+func verifyEmail(api *api.Client, resend bool) error {
+	if resend {
+		err := api.SendVerificationEmail(context.Background())
 		if err != nil {
-			st, ok := status.FromError(err)
-
-			if ok {
-				switch st.Code() {
-				case codes.InvalidArgument:
-					err = fmt.Errorf("wrong login or password")
-					printErrorTable(err)
-					return "", err
-
-				case codes.AlreadyExists:
-					err = fmt.Errorf("user already exists")
-					printErrorTable(err)
-					return "", err
-
-				case codes.Internal:
-					err = fmt.Errorf("internal error")
-					printErrorTable(err)
-					return "", err
-
-				default:
-					fmt.Println("Ошибка при входе:", st.Message())
-					printErrorTable(err)
-					return "", err
-				}
+			if retryAfter, ok := loginRetryAfter(err); ok {
+				printErrorTable(fmt.Errorf("email was sent recently, try again in %v", retryAfter))
+				return err
 			}
-			printErrorTable(err)
 
-			return "", err
+			printErrorTable(userError(err))
+			return err
+		}
+	}
+
+	code, err := askCode("Enter code from the verification email")
+	if err != nil {
+		return err
+	}
+
+	if err := api.VerifyEmail(context.Background(), code); err != nil {
+		printErrorTable(userError(err))
+		return err
+	}
+
+	fmt.Println("Email подтверждён.")
+
+	return nil
+}
+
+// resetPassword sends reset code to the email, asks it with the new password and logs in with the new password
+func resetPassword(api *api.Client, email string) error {
+	if err := api.RequestPasswordReset(context.Background(), email); err != nil {
+		return err
+	}
+
+	fmt.Println("Если аккаунт с этим email существует, на него отправлено письмо с кодом.")
+
+	var code, newPassword, confirmation, totpCode string
+	var confirmed bool
+
+	err := huh.NewForm(
+		huh.NewGroup(
+			huh.NewInput().
+				Value(&code).
+				Title("Enter code from the email").
+				Validate(func(s string) error {
+					if s == "" {
+						return errors.New("code is required")
+					}
+					return nil
+				}),
+			huh.NewInput().
+				Value(&newPassword).
+				Title("Enter new password").
+				DescriptionFunc(func() string {
+					return strengthMeter(newPassword, email)
+				}, &newPassword).
+				EchoMode(huh.EchoModePassword).
+				Validate(func(s string) error {
+					if s == "" {
+						return errors.New("password is required")
+					}
+					return nil
+				}),
+			huh.NewInput().
+				Value(&confirmation).
+				Title("Repeat new password").
+				EchoMode(huh.EchoModePassword).
+				Validate(func(s string) error {
+					if s != newPassword {
+						return errors.New("passwords do not match")
+					}
+					return nil
+				}),
+			huh.NewInput().
+				Value(&totpCode).
+				Title("Enter code from authenticator app or recovery code").
+				Description("Leave empty if two-factor authentication is not enabled."),
+			huh.NewConfirm().
+				Title("Reset password?").
+				Description("Records are encrypted with the old password, they are deleted and can not be recovered.").
+				Value(&confirmed).
+				Affirmative("Reset").
+				Negative("Cancel"),
+		),
+	).Run()
+	if err != nil {
+		return err
+	}
+
+	if !confirmed {
+		return errors.New("password reset cancelled")
+	}
+
+	if err := api.ResetPassword(context.Background(), email, code, newPassword, totpCode); err != nil {
+		return err
+	}
+
+	fmt.Println("Пароль изменён, все сеансы завершены.")
+
+	return loginWithSecondFactor(api, email, newPassword)
+}
+
+// strengthLabels describe password strength scores
+var strengthLabels = [password.MaxScore + 1]string{"very weak", "weak", "fair", "good", "strong"}
+
+// strengthMeter shows estimated strength of the password as a bar, the policy of the server is checked on submit
+func strengthMeter(pass, email string) string {
+	if pass == "" {
+		return ""
+	}
+
+	strength := password.Estimate(pass, email)
+
+	bar := strings.Repeat("█", strength.Score+1) + strings.Repeat("░", password.MaxScore-strength.Score)
+
+	return fmt.Sprintf("Strength: %s %s", bar, strengthLabels[strength.Score])
+}
+
+// reasonMessages are shown to the user for errors with ErrorInfo of these reasons
+var reasonMessages = map[string]string{
+	apierror.ReasonInvalidCredentials:   "wrong login or password",
+	apierror.ReasonWrongPassword:        "wrong password",
+	apierror.ReasonInvalidToken:         "session expired, please log in again",
+	apierror.ReasonInvalidChallenge:     "login took too long, please log in again",
+	apierror.ReasonInvalidTOTPCode:      "wrong code",
+	apierror.ReasonTOTPAlreadyEnabled:   "two-factor authentication already enabled",
+	apierror.ReasonTOTPNotEnrolled:      "two-factor authentication is not enrolled",
+	apierror.ReasonUserExists:           "user already exists",
+	apierror.ReasonUserNotFound:         "user not found",
+	apierror.ReasonAccountDeleted:       "account is deleted",
+	apierror.ReasonAccountNotDeleted:    "account can not be restored anymore",
+	apierror.ReasonInvalidEmail:         "invalid email",
+	apierror.ReasonInvalidEmailCode:     "invalid or expired code",
+	apierror.ReasonEmailNotVerified:     "email is not verified",
+	apierror.ReasonEmailAlreadyVerified: "email already verified",
+	apierror.ReasonVaultChanged:         "records changed on another device, try again",
+	apierror.ReasonInvalidVerifier:      "the server rejected the password, update the app",
+	apierror.ReasonDataIntegrity:        "records are damaged or were changed outside of the app",
+	apierror.ReasonInvalidReservation:   "the record could not be saved, try again",
+	apierror.ReasonFileNotFound:         "file not found",
+	apierror.ReasonChecksumMismatch:     "file was damaged in transfer, try again",
+	apierror.ReasonUnexpectedChunk:      "file is being uploaded from another device",
+	apierror.ReasonUploadIncomplete:     "file is not uploaded completely yet",
+	apierror.ReasonQuotaExceeded:        "storage limit reached, the file is too large",
+}
+
+// userError returns error to show to the user, the message is chosen by the details of the status
+// and the message of the server is shown only for errors without known reason
+func userError(err error) error {
+	if isSessionExpired(err) {
+		return errors.New("session expired, please log in again")
+	}
+
+	reason := apierror.Reason(err)
+
+	if retryAfter, ok := loginRetryAfter(err); ok {
+		if reason == apierror.ReasonAccountLocked {
+			return fmt.Errorf("account temporarily locked, try again in %v", retryAfter)
 		}
 
-		return token, nil
+		return fmt.Errorf("too many attempts, try again in %v", retryAfter)
+	}
+
+	var policyErr *password.PolicyError
+	if errors.As(err, &policyErr) {
+		violations := make([]string, 0, len(policyErr.Violations))
+		for _, v := range policyErr.Violations {
+			violations = append(violations, v.Description)
+		}
+
+		return fmt.Errorf("weak password: %s", strings.Join(violations, "; "))
+	}
+
+	if msg, ok := reasonMessages[reason]; ok {
+		return errors.New(msg)
 	}
 
-	return "", nil
+	st, ok := status.FromError(err)
+	if !ok {
+		return err
+	}
+
+	if st.Code() == codes.Internal {
+		return errors.New("internal error")
+	}
+
+	return errors.New(st.Message())
+}
+
+func askCode(title string) (string, error) {
+	var code string
+
+	err := huh.NewInput().
+		Value(&code).
+		Title(title).
+		Placeholder("123456").
+		Validate(func(s string) error {
+			if s == "" {
+				return errors.New("code is required")
+			}
+			return nil
+		}).
+		Run()
+
+	return code, err
 }
 
 func printErrorTable(err error) {
@@ -433,8 +833,10 @@ func printDataTable(data []models.Data) error {
 
 	for _, d := range data {
 		dataRow := []string{
+			strconv.FormatInt(d.ID, 10),
 			d.DataType,
-			d.Content,
+			recordSummary(d),
+			d.UpdatedAt.Local().Format("02.01.2006 15:04"),
 		}
 
 		dataRows = append(dataRows, dataRow)
@@ -460,16 +862,27 @@ func printDataTable(data []models.Data) error {
 		Border(lipgloss.NormalBorder()).
 		BorderStyle(BorderStyle).
 		StyleFunc(func(row, col int) lipgloss.Style {
+			var style lipgloss.Style
 			switch {
 			case row == 0:
-				return HeaderStyle
+				style = HeaderStyle
 			case row%2 == 0:
-				return EvenRowStyle
+				style = EvenRowStyle
 			default:
-				return OddRowStyle
+				style = OddRowStyle
+			}
+
+			// ID and time of the last change are short
+			switch col {
+			case 0:
+				return style.Width(6)
+			case 3:
+				return style.Width(18)
 			}
+
+			return style
 		}).
-		Headers("TYPE", "DATA").
+		Headers("ID", "TYPE", "DATA", "UPDATED").
 		Rows(dataRows...)
 
 	fmt.Println(t)
@@ -0,0 +1,316 @@
+// This file contains upload and download of files attached to records.
+
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/nglmq/password-keeper/gen/go/sso"
+	"github.com/nglmq/password-keeper/internal/domain/models"
+	"github.com/nglmq/password-keeper/internal/lib/attachment"
+)
+
+var (
+	// ErrFileChanged is returned by ResumeUpload when the local file differs from the one the upload started with
+	ErrFileChanged = errors.New("file changed since the upload started")
+	// ErrEmptyFile is returned by UploadFile for files without content
+	ErrEmptyFile = errors.New("file is empty")
+)
+
+// partSuffix is added to the path of the file while it is downloaded, an interrupted download continues from it
+const partSuffix = ".part"
+
+// UploadFile attaches the local file to the record. The file is encrypted with its own key by chunks and uploaded
+// with one stream. If the upload is interrupted, the returned file is not completed and the upload can be
+// continued with ResumeUpload.
+func (c *Client) UploadFile(ctx context.Context, recordID int64, path string) (models.File, error) {
+	if c.vault == nil {
+		return models.File{}, ErrVaultLocked
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return models.File{}, err
+	}
+	defer f.Close()
+
+	size, sum, err := fileChecksum(f)
+	if err != nil {
+		return models.File{}, err
+	}
+
+	if size == 0 {
+		return models.File{}, ErrEmptyFile
+	}
+
+	meta, err := attachment.NewMeta(filepath.Base(path), size, sum)
+	if err != nil {
+		return models.File{}, err
+	}
+
+	plainMeta, err := meta.Marshal()
+	if err != nil {
+		return models.File{}, err
+	}
+
+	encryptedMeta, err := c.vault.Seal(plainMeta, c.metaAD(recordID))
+	if err != nil {
+		return models.File{}, fmt.Errorf("failed to encrypt meta: %w", err)
+	}
+
+	resp, err := c.apiData.CreateUpload(ctx, &sso.CreateUploadRequest{
+		RecordId:   recordID,
+		Meta:       encryptedMeta,
+		ChunkCount: int32(meta.ChunkCount()),
+	})
+	if err != nil {
+		return models.File{}, fmt.Errorf("failed to create upload: %w", err)
+	}
+
+	file := toFile(resp)
+	file.Meta = plainMeta
+
+	return c.uploadChunks(ctx, file, meta, f)
+}
+
+// ResumeUpload uploads chunks of the file the server does not have yet, ErrFileChanged is returned
+// if the local file is not the one the upload started with
+func (c *Client) ResumeUpload(ctx context.Context, file models.File, path string) (models.File, error) {
+	meta, err := attachment.ParseMeta(file.Meta)
+	if err != nil {
+		return file, err
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return file, err
+	}
+	defer f.Close()
+
+	size, sum, err := fileChecksum(f)
+	if err != nil {
+		return file, err
+	}
+
+	if size != meta.Size || !bytes.Equal(sum, meta.SHA256) {
+		return file, ErrFileChanged
+	}
+
+	resp, err := c.apiData.GetUpload(ctx, &sso.GetUploadRequest{FileId: file.ID})
+	if err != nil {
+		return file, fmt.Errorf("failed to get upload: %w", err)
+	}
+
+	file.UploadedChunks = int(resp.UploadedChunks)
+	file.CompletedAt = unixTime(resp.CompletedAt)
+
+	return c.uploadChunks(ctx, file, meta, f)
+}
+
+// uploadChunks sends chunks of the local file starting from the first one not uploaded yet
+func (c *Client) uploadChunks(ctx context.Context, file models.File, meta attachment.Meta, f *os.File) (models.File, error) {
+	if file.Completed() {
+		return file, nil
+	}
+
+	cipher, err := attachment.NewCipher(meta, file.ID)
+	if err != nil {
+		return file, err
+	}
+
+	if _, err := f.Seek(int64(file.UploadedChunks)*attachment.ChunkSize, io.SeekStart); err != nil {
+		return file, err
+	}
+
+	stream, err := c.apiData.UploadFile(ctx)
+	if err != nil {
+		return file, fmt.Errorf("failed to upload file: %w", err)
+	}
+
+	buf := make([]byte, attachment.ChunkSize)
+
+	for i := file.UploadedChunks; i < file.ChunkCount; i++ {
+		n, err := io.ReadFull(f, buf)
+		if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+			return file, fmt.Errorf("failed to read chunk %d: %w", i, err)
+		}
+
+		sealed, checksum, err := cipher.SealChunk(i, buf[:n])
+		if err != nil {
+			return file, err
+		}
+
+		err = stream.Send(&sso.FileChunk{
+			FileId:   file.ID,
+			Index:    int32(i),
+			Data:     sealed,
+			Checksum: checksum,
+		})
+		if errors.Is(err, io.EOF) {
+			// the server closed the stream, the reason is returned by CloseAndRecv
+			break
+		}
+		if err != nil {
+			return file, fmt.Errorf("failed to send chunk %d: %w", i, err)
+		}
+	}
+
+	resp, err := stream.CloseAndRecv()
+	if err != nil {
+		return file, fmt.Errorf("failed to upload file: %w", err)
+	}
+
+	file.UploadedChunks = int(resp.UploadedChunks)
+	file.CompletedAt = unixTime(resp.CompletedAt)
+
+	return file, nil
+}
+
+// DownloadFile downloads the completed file to path, an existing file is not overwritten.
+// Chunks are written to the file with .part suffix first, an interrupted download continues from it
+// on the next call, and the file is moved to path when its checksum matches.
+func (c *Client) DownloadFile(ctx context.Context, file models.File, path string) error {
+	meta, err := attachment.ParseMeta(file.Meta)
+	if err != nil {
+		return err
+	}
+
+	if _, err := os.Stat(path); err == nil {
+		return fmt.Errorf("%s: %w", path, os.ErrExist)
+	}
+
+	part, err := os.OpenFile(path+partSuffix, os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return err
+	}
+	defer part.Close()
+
+	info, err := part.Stat()
+	if err != nil {
+		return err
+	}
+
+	// only whole chunks of the previous download are kept
+	from := int(info.Size() / attachment.ChunkSize)
+	if err := part.Truncate(int64(from) * attachment.ChunkSize); err != nil {
+		return err
+	}
+
+	if _, err := part.Seek(0, io.SeekEnd); err != nil {
+		return err
+	}
+
+	if err := c.downloadChunks(ctx, file.ID, meta, from, part); err != nil {
+		return err
+	}
+
+	size, sum, err := fileChecksum(part)
+	if err != nil {
+		return err
+	}
+
+	if size != meta.Size || !bytes.Equal(sum, meta.SHA256) {
+		// the next download starts over
+		_ = os.Remove(path + partSuffix)
+
+		return fmt.Errorf("file %s: %w", meta.Name, attachment.ErrChecksumMismatch)
+	}
+
+	if err := part.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(path+partSuffix, path)
+}
+
+// downloadChunks writes content of chunks of the file to w starting from the chunk from
+func (c *Client) downloadChunks(ctx context.Context, fileID int64, meta attachment.Meta, from int, w io.Writer) error {
+	if from >= meta.ChunkCount() {
+		return nil
+	}
+
+	cipher, err := attachment.NewCipher(meta, fileID)
+	if err != nil {
+		return err
+	}
+
+	stream, err := c.apiData.DownloadFile(ctx, &sso.DownloadFileRequest{
+		FileId:    fileID,
+		FromChunk: int32(from),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to download file: %w", err)
+	}
+
+	for i := from; ; i++ {
+		chunk, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			if i != meta.ChunkCount() {
+				return fmt.Errorf("download ended after %d of %d chunks", i, meta.ChunkCount())
+			}
+
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to download file: %w", err)
+		}
+
+		if int(chunk.Index) != i {
+			return fmt.Errorf("got chunk %d instead of %d", chunk.Index, i)
+		}
+
+		data, err := cipher.OpenChunk(i, chunk.Data, chunk.Checksum)
+		if err != nil {
+			return err
+		}
+
+		if _, err := w.Write(data); err != nil {
+			return err
+		}
+	}
+}
+
+// fileChecksum returns size and SHA-256 of content of the file from its start
+func fileChecksum(f *os.File) (int64, []byte, error) {
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return 0, nil, err
+	}
+
+	h := sha256.New()
+
+	size, err := io.Copy(h, f)
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to read file: %w", err)
+	}
+
+	return size, h.Sum(nil), nil
+}
+
+func toFile(f *sso.File) models.File {
+	return models.File{
+		ID:             f.Id,
+		RecordID:       f.RecordId,
+		Meta:           f.Meta,
+		ChunkCount:     int(f.ChunkCount),
+		UploadedChunks: int(f.UploadedChunks),
+		CreatedAt:      time.Unix(f.CreatedAt, 0),
+		CompletedAt:    unixTime(f.CompletedAt),
+	}
+}
+
+// unixTime returns zero time for 0
+func unixTime(sec int64) time.Time {
+	if sec == 0 {
+		return time.Time{}
+	}
+
+	return time.Unix(sec, 0)
+}
@@ -4,95 +4,860 @@ package api
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"fmt"
+	"github.com/nglmq/password-keeper/gen/go/sso"
 	"github.com/nglmq/password-keeper/internal/domain/models"
-	"github.com/nglmq/protos/gen/go/sso"
+	"github.com/nglmq/password-keeper/internal/lib/apierror"
+	"github.com/nglmq/password-keeper/internal/lib/crypt"
+	"github.com/nglmq/password-keeper/internal/lib/logger/sl"
+	"github.com/nglmq/password-keeper/internal/lib/password"
+	"github.com/nglmq/password-keeper/internal/lib/record"
+	"github.com/nglmq/password-keeper/internal/lib/srp"
+	"github.com/nglmq/password-keeper/internal/lib/vault"
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 )
 
+var (
+	// ErrVaultLocked is returned when data is accessed before login or registration
+	ErrVaultLocked = errors.New("vault is locked")
+	// ErrSessionExpired is returned when the session cannot be refreshed and user should log in again
+	ErrSessionExpired = errors.New("session expired")
+	// ErrSecondFactorRequired is returned by Login when the login must be completed with LoginTOTP
+	ErrSecondFactorRequired = errors.New("second factor required")
+	// ErrNoPendingLogin is returned by LoginTOTP called without Login
+	ErrNoPendingLogin = errors.New("no login waiting for second factor")
+	// ErrServerNotVerified is returned when the server fails to prove it knows the password verifier
+	ErrServerNotVerified = errors.New("server could not prove it knows the password verifier")
+	// ErrNoVerifier is returned when the password must be proved but the user has not logged in with SRP yet
+	ErrNoVerifier = errors.New("password has no verifier yet, log in again")
+	// ErrUntrustedRecords is returned by ChangePassword while there are records the vault key does not verify,
+	// they should be saved again or deleted first
+	ErrUntrustedRecords = errors.New("some records are not verified by the vault key, save or delete them first")
+)
+
+// LimitError is returned by Login when the server rejects login attempts for a while
+type LimitError struct {
+	RetryAfter time.Duration
+	Err        error
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("%v, retry after %v", e.Err, e.RetryAfter)
+}
+
+func (e *LimitError) Unwrap() error {
+	return e.Err
+}
+
+// limitError wraps error of rejected login attempt with the time to wait from RetryInfo detail,
+// retry-after header is used for servers not sending the detail
+func limitError(err error, header metadata.MD) error {
+	switch status.Code(err) {
+	case codes.ResourceExhausted, codes.Unavailable:
+	default:
+		return err
+	}
+
+	if delay, ok := apierror.RetryDelay(err); ok {
+		return &LimitError{RetryAfter: delay, Err: err}
+	}
+
+	values := header.Get("retry-after")
+	if len(values) == 0 {
+		return err
+	}
+
+	seconds, convErr := strconv.Atoi(values[0])
+	if convErr != nil {
+		return err
+	}
+
+	return &LimitError{RetryAfter: time.Duration(seconds) * time.Second, Err: err}
+}
+
+// refreshBefore is how long before expiration the auth token is refreshed
+const refreshBefore = 30 * time.Second
+
 // Client is a client for the SSO service
 type Client struct {
 	apiAuth sso.AuthClient
 	apiData sso.UserDataClient
 	log     *slog.Logger
+	vault   crypt.Crypter
+
+	mu           sync.Mutex
+	token        string
+	refreshToken string
+	expiresAt    time.Time
+
+	// email of the signed in user, new passwords are checked against it
+	email string
+
+	// login waiting for the second factor, the password is kept to unlock the vault after it
+	challenge       string
+	pendingEmail    string
+	pendingPassword string
 }
 
-// New creates a new SSO client
-func New(log *slog.Logger, addr string) (*Client, error) {
-	conn, err := grpc.Dial(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+// New creates a new SSO client, the connection to the server is protected with TLS of tlsConfig
+func New(log *slog.Logger, addr string, tlsConfig *tls.Config) (*Client, error) {
+	c := &Client{
+		log: log,
+	}
+
+	// the access token of the session is added to calls by interceptors
+	conn, err := grpc.NewClient(addr,
+		grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig)),
+		grpc.WithChainUnaryInterceptor(c.unaryAuthInterceptor),
+		grpc.WithChainStreamInterceptor(c.streamAuthInterceptor),
+	)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create client: %w", err)
 	}
 
-	//conn, err := grpc.NewClient(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
-	//if err != nil {
-	//	return nil, fmt.Errorf("failed to create client: %w", err)
-	//}
+	c.apiAuth = sso.NewAuthClient(conn)
+	c.apiData = sso.NewUserDataClient(conn)
 
-	return &Client{
-		apiAuth: sso.NewAuthClient(conn),
-		apiData: sso.NewUserDataClient(conn),
-		log:     log,
-	}, nil
+	return c, nil
 }
 
-// Register registers a new user
-func (c *Client) Register(ctx context.Context, email, password string) (string, error) {
+// TLSConfig returns TLS config trusting certificates of caFile, system roots are trusted when it is empty
+func TLSConfig(caFile string) (*tls.Config, error) {
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if caFile == "" {
+		return cfg, nil
+	}
+
+	pem, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read ca file: %w", err)
+	}
+
+	cfg.RootCAs = x509.NewCertPool()
+	if !cfg.RootCAs.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates in ca file %s", caFile)
+	}
+
+	return cfg, nil
+}
+
+// Register registers a new user and starts the session, only the verifier of the password is sent to the server.
+// password.PolicyError is returned if the password does not meet the policy of the server.
+func (c *Client) Register(ctx context.Context, email, password string) error {
+	verifier, report, err := c.newVerifier(ctx, email, password)
+	if err != nil {
+		return err
+	}
+
 	resp, err := c.apiAuth.Register(ctx, &sso.RegisterRequest{
-		Email:    email,
-		Password: password,
+		Email:        email,
+		Verifier:     verifier,
+		PolicyReport: report,
 	})
 	if err != nil {
-		return "", fmt.Errorf("failed to register: %w", err)
+		return fmt.Errorf("failed to register: %w", err)
+	}
+
+	c.email = email
+	c.setSession(resp.Token, resp.RefreshToken, resp.ExpiresAt)
+
+	return c.unlockVault(ctx, password)
+}
+
+// newVerifier checks the new password against the policy of the server and computes its SRP verifier
+// with a new salt and the key derivation params of the server. The report of the check is sent with
+// the verifier, the server checks it against its policy as it does not get the password.
+func (c *Client) newVerifier(ctx context.Context, email, newPassword string) (*sso.SRPVerifier, *sso.PasswordReport, error) {
+	settings, err := c.apiAuth.GetPasswordSettings(ctx, &sso.GetPasswordSettingsRequest{})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get password settings: %w", err)
+	}
+
+	policy := password.Policy{
+		MinLength:    int(settings.MinLength),
+		MinScore:     int(settings.MinScore),
+		RejectCommon: settings.RejectCommon,
+	}
+
+	if err := policy.Validate(newPassword, email); err != nil {
+		return nil, nil, err
+	}
+
+	salt, verifier, err := srp.NewVerifier(newPassword, settings.KdfParams)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to compute verifier: %w", err)
 	}
 
-	return resp.Token, nil
+	report := password.Measure(newPassword, email)
+
+	return &sso.SRPVerifier{
+		Salt:      salt,
+		KdfParams: settings.KdfParams,
+		Verifier:  verifier,
+	}, &sso.PasswordReport{
+		Length: int32(report.Length),
+		Score:  int32(report.Score),
+		Common: report.Common,
+		Email:  report.Email,
+	}, nil
 }
 
-// Login logs in a user
-func (c *Client) Login(ctx context.Context, email, password string) (string, error) {
+// Login logs in a user with SRP and starts the session, the password is not sent to the server.
+// ErrSecondFactorRequired is returned if the user has TOTP enabled, the login is completed with LoginTOTP then.
+func (c *Client) Login(ctx context.Context, email, password string) error {
+	srpClient, start, proof, err := c.passwordProof(ctx, email, password)
+	if err != nil {
+		return err
+	}
+
+	if start.Legacy {
+		return c.loginWithPassword(ctx, email, password)
+	}
+
+	var newVerifier *sso.SRPVerifier
+	if start.UpgradeKdfParams != "" {
+		// the verifier is derived with stale params, the server replaces it after the proof is checked
+		salt, verifier, err := srp.NewVerifier(password, start.UpgradeKdfParams)
+		if err != nil {
+			return fmt.Errorf("failed to compute verifier: %w", err)
+		}
+
+		newVerifier = &sso.SRPVerifier{
+			Salt:      salt,
+			KdfParams: start.UpgradeKdfParams,
+			Verifier:  verifier,
+		}
+	}
+
+	var finishHeader metadata.MD
+
+	resp, err := c.apiAuth.LoginFinish(ctx, &sso.LoginFinishRequest{
+		Session:     start.Session,
+		ClientProof: proof,
+		NewVerifier: newVerifier,
+	}, grpc.Header(&finishHeader))
+	if err != nil {
+		return limitError(err, finishHeader)
+	}
+
+	// the server proves it knows the verifier, so the tokens do not come from an impostor
+	if err := srpClient.VerifyServer(resp.ServerProof); err != nil {
+		return ErrServerNotVerified
+	}
+
+	return c.completeLogin(ctx, resp, email, password)
+}
+
+// passwordProof starts SRP login of the email and computes client proof of the password for its session,
+// the proof is nil if the user has no verifier yet
+func (c *Client) passwordProof(ctx context.Context, email, password string) (*srp.Client, *sso.LoginStartResponse, []byte, error) {
+	srpClient, err := srp.NewClient(email)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to start login: %w", err)
+	}
+
+	var header metadata.MD
+
+	start, err := c.apiAuth.LoginStart(ctx, &sso.LoginStartRequest{
+		Email:        email,
+		ClientPublic: srpClient.Public(),
+	}, grpc.Header(&header))
+	if err != nil {
+		return nil, nil, nil, limitError(err, header)
+	}
+
+	if start.Legacy {
+		return srpClient, start, nil, nil
+	}
+
+	x, err := srp.PasswordX(password, start.Salt, start.KdfParams)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to derive login key: %w", err)
+	}
+
+	proof, err := srpClient.Proof(start.Salt, x, start.ServerPublic)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to compute login proof: %w", err)
+	}
+
+	return srpClient, start, proof, nil
+}
+
+// loginWithPassword sends the password to the server, it is used once by users who have no verifier yet
+func (c *Client) loginWithPassword(ctx context.Context, email, password string) error {
+	var header metadata.MD
+
 	resp, err := c.apiAuth.Login(ctx, &sso.LoginRequest{
 		Email:    email,
 		Password: password,
+	}, grpc.Header(&header))
+	if err != nil {
+		return limitError(err, header)
+	}
+
+	return c.completeLogin(ctx, resp, email, password)
+}
+
+// completeLogin opens the session from the login response or waits for the second factor
+func (c *Client) completeLogin(ctx context.Context, resp *sso.LoginResponse, email, password string) error {
+	if resp.Challenge != "" {
+		c.challenge = resp.Challenge
+		c.pendingEmail, c.pendingPassword = email, password
+
+		return ErrSecondFactorRequired
+	}
+
+	c.email = email
+	c.setSession(resp.Token, resp.RefreshToken, resp.ExpiresAt)
+
+	return c.unlockVault(ctx, password)
+}
+
+// LoginTOTP completes login with TOTP code or one of recovery codes
+func (c *Client) LoginTOTP(ctx context.Context, code string) error {
+	if c.challenge == "" {
+		return ErrNoPendingLogin
+	}
+
+	var header metadata.MD
+
+	resp, err := c.apiAuth.LoginTOTP(ctx, &sso.LoginTOTPRequest{
+		Challenge: c.challenge,
+		Code:      code,
+	}, grpc.Header(&header))
+	if err != nil {
+		return limitError(err, header)
+	}
+
+	password := c.pendingPassword
+	c.email = c.pendingEmail
+	c.challenge, c.pendingEmail, c.pendingPassword = "", "", ""
+
+	c.setSession(resp.Token, resp.RefreshToken, resp.ExpiresAt)
+
+	return c.unlockVault(ctx, password)
+}
+
+// EnrollTOTP generates TOTP secret to add to authenticator app, it is enabled by ConfirmTOTP
+func (c *Client) EnrollTOTP(ctx context.Context) (models.TOTPEnrollment, error) {
+	resp, err := c.apiAuth.EnrollTOTP(ctx, &sso.EnrollTOTPRequest{})
+	if err != nil {
+		return models.TOTPEnrollment{}, fmt.Errorf("failed to enroll totp: %w", err)
+	}
+
+	return models.TOTPEnrollment{
+		Secret: resp.Secret,
+		URI:    resp.Uri,
+	}, nil
+}
+
+// ConfirmTOTP enables enrolled TOTP secret and returns recovery codes
+func (c *Client) ConfirmTOTP(ctx context.Context, code string) ([]string, error) {
+	resp, err := c.apiAuth.ConfirmTOTP(ctx, &sso.ConfirmTOTPRequest{
+		Code: code,
 	})
 	if err != nil {
-		return "", err
+		return nil, fmt.Errorf("failed to confirm totp: %w", err)
 	}
 
-	return resp.Token, nil
+	return resp.RecoveryCodes, nil
 }
 
-// GetUserData gets user data
-func (c *Client) GetUserData(ctx context.Context, token string) ([]models.Data, error) {
-	resp, err := c.apiData.GetData(ctx, &sso.GetDataRequest{
-		Token: token,
+// Email returns the email of the signed in user
+func (c *Client) Email() string {
+	return c.email
+}
+
+// ChangePassword changes the password and re-encrypts all records and meta of their files with the vault key
+// derived from it. The old password is proved with SRP and only the verifier of the new one is sent to the server.
+// Sessions on other devices are ended, this client continues with a new session.
+func (c *Client) ChangePassword(ctx context.Context, oldPassword, newPassword string) error {
+	records, err := c.GetUserData(ctx)
+	if err != nil {
+		return err
+	}
+
+	verifier, report, err := c.newVerifier(ctx, c.email, newPassword)
+	if err != nil {
+		return err
+	}
+
+	salt, err := vault.NewSalt()
+	if err != nil {
+		return err
+	}
+
+	newVault, err := vault.Open(newPassword, salt)
+	if err != nil {
+		return fmt.Errorf("failed to open vault: %w", err)
+	}
+
+	data := make([]*sso.Data, 0, len(records))
+	for _, r := range records {
+		// sealing with the new key would make content of the server trusted
+		if r.Untrusted {
+			return ErrUntrustedRecords
+		}
+
+		content, err := newVault.Seal(r.Content, c.recordAD(r.ID, r.DataType))
+		if err != nil {
+			return fmt.Errorf("failed to encrypt user data: %w", err)
+		}
+
+		// content of files is encrypted with their own keys, only meta with the keys is re-encrypted
+		files := make([]*sso.File, 0, len(r.Files))
+		for _, f := range r.Files {
+			meta, err := newVault.Seal(f.Meta, c.metaAD(r.ID))
+			if err != nil {
+				return fmt.Errorf("failed to encrypt meta: %w", err)
+			}
+
+			files = append(files, &sso.File{Id: f.ID, Meta: meta})
+		}
+
+		data = append(data, &sso.Data{
+			Id:       r.ID,
+			DataType: r.DataType,
+			Content:  content,
+			Files:    files,
+		})
+	}
+
+	// the session of the proof expires soon, so it is started after the records are re-encrypted
+	srpClient, start, proof, err := c.passwordProof(ctx, c.email, oldPassword)
+	if err != nil {
+		return err
+	}
+
+	if start.Legacy {
+		return ErrNoVerifier
+	}
+
+	var header metadata.MD
+
+	resp, err := c.apiAuth.ChangePassword(ctx, &sso.ChangePasswordRequest{
+		Session:      start.Session,
+		ClientProof:  proof,
+		Verifier:     verifier,
+		PolicyReport: report,
+		VaultSalt:    salt,
+		Data:         data,
+	}, grpc.Header(&header))
+	if err != nil {
+		return limitError(err, header)
+	}
+
+	if err := srpClient.VerifyServer(resp.ServerProof); err != nil {
+		return ErrServerNotVerified
+	}
+
+	c.setSession(resp.Token, resp.RefreshToken, resp.ExpiresAt)
+	c.vault = newVault
+
+	return nil
+}
+
+// DeleteAccount deletes the account and ends the session, the password is proved with SRP.
+// The account can be restored with RestoreAccount until the returned time.
+func (c *Client) DeleteAccount(ctx context.Context, password string) (time.Time, error) {
+	_, start, proof, err := c.passwordProof(ctx, c.email, password)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	if start.Legacy {
+		return time.Time{}, ErrNoVerifier
+	}
+
+	var header metadata.MD
+
+	resp, err := c.apiAuth.DeleteAccount(ctx, &sso.DeleteAccountRequest{
+		Session:     start.Session,
+		ClientProof: proof,
+	}, grpc.Header(&header))
+	if err != nil {
+		return time.Time{}, limitError(err, header)
+	}
+
+	c.setSession("", "", 0)
+	c.vault = nil
+
+	return time.Unix(resp.PurgeAt, 0), nil
+}
+
+// RestoreAccount restores deleted account, the password is proved with SRP. Log in after it to start the session.
+func (c *Client) RestoreAccount(ctx context.Context, email, password string) error {
+	_, start, proof, err := c.passwordProof(ctx, email, password)
+	if err != nil {
+		return err
+	}
+
+	if start.Legacy {
+		// login of the deleted account is rejected after its password hash is replaced with a verifier,
+		// so the proof can be computed after it
+		var header metadata.MD
+
+		_, err := c.apiAuth.Login(ctx, &sso.LoginRequest{
+			Email:    email,
+			Password: password,
+		}, grpc.Header(&header))
+		if err != nil && apierror.Reason(err) != apierror.ReasonAccountDeleted {
+			return limitError(err, header)
+		}
+
+		_, start, proof, err = c.passwordProof(ctx, email, password)
+		if err != nil {
+			return err
+		}
+
+		if start.Legacy {
+			return ErrNoVerifier
+		}
+	}
+
+	var header metadata.MD
+
+	_, err = c.apiAuth.RestoreAccount(ctx, &sso.RestoreAccountRequest{
+		Session:     start.Session,
+		ClientProof: proof,
+	}, grpc.Header(&header))
+	if err != nil {
+		return limitError(err, header)
+	}
+
+	return nil
+}
+
+// SendVerificationEmail asks the server to send a new verification email to the signed in user
+func (c *Client) SendVerificationEmail(ctx context.Context) error {
+	var header metadata.MD
+
+	_, err := c.apiAuth.SendVerificationEmail(ctx, &sso.SendVerificationEmailRequest{}, grpc.Header(&header))
+	if err != nil {
+		return limitError(err, header)
+	}
+
+	return nil
+}
+
+// VerifyEmail verifies email with the code from the verification email
+func (c *Client) VerifyEmail(ctx context.Context, code string) error {
+	_, err := c.apiAuth.VerifyEmail(ctx, &sso.VerifyEmailRequest{
+		Code: code,
 	})
+
+	return err
+}
+
+// RequestPasswordReset asks the server to email a password reset code
+func (c *Client) RequestPasswordReset(ctx context.Context, email string) error {
+	_, err := c.apiAuth.RequestPasswordReset(ctx, &sso.RequestPasswordResetRequest{
+		Email: email,
+	})
+
+	return err
+}
+
+// ResetPassword sets new password of the email with the code from the reset email, totpCode is needed
+// if TOTP is enabled. Only the verifier of the password is sent to the server.
+// Records encrypted with the forgotten password are deleted, log in after it to start a new vault.
+func (c *Client) ResetPassword(ctx context.Context, email, code, newPassword, totpCode string) error {
+	verifier, report, err := c.newVerifier(ctx, email, newPassword)
+	if err != nil {
+		return err
+	}
+
+	var header metadata.MD
+
+	_, err = c.apiAuth.ResetPassword(ctx, &sso.ResetPasswordRequest{
+		Code:         code,
+		Verifier:     verifier,
+		PolicyReport: report,
+		TotpCode:     totpCode,
+	}, grpc.Header(&header))
+	if err != nil {
+		return limitError(err, header)
+	}
+
+	return nil
+}
+
+// Logout ends the session, with allDevices set sessions of the user on all devices are ended
+func (c *Client) Logout(ctx context.Context, allDevices bool) error {
+	c.mu.Lock()
+	refreshToken := c.refreshToken
+	c.mu.Unlock()
+
+	_, err := c.apiAuth.Logout(ctx, &sso.LogoutRequest{
+		RefreshToken: refreshToken,
+		AllDevices:   allDevices,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to logout: %w", err)
+	}
+
+	c.setSession("", "", 0)
+	c.vault = nil
+
+	return nil
+}
+
+func (c *Client) setSession(token, refreshToken string, expiresAt int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.token = token
+	c.refreshToken = refreshToken
+	c.expiresAt = time.Unix(expiresAt, 0)
+}
+
+// accessToken returns auth token of the session, the token is refreshed if it is about to expire
+func (c *Client) accessToken(ctx context.Context) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.refreshToken == "" {
+		return "", ErrSessionExpired
+	}
+
+	if time.Until(c.expiresAt) > refreshBefore {
+		return c.token, nil
+	}
+
+	resp, err := c.apiAuth.Refresh(ctx, &sso.RefreshRequest{
+		RefreshToken: c.refreshToken,
+	})
+	if err != nil {
+		c.log.Warn("failed to refresh session", sl.Err(err))
+
+		if status.Code(err) == codes.Unauthenticated {
+			c.token, c.refreshToken = "", ""
+
+			return "", ErrSessionExpired
+		}
+
+		return "", fmt.Errorf("failed to refresh session: %w", err)
+	}
+
+	c.token = resp.Token
+	c.refreshToken = resp.RefreshToken
+	c.expiresAt = time.Unix(resp.ExpiresAt, 0)
+
+	return c.token, nil
+}
+
+// unlockVault derives vault key from the master password and the salt stored on the server
+func (c *Client) unlockVault(ctx context.Context, password string) error {
+	resp, err := c.apiData.GetVaultSalt(ctx, &sso.GetVaultSaltRequest{})
+	if err != nil {
+		return fmt.Errorf("failed to get vault salt: %w", err)
+	}
+
+	v, err := vault.Open(password, resp.Salt)
+	if err != nil {
+		return fmt.Errorf("failed to open vault: %w", err)
+	}
+
+	c.vault = v
+
+	return nil
+}
+
+// GetUserData gets user data. Content of records is verified with the vault key to be sealed for the user,
+// the ID and type of the record. Records the key does not verify are marked Untrusted and their content
+// is returned as the server sent it. Records sealed by older clients without the binding are bound again.
+func (c *Client) GetUserData(ctx context.Context) ([]models.Data, error) {
+	if c.vault == nil {
+		return []models.Data{}, ErrVaultLocked
+	}
+
+	resp, err := c.apiData.GetData(ctx, &sso.GetDataRequest{})
 	if err != nil {
 		return []models.Data{}, fmt.Errorf("failed to get user data: %w", err)
 	}
 
 	var dataModel []models.Data
 	for _, d := range resp.Data {
-		dataModel = append(dataModel, models.Data{
-			DataType: d.DataType,
-			Content:  d.Content,
-		})
+		record := models.Data{
+			ID:        d.Id,
+			DataType:  d.DataType,
+			CreatedAt: time.Unix(d.CreatedAt, 0),
+			UpdatedAt: time.Unix(d.UpdatedAt, 0),
+		}
+
+		record.Content, err = c.openRecord(ctx, d)
+		if err != nil {
+			c.log.Warn("record is not verified by the vault key", slog.Int64("id", d.Id), sl.Err(err))
+
+			record.Content = d.Content
+			record.Untrusted = true
+		}
+
+		record.Files = make([]models.File, 0, len(d.Files))
+		for _, f := range d.Files {
+			file := toFile(f)
+
+			file.Meta, err = c.openMeta(f.Meta, d.Id)
+			if err != nil {
+				return []models.Data{}, fmt.Errorf("failed to decrypt meta of file %d: %w", f.Id, err)
+			}
+
+			record.Files = append(record.Files, file)
+		}
+
+		dataModel = append(dataModel, record)
 	}
 
 	return dataModel, nil
 }
 
-// SaveUserData saves user data
-func (c *Client) SaveUserData(ctx context.Context, token, dataType, data string) error {
-	_, err := c.apiData.SaveData(ctx, &sso.SaveDataRequest{
-		Token:    token,
+// openRecord returns content of the record sealed for it with the vault key. Content sealed by older clients
+// without the binding is sealed again with it, so the server can not move it to another record later.
+func (c *Client) openRecord(ctx context.Context, d *sso.Data) (string, error) {
+	ad := c.recordAD(d.Id, d.DataType)
+
+	content, err := c.vault.Open(d.Content, ad)
+	if err == nil {
+		return content, nil
+	}
+
+	content, err = c.openUnbound(d.Content)
+	if err != nil {
+		return "", err
+	}
+
+	if err := c.UpdateUserData(ctx, d.Id, d.DataType, content); err != nil {
+		// the content is verified by the vault key, binding is retried on the next sync
+		c.log.Warn("failed to bind record", slog.Int64("id", d.Id), sl.Err(err))
+	}
+
+	return content, nil
+}
+
+// openMeta returns meta of the file of the record, meta sealed by older clients without the binding
+// is accepted as it binds content of the file to its ID itself, it is bound on the next password change
+func (c *Client) openMeta(meta string, recordID int64) (string, error) {
+	plain, err := c.vault.Open(meta, c.metaAD(recordID))
+	if err == nil {
+		return plain, nil
+	}
+
+	return c.openUnbound(meta)
+}
+
+// openUnbound returns content sealed with the vault key by clients which did not bind it
+func (c *Client) openUnbound(sha string) (string, error) {
+	if plain, err := c.vault.Open(sha, nil); err == nil {
+		return plain, nil
+	}
+
+	return c.vault.OpenLegacy(sha)
+}
+
+// recordAD binds content of the record to the signed in user, the ID and type of the record
+func (c *Client) recordAD(id int64, dataType string) []byte {
+	return []byte(strings.ToLower(c.email) + "\x00" + strconv.FormatInt(id, 10) + "\x00" + dataType)
+}
+
+// metaAD binds meta of the file to the signed in user and the record the file is attached to
+func (c *Client) metaAD(recordID int64) []byte {
+	return c.recordAD(recordID, "file meta")
+}
+
+// SaveUserData saves user data and returns ID of the new record
+func (c *Client) SaveUserData(ctx context.Context, dataType, data string) (int64, error) {
+	if c.vault == nil {
+		return 0, ErrVaultLocked
+	}
+
+	// the ID is reserved first, so the content is bound to it before it reaches the server
+	reserved, err := c.apiData.ReserveDataID(ctx, &sso.ReserveDataIDRequest{})
+	if err != nil {
+		return 0, fmt.Errorf("failed to reserve record id: %w", err)
+	}
+
+	content, err := c.vault.Seal(data, c.recordAD(reserved.Id, dataType))
+	if err != nil {
+		return 0, fmt.Errorf("failed to encrypt user data: %w", err)
+	}
+
+	resp, err := c.apiData.SaveData(ctx, &sso.SaveDataRequest{
+		Id:          reserved.Id,
+		Reservation: reserved.Reservation,
+		DataType:    dataType,
+		Data:        content,
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to save user data: %w", err)
+	}
+
+	return resp.Id, nil
+}
+
+// UpdateUserData replaces type and content of the record
+func (c *Client) UpdateUserData(ctx context.Context, id int64, dataType, data string) error {
+	if c.vault == nil {
+		return ErrVaultLocked
+	}
+
+	content, err := c.vault.Seal(data, c.recordAD(id, dataType))
+	if err != nil {
+		return fmt.Errorf("failed to encrypt user data: %w", err)
+	}
+
+	_, err = c.apiData.UpdateData(ctx, &sso.UpdateDataRequest{
+		Id:       id,
 		DataType: dataType,
-		Data:     data,
+		Data:     content,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to update user data: %w", err)
+	}
+
+	return nil
+}
+
+// SaveRecord validates the typed record and saves it, the type is the data type of the record
+func (c *Client) SaveRecord(ctx context.Context, r record.Record) (int64, error) {
+	content, err := record.Marshal(r)
+	if err != nil {
+		return 0, err
+	}
+
+	return c.SaveUserData(ctx, string(r.Type()), content)
+}
+
+// UpdateRecord validates the typed record and replaces the record with the ID with it
+func (c *Client) UpdateRecord(ctx context.Context, id int64, r record.Record) error {
+	content, err := record.Marshal(r)
+	if err != nil {
+		return err
+	}
+
+	return c.UpdateUserData(ctx, id, string(r.Type()), content)
+}
+
+// DeleteUserData deletes the record
+func (c *Client) DeleteUserData(ctx context.Context, id int64) error {
+	_, err := c.apiData.DeleteData(ctx, &sso.DeleteDataRequest{
+		Id: id,
 	})
 	if err != nil {
-		return fmt.Errorf("failed to save user data: %w", err)
+		return fmt.Errorf("failed to delete user data: %w", err)
 	}
 
 	return nil
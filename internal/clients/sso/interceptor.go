@@ -0,0 +1,76 @@
+package api
+
+import (
+	"context"
+
+	"github.com/nglmq/password-keeper/gen/go/sso"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// authorizationHeader is the metadata key the server reads the access token from
+const authorizationHeader = "authorization"
+
+// publicMethods are called without access token as the server allows,
+// Refresh must be among them since the interceptor calls it to refresh the token
+var publicMethods = map[string]bool{
+	sso.Auth_Register_FullMethodName:             true,
+	sso.Auth_Login_FullMethodName:                true,
+	sso.Auth_LoginStart_FullMethodName:           true,
+	sso.Auth_LoginFinish_FullMethodName:          true,
+	sso.Auth_LoginTOTP_FullMethodName:            true,
+	sso.Auth_Refresh_FullMethodName:              true,
+	sso.Auth_RestoreAccount_FullMethodName:       true,
+	sso.Auth_VerifyEmail_FullMethodName:          true,
+	sso.Auth_RequestPasswordReset_FullMethodName: true,
+	sso.Auth_ResetPassword_FullMethodName:        true,
+}
+
+// unaryAuthInterceptor adds the access token of the session to calls of methods requiring it,
+// ErrSessionExpired is returned without calling the server if there is no session
+func (c *Client) unaryAuthInterceptor(
+	ctx context.Context,
+	method string,
+	req, reply any,
+	cc *grpc.ClientConn,
+	invoker grpc.UnaryInvoker,
+	opts ...grpc.CallOption,
+) error {
+	ctx, err := c.authContext(ctx, method)
+	if err != nil {
+		return err
+	}
+
+	return invoker(ctx, method, req, reply, cc, opts...)
+}
+
+// streamAuthInterceptor adds the access token to streams as unaryAuthInterceptor does
+func (c *Client) streamAuthInterceptor(
+	ctx context.Context,
+	desc *grpc.StreamDesc,
+	cc *grpc.ClientConn,
+	method string,
+	streamer grpc.Streamer,
+	opts ...grpc.CallOption,
+) (grpc.ClientStream, error) {
+	ctx, err := c.authContext(ctx, method)
+	if err != nil {
+		return nil, err
+	}
+
+	return streamer(ctx, desc, cc, method, opts...)
+}
+
+// authContext returns ctx with authorization metadata for the method, the token is refreshed if it is about to expire
+func (c *Client) authContext(ctx context.Context, method string) (context.Context, error) {
+	if publicMethods[method] {
+		return ctx, nil
+	}
+
+	token, err := c.accessToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return metadata.AppendToOutgoingContext(ctx, authorizationHeader, "Bearer "+token), nil
+}
@@ -5,19 +5,167 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"runtime"
+	"strings"
+	"time"
 
 	"log"
 )
 
 type Config struct {
-	DBConnection string `json:"database_dsn"`
+	// Storage is "database" (default, "postgres" is kept as an alias) or "memory" to keep everything in memory
+	// of the process for tests and demos, --storage flag overrides it
+	Storage string `json:"storage"`
+	// DBConnection is a Postgres DSN or sqlite:///path/to/file.db for the embedded SQLite database,
+	// sqlite://password-keeper.db in the working directory by default
+	DBConnection       string   `json:"database_dsn"`
+	Port               int      `json:"port"`
+	MasterKey          string   `json:"master_key"`
+	MasterKeyFile      string   `json:"master_key_file"`
+	PreviousMasterKeys []string `json:"previous_master_keys"`
+	// LegacyDataKey is the hex encoded key records were sealed with before per-user keys,
+	// only keeper-admin reseal-records reads it, it is removed once the command reports no failures
+	LegacyDataKey string      `json:"legacy_data_key"`
+	JWT           JWT         `json:"jwt"`
+	LoginLimits   LoginLimits `json:"login_limits"`
+	// TLS is the certificate gRPC is served with, it is required as clients connect only with TLS
+	TLS TLS `json:"tls"`
+	// PasswordPolicy is checked by clients on registration, password change and reset,
+	// the server checks reports of their checks as it never gets the password
+	PasswordPolicy PasswordPolicy `json:"password_policy"`
+	// PasswordHashing sets Argon2id parameters of the key derivation of SRP verifiers
+	PasswordHashing PasswordHashing `json:"password_hashing"`
+	// DeletionGracePeriod is the time a deleted account can be restored before it is purged
+	DeletionGracePeriod Duration `json:"deletion_grace_period"`
+	Mail                Mail     `json:"mail"`
+	// BlobStore keeps chunks of files attached to records
+	BlobStore BlobStore `json:"blob_store"`
+}
+
+// TLS configures certificates of the gRPC connection
+type TLS struct {
+	CertFile string `json:"cert_file"`
+	KeyFile  string `json:"key_file"`
+	// CAFile is the certificate the client verifies the server with, system roots are trusted when it is empty
+	CAFile string `json:"ca_file"`
+}
+
+// BlobStore configures where chunks of attached files are kept
+type BlobStore struct {
+	// Driver is "database" to keep chunks in the storage (default, "postgres" is kept as an alias,
+	// in memory with the memory storage),
+	// "local" to keep them in Dir
+	// or "s3" to keep them in a bucket of an S3 compatible service
+	Driver string `json:"driver"`
+	Dir    string `json:"dir"`
+	S3     S3     `json:"s3"`
+	// GCInterval is the time between deletions of chunks of deleted files, deleting a record triggers it as well
+	GCInterval Duration `json:"gc_interval"`
+}
+
+// S3 is the bucket of an S3 compatible service, the secret key can be read from SecretKeyFile
+type S3 struct {
+	Endpoint      string `json:"endpoint"`
+	Region        string `json:"region"`
+	Bucket        string `json:"bucket"`
+	AccessKey     string `json:"access_key"`
+	SecretKey     string `json:"secret_key"`
+	SecretKeyFile string `json:"secret_key_file"`
+	// PathStyle puts the bucket into the path of URLs, MinIO needs it
+	PathStyle bool `json:"path_style"`
+}
+
+// Mail configures emails with verification and password reset tokens
+type Mail struct {
+	// Driver is "smtp", "file" to append emails to File or "log" to write them to the log (default)
+	Driver string `json:"driver"`
+	From   string `json:"from"`
+	File   string `json:"file"`
+	SMTP   SMTP   `json:"smtp"`
+	// VerificationTTL is the time the email verification token is valid
+	VerificationTTL Duration `json:"verification_ttl"`
+	// ResetTTL is the time the password reset token is valid
+	ResetTTL Duration `json:"reset_ttl"`
+	// ResendInterval is the minimal time between two emails of the same kind to a user
+	ResendInterval Duration `json:"resend_interval"`
+	// RequireVerifiedEmail blocks login until the user verifies the email
+	RequireVerifiedEmail bool `json:"require_verified_email"`
+}
+
+// SMTP is the server emails are sent with, the password can be read from PasswordFile
+type SMTP struct {
+	Host         string `json:"host"`
 	Port         int    `json:"port"`
+	Username     string `json:"username"`
+	Password     string `json:"password"`
+	PasswordFile string `json:"password_file"`
+	// ImplicitTLS connects with TLS from the start (port 465) instead of STARTTLS
+	ImplicitTLS bool `json:"implicit_tls"`
+}
+
+// JWT configures signing and validation of auth tokens
+type JWT struct {
+	Issuer           string   `json:"issuer"`
+	Audience         string   `json:"audience"`
+	SigningKey       JWTKey   `json:"signing_key"`
+	VerificationKeys []JWTKey `json:"verification_keys"`
+	AccessTokenTTL   Duration `json:"access_token_ttl"`
+	RefreshTokenTTL  Duration `json:"refresh_token_ttl"`
+	CleanupInterval  Duration `json:"cleanup_interval"`
+}
+
+// LoginLimits configures protection of Login from password guessing.
+// Failed attempts are counted per email and per client IP and forgotten after ResetAfter without failures.
+type LoginLimits struct {
+	// FreeAttempts is the number of failures of an email allowed without delay
+	FreeAttempts int `json:"free_attempts"`
+	// BaseDelay is the delay after the first failure above FreeAttempts, it doubles with every next failure
+	BaseDelay Duration `json:"base_delay"`
+	// MaxDelay caps the delay between attempts
+	MaxDelay Duration `json:"max_delay"`
+	// MaxFailures of an email lock the account for LockoutDuration
+	MaxFailures int `json:"max_failures"`
+	// IPMaxFailures of a client IP block logins from it for LockoutDuration
+	IPMaxFailures   int      `json:"ip_max_failures"`
+	LockoutDuration Duration `json:"lockout_duration"`
+	ResetAfter      Duration `json:"reset_after"`
+}
+
+// PasswordPolicy configures requirements to new passwords
+type PasswordPolicy struct {
+	// MinLength is the minimal number of characters, 10 by default
+	MinLength int `json:"min_length"`
+	// MinScore is the minimal strength from 1 (very weak) to 4 (strong), 3 by default
+	MinScore int `json:"min_score"`
+	// AllowCommon allows passwords from the bundled list of the most common passwords
+	AllowCommon bool `json:"allow_common"`
+}
+
+// PasswordHashing configures Argon2id key derivation of SRP verifiers, clients derive new verifiers with it
+// and the server checks password hashes of legacy users. Raising the parameters upgrades verifiers of users
+// on their next login, every derivation takes MemoryKiB while it is computed.
+type PasswordHashing struct {
+	MemoryKiB   uint32 `json:"memory_kib"`
+	Iterations  uint32 `json:"iterations"`
+	Parallelism uint8  `json:"parallelism"`
+	// MaxConcurrent limits hashes computed at once, the number of CPUs by default
+	MaxConcurrent int `json:"max_concurrent"`
+	// PreviousParams are params used before the current ones as "m=65536,t=3,p=4", fake login challenges
+	// of unknown emails take them as challenges of users with stale verifiers do
+	PreviousParams []string `json:"previous_params"`
+}
+
+// JWTKey is a key file, ID is put to kid header of signed tokens
+type JWTKey struct {
+	ID        string `json:"kid"`
+	Algorithm string `json:"alg"`
+	File      string `json:"file"`
 }
 
 func MustLoad() *Config {
-	path := parseConfigPath()
+	f := parseFlags()
 
-	file, err := os.Open(path)
+	file, err := os.Open(f.configPath)
 	if err != nil {
 		log.Fatalf("failed to open file: %v", err)
 	}
@@ -28,21 +176,263 @@ func MustLoad() *Config {
 		log.Fatalf("error decoding json: %v", err)
 	}
 
+	if f.storage != "" {
+		cfg.Storage = f.storage
+	}
+
+	setStorageDefaults(&cfg)
+
+	if cfg.MasterKeyFile != "" {
+		key, err := os.ReadFile(cfg.MasterKeyFile)
+		if err != nil {
+			log.Fatalf("failed to read master key file: %v", err)
+		}
+
+		cfg.MasterKey = strings.TrimSpace(string(key))
+	}
+
+	if cfg.MasterKey == "" {
+		log.Fatal("master key is not set")
+	}
+
+	if cfg.TLS.CertFile == "" || cfg.TLS.KeyFile == "" {
+		log.Fatal("tls certificate and key are not set")
+	}
+
+	if cfg.JWT.SigningKey.File == "" {
+		log.Fatal("jwt signing key is not set")
+	}
+
+	if cfg.JWT.Issuer == "" {
+		cfg.JWT.Issuer = "password-keeper"
+	}
+
+	if cfg.JWT.Audience == "" {
+		cfg.JWT.Audience = "password-keeper"
+	}
+
+	if cfg.JWT.AccessTokenTTL == 0 {
+		cfg.JWT.AccessTokenTTL = Duration(15 * time.Minute)
+	}
+
+	if cfg.JWT.RefreshTokenTTL == 0 {
+		cfg.JWT.RefreshTokenTTL = Duration(30 * 24 * time.Hour)
+	}
+
+	if cfg.JWT.CleanupInterval == 0 {
+		cfg.JWT.CleanupInterval = Duration(time.Hour)
+	}
+
+	setLoginLimitsDefaults(&cfg.LoginLimits)
+
+	if cfg.PasswordPolicy.MinLength == 0 {
+		cfg.PasswordPolicy.MinLength = 10
+	}
+
+	if cfg.PasswordPolicy.MinScore == 0 {
+		cfg.PasswordPolicy.MinScore = 3
+	}
+
+	setPasswordHashingDefaults(&cfg.PasswordHashing)
+
+	if cfg.DeletionGracePeriod == 0 {
+		cfg.DeletionGracePeriod = Duration(30 * 24 * time.Hour)
+	}
+
+	setMailDefaults(&cfg.Mail)
+	setBlobStoreDefaults(&cfg.BlobStore)
+
 	return &cfg
 }
 
-func parseConfigPath() string {
-	var res string
+func setStorageDefaults(cfg *Config) {
+	if cfg.Storage == "" {
+		cfg.Storage = "database"
+	}
+
+	switch cfg.Storage {
+	case "database", "postgres":
+		cfg.Storage = "database"
+
+		if cfg.DBConnection == "" {
+			cfg.DBConnection = "sqlite://password-keeper.db"
+		}
+	case "memory":
+	default:
+		log.Fatalf("unknown storage %q", cfg.Storage)
+	}
+}
+
+func setBlobStoreDefaults(b *BlobStore) {
+	if b.Driver == "" {
+		b.Driver = "database"
+	}
+
+	switch b.Driver {
+	case "database", "postgres":
+		b.Driver = "database"
+	case "local":
+		if b.Dir == "" {
+			log.Fatal("blob store dir is not set")
+		}
+	case "s3":
+		if b.S3.Endpoint == "" || b.S3.Bucket == "" {
+			log.Fatal("s3 endpoint and bucket are not set")
+		}
+
+		if b.S3.SecretKeyFile != "" {
+			secret, err := os.ReadFile(b.S3.SecretKeyFile)
+			if err != nil {
+				log.Fatalf("failed to read s3 secret key file: %v", err)
+			}
+
+			b.S3.SecretKey = strings.TrimSpace(string(secret))
+		}
+	default:
+		log.Fatalf("unknown blob store driver %q", b.Driver)
+	}
+
+	if b.GCInterval == 0 {
+		b.GCInterval = Duration(time.Hour)
+	}
+}
+
+func setPasswordHashingDefaults(h *PasswordHashing) {
+	if h.MemoryKiB == 0 {
+		h.MemoryKiB = 64 * 1024
+	}
+
+	if h.Iterations == 0 {
+		h.Iterations = 3
+	}
+
+	if h.Parallelism == 0 {
+		h.Parallelism = 4
+	}
+
+	if h.MaxConcurrent == 0 {
+		h.MaxConcurrent = runtime.NumCPU()
+	}
+}
+
+func setMailDefaults(m *Mail) {
+	if m.Driver == "" {
+		m.Driver = "log"
+	}
+
+	switch m.Driver {
+	case "log":
+	case "file":
+		if m.File == "" {
+			log.Fatal("mail file is not set")
+		}
+	case "smtp":
+		if m.SMTP.Host == "" || m.From == "" {
+			log.Fatal("smtp host and mail sender are not set")
+		}
+
+		if m.SMTP.Port == 0 {
+			m.SMTP.Port = 587
+		}
+
+		if m.SMTP.PasswordFile != "" {
+			password, err := os.ReadFile(m.SMTP.PasswordFile)
+			if err != nil {
+				log.Fatalf("failed to read smtp password file: %v", err)
+			}
+
+			m.SMTP.Password = strings.TrimSpace(string(password))
+		}
+	default:
+		log.Fatalf("unknown mail driver %q", m.Driver)
+	}
+
+	if m.From == "" {
+		m.From = "password-keeper@localhost"
+	}
+
+	if m.VerificationTTL == 0 {
+		m.VerificationTTL = Duration(48 * time.Hour)
+	}
+
+	if m.ResetTTL == 0 {
+		m.ResetTTL = Duration(time.Hour)
+	}
+
+	if m.ResendInterval == 0 {
+		m.ResendInterval = Duration(time.Minute)
+	}
+}
+
+func setLoginLimitsDefaults(l *LoginLimits) {
+	if l.FreeAttempts == 0 {
+		l.FreeAttempts = 3
+	}
+
+	if l.BaseDelay == 0 {
+		l.BaseDelay = Duration(time.Second)
+	}
+
+	if l.MaxDelay == 0 {
+		l.MaxDelay = Duration(5 * time.Minute)
+	}
+
+	if l.MaxFailures == 0 {
+		l.MaxFailures = 10
+	}
+
+	if l.IPMaxFailures == 0 {
+		l.IPMaxFailures = 100
+	}
+
+	if l.LockoutDuration == 0 {
+		l.LockoutDuration = Duration(15 * time.Minute)
+	}
+
+	if l.ResetAfter == 0 {
+		l.ResetAfter = Duration(time.Hour)
+	}
+}
+
+// Duration is time.Duration written in config as a string like "15m"
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duration should be a string: %w", err)
+	}
+
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+
+	*d = Duration(v)
+
+	return nil
+}
+
+// flags are command line options of the server
+type flags struct {
+	configPath string
+	storage    string
+}
+
+func parseFlags() flags {
+	var res flags
+
+	flag.StringVar(&res.configPath, "config", "", "path to config file")
+	flag.StringVar(&res.storage, "storage", "", `storage overriding the config, "database" or "memory"`)
+	flag.Parse()
 
 	if os.Getenv("CONFIG_PATH") != "" {
-		res = os.Getenv("CONFIG_PATH")
+		res.configPath = os.Getenv("CONFIG_PATH")
 
 		return res
 	}
 
-	flag.StringVar(&res, "config", "", "path to config file")
-	flag.Parse()
-	fmt.Println(res)
+	fmt.Println(res.configPath)
 
 	return res
 }
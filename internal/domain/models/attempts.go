@@ -0,0 +1,10 @@
+package models
+
+import "time"
+
+// LoginAttempts - неудачные попытки входа по email или IP клиента
+type LoginAttempts struct {
+	Key          string    // "email:<email>" или "ip:<ip>"
+	Failures     int       // Неудачные попытки подряд
+	BlockedUntil time.Time // До этого времени попытки входа отклоняются
+}
@@ -1,7 +1,23 @@
 package models
 
+import "time"
+
 // Data - структура для хранения данных
 type Data struct {
-	DataType string // Тип данных (например, текст, пароль, карта и т.д.)
-	Content  string // Основное содержимое данных
+	ID        int64     // Идентификатор записи
+	DataType  string    // Тип данных (например, текст, пароль, карта и т.д.)
+	Content   string    // Основное содержимое данных
+	CreatedAt time.Time // Время создания записи
+	UpdatedAt time.Time // Время последнего изменения записи
+	Files     []File    // Файлы, прикреплённые к записи
+	Legacy    bool      // Запись зашифрована без привязки к владельцу и ещё не перешифрована
+	Untrusted bool      // Содержимое не проверено ключом хранилища клиента и показано в том виде, в каком его прислал сервер
+}
+
+// LegacyRecord - запись, зашифрованная без привязки к владельцу, её нужно перешифровать
+type LegacyRecord struct {
+	ID       int64
+	UserID   int64
+	DataType string
+	Content  string
 }
@@ -0,0 +1,18 @@
+package models
+
+import "time"
+
+// Назначения токенов из писем
+const (
+	EmailTokenVerify = "verify_email"
+	EmailTokenReset  = "reset_password"
+)
+
+// EmailToken - одноразовый токен, отправленный пользователю письмом
+type EmailToken struct {
+	Hash      string    // SHA-256 токена, сам токен не хранится
+	UserID    int64     // Пользователь, которому отправлено письмо
+	Purpose   string    // EmailTokenVerify или EmailTokenReset
+	ExpiresAt time.Time // Время истечения токена
+	CreatedAt time.Time // Время отправки письма
+}
@@ -0,0 +1,28 @@
+package models
+
+import "time"
+
+// File - файл, прикреплённый к записи, содержимое хранится по частям
+type File struct {
+	ID       int64 // Идентификатор файла
+	RecordID int64 // Идентификатор записи, к которой прикреплён файл
+	// Имя, размер, контрольная сумма и ключ файла, зашифрованные на клиенте
+	Meta           string
+	ChunkCount     int       // Число частей файла
+	UploadedChunks int       // Число загруженных частей, части загружаются по порядку
+	CreatedAt      time.Time // Время начала загрузки
+	CompletedAt    time.Time // Время загрузки последней части, нулевое пока загрузка не завершена
+}
+
+// Completed - все части файла загружены
+func (f File) Completed() bool {
+	return f.UploadedChunks == f.ChunkCount
+}
+
+// FileChunk - часть содержимого файла
+type FileChunk struct {
+	FileID   int64  // Идентификатор файла
+	Index    int    // Номер части, начиная с 0
+	Data     []byte // Содержимое части
+	Checksum []byte // SHA-256 содержимого части
+}
@@ -0,0 +1,8 @@
+package models
+
+// UserKey - ключ данных пользователя, зашифрованный мастер-ключом
+type UserKey struct {
+	UserID     int64
+	WrappedKey string
+	KeyID      uint32 // Идентификатор мастер-ключа, которым зашифрован ключ
+}
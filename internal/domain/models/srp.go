@@ -0,0 +1,39 @@
+package models
+
+import "time"
+
+// SRPVerifier - данные для входа по SRP, хранятся вместо хеша пароля
+type SRPVerifier struct {
+	Salt     []byte // Соль для вывода ключа из пароля
+	Params   string // Параметры Argon2id для вывода ключа в виде m=..,t=..,p=..
+	Verifier []byte // g^x, пароль из него можно получить только перебором
+}
+
+// SRPSession - первый раунд входа по SRP, ожидающий доказательство клиента
+type SRPSession struct {
+	Hash         string    // SHA-256 идентификатора сессии, сам идентификатор не хранится
+	UserID       int64     // Пользователь, начавший вход
+	ClientPublic []byte    // Публичное значение A клиента
+	ServerSecret string    // Секрет b сервера, зашифрованный ключом пользователя
+	ExpiresAt    time.Time // Время истечения сессии
+}
+
+// SRPChallenge - ответ на первый раунд входа по SRP
+type SRPChallenge struct {
+	Session      string // Идентификатор сессии для второго раунда
+	Salt         []byte // Соль пользователя
+	Params       string // Параметры Argon2id пользователя
+	ServerPublic []byte // Публичное значение B сервера
+	Legacy       bool   // У пользователя ещё нет верификатора, нужно войти паролем через Login
+	// Параметры Argon2id, если верификатор выведен с устаревшими, клиент присылает новый верификатор с ними
+	UpgradeParams string
+}
+
+// PasswordSettings - требования к новым паролям и параметры вывода ключа, клиент сам проверяет пароль
+// и вычисляет верификатор, пароль на сервер не передаётся
+type PasswordSettings struct {
+	Params       string // Параметры Argon2id новых верификаторов
+	MinLength    int    // Минимальная длина пароля
+	MinScore     int    // Минимальная оценка стойкости пароля
+	RejectCommon bool   // Распространённые пароли запрещены
+}
@@ -0,0 +1,18 @@
+package models
+
+import "time"
+
+// TokenPair - токены, выдаваемые при входе
+type TokenPair struct {
+	AccessToken  string    // Короткоживущий JWT для доступа к данным
+	RefreshToken string    // Одноразовый токен для получения новой пары
+	ExpiresAt    time.Time // Время истечения AccessToken
+}
+
+// RefreshToken - refresh-токен, хранящийся на сервере
+type RefreshToken struct {
+	Hash      string    // SHA-256 токена, сам токен не хранится
+	UserID    int64     // Владелец токена
+	FamilyID  string    // Все токены, полученные из одного входа, образуют семейство
+	ExpiresAt time.Time // Время истечения токена
+}
@@ -0,0 +1,30 @@
+package models
+
+import "time"
+
+// TOTP - второй фактор пользователя
+type TOTP struct {
+	UserID  int64
+	Secret  string // Секрет, зашифрованный ключом пользователя
+	Enabled bool   // Секрет подтверждён кодом, вход требует второй фактор
+}
+
+// TOTPEnrollment - данные для добавления секрета в приложение-аутентификатор
+type TOTPEnrollment struct {
+	Secret string // Секрет в base32 для ручного ввода
+	URI    string // otpauth URI, показывается QR-кодом
+}
+
+// LoginChallenge - незавершённый вход, ожидающий второй фактор
+type LoginChallenge struct {
+	Hash      string    // SHA-256 challenge, сам challenge не хранится
+	UserID    int64     // Пользователь, прошедший проверку пароля
+	ExpiresAt time.Time // Время истечения challenge
+}
+
+// LoginResult - результат проверки пароля
+type LoginResult struct {
+	Tokens      TokenPair // Токены, если второй фактор не нужен
+	Challenge   string    // Не пустой, если вход нужно завершить кодом TOTP
+	ServerProof []byte    // Доказательство сервера M2 при входе по SRP
+}
@@ -1,8 +1,14 @@
 package models
 
+import "time"
+
 // User - структура для хранения данных о пользователе
 type User struct {
-	ID       int64
-	Email    string
-	PassHash []byte
+	ID            int64
+	Email         string
+	PassHash      []byte      // Хеш пароля пользователей, ещё не перешедших на SRP
+	SRP           SRPVerifier // Верификатор SRP, пустой у пользователей со старым хешем
+	EmailVerified bool        // Email подтверждён токеном из письма
+	Deleted       bool        // Аккаунт удалён и будет окончательно стёрт после периода восстановления
+	DeletedAt     time.Time   // Время удаления аккаунта
 }
@@ -0,0 +1,179 @@
+package authgrpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/nglmq/password-keeper/internal/lib/apierror"
+	"github.com/nglmq/password-keeper/internal/lib/password"
+	"github.com/nglmq/password-keeper/internal/services/auth"
+	"github.com/nglmq/password-keeper/internal/storage"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/protoadapt"
+)
+
+// RetryAfterHeader is the header with seconds to wait before next login attempt
+const RetryAfterHeader = "retry-after"
+
+// errorStatus describes status returned for errors of the services
+type errorStatus struct {
+	err    error
+	code   codes.Code
+	reason string
+	msg    string
+	// field of the request the error is about, it is reported with BadRequest detail
+	field string
+}
+
+// errorStatuses translate errors of the services to statuses, the first matching one is used
+var errorStatuses = []errorStatus{
+	{err: auth.ErrInvalidCredentials, code: codes.Unauthenticated, reason: apierror.ReasonInvalidCredentials,
+		msg: "wrong login or password"},
+	{err: auth.ErrInvalidToken, code: codes.Unauthenticated, reason: apierror.ReasonInvalidToken,
+		msg: "invalid or expired token"},
+	{err: auth.ErrInvalidChallenge, code: codes.Unauthenticated, reason: apierror.ReasonInvalidChallenge,
+		msg: "login challenge expired, login again"},
+	{err: auth.ErrInvalidTOTPCode, code: codes.Unauthenticated, reason: apierror.ReasonInvalidTOTPCode,
+		msg: "invalid two-factor code"},
+	{err: auth.ErrTOTPAlreadyEnabled, code: codes.FailedPrecondition, reason: apierror.ReasonTOTPAlreadyEnabled,
+		msg: "two-factor authentication already enabled"},
+	{err: auth.ErrTOTPNotEnrolled, code: codes.FailedPrecondition, reason: apierror.ReasonTOTPNotEnrolled,
+		msg: "two-factor authentication is not enrolled"},
+	{err: auth.ErrUserAlreadyExists, code: codes.AlreadyExists, reason: apierror.ReasonUserExists,
+		msg: "user already exists"},
+	{err: storage.ErrUserNotFound, code: codes.NotFound, reason: apierror.ReasonUserNotFound,
+		msg: "user not found"},
+	{err: auth.ErrAccountDeleted, code: codes.FailedPrecondition, reason: apierror.ReasonAccountDeleted,
+		msg: "account is deleted, restore it first"},
+	{err: auth.ErrAccountNotDeleted, code: codes.FailedPrecondition, reason: apierror.ReasonAccountNotDeleted,
+		msg: "account is not deleted or can not be restored anymore"},
+	{err: auth.ErrInvalidEmail, code: codes.InvalidArgument, reason: apierror.ReasonInvalidEmail,
+		msg: "invalid email", field: "email"},
+	{err: auth.ErrInvalidEmailToken, code: codes.InvalidArgument, reason: apierror.ReasonInvalidEmailCode,
+		msg: "invalid or expired code", field: "code"},
+	{err: auth.ErrEmailNotVerified, code: codes.PermissionDenied, reason: apierror.ReasonEmailNotVerified,
+		msg: "email is not verified, check your inbox"},
+	{err: auth.ErrEmailAlreadyVerified, code: codes.FailedPrecondition, reason: apierror.ReasonEmailAlreadyVerified,
+		msg: "email already verified"},
+	{err: auth.ErrVaultChanged, code: codes.Aborted, reason: apierror.ReasonVaultChanged,
+		msg: "records changed, sync and retry"},
+	{err: auth.ErrInvalidVaultSalt, code: codes.InvalidArgument, reason: apierror.ReasonInvalidVaultSalt,
+		msg: "invalid vault salt", field: "vault_salt"},
+	{err: auth.ErrInvalidVerifier, code: codes.InvalidArgument, reason: apierror.ReasonInvalidVerifier,
+		msg: "invalid password verifier", field: "verifier"},
+	{err: auth.ErrDataIntegrity, code: codes.DataLoss, reason: apierror.ReasonDataIntegrity,
+		msg: "data integrity check failed"},
+	{err: auth.ErrRecordNotFound, code: codes.NotFound, reason: apierror.ReasonRecordNotFound,
+		msg: "record not found", field: "id"},
+	{err: auth.ErrInvalidReservation, code: codes.InvalidArgument, reason: apierror.ReasonInvalidReservation,
+		msg: "record id is not reserved", field: "reservation"},
+	{err: auth.ErrFileNotFound, code: codes.NotFound, reason: apierror.ReasonFileNotFound,
+		msg: "file not found", field: "file_id"},
+	{err: auth.ErrChecksumMismatch, code: codes.DataLoss, reason: apierror.ReasonChecksumMismatch,
+		msg: "chunk checksum mismatch, upload the chunk again", field: "checksum"},
+	{err: auth.ErrUnexpectedChunk, code: codes.FailedPrecondition, reason: apierror.ReasonUnexpectedChunk,
+		msg: "unexpected chunk, get the upload and continue from the next chunk", field: "index"},
+	{err: auth.ErrEmptyUpload, code: codes.InvalidArgument, reason: apierror.ReasonEmptyUpload,
+		msg: "no chunks uploaded"},
+	{err: auth.ErrUploadIncomplete, code: codes.FailedPrecondition, reason: apierror.ReasonUploadIncomplete,
+		msg: "file upload is not completed"},
+	{err: auth.ErrQuotaExceeded, code: codes.ResourceExhausted, reason: apierror.ReasonQuotaExceeded,
+		msg: "quota exceeded"},
+}
+
+// wrongPassword is used by methods asking the password of the signed in user again,
+// PermissionDenied keeps the client from taking it for the expired session
+var wrongPassword = errorStatus{
+	err:    auth.ErrInvalidCredentials,
+	code:   codes.PermissionDenied,
+	reason: apierror.ReasonWrongPassword,
+	msg:    "wrong password",
+}
+
+// confirmTOTPCode is used by ConfirmTOTP, the wrong code there does not fail authentication of the user
+var confirmTOTPCode = errorStatus{
+	err:    auth.ErrInvalidTOTPCode,
+	code:   codes.InvalidArgument,
+	reason: apierror.ReasonInvalidTOTPCode,
+	msg:    "invalid code",
+	field:  "code",
+}
+
+// toStatus translates error returned by a service to status error, overrides are checked before errorStatuses.
+// Errors of login limits and of the password policy get their own details, unknown errors become Internal.
+func toStatus(ctx context.Context, err error, overrides ...errorStatus) error {
+	var limitErr *auth.LimitError
+	if errors.As(err, &limitErr) {
+		return limitStatus(ctx, limitErr)
+	}
+
+	var policyErr *password.PolicyError
+	if errors.As(err, &policyErr) {
+		return policyStatus(policyErr)
+	}
+
+	for _, statuses := range [][]errorStatus{overrides, errorStatuses} {
+		for _, s := range statuses {
+			if errors.Is(err, s.err) {
+				return s.status()
+			}
+		}
+	}
+
+	return status.Error(codes.Internal, "internal error")
+}
+
+func (s errorStatus) status() error {
+	var details []protoadapt.MessageV1
+	if s.field != "" {
+		details = append(details, apierror.BadRequest(s.field, s.msg))
+	}
+
+	return apierror.Error(s.code, s.reason, s.msg, nil, details...)
+}
+
+// limitStatus returns status of rejected login attempt with RetryInfo detail,
+// the time to wait is also sent in retry-after header
+func limitStatus(ctx context.Context, err *auth.LimitError) error {
+	seconds := int64(math.Ceil(err.RetryAfter.Seconds()))
+
+	// fails only when called outside of a handler, the status still tells the client to wait
+	_ = grpc.SetHeader(ctx, metadata.Pairs(RetryAfterHeader, strconv.FormatInt(seconds, 10)))
+
+	if err.Locked {
+		return apierror.Error(codes.Unavailable, apierror.ReasonAccountLocked,
+			fmt.Sprintf("account temporarily locked, retry after %d seconds", seconds),
+			nil, apierror.RetryInfo(err.RetryAfter))
+	}
+
+	return apierror.Error(codes.ResourceExhausted, apierror.ReasonTooManyAttempts,
+		fmt.Sprintf("too many attempts, retry after %d seconds", seconds),
+		nil, apierror.RetryInfo(err.RetryAfter))
+}
+
+// policyStatus returns InvalidArgument with a BadRequest violation of the password field for every broken rule
+// and ErrorInfo with the rules and the strength of the password
+func policyStatus(err *password.PolicyError) error {
+	descriptions := make([]string, 0, len(err.Violations))
+	rules := make([]string, 0, len(err.Violations))
+	for _, v := range err.Violations {
+		descriptions = append(descriptions, v.Description)
+		rules = append(rules, v.Rule)
+	}
+
+	return apierror.Error(codes.InvalidArgument, apierror.ReasonWeakPassword,
+		"password does not meet the password policy",
+		map[string]string{
+			"rules": strings.Join(rules, ","),
+			"score": strconv.Itoa(err.Strength.Score),
+		},
+		apierror.BadRequest("password", descriptions...),
+	)
+}
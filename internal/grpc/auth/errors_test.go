@@ -0,0 +1,100 @@
+package authgrpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/nglmq/password-keeper/internal/lib/apierror"
+	"github.com/nglmq/password-keeper/internal/services/auth"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func Test_toStatus(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		overrides  []errorStatus
+		wantCode   codes.Code
+		wantReason string
+		wantField  string
+	}{
+		{
+			name:       "Invalid credentials",
+			err:        auth.ErrInvalidCredentials,
+			wantCode:   codes.Unauthenticated,
+			wantReason: apierror.ReasonInvalidCredentials,
+		},
+		{
+			name:       "Wrapped error",
+			err:        fmt.Errorf("failed to refresh: %w", auth.ErrInvalidToken),
+			wantCode:   codes.Unauthenticated,
+			wantReason: apierror.ReasonInvalidToken,
+		},
+		{
+			name:       "User exists",
+			err:        auth.ErrUserAlreadyExists,
+			wantCode:   codes.AlreadyExists,
+			wantReason: apierror.ReasonUserExists,
+		},
+		{
+			name:       "Field error",
+			err:        auth.ErrInvalidEmailToken,
+			wantCode:   codes.InvalidArgument,
+			wantReason: apierror.ReasonInvalidEmailCode,
+			wantField:  "code",
+		},
+		{
+			name:       "Override",
+			err:        auth.ErrInvalidCredentials,
+			overrides:  []errorStatus{wrongPassword},
+			wantCode:   codes.PermissionDenied,
+			wantReason: apierror.ReasonWrongPassword,
+		},
+		{
+			name:       "Too many attempts",
+			err:        &auth.LimitError{RetryAfter: 4 * time.Second},
+			wantCode:   codes.ResourceExhausted,
+			wantReason: apierror.ReasonTooManyAttempts,
+		},
+		{
+			name:       "Quota exceeded",
+			err:        fmt.Errorf("%w: file of 4097 chunks, at most 4096", auth.ErrQuotaExceeded),
+			wantCode:   codes.ResourceExhausted,
+			wantReason: apierror.ReasonQuotaExceeded,
+		},
+		{
+			name:     "Unknown error",
+			err:      errors.New("connection refused"),
+			wantCode: codes.Internal,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := toStatus(context.Background(), tt.err, tt.overrides...)
+
+			assert.Equal(t, tt.wantCode, status.Code(err))
+			assert.Equal(t, tt.wantReason, apierror.Reason(err))
+
+			if tt.wantField != "" {
+				assert.NotEmpty(t, apierror.FieldViolations(err, tt.wantField))
+			}
+		})
+	}
+}
+
+func Test_limitStatus_RetryInfo(t *testing.T) {
+	err := toStatus(context.Background(), &auth.LimitError{RetryAfter: time.Minute, Locked: true})
+
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+	assert.Equal(t, apierror.ReasonAccountLocked, apierror.Reason(err))
+
+	delay, ok := apierror.RetryDelay(err)
+	assert.True(t, ok)
+	assert.Equal(t, time.Minute, delay)
+}
@@ -0,0 +1,119 @@
+package authgrpc
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+
+	sso "github.com/nglmq/password-keeper/gen/go/sso"
+	"github.com/nglmq/password-keeper/internal/lib/apierror"
+	"github.com/nglmq/password-keeper/internal/lib/jwt"
+	"github.com/nglmq/password-keeper/internal/lib/logger/sl"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+)
+
+// AuthorizationHeader is the metadata key of the access token, the value is "Bearer <token>"
+const AuthorizationHeader = "authorization"
+
+const bearerPrefix = "bearer "
+
+// PublicMethods can be called without access token, all other methods require it
+var PublicMethods = map[string]bool{
+	sso.Auth_GetPasswordSettings_FullMethodName:  true,
+	sso.Auth_Register_FullMethodName:             true,
+	sso.Auth_Login_FullMethodName:                true,
+	sso.Auth_LoginStart_FullMethodName:           true,
+	sso.Auth_LoginFinish_FullMethodName:          true,
+	sso.Auth_LoginTOTP_FullMethodName:            true,
+	sso.Auth_Refresh_FullMethodName:              true,
+	sso.Auth_RestoreAccount_FullMethodName:       true,
+	sso.Auth_VerifyEmail_FullMethodName:          true,
+	sso.Auth_RequestPasswordReset_FullMethodName: true,
+	sso.Auth_ResetPassword_FullMethodName:        true,
+}
+
+type TokenParser interface {
+	ParseToken(ctx context.Context, token string) (jwt.Claims, error)
+}
+
+// UnaryAuthInterceptor authenticates calls of methods not in PublicMethods with the access token from
+// authorization metadata and puts its claims into the context of the handler, see jwt.FromContext
+func UnaryAuthInterceptor(log *slog.Logger, tokens TokenParser) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if PublicMethods[info.FullMethod] {
+			return handler(ctx, req)
+		}
+
+		ctx, err := authenticate(ctx, log, tokens, info.FullMethod)
+		if err != nil {
+			return nil, err
+		}
+
+		return handler(ctx, req)
+	}
+}
+
+// StreamAuthInterceptor authenticates streams as UnaryAuthInterceptor does
+func StreamAuthInterceptor(log *slog.Logger, tokens TokenParser) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if PublicMethods[info.FullMethod] {
+			return handler(srv, ss)
+		}
+
+		ctx, err := authenticate(ss.Context(), log, tokens, info.FullMethod)
+		if err != nil {
+			return err
+		}
+
+		return handler(srv, &authenticatedStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+// authenticate returns context with claims of the access token of the call,
+// missing, invalid, expired and revoked tokens are rejected with the same Unauthenticated status
+func authenticate(ctx context.Context, log *slog.Logger, tokens TokenParser, method string) (context.Context, error) {
+	token, ok := bearerToken(ctx)
+	if !ok {
+		return nil, apierror.Error(codes.Unauthenticated, apierror.ReasonInvalidToken, "missing access token", nil)
+	}
+
+	claims, err := tokens.ParseToken(ctx, token)
+	if err != nil {
+		log.Info("authentication failed", slog.String("method", method), sl.Err(err))
+
+		return nil, apierror.Error(codes.Unauthenticated, apierror.ReasonInvalidToken, "invalid access token", nil)
+	}
+
+	return jwt.NewContext(ctx, claims), nil
+}
+
+// bearerToken returns the token from authorization metadata of the incoming call
+func bearerToken(ctx context.Context) (string, bool) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return "", false
+	}
+
+	values := md.Get(AuthorizationHeader)
+	if len(values) != 1 {
+		return "", false
+	}
+
+	if len(values[0]) <= len(bearerPrefix) || !strings.EqualFold(values[0][:len(bearerPrefix)], bearerPrefix) {
+		return "", false
+	}
+
+	return strings.TrimSpace(values[0][len(bearerPrefix):]), true
+}
+
+// authenticatedStream replaces context of the stream with the authenticated one
+type authenticatedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *authenticatedStream) Context() context.Context {
+	return s.ctx
+}
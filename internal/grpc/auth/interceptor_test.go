@@ -0,0 +1,118 @@
+package authgrpc
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"testing"
+
+	"github.com/nglmq/password-keeper/internal/lib/jwt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	sso "github.com/nglmq/password-keeper/gen/go/sso"
+)
+
+// userCtx is context of a call authenticated by the interceptor as user 1
+var userCtx = jwt.NewContext(context.Background(), jwt.Claims{UID: 1})
+
+type stubTokenParser map[string]jwt.Claims
+
+func (p stubTokenParser) ParseToken(_ context.Context, token string) (jwt.Claims, error) {
+	claims, ok := p[token]
+	if !ok {
+		return jwt.Claims{}, errors.New("invalid token")
+	}
+
+	return claims, nil
+}
+
+func TestUnaryAuthInterceptor(t *testing.T) {
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	interceptor := UnaryAuthInterceptor(log, stubTokenParser{"valid-token": {UID: 42}})
+
+	tests := []struct {
+		name        string
+		method      string
+		md          metadata.MD
+		wantUserID  int64
+		wantErrCode codes.Code
+	}{
+		{
+			name:       "Valid token",
+			method:     sso.UserData_GetData_FullMethodName,
+			md:         metadata.Pairs(AuthorizationHeader, "Bearer valid-token"),
+			wantUserID: 42,
+		},
+		{
+			name:       "Scheme is case-insensitive",
+			method:     sso.Auth_EnrollTOTP_FullMethodName,
+			md:         metadata.Pairs(AuthorizationHeader, "bearer valid-token"),
+			wantUserID: 42,
+		},
+		{
+			name:        "Missing token",
+			method:      sso.UserData_GetData_FullMethodName,
+			wantErrCode: codes.Unauthenticated,
+		},
+		{
+			name:        "Wrong scheme",
+			method:      sso.UserData_GetData_FullMethodName,
+			md:          metadata.Pairs(AuthorizationHeader, "Basic valid-token"),
+			wantErrCode: codes.Unauthenticated,
+		},
+		{
+			name:        "Invalid token",
+			method:      sso.Auth_Logout_FullMethodName,
+			md:          metadata.Pairs(AuthorizationHeader, "Bearer forged-token"),
+			wantErrCode: codes.Unauthenticated,
+		},
+		{
+			name:   "Public method without token",
+			method: sso.Auth_Login_FullMethodName,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tt.md != nil {
+				ctx = metadata.NewIncomingContext(ctx, tt.md)
+			}
+
+			var handlerCtx context.Context
+
+			_, err := interceptor(ctx, nil, &grpc.UnaryServerInfo{FullMethod: tt.method},
+				func(ctx context.Context, _ any) (any, error) {
+					handlerCtx = ctx
+
+					return nil, nil
+				})
+
+			if tt.wantErrCode != codes.OK {
+				assert.Equal(t, tt.wantErrCode, status.Code(err))
+				assert.Nil(t, handlerCtx, "handler must not be called")
+
+				return
+			}
+
+			require.NoError(t, err)
+			require.NotNil(t, handlerCtx)
+
+			claims, ok := jwt.FromContext(handlerCtx)
+			if tt.wantUserID == 0 {
+				assert.False(t, ok)
+
+				return
+			}
+
+			require.True(t, ok)
+			assert.Equal(t, tt.wantUserID, claims.UID)
+		})
+	}
+}
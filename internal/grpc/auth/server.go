@@ -5,22 +5,80 @@ package authgrpc
 import (
 	"context"
 	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	sso "github.com/nglmq/password-keeper/gen/go/sso"
 	"github.com/nglmq/password-keeper/internal/domain/models"
-	"github.com/nglmq/password-keeper/internal/storage"
-	sso "github.com/nglmq/protos/gen/go/sso"
+	"github.com/nglmq/password-keeper/internal/lib/apierror"
+	"github.com/nglmq/password-keeper/internal/lib/jwt"
+	"github.com/nglmq/password-keeper/internal/lib/password"
+	"github.com/nglmq/password-keeper/internal/lib/srp"
+	"github.com/nglmq/password-keeper/internal/services/auth"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
-	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/peer"
 )
 
 type Auth interface {
-	Login(ctx context.Context, email string, password string) (token string, err error)
-	RegisterNewUser(ctx context.Context, email string, password string) (token string, err error)
+	Login(ctx context.Context, email, password, clientIP string) (result models.LoginResult, err error)
+	LoginStart(ctx context.Context, email string, clientPublic []byte, clientIP string) (models.SRPChallenge, error)
+	LoginFinish(
+		ctx context.Context,
+		session string,
+		clientProof []byte,
+		newVerifier *models.SRPVerifier,
+		clientIP string,
+	) (models.LoginResult, error)
+	PasswordSettings(ctx context.Context) models.PasswordSettings
+	RegisterNewUser(
+		ctx context.Context,
+		email string,
+		verifier models.SRPVerifier,
+		report password.Report,
+	) (tokens models.TokenPair, err error)
+	Refresh(ctx context.Context, refreshToken string) (tokens models.TokenPair, err error)
+	Logout(ctx context.Context, claims jwt.Claims, refreshToken string, allDevices bool) error
+	LoginTOTP(ctx context.Context, challenge, code, clientIP string) (tokens models.TokenPair, err error)
+	EnrollTOTP(ctx context.Context, userID int64) (models.TOTPEnrollment, error)
+	ConfirmTOTP(ctx context.Context, userID int64, code string) (recoveryCodes []string, err error)
+	ChangePassword(
+		ctx context.Context,
+		userID int64,
+		session string,
+		clientProof []byte,
+		verifier models.SRPVerifier,
+		report password.Report,
+		vaultSalt []byte,
+		records []models.Data,
+	) (tokens models.TokenPair, serverProof []byte, err error)
+	DeleteAccount(ctx context.Context, userID int64, session string, clientProof []byte) (purgeAt time.Time, err error)
+	RestoreAccount(ctx context.Context, session string, clientProof []byte, clientIP string) error
+	SendVerificationEmail(ctx context.Context, userID int64) error
+	VerifyEmail(ctx context.Context, code string) error
+	RequestPasswordReset(ctx context.Context, email string) error
+	ResetPassword(
+		ctx context.Context,
+		code string,
+		verifier models.SRPVerifier,
+		report password.Report,
+		totpCode, clientIP string,
+	) error
 }
 
 type Data interface {
-	SaveData(ctx context.Context, token, dataType, data string) (string, error)
-	GetData(ctx context.Context, token string) (string, []models.Data, error)
+	ReserveDataID(ctx context.Context, userID int64) (int64, string, error)
+	SaveData(ctx context.Context, userID, id int64, reservation, dataType, data string) (models.Data, error)
+	UpdateData(ctx context.Context, userID, id int64, dataType, data string) (models.Data, error)
+	DeleteData(ctx context.Context, userID, id int64) error
+	GetData(ctx context.Context, userID int64) ([]models.Data, error)
+	GetVaultSalt(ctx context.Context, userID int64) ([]byte, error)
+	CreateUpload(ctx context.Context, userID, recordID int64, meta string, chunkCount int) (models.File, error)
+	UploadFile(ctx context.Context, userID int64, recv func() (models.FileChunk, error)) (models.File, error)
+	GetUpload(ctx context.Context, userID, fileID int64) (models.File, error)
+	DownloadFile(ctx context.Context, userID, fileID int64, from int, send func(models.FileChunk) error) error
 }
 
 type serverAPI struct {
@@ -39,95 +97,745 @@ func Register(gRPC *grpc.Server, auth Auth, data Data) {
 // Login logs in a user
 func (s *serverAPI) Login(ctx context.Context, req *sso.LoginRequest) (*sso.LoginResponse, error) {
 	if req.GetEmail() == "" {
-		return nil, status.Error(codes.InvalidArgument, "email should not be empty")
+		return nil, apierror.FieldError("email", "email should not be empty")
 	}
 
 	if req.GetPassword() == "" {
-		return nil, status.Error(codes.InvalidArgument, "password should not be empty")
+		return nil, apierror.FieldError("password", "password should not be empty")
+	}
+
+	result, err := s.auth.Login(ctx, req.GetEmail(), req.GetPassword(), clientIP(ctx))
+	if err != nil {
+		return nil, toStatus(ctx, err)
+	}
+
+	return loginResponse(result), nil
+}
+
+// LoginStart starts SRP login, the password is not sent to the server
+func (s *serverAPI) LoginStart(ctx context.Context, req *sso.LoginStartRequest) (*sso.LoginStartResponse, error) {
+	if req.GetEmail() == "" {
+		return nil, apierror.FieldError("email", "email should not be empty")
+	}
+
+	if len(req.GetClientPublic()) != srp.PublicSize {
+		return nil, apierror.FieldError("client_public", fmt.Sprintf("client public value should be %d bytes", srp.PublicSize))
+	}
+
+	challenge, err := s.auth.LoginStart(ctx, req.GetEmail(), req.GetClientPublic(), clientIP(ctx))
+	if err != nil {
+		return nil, toStatus(ctx, err)
+	}
+
+	return &sso.LoginStartResponse{
+		Session:          challenge.Session,
+		Salt:             challenge.Salt,
+		KdfParams:        challenge.Params,
+		ServerPublic:     challenge.ServerPublic,
+		Legacy:           challenge.Legacy,
+		UpgradeKdfParams: challenge.UpgradeParams,
+	}, nil
+}
+
+// LoginFinish completes SRP login with the client proof, the verifier is upgraded if the client sent a new one
+func (s *serverAPI) LoginFinish(ctx context.Context, req *sso.LoginFinishRequest) (*sso.LoginResponse, error) {
+	if req.GetSession() == "" {
+		return nil, apierror.FieldError("session", "session should not be empty")
 	}
 
-	token, err := s.auth.Login(ctx, req.GetEmail(), req.GetPassword())
+	if len(req.GetClientProof()) == 0 {
+		return nil, apierror.FieldError("client_proof", "client proof should not be empty")
+	}
+
+	var newVerifier *models.SRPVerifier
+	if req.GetNewVerifier() != nil {
+		v := srpVerifier(req.GetNewVerifier())
+		newVerifier = &v
+	}
+
+	result, err := s.auth.LoginFinish(ctx, req.GetSession(), req.GetClientProof(), newVerifier, clientIP(ctx))
 	if err != nil {
-		if errors.Is(err, storage.ErrUserNotFound) {
-			return nil, status.Error(codes.NotFound, "user not found")
+		return nil, toStatus(ctx, err)
+	}
+
+	return loginResponse(result), nil
+}
+
+func loginResponse(result models.LoginResult) *sso.LoginResponse {
+	if result.Challenge != "" {
+		return &sso.LoginResponse{
+			Challenge:   result.Challenge,
+			ServerProof: result.ServerProof,
 		}
+	}
 
-		return nil, status.Error(codes.Internal, "internal error")
+	return &sso.LoginResponse{
+		Token:        result.Tokens.AccessToken,
+		RefreshToken: result.Tokens.RefreshToken,
+		ExpiresAt:    result.Tokens.ExpiresAt.Unix(),
+		ServerProof:  result.ServerProof,
+	}
+}
+
+// LoginTOTP completes login of the user with TOTP enabled
+func (s *serverAPI) LoginTOTP(ctx context.Context, req *sso.LoginTOTPRequest) (*sso.LoginResponse, error) {
+	if req.GetChallenge() == "" {
+		return nil, apierror.FieldError("challenge", "challenge should not be empty")
+	}
+
+	if req.GetCode() == "" {
+		return nil, apierror.FieldError("code", "code should not be empty")
+	}
+
+	tokens, err := s.auth.LoginTOTP(ctx, req.GetChallenge(), req.GetCode(), clientIP(ctx))
+	if err != nil {
+		return nil, toStatus(ctx, err)
 	}
 
 	return &sso.LoginResponse{
-		Token: token,
+		Token:        tokens.AccessToken,
+		RefreshToken: tokens.RefreshToken,
+		ExpiresAt:    tokens.ExpiresAt.Unix(),
 	}, nil
 }
 
-// Register registers a new user
-func (s *serverAPI) Register(ctx context.Context, req *sso.RegisterRequest) (*sso.RegisterResponse, error) {
-	if req.GetEmail() == "" {
-		return nil, status.Error(codes.InvalidArgument, "email should not be empty")
+// EnrollTOTP generates TOTP secret of the user
+func (s *serverAPI) EnrollTOTP(ctx context.Context, req *sso.EnrollTOTPRequest) (*sso.EnrollTOTPResponse, error) {
+	userID, err := authUserID(ctx)
+	if err != nil {
+		return nil, err
 	}
 
-	if req.GetPassword() == "" {
-		return nil, status.Error(codes.InvalidArgument, "password should not be empty")
+	enrollment, err := s.auth.EnrollTOTP(ctx, userID)
+	if err != nil {
+		return nil, toStatus(ctx, err)
+	}
+
+	return &sso.EnrollTOTPResponse{
+		Secret: enrollment.Secret,
+		Uri:    enrollment.URI,
+	}, nil
+}
+
+// ConfirmTOTP enables enrolled TOTP secret of the user
+func (s *serverAPI) ConfirmTOTP(ctx context.Context, req *sso.ConfirmTOTPRequest) (*sso.ConfirmTOTPResponse, error) {
+	if req.GetCode() == "" {
+		return nil, apierror.FieldError("code", "code should not be empty")
 	}
 
-	token, err := s.auth.RegisterNewUser(ctx, req.GetEmail(), req.GetPassword())
+	userID, err := authUserID(ctx)
 	if err != nil {
-		if errors.Is(err, storage.ErrUserExists) {
-			return nil, status.Error(codes.AlreadyExists, "user already exists")
+		return nil, err
+	}
+
+	recoveryCodes, err := s.auth.ConfirmTOTP(ctx, userID, req.GetCode())
+	if err != nil {
+		return nil, toStatus(ctx, err, confirmTOTPCode)
+	}
+
+	return &sso.ConfirmTOTPResponse{
+		RecoveryCodes: recoveryCodes,
+	}, nil
+}
+
+// ChangePassword changes password of the user proved with SRP and replaces records with ones re-encrypted
+// with the new password
+func (s *serverAPI) ChangePassword(ctx context.Context, req *sso.ChangePasswordRequest) (*sso.ChangePasswordResponse, error) {
+	if req.GetSession() == "" {
+		return nil, apierror.FieldError("session", "session should not be empty")
+	}
+
+	if len(req.GetClientProof()) == 0 {
+		return nil, apierror.FieldError("client_proof", "client proof should not be empty")
+	}
+
+	if req.GetVerifier() == nil {
+		return nil, apierror.FieldError("verifier", "verifier should not be empty")
+	}
+
+	records := make([]models.Data, 0, len(req.GetData()))
+	for _, d := range req.GetData() {
+		if d.GetContent() == "" {
+			return nil, apierror.FieldError("data", "data should not be empty")
 		}
 
-		return nil, status.Error(codes.Internal, "internal error")
+		var files []models.File
+		for _, f := range d.GetFiles() {
+			if f.GetMeta() == "" {
+				return nil, apierror.FieldError("meta", "meta should not be empty")
+			}
+
+			files = append(files, models.File{
+				ID:   f.GetId(),
+				Meta: f.GetMeta(),
+			})
+		}
+
+		records = append(records, models.Data{
+			ID:       d.GetId(),
+			DataType: d.GetDataType(),
+			Content:  d.GetContent(),
+			Files:    files,
+		})
+	}
+
+	userID, err := authUserID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	tokens, serverProof, err := s.auth.ChangePassword(ctx, userID, req.GetSession(), req.GetClientProof(),
+		srpVerifier(req.GetVerifier()), passwordReport(req.GetPolicyReport()), req.GetVaultSalt(), records)
+	if err != nil {
+		return nil, toStatus(ctx, err, wrongPassword)
+	}
+
+	return &sso.ChangePasswordResponse{
+		Token:        tokens.AccessToken,
+		RefreshToken: tokens.RefreshToken,
+		ExpiresAt:    tokens.ExpiresAt.Unix(),
+		ServerProof:  serverProof,
+	}, nil
+}
+
+// DeleteAccount deletes account of the user proved with SRP, it can be restored during the grace period
+func (s *serverAPI) DeleteAccount(ctx context.Context, req *sso.DeleteAccountRequest) (*sso.DeleteAccountResponse, error) {
+	if req.GetSession() == "" {
+		return nil, apierror.FieldError("session", "session should not be empty")
+	}
+
+	if len(req.GetClientProof()) == 0 {
+		return nil, apierror.FieldError("client_proof", "client proof should not be empty")
+	}
+
+	userID, err := authUserID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	purgeAt, err := s.auth.DeleteAccount(ctx, userID, req.GetSession(), req.GetClientProof())
+	if err != nil {
+		return nil, toStatus(ctx, err, wrongPassword)
+	}
+
+	return &sso.DeleteAccountResponse{
+		PurgeAt: purgeAt.Unix(),
+	}, nil
+}
+
+// RestoreAccount restores deleted account, the password is proved with SRP
+func (s *serverAPI) RestoreAccount(ctx context.Context, req *sso.RestoreAccountRequest) (*sso.RestoreAccountResponse, error) {
+	if req.GetSession() == "" {
+		return nil, apierror.FieldError("session", "session should not be empty")
+	}
+
+	if len(req.GetClientProof()) == 0 {
+		return nil, apierror.FieldError("client_proof", "client proof should not be empty")
+	}
+
+	err := s.auth.RestoreAccount(ctx, req.GetSession(), req.GetClientProof(), clientIP(ctx))
+	if err != nil {
+		return nil, toStatus(ctx, err)
+	}
+
+	return &sso.RestoreAccountResponse{}, nil
+}
+
+// SendVerificationEmail sends a new verification email to the signed in user
+func (s *serverAPI) SendVerificationEmail(
+	ctx context.Context,
+	req *sso.SendVerificationEmailRequest,
+) (*sso.SendVerificationEmailResponse, error) {
+	userID, err := authUserID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.auth.SendVerificationEmail(ctx, userID)
+	if err != nil {
+		return nil, toStatus(ctx, err)
+	}
+
+	return &sso.SendVerificationEmailResponse{}, nil
+}
+
+// VerifyEmail verifies email of the user with the code from the verification email
+func (s *serverAPI) VerifyEmail(ctx context.Context, req *sso.VerifyEmailRequest) (*sso.VerifyEmailResponse, error) {
+	if req.GetCode() == "" {
+		return nil, apierror.FieldError("code", "code should not be empty")
+	}
+
+	err := s.auth.VerifyEmail(ctx, req.GetCode())
+	if err != nil {
+		return nil, toStatus(ctx, err)
+	}
+
+	return &sso.VerifyEmailResponse{}, nil
+}
+
+// RequestPasswordReset sends password reset email if the account exists
+func (s *serverAPI) RequestPasswordReset(
+	ctx context.Context,
+	req *sso.RequestPasswordResetRequest,
+) (*sso.RequestPasswordResetResponse, error) {
+	if req.GetEmail() == "" {
+		return nil, apierror.FieldError("email", "email should not be empty")
+	}
+
+	err := s.auth.RequestPasswordReset(ctx, req.GetEmail())
+	if err != nil {
+		return nil, toStatus(ctx, err)
+	}
+
+	return &sso.RequestPasswordResetResponse{}, nil
+}
+
+// ResetPassword sets new password with the code from the password reset email
+func (s *serverAPI) ResetPassword(ctx context.Context, req *sso.ResetPasswordRequest) (*sso.ResetPasswordResponse, error) {
+	if req.GetCode() == "" {
+		return nil, apierror.FieldError("code", "code should not be empty")
+	}
+
+	if req.GetVerifier() == nil {
+		return nil, apierror.FieldError("verifier", "verifier should not be empty")
+	}
+
+	err := s.auth.ResetPassword(ctx, req.GetCode(), srpVerifier(req.GetVerifier()), passwordReport(req.GetPolicyReport()),
+		req.GetTotpCode(), clientIP(ctx))
+	if err != nil {
+		return nil, toStatus(ctx, err)
+	}
+
+	return &sso.ResetPasswordResponse{}, nil
+}
+
+// passwordReport returns the client's check of a new password, a missing report meets no requirement
+func passwordReport(r *sso.PasswordReport) password.Report {
+	return password.Report{
+		Length: int(r.GetLength()),
+		Score:  int(r.GetScore()),
+		Common: r.GetCommon(),
+		Email:  r.GetEmail(),
+	}
+}
+
+// srpVerifier returns verifier of a new password sent by the client, it is checked by the service
+func srpVerifier(v *sso.SRPVerifier) models.SRPVerifier {
+	return models.SRPVerifier{
+		Salt:     v.GetSalt(),
+		Params:   v.GetKdfParams(),
+		Verifier: v.GetVerifier(),
+	}
+}
+
+// authUserID returns ID of the user authenticated by the auth interceptor
+func authUserID(ctx context.Context) (int64, error) {
+	claims, ok := jwt.FromContext(ctx)
+	if !ok {
+		return 0, apierror.Error(codes.Unauthenticated, apierror.ReasonInvalidToken, "missing access token", nil)
+	}
+
+	return claims.UID, nil
+}
+
+// clientIP returns IP address of the client connection
+func clientIP(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return ""
+	}
+
+	host, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		return p.Addr.String()
+	}
+
+	return host
+}
+
+// GetPasswordSettings returns the password policy and key derivation params the client needs
+// to check a new password and compute its verifier
+func (s *serverAPI) GetPasswordSettings(
+	ctx context.Context,
+	req *sso.GetPasswordSettingsRequest,
+) (*sso.GetPasswordSettingsResponse, error) {
+	settings := s.auth.PasswordSettings(ctx)
+
+	return &sso.GetPasswordSettingsResponse{
+		KdfParams:    settings.Params,
+		MinLength:    int32(settings.MinLength),
+		MinScore:     int32(settings.MinScore),
+		RejectCommon: settings.RejectCommon,
+	}, nil
+}
+
+// Register registers a new user with the verifier of the password, the password is not sent to the server
+func (s *serverAPI) Register(ctx context.Context, req *sso.RegisterRequest) (*sso.RegisterResponse, error) {
+	if req.GetEmail() == "" {
+		return nil, apierror.FieldError("email", "email should not be empty")
+	}
+
+	if req.GetVerifier() == nil {
+		return nil, apierror.FieldError("verifier", "verifier should not be empty")
+	}
+
+	tokens, err := s.auth.RegisterNewUser(ctx, req.GetEmail(), srpVerifier(req.GetVerifier()),
+		passwordReport(req.GetPolicyReport()))
+	if err != nil {
+		return nil, toStatus(ctx, err)
 	}
 
 	return &sso.RegisterResponse{
-		Token: token,
+		Token:        tokens.AccessToken,
+		RefreshToken: tokens.RefreshToken,
+		ExpiresAt:    tokens.ExpiresAt.Unix(),
 	}, nil
 }
 
+// Refresh exchanges refresh token for a new token pair
+func (s *serverAPI) Refresh(ctx context.Context, req *sso.RefreshRequest) (*sso.RefreshResponse, error) {
+	if req.GetRefreshToken() == "" {
+		return nil, apierror.FieldError("refresh_token", "refresh token should not be empty")
+	}
+
+	tokens, err := s.auth.Refresh(ctx, req.GetRefreshToken())
+	if err != nil {
+		return nil, toStatus(ctx, err)
+	}
+
+	return &sso.RefreshResponse{
+		Token:        tokens.AccessToken,
+		RefreshToken: tokens.RefreshToken,
+		ExpiresAt:    tokens.ExpiresAt.Unix(),
+	}, nil
+}
+
+// Logout revokes tokens of the session or of all user sessions
+func (s *serverAPI) Logout(ctx context.Context, req *sso.LogoutRequest) (*sso.LogoutResponse, error) {
+	claims, ok := jwt.FromContext(ctx)
+	if !ok {
+		return nil, apierror.Error(codes.Unauthenticated, apierror.ReasonInvalidToken, "missing access token", nil)
+	}
+
+	err := s.auth.Logout(ctx, claims, req.GetRefreshToken(), req.GetAllDevices())
+	if err != nil {
+		return nil, toStatus(ctx, err)
+	}
+
+	return &sso.LogoutResponse{}, nil
+}
+
 // SaveData saves user data
+// ReserveDataID returns ID of a new record of the user, the record is saved with it by SaveData
+func (s *serverAPI) ReserveDataID(ctx context.Context, _ *sso.ReserveDataIDRequest) (*sso.ReserveDataIDResponse, error) {
+	userID, err := authUserID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	id, reservation, err := s.data.ReserveDataID(ctx, userID)
+	if err != nil {
+		return nil, toStatus(ctx, err)
+	}
+
+	return &sso.ReserveDataIDResponse{
+		Id:          id,
+		Reservation: reservation,
+	}, nil
+}
+
 func (s *serverAPI) SaveData(ctx context.Context, req *sso.SaveDataRequest) (*sso.SaveDataResponse, error) {
-	if req.GetToken() == "" {
-		return nil, status.Error(codes.InvalidArgument, "token should not be empty")
+	if req.GetId() <= 0 {
+		return nil, apierror.FieldError("id", "id should be positive")
 	}
+
+	if req.GetReservation() == "" {
+		return nil, apierror.FieldError("reservation", "reservation should not be empty")
+	}
+
 	if req.GetDataType() == "" {
-		return nil, status.Error(codes.InvalidArgument, "data type should not be empty")
+		return nil, apierror.FieldError("data_type", "data type should not be empty")
 	}
 
 	if req.GetData() == "" {
-		return nil, status.Error(codes.InvalidArgument, "data should not be empty")
+		return nil, apierror.FieldError("data", "data should not be empty")
+	}
+
+	userID, err := authUserID(ctx)
+	if err != nil {
+		return nil, err
 	}
 
-	token, err := s.data.SaveData(ctx, req.GetToken(), req.GetDataType(), req.GetData())
+	record, err := s.data.SaveData(ctx, userID, req.GetId(), req.GetReservation(), req.GetDataType(), req.GetData())
 	if err != nil {
-		return nil, status.Error(codes.Internal, "internal error")
+		return nil, toStatus(ctx, err)
 	}
 
 	return &sso.SaveDataResponse{
-		Token: token,
+		Id:        record.ID,
+		CreatedAt: record.CreatedAt.Unix(),
+	}, nil
+}
+
+// UpdateData replaces type and content of the record of the user
+func (s *serverAPI) UpdateData(ctx context.Context, req *sso.UpdateDataRequest) (*sso.UpdateDataResponse, error) {
+	if req.GetId() <= 0 {
+		return nil, apierror.FieldError("id", "id should be positive")
+	}
+
+	if req.GetDataType() == "" {
+		return nil, apierror.FieldError("data_type", "data type should not be empty")
+	}
+
+	if req.GetData() == "" {
+		return nil, apierror.FieldError("data", "data should not be empty")
+	}
+
+	userID, err := authUserID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	record, err := s.data.UpdateData(ctx, userID, req.GetId(), req.GetDataType(), req.GetData())
+	if err != nil {
+		return nil, toStatus(ctx, err)
+	}
+
+	return &sso.UpdateDataResponse{
+		UpdatedAt: record.UpdatedAt.Unix(),
 	}, nil
 }
 
+// DeleteData deletes the record of the user
+func (s *serverAPI) DeleteData(ctx context.Context, req *sso.DeleteDataRequest) (*sso.DeleteDataResponse, error) {
+	if req.GetId() <= 0 {
+		return nil, apierror.FieldError("id", "id should be positive")
+	}
+
+	userID, err := authUserID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.data.DeleteData(ctx, userID, req.GetId()); err != nil {
+		return nil, toStatus(ctx, err)
+	}
+
+	return &sso.DeleteDataResponse{}, nil
+}
+
 // GetData gets user data
 func (s *serverAPI) GetData(ctx context.Context, req *sso.GetDataRequest) (*sso.GetDataResponse, error) {
-	if req.GetToken() == "" {
-		return nil, status.Error(codes.InvalidArgument, "token should not be empty")
+	userID, err := authUserID(ctx)
+	if err != nil {
+		return nil, err
 	}
 
-	token, data, err := s.data.GetData(ctx, req.GetToken())
+	data, err := s.data.GetData(ctx, userID)
 	if err != nil {
-		return nil, status.Error(codes.Internal, "internal error")
+		return nil, toStatus(ctx, err)
 	}
 
 	var grpcData []*sso.Data
 	for _, d := range data {
 		grpcData = append(grpcData, &sso.Data{
-			DataType: d.DataType,
-			Content:  d.Content,
+			Id:        d.ID,
+			DataType:  d.DataType,
+			Content:   d.Content,
+			CreatedAt: d.CreatedAt.Unix(),
+			UpdatedAt: d.UpdatedAt.Unix(),
+			Files:     toFiles(d.Files),
 		})
 	}
 
 	return &sso.GetDataResponse{
-		Token: token,
-		Data:  grpcData,
+		Data: grpcData,
+	}, nil
+}
+
+// GetVaultSalt gets salt of the user vault key
+func (s *serverAPI) GetVaultSalt(ctx context.Context, req *sso.GetVaultSaltRequest) (*sso.GetVaultSaltResponse, error) {
+	userID, err := authUserID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	salt, err := s.data.GetVaultSalt(ctx, userID)
+	if err != nil {
+		return nil, toStatus(ctx, err)
+	}
+
+	return &sso.GetVaultSaltResponse{
+		Salt: salt,
 	}, nil
 }
+
+// CreateUpload attaches a new file to the record of the user, its chunks are sent with UploadFile
+func (s *serverAPI) CreateUpload(ctx context.Context, req *sso.CreateUploadRequest) (*sso.File, error) {
+	if req.GetRecordId() <= 0 {
+		return nil, apierror.FieldError("record_id", "record id should be positive")
+	}
+
+	if req.GetMeta() == "" {
+		return nil, apierror.FieldError("meta", "meta should not be empty")
+	}
+
+	// files of too many chunks are rejected by the service as over the quota
+	if req.GetChunkCount() <= 0 {
+		return nil, apierror.FieldError("chunk_count", "chunk count should be positive")
+	}
+
+	userID, err := authUserID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := s.data.CreateUpload(ctx, userID, req.GetRecordId(), req.GetMeta(), int(req.GetChunkCount()))
+	if err != nil {
+		return nil, toStatus(ctx, err)
+	}
+
+	return toFile(file), nil
+}
+
+// UploadFile saves chunks of the file in order, the file with number of uploaded chunks is returned
+// when the client closes the stream. After an error the upload is continued from GetUpload.
+func (s *serverAPI) UploadFile(stream grpc.ClientStreamingServer[sso.FileChunk, sso.File]) error {
+	ctx := stream.Context()
+
+	userID, err := authUserID(ctx)
+	if err != nil {
+		return err
+	}
+
+	// errors of the stream and invalid chunks are returned as is and not translated by toStatus
+	var recvErr error
+
+	recv := func() (models.FileChunk, error) {
+		chunk, err := stream.Recv()
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				recvErr = err
+			}
+
+			return models.FileChunk{}, err
+		}
+
+		switch {
+		case chunk.GetFileId() <= 0:
+			recvErr = apierror.FieldError("file_id", "file id should be positive")
+		case chunk.GetIndex() < 0:
+			recvErr = apierror.FieldError("index", "index should not be negative")
+		case len(chunk.GetData()) > auth.MaxChunkSize:
+			recvErr = apierror.FieldError("data", fmt.Sprintf("chunk should not be larger than %d bytes", auth.MaxChunkSize))
+		}
+		if recvErr != nil {
+			return models.FileChunk{}, recvErr
+		}
+
+		return models.FileChunk{
+			FileID:   chunk.GetFileId(),
+			Index:    int(chunk.GetIndex()),
+			Data:     chunk.GetData(),
+			Checksum: chunk.GetChecksum(),
+		}, nil
+	}
+
+	file, err := s.data.UploadFile(ctx, userID, recv)
+	if err != nil {
+		if recvErr != nil {
+			return recvErr
+		}
+
+		return toStatus(ctx, err)
+	}
+
+	return stream.SendAndClose(toFile(file))
+}
+
+// GetUpload returns the file of the user with number of chunks uploaded so far
+func (s *serverAPI) GetUpload(ctx context.Context, req *sso.GetUploadRequest) (*sso.File, error) {
+	if req.GetFileId() <= 0 {
+		return nil, apierror.FieldError("file_id", "file id should be positive")
+	}
+
+	userID, err := authUserID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := s.data.GetUpload(ctx, userID, req.GetFileId())
+	if err != nil {
+		return nil, toStatus(ctx, err)
+	}
+
+	return toFile(file), nil
+}
+
+// DownloadFile sends chunks of the completed file starting from from_chunk
+func (s *serverAPI) DownloadFile(req *sso.DownloadFileRequest, stream grpc.ServerStreamingServer[sso.FileChunk]) error {
+	if req.GetFileId() <= 0 {
+		return apierror.FieldError("file_id", "file id should be positive")
+	}
+
+	if req.GetFromChunk() < 0 {
+		return apierror.FieldError("from_chunk", "from chunk should not be negative")
+	}
+
+	ctx := stream.Context()
+
+	userID, err := authUserID(ctx)
+	if err != nil {
+		return err
+	}
+
+	var sendErr error
+
+	send := func(chunk models.FileChunk) error {
+		sendErr = stream.Send(&sso.FileChunk{
+			FileId:   chunk.FileID,
+			Index:    int32(chunk.Index),
+			Data:     chunk.Data,
+			Checksum: chunk.Checksum,
+		})
+
+		return sendErr
+	}
+
+	err = s.data.DownloadFile(ctx, userID, req.GetFileId(), int(req.GetFromChunk()), send)
+	if err != nil {
+		if sendErr != nil {
+			return sendErr
+		}
+
+		return toStatus(ctx, err)
+	}
+
+	return nil
+}
+
+func toFiles(files []models.File) []*sso.File {
+	res := make([]*sso.File, 0, len(files))
+	for _, f := range files {
+		res = append(res, toFile(f))
+	}
+
+	return res
+}
+
+func toFile(f models.File) *sso.File {
+	var completedAt int64
+	if !f.CompletedAt.IsZero() {
+		completedAt = f.CompletedAt.Unix()
+	}
+
+	return &sso.File{
+		Id:             f.ID,
+		RecordId:       f.RecordID,
+		Meta:           f.Meta,
+		ChunkCount:     int32(f.ChunkCount),
+		UploadedChunks: int32(f.UploadedChunks),
+		CreatedAt:      f.CreatedAt.Unix(),
+		CompletedAt:    completedAt,
+	}
+}
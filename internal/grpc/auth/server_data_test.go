@@ -0,0 +1,375 @@
+package authgrpc
+
+import (
+	"context"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/nglmq/password-keeper/internal/domain/models"
+	"github.com/nglmq/password-keeper/internal/services/auth"
+	"github.com/stretchr/testify/mock"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	sso "github.com/nglmq/password-keeper/gen/go/sso"
+)
+
+// Мок для Data (реализует интерфейс Data)
+type MockData struct {
+	mock.Mock
+}
+
+func (m *MockData) ReserveDataID(ctx context.Context, userID int64) (int64, string, error) {
+	args := m.Called(ctx, userID)
+	return args.Get(0).(int64), args.String(1), args.Error(2)
+}
+
+func (m *MockData) SaveData(ctx context.Context, userID, id int64, reservation, dataType, data string) (models.Data, error) {
+	args := m.Called(ctx, userID, id, reservation, dataType, data)
+	return args.Get(0).(models.Data), args.Error(1)
+}
+
+func (m *MockData) UpdateData(ctx context.Context, userID, id int64, dataType, data string) (models.Data, error) {
+	args := m.Called(ctx, userID, id, dataType, data)
+	return args.Get(0).(models.Data), args.Error(1)
+}
+
+func (m *MockData) DeleteData(ctx context.Context, userID, id int64) error {
+	args := m.Called(ctx, userID, id)
+	return args.Error(0)
+}
+
+func (m *MockData) GetData(ctx context.Context, userID int64) ([]models.Data, error) {
+	args := m.Called(ctx, userID)
+	return args.Get(0).([]models.Data), args.Error(1)
+}
+
+func (m *MockData) GetVaultSalt(ctx context.Context, userID int64) ([]byte, error) {
+	args := m.Called(ctx, userID)
+	return args.Get(0).([]byte), args.Error(1)
+}
+
+func (m *MockData) CreateUpload(ctx context.Context, userID, recordID int64, meta string, chunkCount int) (models.File, error) {
+	args := m.Called(ctx, userID, recordID, meta, chunkCount)
+	return args.Get(0).(models.File), args.Error(1)
+}
+
+// UploadFile reads all chunks as the service does and passes them to the mock
+func (m *MockData) UploadFile(ctx context.Context, userID int64, recv func() (models.FileChunk, error)) (models.File, error) {
+	var chunks []models.FileChunk
+	for {
+		chunk, err := recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return models.File{}, err
+		}
+
+		chunks = append(chunks, chunk)
+	}
+
+	args := m.Called(ctx, userID, chunks)
+	return args.Get(0).(models.File), args.Error(1)
+}
+
+func (m *MockData) GetUpload(ctx context.Context, userID, fileID int64) (models.File, error) {
+	args := m.Called(ctx, userID, fileID)
+	return args.Get(0).(models.File), args.Error(1)
+}
+
+// DownloadFile sends chunks returned by the mock
+func (m *MockData) DownloadFile(ctx context.Context, userID, fileID int64, from int, send func(models.FileChunk) error) error {
+	args := m.Called(ctx, userID, fileID, from)
+	for _, chunk := range args.Get(0).([]models.FileChunk) {
+		if err := send(chunk); err != nil {
+			return err
+		}
+	}
+
+	return args.Error(1)
+}
+
+func Test_serverAPI_SaveData(t *testing.T) {
+	createdAt := time.Unix(1700000000, 0)
+
+	tests := []struct {
+		name        string
+		ctx         context.Context
+		mockData    func() *MockData
+		args        *sso.SaveDataRequest
+		want        *sso.SaveDataResponse
+		wantErr     bool
+		wantErrCode codes.Code
+	}{
+		{
+			name: "Successful save",
+			ctx:  userCtx,
+			mockData: func() *MockData {
+				m := new(MockData)
+				m.On("SaveData", mock.Anything, int64(1), int64(7), "reservation", "password", "content").
+					Return(models.Data{ID: 7, DataType: "password", CreatedAt: createdAt}, nil)
+				return m
+			},
+			args: &sso.SaveDataRequest{
+				Id:          7,
+				Reservation: "reservation",
+				DataType:    "password",
+				Data:        "content",
+			},
+			want: &sso.SaveDataResponse{
+				Id:        7,
+				CreatedAt: createdAt.Unix(),
+			},
+			wantErr:     false,
+			wantErrCode: codes.OK,
+		},
+		{
+			name: "Missing id",
+			ctx:  userCtx,
+			mockData: func() *MockData {
+				return new(MockData)
+			},
+			args: &sso.SaveDataRequest{
+				Reservation: "reservation",
+				DataType:    "password",
+				Data:        "content",
+			},
+			wantErr:     true,
+			wantErrCode: codes.InvalidArgument,
+		},
+		{
+			name: "ID not reserved for the user",
+			ctx:  userCtx,
+			mockData: func() *MockData {
+				m := new(MockData)
+				m.On("SaveData", mock.Anything, int64(1), int64(8), "reservation", "password", "content").
+					Return(models.Data{}, auth.ErrInvalidReservation)
+				return m
+			},
+			args: &sso.SaveDataRequest{
+				Id:          8,
+				Reservation: "reservation",
+				DataType:    "password",
+				Data:        "content",
+			},
+			wantErr:     true,
+			wantErrCode: codes.InvalidArgument,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &serverAPI{
+				data: tt.mockData(),
+			}
+
+			got, err := s.SaveData(tt.ctx, tt.args)
+
+			if (err != nil) != tt.wantErr {
+				t.Errorf("SaveData() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+
+			if tt.wantErr {
+				if st, _ := status.FromError(err); st.Code() != tt.wantErrCode {
+					t.Errorf("expected error code %v, got %v", tt.wantErrCode, st.Code())
+				}
+			} else if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("SaveData() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_serverAPI_UpdateData(t *testing.T) {
+	updatedAt := time.Unix(1700000000, 0)
+
+	tests := []struct {
+		name        string
+		ctx         context.Context
+		mockData    func() *MockData
+		args        *sso.UpdateDataRequest
+		want        *sso.UpdateDataResponse
+		wantErr     bool
+		wantErrCode codes.Code
+	}{
+		{
+			name: "Successful update",
+			ctx:  userCtx,
+			mockData: func() *MockData {
+				m := new(MockData)
+				m.On("UpdateData", mock.Anything, int64(1), int64(7), "password", "new-content").
+					Return(models.Data{ID: 7, DataType: "password", UpdatedAt: updatedAt}, nil)
+				return m
+			},
+			args: &sso.UpdateDataRequest{
+				Id:       7,
+				DataType: "password",
+				Data:     "new-content",
+			},
+			want: &sso.UpdateDataResponse{
+				UpdatedAt: updatedAt.Unix(),
+			},
+			wantErr:     false,
+			wantErrCode: codes.OK,
+		},
+		{
+			name: "Missing id",
+			ctx:  userCtx,
+			mockData: func() *MockData {
+				return new(MockData)
+			},
+			args: &sso.UpdateDataRequest{
+				DataType: "password",
+				Data:     "new-content",
+			},
+			wantErr:     true,
+			wantErrCode: codes.InvalidArgument,
+		},
+		{
+			name: "Missing data",
+			ctx:  userCtx,
+			mockData: func() *MockData {
+				return new(MockData)
+			},
+			args: &sso.UpdateDataRequest{
+				Id:       7,
+				DataType: "password",
+			},
+			wantErr:     true,
+			wantErrCode: codes.InvalidArgument,
+		},
+		{
+			name: "Record of another user",
+			ctx:  userCtx,
+			mockData: func() *MockData {
+				m := new(MockData)
+				m.On("UpdateData", mock.Anything, int64(1), int64(8), "password", "new-content").
+					Return(models.Data{}, auth.ErrRecordNotFound)
+				return m
+			},
+			args: &sso.UpdateDataRequest{
+				Id:       8,
+				DataType: "password",
+				Data:     "new-content",
+			},
+			wantErr:     true,
+			wantErrCode: codes.NotFound,
+		},
+		{
+			name: "Not authenticated",
+			ctx:  context.Background(),
+			mockData: func() *MockData {
+				return new(MockData)
+			},
+			args: &sso.UpdateDataRequest{
+				Id:       7,
+				DataType: "password",
+				Data:     "new-content",
+			},
+			wantErr:     true,
+			wantErrCode: codes.Unauthenticated,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &serverAPI{
+				data: tt.mockData(),
+			}
+
+			got, err := s.UpdateData(tt.ctx, tt.args)
+
+			if (err != nil) != tt.wantErr {
+				t.Errorf("UpdateData() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+
+			if tt.wantErr {
+				if st, _ := status.FromError(err); st.Code() != tt.wantErrCode {
+					t.Errorf("expected error code %v, got %v", tt.wantErrCode, st.Code())
+				}
+			} else if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("UpdateData() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_serverAPI_DeleteData(t *testing.T) {
+	tests := []struct {
+		name        string
+		mockData    func() *MockData
+		args        *sso.DeleteDataRequest
+		wantErr     bool
+		wantErrCode codes.Code
+	}{
+		{
+			name: "Successful delete",
+			mockData: func() *MockData {
+				m := new(MockData)
+				m.On("DeleteData", mock.Anything, int64(1), int64(7)).Return(nil)
+				return m
+			},
+			args:        &sso.DeleteDataRequest{Id: 7},
+			wantErr:     false,
+			wantErrCode: codes.OK,
+		},
+		{
+			name: "Missing id",
+			mockData: func() *MockData {
+				return new(MockData)
+			},
+			args:        &sso.DeleteDataRequest{},
+			wantErr:     true,
+			wantErrCode: codes.InvalidArgument,
+		},
+		{
+			name: "Record not found",
+			mockData: func() *MockData {
+				m := new(MockData)
+				m.On("DeleteData", mock.Anything, int64(1), int64(8)).Return(auth.ErrRecordNotFound)
+				return m
+			},
+			args:        &sso.DeleteDataRequest{Id: 8},
+			wantErr:     true,
+			wantErrCode: codes.NotFound,
+		},
+		{
+			name: "Internal error",
+			mockData: func() *MockData {
+				m := new(MockData)
+				m.On("DeleteData", mock.Anything, int64(1), int64(7)).Return(errors.New("internal error"))
+				return m
+			},
+			args:        &sso.DeleteDataRequest{Id: 7},
+			wantErr:     true,
+			wantErrCode: codes.Internal,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &serverAPI{
+				data: tt.mockData(),
+			}
+
+			_, err := s.DeleteData(userCtx, tt.args)
+
+			if (err != nil) != tt.wantErr {
+				t.Errorf("DeleteData() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+
+			if tt.wantErr {
+				if st, _ := status.FromError(err); st.Code() != tt.wantErrCode {
+					t.Errorf("expected error code %v, got %v", tt.wantErrCode, st.Code())
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,197 @@
+package authgrpc
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/nglmq/password-keeper/internal/services/auth"
+	"github.com/stretchr/testify/mock"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	sso "github.com/nglmq/password-keeper/gen/go/sso"
+)
+
+func Test_serverAPI_VerifyEmail(t *testing.T) {
+	tests := []struct {
+		name        string
+		mockAuth    func() *MockAuthLogin
+		args        *sso.VerifyEmailRequest
+		wantErrCode codes.Code
+	}{
+		{
+			name: "Successful verification",
+			mockAuth: func() *MockAuthLogin {
+				m := new(MockAuthLogin)
+				m.On("VerifyEmail", mock.Anything, "code").Return(nil)
+				return m
+			},
+			args:        &sso.VerifyEmailRequest{Code: "code"},
+			wantErrCode: codes.OK,
+		},
+		{
+			name: "Missing code",
+			mockAuth: func() *MockAuthLogin {
+				return new(MockAuthLogin)
+			},
+			args:        &sso.VerifyEmailRequest{},
+			wantErrCode: codes.InvalidArgument,
+		},
+		{
+			name: "Invalid code",
+			mockAuth: func() *MockAuthLogin {
+				m := new(MockAuthLogin)
+				m.On("VerifyEmail", mock.Anything, "code").Return(auth.ErrInvalidEmailToken)
+				return m
+			},
+			args:        &sso.VerifyEmailRequest{Code: "code"},
+			wantErrCode: codes.InvalidArgument,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &serverAPI{
+				auth: tt.mockAuth(),
+			}
+
+			_, err := s.VerifyEmail(context.Background(), tt.args)
+
+			if st := status.Convert(err); st.Code() != tt.wantErrCode {
+				t.Errorf("expected error code %v, got %v", tt.wantErrCode, st.Code())
+			}
+		})
+	}
+}
+
+func Test_serverAPI_RequestPasswordReset(t *testing.T) {
+	tests := []struct {
+		name        string
+		mockAuth    func() *MockAuthLogin
+		args        *sso.RequestPasswordResetRequest
+		wantErrCode codes.Code
+	}{
+		{
+			name: "Reset requested",
+			mockAuth: func() *MockAuthLogin {
+				m := new(MockAuthLogin)
+				m.On("RequestPasswordReset", mock.Anything, "user@example.com").Return(nil)
+				return m
+			},
+			args:        &sso.RequestPasswordResetRequest{Email: "user@example.com"},
+			wantErrCode: codes.OK,
+		},
+		{
+			name: "Missing email",
+			mockAuth: func() *MockAuthLogin {
+				return new(MockAuthLogin)
+			},
+			args:        &sso.RequestPasswordResetRequest{},
+			wantErrCode: codes.InvalidArgument,
+		},
+		{
+			name: "Invalid email",
+			mockAuth: func() *MockAuthLogin {
+				m := new(MockAuthLogin)
+				m.On("RequestPasswordReset", mock.Anything, "user").Return(auth.ErrInvalidEmail)
+				return m
+			},
+			args:        &sso.RequestPasswordResetRequest{Email: "user"},
+			wantErrCode: codes.InvalidArgument,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &serverAPI{
+				auth: tt.mockAuth(),
+			}
+
+			_, err := s.RequestPasswordReset(context.Background(), tt.args)
+
+			if st := status.Convert(err); st.Code() != tt.wantErrCode {
+				t.Errorf("expected error code %v, got %v", tt.wantErrCode, st.Code())
+			}
+		})
+	}
+}
+
+func Test_serverAPI_ResetPassword(t *testing.T) {
+	request := &sso.ResetPasswordRequest{
+		Code:         "code",
+		Verifier:     validVerifier,
+		PolicyReport: validReport,
+		TotpCode:     "123456",
+	}
+
+	tests := []struct {
+		name        string
+		mockAuth    func() *MockAuthLogin
+		args        *sso.ResetPasswordRequest
+		wantErrCode codes.Code
+	}{
+		{
+			name: "Successful reset",
+			mockAuth: func() *MockAuthLogin {
+				m := new(MockAuthLogin)
+				m.On("ResetPassword", mock.Anything, "code", validSRPVerifier, validPasswordReport, "123456", "").Return(nil)
+				return m
+			},
+			args:        request,
+			wantErrCode: codes.OK,
+		},
+		{
+			name: "Missing verifier",
+			mockAuth: func() *MockAuthLogin {
+				return new(MockAuthLogin)
+			},
+			args:        &sso.ResetPasswordRequest{Code: "code"},
+			wantErrCode: codes.InvalidArgument,
+		},
+		{
+			name: "Invalid code",
+			mockAuth: func() *MockAuthLogin {
+				m := new(MockAuthLogin)
+				m.On("ResetPassword", mock.Anything, "code", validSRPVerifier, validPasswordReport, "123456", "").Return(auth.ErrInvalidEmailToken)
+				return m
+			},
+			args:        request,
+			wantErrCode: codes.InvalidArgument,
+		},
+		{
+			name: "Invalid two-factor code",
+			mockAuth: func() *MockAuthLogin {
+				m := new(MockAuthLogin)
+				m.On("ResetPassword", mock.Anything, "code", validSRPVerifier, validPasswordReport, "123456", "").Return(auth.ErrInvalidTOTPCode)
+				return m
+			},
+			args:        request,
+			wantErrCode: codes.Unauthenticated,
+		},
+		{
+			name: "Internal error",
+			mockAuth: func() *MockAuthLogin {
+				m := new(MockAuthLogin)
+				m.On("ResetPassword", mock.Anything, "code", validSRPVerifier, validPasswordReport, "123456", "").Return(errors.New("internal error"))
+				return m
+			},
+			args:        request,
+			wantErrCode: codes.Internal,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &serverAPI{
+				auth: tt.mockAuth(),
+			}
+
+			_, err := s.ResetPassword(context.Background(), tt.args)
+
+			if st := status.Convert(err); st.Code() != tt.wantErrCode {
+				t.Errorf("expected error code %v, got %v", tt.wantErrCode, st.Code())
+			}
+		})
+	}
+}
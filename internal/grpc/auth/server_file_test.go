@@ -0,0 +1,324 @@
+package authgrpc
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/nglmq/password-keeper/internal/domain/models"
+	"github.com/nglmq/password-keeper/internal/lib/apierror"
+	"github.com/nglmq/password-keeper/internal/services/auth"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	sso "github.com/nglmq/password-keeper/gen/go/sso"
+)
+
+// uploadStream is a client stream of chunks, the error is returned after the chunks instead of io.EOF
+type uploadStream struct {
+	grpc.ServerStream
+	ctx    context.Context
+	chunks []*sso.FileChunk
+	err    error
+	sent   *sso.File
+}
+
+func (s *uploadStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *uploadStream) Recv() (*sso.FileChunk, error) {
+	if len(s.chunks) == 0 {
+		if s.err != nil {
+			return nil, s.err
+		}
+
+		return nil, io.EOF
+	}
+
+	chunk := s.chunks[0]
+	s.chunks = s.chunks[1:]
+
+	return chunk, nil
+}
+
+func (s *uploadStream) SendAndClose(file *sso.File) error {
+	s.sent = file
+	return nil
+}
+
+// downloadStream is a server stream collecting sent chunks
+type downloadStream struct {
+	grpc.ServerStream
+	ctx  context.Context
+	sent []*sso.FileChunk
+}
+
+func (s *downloadStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *downloadStream) Send(chunk *sso.FileChunk) error {
+	s.sent = append(s.sent, chunk)
+	return nil
+}
+
+func Test_serverAPI_CreateUpload(t *testing.T) {
+	createdAt := time.Unix(1700000000, 0)
+
+	tests := []struct {
+		name        string
+		mockData    func() *MockData
+		args        *sso.CreateUploadRequest
+		want        *sso.File
+		wantErrCode codes.Code
+	}{
+		{
+			name: "Successful create",
+			mockData: func() *MockData {
+				m := new(MockData)
+				m.On("CreateUpload", mock.Anything, int64(1), int64(7), "meta", 3).
+					Return(models.File{ID: 5, RecordID: 7, Meta: "meta", ChunkCount: 3, CreatedAt: createdAt}, nil)
+				return m
+			},
+			args: &sso.CreateUploadRequest{RecordId: 7, Meta: "meta", ChunkCount: 3},
+			want: &sso.File{Id: 5, RecordId: 7, Meta: "meta", ChunkCount: 3, CreatedAt: createdAt.Unix()},
+		},
+		{
+			name: "Too many chunks",
+			mockData: func() *MockData {
+				m := new(MockData)
+				m.On("CreateUpload", mock.Anything, int64(1), int64(7), "meta", auth.MaxFileChunks+1).
+					Return(models.File{}, auth.ErrQuotaExceeded)
+				return m
+			},
+			args:        &sso.CreateUploadRequest{RecordId: 7, Meta: "meta", ChunkCount: auth.MaxFileChunks + 1},
+			wantErrCode: codes.ResourceExhausted,
+		},
+		{
+			name: "No chunks",
+			mockData: func() *MockData {
+				return new(MockData)
+			},
+			args:        &sso.CreateUploadRequest{RecordId: 7, Meta: "meta"},
+			wantErrCode: codes.InvalidArgument,
+		},
+		{
+			name: "Missing meta",
+			mockData: func() *MockData {
+				return new(MockData)
+			},
+			args:        &sso.CreateUploadRequest{RecordId: 7, ChunkCount: 3},
+			wantErrCode: codes.InvalidArgument,
+		},
+		{
+			name: "Record of another user",
+			mockData: func() *MockData {
+				m := new(MockData)
+				m.On("CreateUpload", mock.Anything, int64(1), int64(8), "meta", 3).
+					Return(models.File{}, auth.ErrRecordNotFound)
+				return m
+			},
+			args:        &sso.CreateUploadRequest{RecordId: 8, Meta: "meta", ChunkCount: 3},
+			wantErrCode: codes.NotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &serverAPI{
+				data: tt.mockData(),
+			}
+
+			got, err := s.CreateUpload(userCtx, tt.args)
+			if tt.wantErrCode != codes.OK {
+				assert.Equal(t, tt.wantErrCode, status.Code(err))
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_serverAPI_UploadFile(t *testing.T) {
+	createdAt := time.Unix(1700000000, 0)
+	completedAt := createdAt.Add(time.Minute)
+
+	chunks := []*sso.FileChunk{
+		{FileId: 5, Index: 0, Data: []byte("first"), Checksum: []byte("sum0")},
+		{FileId: 5, Index: 1, Data: []byte("second"), Checksum: []byte("sum1")},
+	}
+
+	tests := []struct {
+		name        string
+		mockData    func() *MockData
+		stream      *uploadStream
+		want        *sso.File
+		wantErrCode codes.Code
+		wantReason  string
+	}{
+		{
+			name: "Successful upload",
+			mockData: func() *MockData {
+				m := new(MockData)
+				m.On("UploadFile", mock.Anything, int64(1), []models.FileChunk{
+					{FileID: 5, Index: 0, Data: []byte("first"), Checksum: []byte("sum0")},
+					{FileID: 5, Index: 1, Data: []byte("second"), Checksum: []byte("sum1")},
+				}).Return(models.File{ID: 5, RecordID: 7, ChunkCount: 2, UploadedChunks: 2,
+					CreatedAt: createdAt, CompletedAt: completedAt}, nil)
+				return m
+			},
+			stream: &uploadStream{ctx: userCtx, chunks: chunks},
+			want: &sso.File{Id: 5, RecordId: 7, ChunkCount: 2, UploadedChunks: 2,
+				CreatedAt: createdAt.Unix(), CompletedAt: completedAt.Unix()},
+		},
+		{
+			name: "Too large chunk",
+			mockData: func() *MockData {
+				return new(MockData)
+			},
+			stream: &uploadStream{ctx: userCtx, chunks: []*sso.FileChunk{
+				{FileId: 5, Data: make([]byte, auth.MaxChunkSize+1)},
+			}},
+			wantErrCode: codes.InvalidArgument,
+		},
+		{
+			name: "Interrupted stream",
+			mockData: func() *MockData {
+				return new(MockData)
+			},
+			stream:      &uploadStream{ctx: userCtx, chunks: chunks, err: status.Error(codes.Canceled, "canceled")},
+			wantErrCode: codes.Canceled,
+		},
+		{
+			name: "Checksum mismatch",
+			mockData: func() *MockData {
+				m := new(MockData)
+				m.On("UploadFile", mock.Anything, int64(1), mock.Anything).
+					Return(models.File{}, auth.ErrChecksumMismatch)
+				return m
+			},
+			stream:      &uploadStream{ctx: userCtx, chunks: chunks},
+			wantErrCode: codes.DataLoss,
+			wantReason:  apierror.ReasonChecksumMismatch,
+		},
+		{
+			name: "Chunk out of order",
+			mockData: func() *MockData {
+				m := new(MockData)
+				m.On("UploadFile", mock.Anything, int64(1), mock.Anything).
+					Return(models.File{}, auth.ErrUnexpectedChunk)
+				return m
+			},
+			stream:      &uploadStream{ctx: userCtx, chunks: chunks},
+			wantErrCode: codes.FailedPrecondition,
+			wantReason:  apierror.ReasonUnexpectedChunk,
+		},
+		{
+			name: "Not authenticated",
+			mockData: func() *MockData {
+				return new(MockData)
+			},
+			stream:      &uploadStream{ctx: context.Background(), chunks: chunks},
+			wantErrCode: codes.Unauthenticated,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &serverAPI{
+				data: tt.mockData(),
+			}
+
+			err := s.UploadFile(tt.stream)
+			if tt.wantErrCode != codes.OK {
+				assert.Equal(t, tt.wantErrCode, status.Code(err))
+				if tt.wantReason != "" {
+					assert.Equal(t, tt.wantReason, apierror.Reason(err))
+				}
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, tt.stream.sent)
+		})
+	}
+}
+
+func Test_serverAPI_DownloadFile(t *testing.T) {
+	tests := []struct {
+		name        string
+		mockData    func() *MockData
+		args        *sso.DownloadFileRequest
+		want        []*sso.FileChunk
+		wantErrCode codes.Code
+	}{
+		{
+			name: "Successful download from the second chunk",
+			mockData: func() *MockData {
+				m := new(MockData)
+				m.On("DownloadFile", mock.Anything, int64(1), int64(5), 1).
+					Return([]models.FileChunk{{FileID: 5, Index: 1, Data: []byte("second"), Checksum: []byte("sum1")}}, nil)
+				return m
+			},
+			args: &sso.DownloadFileRequest{FileId: 5, FromChunk: 1},
+			want: []*sso.FileChunk{{FileId: 5, Index: 1, Data: []byte("second"), Checksum: []byte("sum1")}},
+		},
+		{
+			name: "Negative from chunk",
+			mockData: func() *MockData {
+				return new(MockData)
+			},
+			args:        &sso.DownloadFileRequest{FileId: 5, FromChunk: -1},
+			wantErrCode: codes.InvalidArgument,
+		},
+		{
+			name: "Upload not completed",
+			mockData: func() *MockData {
+				m := new(MockData)
+				m.On("DownloadFile", mock.Anything, int64(1), int64(5), 0).
+					Return([]models.FileChunk{}, auth.ErrUploadIncomplete)
+				return m
+			},
+			args:        &sso.DownloadFileRequest{FileId: 5},
+			wantErrCode: codes.FailedPrecondition,
+		},
+		{
+			name: "File of another user",
+			mockData: func() *MockData {
+				m := new(MockData)
+				m.On("DownloadFile", mock.Anything, int64(1), int64(6), 0).
+					Return([]models.FileChunk{}, auth.ErrFileNotFound)
+				return m
+			},
+			args:        &sso.DownloadFileRequest{FileId: 6},
+			wantErrCode: codes.NotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &serverAPI{
+				data: tt.mockData(),
+			}
+
+			stream := &downloadStream{ctx: userCtx}
+
+			err := s.DownloadFile(tt.args, stream)
+			if tt.wantErrCode != codes.OK {
+				assert.Equal(t, tt.wantErrCode, status.Code(err))
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, stream.sent)
+		})
+	}
+}
@@ -5,27 +5,129 @@ import (
 	"errors"
 	"reflect"
 	"testing"
+	"time"
 
+	"github.com/nglmq/password-keeper/internal/domain/models"
+	"github.com/nglmq/password-keeper/internal/lib/jwt"
+	"github.com/nglmq/password-keeper/internal/lib/password"
+	"github.com/nglmq/password-keeper/internal/services/auth"
 	"github.com/nglmq/password-keeper/internal/storage"
 	"github.com/stretchr/testify/mock"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 
-	sso "github.com/nglmq/protos/gen/go/sso"
+	sso "github.com/nglmq/password-keeper/gen/go/sso"
+)
+
+var validTokens = models.TokenPair{
+	AccessToken:  "valid-token",
+	RefreshToken: "refresh-token",
+	ExpiresAt:    time.Unix(1700000000, 0),
+}
+
+// verifier and report of a new password as sent by the client and as passed to the service
+var (
+	validVerifier = &sso.SRPVerifier{
+		Salt:      []byte("salt"),
+		KdfParams: "m=65536,t=3,p=4",
+		Verifier:  []byte("verifier"),
+	}
+	validSRPVerifier = models.SRPVerifier{
+		Salt:     []byte("salt"),
+		Params:   "m=65536,t=3,p=4",
+		Verifier: []byte("verifier"),
+	}
+	validReport         = &sso.PasswordReport{Length: 16, Score: 4}
+	validPasswordReport = password.Report{Length: 16, Score: 4}
 )
 
 type MockAuthLogin struct {
 	mock.Mock
 }
 
-func (m *MockAuthLogin) Login(ctx context.Context, email string, password string) (string, error) {
-	args := m.Called(ctx, email, password)
-	return args.String(0), args.Error(1)
+func (m *MockAuthLogin) Login(ctx context.Context, email, password, clientIP string) (models.LoginResult, error) {
+	args := m.Called(ctx, email, password, clientIP)
+	return args.Get(0).(models.LoginResult), args.Error(1)
+}
+
+func (m *MockAuthLogin) LoginStart(ctx context.Context, email string, clientPublic []byte, clientIP string) (models.SRPChallenge, error) {
+	args := m.Called(ctx, email, clientPublic, clientIP)
+	return args.Get(0).(models.SRPChallenge), args.Error(1)
+}
+
+func (m *MockAuthLogin) LoginFinish(ctx context.Context, session string, clientProof []byte, newVerifier *models.SRPVerifier, clientIP string) (models.LoginResult, error) {
+	args := m.Called(ctx, session, clientProof, newVerifier, clientIP)
+	return args.Get(0).(models.LoginResult), args.Error(1)
+}
+
+func (m *MockAuthLogin) PasswordSettings(ctx context.Context) models.PasswordSettings {
+	args := m.Called(ctx)
+	return args.Get(0).(models.PasswordSettings)
+}
+
+func (m *MockAuthLogin) RegisterNewUser(ctx context.Context, email string, verifier models.SRPVerifier, report password.Report) (models.TokenPair, error) {
+	args := m.Called(ctx, email, verifier, report)
+	return args.Get(0).(models.TokenPair), args.Error(1)
+}
+
+func (m *MockAuthLogin) Refresh(ctx context.Context, refreshToken string) (models.TokenPair, error) {
+	args := m.Called(ctx, refreshToken)
+	return args.Get(0).(models.TokenPair), args.Error(1)
+}
+
+func (m *MockAuthLogin) Logout(ctx context.Context, claims jwt.Claims, refreshToken string, allDevices bool) error {
+	args := m.Called(ctx, claims, refreshToken, allDevices)
+	return args.Error(0)
+}
+
+func (m *MockAuthLogin) LoginTOTP(ctx context.Context, challenge, code, clientIP string) (models.TokenPair, error) {
+	args := m.Called(ctx, challenge, code, clientIP)
+	return args.Get(0).(models.TokenPair), args.Error(1)
+}
+
+func (m *MockAuthLogin) EnrollTOTP(ctx context.Context, userID int64) (models.TOTPEnrollment, error) {
+	args := m.Called(ctx, userID)
+	return args.Get(0).(models.TOTPEnrollment), args.Error(1)
+}
+
+func (m *MockAuthLogin) ConfirmTOTP(ctx context.Context, userID int64, code string) ([]string, error) {
+	args := m.Called(ctx, userID, code)
+	return args.Get(0).([]string), args.Error(1)
 }
 
-func (m *MockAuthLogin) RegisterNewUser(ctx context.Context, email string, password string) (string, error) {
-	args := m.Called(ctx, email, password)
-	return args.String(0), args.Error(1)
+func (m *MockAuthLogin) ChangePassword(ctx context.Context, userID int64, session string, clientProof []byte, verifier models.SRPVerifier, report password.Report, vaultSalt []byte, records []models.Data) (models.TokenPair, []byte, error) {
+	args := m.Called(ctx, userID, session, clientProof, verifier, report, vaultSalt, records)
+	return args.Get(0).(models.TokenPair), args.Get(1).([]byte), args.Error(2)
+}
+
+func (m *MockAuthLogin) DeleteAccount(ctx context.Context, userID int64, session string, clientProof []byte) (time.Time, error) {
+	args := m.Called(ctx, userID, session, clientProof)
+	return args.Get(0).(time.Time), args.Error(1)
+}
+
+func (m *MockAuthLogin) RestoreAccount(ctx context.Context, session string, clientProof []byte, clientIP string) error {
+	args := m.Called(ctx, session, clientProof, clientIP)
+	return args.Error(0)
+}
+
+func (m *MockAuthLogin) SendVerificationEmail(ctx context.Context, userID int64) error {
+	args := m.Called(ctx, userID)
+	return args.Error(0)
+}
+
+func (m *MockAuthLogin) VerifyEmail(ctx context.Context, code string) error {
+	args := m.Called(ctx, code)
+	return args.Error(0)
+}
+
+func (m *MockAuthLogin) RequestPasswordReset(ctx context.Context, email string) error {
+	args := m.Called(ctx, email)
+	return args.Error(0)
+}
+
+func (m *MockAuthLogin) ResetPassword(ctx context.Context, code string, verifier models.SRPVerifier, report password.Report, totpCode, clientIP string) error {
+	args := m.Called(ctx, code, verifier, report, totpCode, clientIP)
+	return args.Error(0)
 }
 
 func Test_serverAPI_Login(t *testing.T) {
@@ -41,7 +143,26 @@ func Test_serverAPI_Login(t *testing.T) {
 			name: "Successful login",
 			mockAuth: func() *MockAuthLogin {
 				m := new(MockAuthLogin)
-				m.On("Login", mock.Anything, "qwerty@gmail.com", "12345").Return("valid-token", nil)
+				m.On("Login", mock.Anything, "qwerty@gmail.com", "12345", "").Return(models.LoginResult{Tokens: validTokens}, nil)
+				return m
+			},
+			args: &sso.LoginRequest{
+				Email:    "qwerty@gmail.com",
+				Password: "12345",
+			},
+			want: &sso.LoginResponse{
+				Token:        "valid-token",
+				RefreshToken: "refresh-token",
+				ExpiresAt:    validTokens.ExpiresAt.Unix(),
+			},
+			wantErr:     false,
+			wantErrCode: codes.OK,
+		},
+		{
+			name: "Second factor required",
+			mockAuth: func() *MockAuthLogin {
+				m := new(MockAuthLogin)
+				m.On("Login", mock.Anything, "qwerty@gmail.com", "12345", "").Return(models.LoginResult{Challenge: "challenge"}, nil)
 				return m
 			},
 			args: &sso.LoginRequest{
@@ -49,7 +170,7 @@ func Test_serverAPI_Login(t *testing.T) {
 				Password: "12345",
 			},
 			want: &sso.LoginResponse{
-				Token: "valid-token",
+				Challenge: "challenge",
 			},
 			wantErr:     false,
 			wantErrCode: codes.OK,
@@ -82,7 +203,7 @@ func Test_serverAPI_Login(t *testing.T) {
 			name: "User not found",
 			mockAuth: func() *MockAuthLogin {
 				m := new(MockAuthLogin)
-				m.On("Login", mock.Anything, "qwerty@mail.com", "12345678").Return("", storage.ErrUserNotFound)
+				m.On("Login", mock.Anything, "qwerty@mail.com", "12345678", "").Return(models.LoginResult{}, storage.ErrUserNotFound)
 				return m
 			},
 			args: &sso.LoginRequest{
@@ -92,11 +213,67 @@ func Test_serverAPI_Login(t *testing.T) {
 			wantErr:     true,
 			wantErrCode: codes.NotFound,
 		},
+		{
+			name: "Wrong password",
+			mockAuth: func() *MockAuthLogin {
+				m := new(MockAuthLogin)
+				m.On("Login", mock.Anything, "qwerty@gmail.com", "12345", "").Return(models.LoginResult{}, auth.ErrInvalidCredentials)
+				return m
+			},
+			args: &sso.LoginRequest{
+				Email:    "qwerty@gmail.com",
+				Password: "12345",
+			},
+			wantErr:     true,
+			wantErrCode: codes.Unauthenticated,
+		},
+		{
+			name: "Account deleted",
+			mockAuth: func() *MockAuthLogin {
+				m := new(MockAuthLogin)
+				m.On("Login", mock.Anything, "qwerty@gmail.com", "12345", "").Return(models.LoginResult{}, auth.ErrAccountDeleted)
+				return m
+			},
+			args: &sso.LoginRequest{
+				Email:    "qwerty@gmail.com",
+				Password: "12345",
+			},
+			wantErr:     true,
+			wantErrCode: codes.FailedPrecondition,
+		},
+		{
+			name: "Too many attempts",
+			mockAuth: func() *MockAuthLogin {
+				m := new(MockAuthLogin)
+				m.On("Login", mock.Anything, "qwerty@gmail.com", "12345", "").Return(models.LoginResult{}, &auth.LimitError{RetryAfter: 4 * time.Second})
+				return m
+			},
+			args: &sso.LoginRequest{
+				Email:    "qwerty@gmail.com",
+				Password: "12345",
+			},
+			wantErr:     true,
+			wantErrCode: codes.ResourceExhausted,
+		},
+		{
+			name: "Account locked",
+			mockAuth: func() *MockAuthLogin {
+				m := new(MockAuthLogin)
+				m.On("Login", mock.Anything, "qwerty@gmail.com", "12345", "").Return(models.LoginResult{}, &auth.LimitError{RetryAfter: time.Minute, Locked: true})
+				return m
+			},
+			args: &sso.LoginRequest{
+				Email:    "qwerty@gmail.com",
+				Password: "12345",
+			},
+			wantErr:     true,
+			wantErrCode: codes.Unavailable,
+		},
 		{
 			name: "Internal error",
 			mockAuth: func() *MockAuthLogin {
 				m := new(MockAuthLogin)
-				m.On("Login", mock.Anything, "qwerty@gmail.com", "12345").Return("", errors.New("internal error"))
+				m.On("Login", mock.Anything, "qwerty@gmail.com", "12345", "").Return(models.LoginResult{}, errors.New("internal error"))
 				return m
 			},
 			args: &sso.LoginRequest{
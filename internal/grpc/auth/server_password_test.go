@@ -0,0 +1,178 @@
+package authgrpc
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/nglmq/password-keeper/internal/domain/models"
+	"github.com/nglmq/password-keeper/internal/services/auth"
+	"github.com/stretchr/testify/mock"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	sso "github.com/nglmq/password-keeper/gen/go/sso"
+)
+
+func Test_serverAPI_ChangePassword(t *testing.T) {
+	salt := []byte("0123456789abcdef")
+	records := []models.Data{{ID: 1, DataType: "note", Content: "new-blob"}}
+	request := &sso.ChangePasswordRequest{
+		Session:      "session",
+		ClientProof:  []byte("proof"),
+		Verifier:     validVerifier,
+		PolicyReport: validReport,
+		VaultSalt:    salt,
+		Data:         []*sso.Data{{Id: 1, DataType: "note", Content: "new-blob"}},
+	}
+
+	tests := []struct {
+		name        string
+		ctx         context.Context
+		mockAuth    func() *MockAuthLogin
+		args        *sso.ChangePasswordRequest
+		want        *sso.ChangePasswordResponse
+		wantErr     bool
+		wantErrCode codes.Code
+	}{
+		{
+			name: "Successful change",
+			ctx:  userCtx,
+			mockAuth: func() *MockAuthLogin {
+				m := new(MockAuthLogin)
+				m.On("ChangePassword", mock.Anything, int64(1), "session", []byte("proof"), validSRPVerifier, validPasswordReport, salt, records).Return(validTokens, []byte("server-proof"), nil)
+				return m
+			},
+			args: request,
+			want: &sso.ChangePasswordResponse{
+				Token:        "valid-token",
+				RefreshToken: "refresh-token",
+				ExpiresAt:    validTokens.ExpiresAt.Unix(),
+				ServerProof:  []byte("server-proof"),
+			},
+			wantErr:     false,
+			wantErrCode: codes.OK,
+		},
+		{
+			name: "Not authenticated",
+			ctx:  context.Background(),
+			mockAuth: func() *MockAuthLogin {
+				return new(MockAuthLogin)
+			},
+			args:        request,
+			wantErr:     true,
+			wantErrCode: codes.Unauthenticated,
+		},
+		{
+			name: "Missing verifier",
+			ctx:  userCtx,
+			mockAuth: func() *MockAuthLogin {
+				return new(MockAuthLogin)
+			},
+			args: &sso.ChangePasswordRequest{
+				Session:     "session",
+				ClientProof: []byte("proof"),
+				VaultSalt:   salt,
+			},
+			wantErr:     true,
+			wantErrCode: codes.InvalidArgument,
+		},
+		{
+			name: "Missing client proof",
+			ctx:  userCtx,
+			mockAuth: func() *MockAuthLogin {
+				return new(MockAuthLogin)
+			},
+			args: &sso.ChangePasswordRequest{
+				Session:      "session",
+				Verifier:     validVerifier,
+				PolicyReport: validReport,
+				VaultSalt:    salt,
+			},
+			wantErr:     true,
+			wantErrCode: codes.InvalidArgument,
+		},
+		{
+			name: "Wrong old password",
+			ctx:  userCtx,
+			mockAuth: func() *MockAuthLogin {
+				m := new(MockAuthLogin)
+				m.On("ChangePassword", mock.Anything, int64(1), "session", []byte("proof"), validSRPVerifier, validPasswordReport, salt, records).Return(models.TokenPair{}, []byte(nil), auth.ErrInvalidCredentials)
+				return m
+			},
+			args:        request,
+			wantErr:     true,
+			wantErrCode: codes.PermissionDenied,
+		},
+		{
+			name: "Invalid verifier",
+			ctx:  userCtx,
+			mockAuth: func() *MockAuthLogin {
+				m := new(MockAuthLogin)
+				m.On("ChangePassword", mock.Anything, int64(1), "session", []byte("proof"), validSRPVerifier, validPasswordReport, salt, records).Return(models.TokenPair{}, []byte(nil), auth.ErrInvalidVerifier)
+				return m
+			},
+			args:        request,
+			wantErr:     true,
+			wantErrCode: codes.InvalidArgument,
+		},
+		{
+			name: "Records changed",
+			ctx:  userCtx,
+			mockAuth: func() *MockAuthLogin {
+				m := new(MockAuthLogin)
+				m.On("ChangePassword", mock.Anything, int64(1), "session", []byte("proof"), validSRPVerifier, validPasswordReport, salt, records).Return(models.TokenPair{}, []byte(nil), auth.ErrVaultChanged)
+				return m
+			},
+			args:        request,
+			wantErr:     true,
+			wantErrCode: codes.Aborted,
+		},
+		{
+			name: "Internal error",
+			ctx:  userCtx,
+			mockAuth: func() *MockAuthLogin {
+				m := new(MockAuthLogin)
+				m.On("ChangePassword", mock.Anything, int64(1), "session", []byte("proof"), validSRPVerifier, validPasswordReport, salt, records).Return(models.TokenPair{}, []byte(nil), errors.New("internal error"))
+				return m
+			},
+			args:        request,
+			wantErr:     true,
+			wantErrCode: codes.Internal,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockAuth := tt.mockAuth()
+
+			s := &serverAPI{
+				auth: mockAuth,
+			}
+
+			got, err := s.ChangePassword(tt.ctx, tt.args)
+
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ChangePassword() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+
+			if tt.wantErr {
+				st, ok := status.FromError(err)
+				if !ok {
+					t.Errorf("expected gRPC status error, got %v", err)
+					return
+				}
+
+				if st.Code() != tt.wantErrCode {
+					t.Errorf("expected error code %v, got %v", tt.wantErrCode, st.Code())
+				}
+			} else {
+				if !reflect.DeepEqual(got, tt.want) {
+					t.Errorf("ChangePassword() got = %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
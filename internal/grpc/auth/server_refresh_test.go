@@ -0,0 +1,113 @@
+package authgrpc
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/nglmq/password-keeper/internal/services/auth"
+	"github.com/stretchr/testify/mock"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	sso "github.com/nglmq/password-keeper/gen/go/sso"
+)
+
+func Test_serverAPI_Refresh(t *testing.T) {
+	tests := []struct {
+		name        string
+		mockAuth    func() *MockAuthLogin
+		args        *sso.RefreshRequest
+		want        *sso.RefreshResponse
+		wantErr     bool
+		wantErrCode codes.Code
+	}{
+		{
+			name: "Successful refresh",
+			mockAuth: func() *MockAuthLogin {
+				m := new(MockAuthLogin)
+				m.On("Refresh", mock.Anything, "old-refresh-token").Return(validTokens, nil)
+				return m
+			},
+			args: &sso.RefreshRequest{
+				RefreshToken: "old-refresh-token",
+			},
+			want: &sso.RefreshResponse{
+				Token:        "valid-token",
+				RefreshToken: "refresh-token",
+				ExpiresAt:    validTokens.ExpiresAt.Unix(),
+			},
+			wantErr:     false,
+			wantErrCode: codes.OK,
+		},
+		{
+			name: "Missing refresh token",
+			mockAuth: func() *MockAuthLogin {
+				return new(MockAuthLogin)
+			},
+			args:        &sso.RefreshRequest{},
+			wantErr:     true,
+			wantErrCode: codes.InvalidArgument,
+		},
+		{
+			name: "Invalid refresh token",
+			mockAuth: func() *MockAuthLogin {
+				m := new(MockAuthLogin)
+				m.On("Refresh", mock.Anything, "reused-token").Return(validTokens, auth.ErrInvalidToken)
+				return m
+			},
+			args: &sso.RefreshRequest{
+				RefreshToken: "reused-token",
+			},
+			wantErr:     true,
+			wantErrCode: codes.Unauthenticated,
+		},
+		{
+			name: "Internal error",
+			mockAuth: func() *MockAuthLogin {
+				m := new(MockAuthLogin)
+				m.On("Refresh", mock.Anything, "refresh-token").Return(validTokens, errors.New("internal error"))
+				return m
+			},
+			args: &sso.RefreshRequest{
+				RefreshToken: "refresh-token",
+			},
+			wantErr:     true,
+			wantErrCode: codes.Internal,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockAuth := tt.mockAuth()
+
+			s := &serverAPI{
+				auth: mockAuth,
+			}
+
+			got, err := s.Refresh(context.Background(), tt.args)
+
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Refresh() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+
+			if tt.wantErr {
+				st, ok := status.FromError(err)
+				if !ok {
+					t.Errorf("expected gRPC status error, got %v", err)
+					return
+				}
+
+				if st.Code() != tt.wantErrCode {
+					t.Errorf("expected error code %v, got %v", tt.wantErrCode, st.Code())
+				}
+			} else {
+				if !reflect.DeepEqual(got, tt.want) {
+					t.Errorf("Refresh() got = %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
@@ -5,13 +5,21 @@ import (
 	"errors"
 	"reflect"
 	"testing"
+	"time"
 
-	"github.com/nglmq/password-keeper/internal/storage"
+	"github.com/nglmq/password-keeper/internal/domain/models"
+	"github.com/nglmq/password-keeper/internal/lib/apierror"
+	"github.com/nglmq/password-keeper/internal/lib/jwt"
+	"github.com/nglmq/password-keeper/internal/lib/password"
+	"github.com/nglmq/password-keeper/internal/services/auth"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 
-	sso "github.com/nglmq/protos/gen/go/sso"
+	sso "github.com/nglmq/password-keeper/gen/go/sso"
 )
 
 // Мок для Auth (реализует интерфейс Auth)
@@ -19,14 +27,89 @@ type MockAuthReg struct {
 	mock.Mock
 }
 
-func (m *MockAuthReg) Login(ctx context.Context, email string, password string) (string, error) {
-	args := m.Called(ctx, email, password)
-	return args.String(0), args.Error(1)
+func (m *MockAuthReg) Login(ctx context.Context, email, password, clientIP string) (models.LoginResult, error) {
+	args := m.Called(ctx, email, password, clientIP)
+	return args.Get(0).(models.LoginResult), args.Error(1)
 }
 
-func (m *MockAuthReg) RegisterNewUser(ctx context.Context, email string, password string) (string, error) {
-	args := m.Called(ctx, email, password)
-	return args.String(0), args.Error(1)
+func (m *MockAuthReg) LoginStart(ctx context.Context, email string, clientPublic []byte, clientIP string) (models.SRPChallenge, error) {
+	args := m.Called(ctx, email, clientPublic, clientIP)
+	return args.Get(0).(models.SRPChallenge), args.Error(1)
+}
+
+func (m *MockAuthReg) LoginFinish(ctx context.Context, session string, clientProof []byte, newVerifier *models.SRPVerifier, clientIP string) (models.LoginResult, error) {
+	args := m.Called(ctx, session, clientProof, newVerifier, clientIP)
+	return args.Get(0).(models.LoginResult), args.Error(1)
+}
+
+func (m *MockAuthReg) PasswordSettings(ctx context.Context) models.PasswordSettings {
+	args := m.Called(ctx)
+	return args.Get(0).(models.PasswordSettings)
+}
+
+func (m *MockAuthReg) RegisterNewUser(ctx context.Context, email string, verifier models.SRPVerifier, report password.Report) (models.TokenPair, error) {
+	args := m.Called(ctx, email, verifier, report)
+	return args.Get(0).(models.TokenPair), args.Error(1)
+}
+
+func (m *MockAuthReg) Refresh(ctx context.Context, refreshToken string) (models.TokenPair, error) {
+	args := m.Called(ctx, refreshToken)
+	return args.Get(0).(models.TokenPair), args.Error(1)
+}
+
+func (m *MockAuthReg) Logout(ctx context.Context, claims jwt.Claims, refreshToken string, allDevices bool) error {
+	args := m.Called(ctx, claims, refreshToken, allDevices)
+	return args.Error(0)
+}
+
+func (m *MockAuthReg) LoginTOTP(ctx context.Context, challenge, code, clientIP string) (models.TokenPair, error) {
+	args := m.Called(ctx, challenge, code, clientIP)
+	return args.Get(0).(models.TokenPair), args.Error(1)
+}
+
+func (m *MockAuthReg) EnrollTOTP(ctx context.Context, userID int64) (models.TOTPEnrollment, error) {
+	args := m.Called(ctx, userID)
+	return args.Get(0).(models.TOTPEnrollment), args.Error(1)
+}
+
+func (m *MockAuthReg) ConfirmTOTP(ctx context.Context, userID int64, code string) ([]string, error) {
+	args := m.Called(ctx, userID, code)
+	return args.Get(0).([]string), args.Error(1)
+}
+
+func (m *MockAuthReg) ChangePassword(ctx context.Context, userID int64, session string, clientProof []byte, verifier models.SRPVerifier, report password.Report, vaultSalt []byte, records []models.Data) (models.TokenPair, []byte, error) {
+	args := m.Called(ctx, userID, session, clientProof, verifier, report, vaultSalt, records)
+	return args.Get(0).(models.TokenPair), args.Get(1).([]byte), args.Error(2)
+}
+
+func (m *MockAuthReg) DeleteAccount(ctx context.Context, userID int64, session string, clientProof []byte) (time.Time, error) {
+	args := m.Called(ctx, userID, session, clientProof)
+	return args.Get(0).(time.Time), args.Error(1)
+}
+
+func (m *MockAuthReg) RestoreAccount(ctx context.Context, session string, clientProof []byte, clientIP string) error {
+	args := m.Called(ctx, session, clientProof, clientIP)
+	return args.Error(0)
+}
+
+func (m *MockAuthReg) SendVerificationEmail(ctx context.Context, userID int64) error {
+	args := m.Called(ctx, userID)
+	return args.Error(0)
+}
+
+func (m *MockAuthReg) VerifyEmail(ctx context.Context, code string) error {
+	args := m.Called(ctx, code)
+	return args.Error(0)
+}
+
+func (m *MockAuthReg) RequestPasswordReset(ctx context.Context, email string) error {
+	args := m.Called(ctx, email)
+	return args.Error(0)
+}
+
+func (m *MockAuthReg) ResetPassword(ctx context.Context, code string, verifier models.SRPVerifier, report password.Report, totpCode, clientIP string) error {
+	args := m.Called(ctx, code, verifier, report, totpCode, clientIP)
+	return args.Error(0)
 }
 
 func Test_serverAPI_Register(t *testing.T) {
@@ -42,15 +125,18 @@ func Test_serverAPI_Register(t *testing.T) {
 			name: "Successful registration",
 			mockAuth: func() *MockAuthReg {
 				m := new(MockAuthReg)
-				m.On("RegisterNewUser", mock.Anything, "user@example.com", "password123").Return("valid-token", nil)
+				m.On("RegisterNewUser", mock.Anything, "user@example.com", validSRPVerifier, validPasswordReport).Return(validTokens, nil)
 				return m
 			},
 			args: &sso.RegisterRequest{
-				Email:    "user@example.com",
-				Password: "password123",
+				Email:        "user@example.com",
+				Verifier:     validVerifier,
+				PolicyReport: validReport,
 			},
 			want: &sso.RegisterResponse{
-				Token: "valid-token",
+				Token:        "valid-token",
+				RefreshToken: "refresh-token",
+				ExpiresAt:    validTokens.ExpiresAt.Unix(),
 			},
 			wantErr:     false,
 			wantErrCode: codes.OK,
@@ -61,20 +147,35 @@ func Test_serverAPI_Register(t *testing.T) {
 				return new(MockAuthReg)
 			},
 			args: &sso.RegisterRequest{
-				Email:    "",
-				Password: "password123",
+				Email:        "",
+				Verifier:     validVerifier,
+				PolicyReport: validReport,
+			},
+			wantErr:     true,
+			wantErrCode: codes.InvalidArgument,
+		},
+		{
+			name: "Invalid email",
+			mockAuth: func() *MockAuthReg {
+				m := new(MockAuthReg)
+				m.On("RegisterNewUser", mock.Anything, "not an email", validSRPVerifier, validPasswordReport).Return(models.TokenPair{}, auth.ErrInvalidEmail)
+				return m
+			},
+			args: &sso.RegisterRequest{
+				Email:        "not an email",
+				Verifier:     validVerifier,
+				PolicyReport: validReport,
 			},
 			wantErr:     true,
 			wantErrCode: codes.InvalidArgument,
 		},
 		{
-			name: "Missing password",
+			name: "Missing verifier",
 			mockAuth: func() *MockAuthReg {
 				return new(MockAuthReg)
 			},
 			args: &sso.RegisterRequest{
-				Email:    "user@example.com",
-				Password: "",
+				Email: "user@example.com",
 			},
 			wantErr:     true,
 			wantErrCode: codes.InvalidArgument,
@@ -83,12 +184,13 @@ func Test_serverAPI_Register(t *testing.T) {
 			name: "User already exists",
 			mockAuth: func() *MockAuthReg {
 				m := new(MockAuthReg)
-				m.On("RegisterNewUser", mock.Anything, "user@example.com", "password123").Return("", storage.ErrUserExists)
+				m.On("RegisterNewUser", mock.Anything, "user@example.com", validSRPVerifier, validPasswordReport).Return(models.TokenPair{}, auth.ErrUserAlreadyExists)
 				return m
 			},
 			args: &sso.RegisterRequest{
-				Email:    "user@example.com",
-				Password: "password123",
+				Email:        "user@example.com",
+				Verifier:     validVerifier,
+				PolicyReport: validReport,
 			},
 			wantErr:     true,
 			wantErrCode: codes.AlreadyExists,
@@ -97,12 +199,13 @@ func Test_serverAPI_Register(t *testing.T) {
 			name: "Internal error",
 			mockAuth: func() *MockAuthReg {
 				m := new(MockAuthReg)
-				m.On("RegisterNewUser", mock.Anything, "user@example.com", "password123").Return("", errors.New("internal error"))
+				m.On("RegisterNewUser", mock.Anything, "user@example.com", validSRPVerifier, validPasswordReport).Return(models.TokenPair{}, errors.New("internal error"))
 				return m
 			},
 			args: &sso.RegisterRequest{
-				Email:    "user@example.com",
-				Password: "password123",
+				Email:        "user@example.com",
+				Verifier:     validVerifier,
+				PolicyReport: validReport,
 			},
 			wantErr:     true,
 			wantErrCode: codes.Internal,
@@ -142,3 +245,101 @@ func Test_serverAPI_Register(t *testing.T) {
 		})
 	}
 }
+
+func Test_serverAPI_Register_InvalidVerifier(t *testing.T) {
+	m := new(MockAuthReg)
+	m.On("RegisterNewUser", mock.Anything, "user@example.com", validSRPVerifier, validPasswordReport).Return(models.TokenPair{}, auth.ErrInvalidVerifier)
+
+	s := &serverAPI{auth: m}
+
+	_, err := s.Register(context.Background(), &sso.RegisterRequest{
+		Email:        "user@example.com",
+		Verifier:     validVerifier,
+		PolicyReport: validReport,
+	})
+
+	st := status.Convert(err)
+	require.Equal(t, codes.InvalidArgument, st.Code())
+
+	var (
+		badRequest *errdetails.BadRequest
+		info       *errdetails.ErrorInfo
+	)
+	for _, d := range st.Details() {
+		switch d := d.(type) {
+		case *errdetails.BadRequest:
+			badRequest = d
+		case *errdetails.ErrorInfo:
+			info = d
+		}
+	}
+
+	require.NotNil(t, badRequest)
+	require.Len(t, badRequest.GetFieldViolations(), 1)
+	assert.Equal(t, "verifier", badRequest.GetFieldViolations()[0].GetField())
+
+	require.NotNil(t, info)
+	assert.Equal(t, apierror.ReasonInvalidVerifier, info.GetReason())
+}
+
+func Test_serverAPI_Register_WeakPassword(t *testing.T) {
+	weak := password.Report{Length: 8, Score: 0, Common: true}
+	policyErr := &password.PolicyError{
+		Violations: []password.Violation{
+			{Rule: password.RuleMinLength, Description: "password must be at least 10 characters long"},
+			{Rule: password.RuleCommon, Description: "password is one of the most common passwords"},
+		},
+		Strength: password.Strength{Score: 0, Common: true},
+	}
+
+	m := new(MockAuthReg)
+	m.On("RegisterNewUser", mock.Anything, "user@example.com", validSRPVerifier, weak).Return(models.TokenPair{}, policyErr)
+
+	s := &serverAPI{auth: m}
+
+	_, err := s.Register(context.Background(), &sso.RegisterRequest{
+		Email:        "user@example.com",
+		Verifier:     validVerifier,
+		PolicyReport: &sso.PasswordReport{Length: 8, Score: 0, Common: true},
+	})
+
+	st := status.Convert(err)
+	require.Equal(t, codes.InvalidArgument, st.Code())
+
+	assert.Equal(t, []string{
+		"password must be at least 10 characters long",
+		"password is one of the most common passwords",
+	}, apierror.FieldViolations(err, "password"))
+
+	var info *errdetails.ErrorInfo
+	for _, d := range st.Details() {
+		if d, ok := d.(*errdetails.ErrorInfo); ok {
+			info = d
+		}
+	}
+
+	require.NotNil(t, info)
+	assert.Equal(t, apierror.ReasonWeakPassword, info.GetReason())
+	assert.Equal(t, "min_length,common", info.GetMetadata()["rules"])
+	assert.Equal(t, "0", info.GetMetadata()["score"])
+}
+
+func Test_serverAPI_GetPasswordSettings(t *testing.T) {
+	m := new(MockAuthReg)
+	m.On("PasswordSettings", mock.Anything).Return(models.PasswordSettings{
+		Params:       "m=65536,t=3,p=4",
+		MinLength:    10,
+		MinScore:     3,
+		RejectCommon: true,
+	})
+
+	s := &serverAPI{auth: m}
+
+	got, err := s.GetPasswordSettings(context.Background(), &sso.GetPasswordSettingsRequest{})
+	require.NoError(t, err)
+
+	assert.Equal(t, "m=65536,t=3,p=4", got.GetKdfParams())
+	assert.Equal(t, int32(10), got.GetMinLength())
+	assert.Equal(t, int32(3), got.GetMinScore())
+	assert.True(t, got.GetRejectCommon())
+}
@@ -0,0 +1,382 @@
+package authgrpc
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nglmq/password-keeper/internal/domain/models"
+	"github.com/nglmq/password-keeper/internal/lib/srp"
+	"github.com/nglmq/password-keeper/internal/services/auth"
+	"github.com/stretchr/testify/mock"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/reflect/protoreflect"
+
+	sso "github.com/nglmq/password-keeper/gen/go/sso"
+)
+
+func Test_serverAPI_LoginStart(t *testing.T) {
+	clientPublic := bytes.Repeat([]byte{1}, srp.PublicSize)
+
+	tests := []struct {
+		name        string
+		mockAuth    func() *MockAuthLogin
+		args        *sso.LoginStartRequest
+		want        *sso.LoginStartResponse
+		wantErr     bool
+		wantErrCode codes.Code
+	}{
+		{
+			name: "Successful start",
+			mockAuth: func() *MockAuthLogin {
+				m := new(MockAuthLogin)
+				m.On("LoginStart", mock.Anything, "qwerty@gmail.com", clientPublic, "").Return(models.SRPChallenge{
+					Session:      "session",
+					Salt:         []byte("salt"),
+					Params:       "m=65536,t=3,p=4",
+					ServerPublic: []byte("server-public"),
+				}, nil)
+				return m
+			},
+			args: &sso.LoginStartRequest{
+				Email:        "qwerty@gmail.com",
+				ClientPublic: clientPublic,
+			},
+			want: &sso.LoginStartResponse{
+				Session:      "session",
+				Salt:         []byte("salt"),
+				KdfParams:    "m=65536,t=3,p=4",
+				ServerPublic: []byte("server-public"),
+			},
+			wantErr:     false,
+			wantErrCode: codes.OK,
+		},
+		{
+			name: "Stale params",
+			mockAuth: func() *MockAuthLogin {
+				m := new(MockAuthLogin)
+				m.On("LoginStart", mock.Anything, "qwerty@gmail.com", clientPublic, "").Return(models.SRPChallenge{
+					Session:       "session",
+					Salt:          []byte("salt"),
+					Params:        "m=16384,t=2,p=1",
+					ServerPublic:  []byte("server-public"),
+					UpgradeParams: "m=65536,t=3,p=4",
+				}, nil)
+				return m
+			},
+			args: &sso.LoginStartRequest{
+				Email:        "qwerty@gmail.com",
+				ClientPublic: clientPublic,
+			},
+			want: &sso.LoginStartResponse{
+				Session:          "session",
+				Salt:             []byte("salt"),
+				KdfParams:        "m=16384,t=2,p=1",
+				ServerPublic:     []byte("server-public"),
+				UpgradeKdfParams: "m=65536,t=3,p=4",
+			},
+			wantErr:     false,
+			wantErrCode: codes.OK,
+		},
+		{
+			name: "Legacy user",
+			mockAuth: func() *MockAuthLogin {
+				m := new(MockAuthLogin)
+				m.On("LoginStart", mock.Anything, "qwerty@gmail.com", clientPublic, "").Return(models.SRPChallenge{Legacy: true}, nil)
+				return m
+			},
+			args: &sso.LoginStartRequest{
+				Email:        "qwerty@gmail.com",
+				ClientPublic: clientPublic,
+			},
+			want: &sso.LoginStartResponse{
+				Legacy: true,
+			},
+			wantErr:     false,
+			wantErrCode: codes.OK,
+		},
+		{
+			name: "Missing email",
+			mockAuth: func() *MockAuthLogin {
+				return new(MockAuthLogin)
+			},
+			args: &sso.LoginStartRequest{
+				ClientPublic: clientPublic,
+			},
+			wantErr:     true,
+			wantErrCode: codes.InvalidArgument,
+		},
+		{
+			name: "Short client public value",
+			mockAuth: func() *MockAuthLogin {
+				return new(MockAuthLogin)
+			},
+			args: &sso.LoginStartRequest{
+				Email:        "qwerty@gmail.com",
+				ClientPublic: []byte{1, 2, 3},
+			},
+			wantErr:     true,
+			wantErrCode: codes.InvalidArgument,
+		},
+		{
+			name: "Too many attempts",
+			mockAuth: func() *MockAuthLogin {
+				m := new(MockAuthLogin)
+				m.On("LoginStart", mock.Anything, "qwerty@gmail.com", clientPublic, "").Return(models.SRPChallenge{}, &auth.LimitError{RetryAfter: 4 * time.Second})
+				return m
+			},
+			args: &sso.LoginStartRequest{
+				Email:        "qwerty@gmail.com",
+				ClientPublic: clientPublic,
+			},
+			wantErr:     true,
+			wantErrCode: codes.ResourceExhausted,
+		},
+		{
+			name: "Internal error",
+			mockAuth: func() *MockAuthLogin {
+				m := new(MockAuthLogin)
+				m.On("LoginStart", mock.Anything, "qwerty@gmail.com", clientPublic, "").Return(models.SRPChallenge{}, errors.New("internal error"))
+				return m
+			},
+			args: &sso.LoginStartRequest{
+				Email:        "qwerty@gmail.com",
+				ClientPublic: clientPublic,
+			},
+			wantErr:     true,
+			wantErrCode: codes.Internal,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &serverAPI{
+				auth: tt.mockAuth(),
+			}
+
+			got, err := s.LoginStart(context.Background(), tt.args)
+
+			if (err != nil) != tt.wantErr {
+				t.Errorf("LoginStart() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+
+			if tt.wantErr {
+				if st, _ := status.FromError(err); st.Code() != tt.wantErrCode {
+					t.Errorf("expected error code %v, got %v", tt.wantErrCode, st.Code())
+				}
+			} else if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("LoginStart() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_serverAPI_LoginFinish(t *testing.T) {
+	tests := []struct {
+		name        string
+		mockAuth    func() *MockAuthLogin
+		args        *sso.LoginFinishRequest
+		want        *sso.LoginResponse
+		wantErr     bool
+		wantErrCode codes.Code
+	}{
+		{
+			name: "Successful login",
+			mockAuth: func() *MockAuthLogin {
+				m := new(MockAuthLogin)
+				m.On("LoginFinish", mock.Anything, "session", []byte("proof"), (*models.SRPVerifier)(nil), "").Return(models.LoginResult{
+					Tokens:      validTokens,
+					ServerProof: []byte("server-proof"),
+				}, nil)
+				return m
+			},
+			args: &sso.LoginFinishRequest{
+				Session:     "session",
+				ClientProof: []byte("proof"),
+			},
+			want: &sso.LoginResponse{
+				Token:        "valid-token",
+				RefreshToken: "refresh-token",
+				ExpiresAt:    validTokens.ExpiresAt.Unix(),
+				ServerProof:  []byte("server-proof"),
+			},
+			wantErr:     false,
+			wantErrCode: codes.OK,
+		},
+		{
+			name: "Upgraded verifier",
+			mockAuth: func() *MockAuthLogin {
+				m := new(MockAuthLogin)
+				m.On("LoginFinish", mock.Anything, "session", []byte("proof"), &validSRPVerifier, "").Return(models.LoginResult{
+					Tokens:      validTokens,
+					ServerProof: []byte("server-proof"),
+				}, nil)
+				return m
+			},
+			args: &sso.LoginFinishRequest{
+				Session:     "session",
+				ClientProof: []byte("proof"),
+				NewVerifier: validVerifier,
+			},
+			want: &sso.LoginResponse{
+				Token:        "valid-token",
+				RefreshToken: "refresh-token",
+				ExpiresAt:    validTokens.ExpiresAt.Unix(),
+				ServerProof:  []byte("server-proof"),
+			},
+			wantErr:     false,
+			wantErrCode: codes.OK,
+		},
+		{
+			name: "Second factor required",
+			mockAuth: func() *MockAuthLogin {
+				m := new(MockAuthLogin)
+				m.On("LoginFinish", mock.Anything, "session", []byte("proof"), (*models.SRPVerifier)(nil), "").Return(models.LoginResult{
+					Challenge:   "challenge",
+					ServerProof: []byte("server-proof"),
+				}, nil)
+				return m
+			},
+			args: &sso.LoginFinishRequest{
+				Session:     "session",
+				ClientProof: []byte("proof"),
+			},
+			want: &sso.LoginResponse{
+				Challenge:   "challenge",
+				ServerProof: []byte("server-proof"),
+			},
+			wantErr:     false,
+			wantErrCode: codes.OK,
+		},
+		{
+			name: "Missing session",
+			mockAuth: func() *MockAuthLogin {
+				return new(MockAuthLogin)
+			},
+			args: &sso.LoginFinishRequest{
+				ClientProof: []byte("proof"),
+			},
+			wantErr:     true,
+			wantErrCode: codes.InvalidArgument,
+		},
+		{
+			name: "Missing proof",
+			mockAuth: func() *MockAuthLogin {
+				return new(MockAuthLogin)
+			},
+			args: &sso.LoginFinishRequest{
+				Session: "session",
+			},
+			wantErr:     true,
+			wantErrCode: codes.InvalidArgument,
+		},
+		{
+			name: "Wrong password",
+			mockAuth: func() *MockAuthLogin {
+				m := new(MockAuthLogin)
+				m.On("LoginFinish", mock.Anything, "session", []byte("proof"), (*models.SRPVerifier)(nil), "").Return(models.LoginResult{}, auth.ErrInvalidCredentials)
+				return m
+			},
+			args: &sso.LoginFinishRequest{
+				Session:     "session",
+				ClientProof: []byte("proof"),
+			},
+			wantErr:     true,
+			wantErrCode: codes.Unauthenticated,
+		},
+		{
+			name: "Account deleted",
+			mockAuth: func() *MockAuthLogin {
+				m := new(MockAuthLogin)
+				m.On("LoginFinish", mock.Anything, "session", []byte("proof"), (*models.SRPVerifier)(nil), "").Return(models.LoginResult{}, auth.ErrAccountDeleted)
+				return m
+			},
+			args: &sso.LoginFinishRequest{
+				Session:     "session",
+				ClientProof: []byte("proof"),
+			},
+			wantErr:     true,
+			wantErrCode: codes.FailedPrecondition,
+		},
+		{
+			name: "Account locked",
+			mockAuth: func() *MockAuthLogin {
+				m := new(MockAuthLogin)
+				m.On("LoginFinish", mock.Anything, "session", []byte("proof"), (*models.SRPVerifier)(nil), "").Return(models.LoginResult{}, &auth.LimitError{RetryAfter: time.Minute, Locked: true})
+				return m
+			},
+			args: &sso.LoginFinishRequest{
+				Session:     "session",
+				ClientProof: []byte("proof"),
+			},
+			wantErr:     true,
+			wantErrCode: codes.Unavailable,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &serverAPI{
+				auth: tt.mockAuth(),
+			}
+
+			got, err := s.LoginFinish(context.Background(), tt.args)
+
+			if (err != nil) != tt.wantErr {
+				t.Errorf("LoginFinish() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+
+			if tt.wantErr {
+				if st, _ := status.FromError(err); st.Code() != tt.wantErrCode {
+					t.Errorf("expected error code %v, got %v", tt.wantErrCode, st.Code())
+				}
+			} else if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("LoginFinish() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// The master password reaches the server only on Login of legacy users, every other request carries
+// SRP proofs or verifiers computed by the client
+func Test_requestsCarryNoPassword(t *testing.T) {
+	var check func(method string, msg protoreflect.MessageDescriptor, seen map[protoreflect.FullName]bool)
+	check = func(method string, msg protoreflect.MessageDescriptor, seen map[protoreflect.FullName]bool) {
+		if seen[msg.FullName()] {
+			return
+		}
+		seen[msg.FullName()] = true
+
+		fields := msg.Fields()
+		for i := 0; i < fields.Len(); i++ {
+			f := fields.Get(i)
+			if strings.Contains(string(f.Name()), "password") {
+				t.Errorf("%s: field %s of %s carries a password", method, f.Name(), msg.Name())
+			}
+
+			if f.Message() != nil {
+				check(method, f.Message(), seen)
+			}
+		}
+	}
+
+	services := sso.File_sso_sso_proto.Services()
+	for i := 0; i < services.Len(); i++ {
+		methods := services.Get(i).Methods()
+		for j := 0; j < methods.Len(); j++ {
+			m := methods.Get(j)
+			if m.FullName() == "auth.Auth.Login" {
+				continue
+			}
+
+			check(string(m.FullName()), m.Input(), map[protoreflect.FullName]bool{})
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package authgrpc
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/nglmq/password-keeper/internal/domain/models"
+	"github.com/nglmq/password-keeper/internal/services/auth"
+	"github.com/stretchr/testify/mock"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	sso "github.com/nglmq/password-keeper/gen/go/sso"
+)
+
+func Test_serverAPI_LoginTOTP(t *testing.T) {
+	tests := []struct {
+		name        string
+		mockAuth    func() *MockAuthLogin
+		args        *sso.LoginTOTPRequest
+		want        *sso.LoginResponse
+		wantErr     bool
+		wantErrCode codes.Code
+	}{
+		{
+			name: "Successful login",
+			mockAuth: func() *MockAuthLogin {
+				m := new(MockAuthLogin)
+				m.On("LoginTOTP", mock.Anything, "challenge", "123456", "").Return(validTokens, nil)
+				return m
+			},
+			args: &sso.LoginTOTPRequest{
+				Challenge: "challenge",
+				Code:      "123456",
+			},
+			want: &sso.LoginResponse{
+				Token:        "valid-token",
+				RefreshToken: "refresh-token",
+				ExpiresAt:    validTokens.ExpiresAt.Unix(),
+			},
+			wantErr:     false,
+			wantErrCode: codes.OK,
+		},
+		{
+			name: "Missing code",
+			mockAuth: func() *MockAuthLogin {
+				return new(MockAuthLogin)
+			},
+			args: &sso.LoginTOTPRequest{
+				Challenge: "challenge",
+			},
+			wantErr:     true,
+			wantErrCode: codes.InvalidArgument,
+		},
+		{
+			name: "Invalid code",
+			mockAuth: func() *MockAuthLogin {
+				m := new(MockAuthLogin)
+				m.On("LoginTOTP", mock.Anything, "challenge", "000000", "").Return(models.TokenPair{}, auth.ErrInvalidTOTPCode)
+				return m
+			},
+			args: &sso.LoginTOTPRequest{
+				Challenge: "challenge",
+				Code:      "000000",
+			},
+			wantErr:     true,
+			wantErrCode: codes.Unauthenticated,
+		},
+		{
+			name: "Expired challenge",
+			mockAuth: func() *MockAuthLogin {
+				m := new(MockAuthLogin)
+				m.On("LoginTOTP", mock.Anything, "expired", "123456", "").Return(models.TokenPair{}, auth.ErrInvalidChallenge)
+				return m
+			},
+			args: &sso.LoginTOTPRequest{
+				Challenge: "expired",
+				Code:      "123456",
+			},
+			wantErr:     true,
+			wantErrCode: codes.Unauthenticated,
+		},
+		{
+			name: "Internal error",
+			mockAuth: func() *MockAuthLogin {
+				m := new(MockAuthLogin)
+				m.On("LoginTOTP", mock.Anything, "challenge", "123456", "").Return(models.TokenPair{}, errors.New("internal error"))
+				return m
+			},
+			args: &sso.LoginTOTPRequest{
+				Challenge: "challenge",
+				Code:      "123456",
+			},
+			wantErr:     true,
+			wantErrCode: codes.Internal,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockAuth := tt.mockAuth()
+
+			s := &serverAPI{
+				auth: mockAuth,
+			}
+
+			got, err := s.LoginTOTP(context.Background(), tt.args)
+
+			if (err != nil) != tt.wantErr {
+				t.Errorf("LoginTOTP() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+
+			if tt.wantErr {
+				st, ok := status.FromError(err)
+				if !ok {
+					t.Errorf("expected gRPC status error, got %v", err)
+					return
+				}
+
+				if st.Code() != tt.wantErrCode {
+					t.Errorf("expected error code %v, got %v", tt.wantErrCode, st.Code())
+				}
+			} else {
+				if !reflect.DeepEqual(got, tt.want) {
+					t.Errorf("LoginTOTP() got = %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,151 @@
+// Package apierror describes errors of the gRPC API: reasons of ErrorInfo details the server puts into statuses
+// and helpers reading the details on the client.
+package apierror
+
+import (
+	"time"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/protoadapt"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// Domain is the domain of ErrorInfo details of the API
+const Domain = "password-keeper"
+
+// Reasons of ErrorInfo details, the client decides what to do by the reason and not by the status code
+const (
+	ReasonInvalidCredentials   = "INVALID_CREDENTIALS"
+	ReasonWrongPassword        = "WRONG_PASSWORD"
+	ReasonInvalidToken         = "INVALID_TOKEN"
+	ReasonInvalidChallenge     = "INVALID_CHALLENGE"
+	ReasonInvalidTOTPCode      = "INVALID_TOTP_CODE"
+	ReasonTOTPAlreadyEnabled   = "TOTP_ALREADY_ENABLED"
+	ReasonTOTPNotEnrolled      = "TOTP_NOT_ENROLLED"
+	ReasonUserExists           = "USER_EXISTS"
+	ReasonUserNotFound         = "USER_NOT_FOUND"
+	ReasonAccountDeleted       = "ACCOUNT_DELETED"
+	ReasonAccountNotDeleted    = "ACCOUNT_NOT_DELETED"
+	ReasonInvalidEmail         = "INVALID_EMAIL"
+	ReasonInvalidEmailCode     = "INVALID_EMAIL_CODE"
+	ReasonEmailNotVerified     = "EMAIL_NOT_VERIFIED"
+	ReasonEmailAlreadyVerified = "EMAIL_ALREADY_VERIFIED"
+	ReasonWeakPassword         = "WEAK_PASSWORD"
+	ReasonVaultChanged         = "VAULT_CHANGED"
+	ReasonInvalidVaultSalt     = "INVALID_VAULT_SALT"
+	ReasonInvalidVerifier      = "INVALID_VERIFIER"
+	ReasonDataIntegrity        = "DATA_INTEGRITY"
+	ReasonRecordNotFound       = "RECORD_NOT_FOUND"
+	ReasonInvalidReservation   = "INVALID_RESERVATION"
+	ReasonFileNotFound         = "FILE_NOT_FOUND"
+	ReasonChecksumMismatch     = "CHECKSUM_MISMATCH"
+	ReasonUnexpectedChunk      = "UNEXPECTED_CHUNK"
+	ReasonEmptyUpload          = "EMPTY_UPLOAD"
+	ReasonUploadIncomplete     = "UPLOAD_INCOMPLETE"
+	ReasonQuotaExceeded        = "QUOTA_EXCEEDED"
+	ReasonTooManyAttempts      = "TOO_MANY_ATTEMPTS"
+	ReasonAccountLocked        = "ACCOUNT_LOCKED"
+)
+
+// Error returns status error with ErrorInfo detail of the reason followed by the other details
+func Error(code codes.Code, reason, msg string, metadata map[string]string, details ...protoadapt.MessageV1) error {
+	st := status.New(code, msg)
+
+	detailed, err := st.WithDetails(append([]protoadapt.MessageV1{errorInfo(reason, metadata)}, details...)...)
+	if err != nil {
+		return st.Err()
+	}
+
+	return detailed.Err()
+}
+
+// RetryInfo returns RetryInfo detail telling the client to retry after the delay
+func RetryInfo(delay time.Duration) *errdetails.RetryInfo {
+	return &errdetails.RetryInfo{RetryDelay: durationpb.New(delay)}
+}
+
+// FieldError returns InvalidArgument status error with BadRequest violation of the request field
+func FieldError(field, description string) error {
+	st := status.New(codes.InvalidArgument, description)
+
+	detailed, err := st.WithDetails(BadRequest(field, description))
+	if err != nil {
+		return st.Err()
+	}
+
+	return detailed.Err()
+}
+
+// BadRequest returns BadRequest detail with a violation of the field for every description
+func BadRequest(field string, descriptions ...string) *errdetails.BadRequest {
+	violations := make([]*errdetails.BadRequest_FieldViolation, 0, len(descriptions))
+	for _, d := range descriptions {
+		violations = append(violations, &errdetails.BadRequest_FieldViolation{
+			Field:       field,
+			Description: d,
+		})
+	}
+
+	return &errdetails.BadRequest{FieldViolations: violations}
+}
+
+// Reason returns reason of ErrorInfo detail of the status error, empty if the error has no such detail
+func Reason(err error) string {
+	if info := Info(err); info != nil {
+		return info.GetReason()
+	}
+
+	return ""
+}
+
+// Info returns ErrorInfo detail of the API domain from the status error
+func Info(err error) *errdetails.ErrorInfo {
+	for _, d := range status.Convert(err).Details() {
+		if info, ok := d.(*errdetails.ErrorInfo); ok && info.GetDomain() == Domain {
+			return info
+		}
+	}
+
+	return nil
+}
+
+// FieldViolations returns descriptions of BadRequest violations of the field from the status error
+func FieldViolations(err error, field string) []string {
+	var violations []string
+
+	for _, d := range status.Convert(err).Details() {
+		badRequest, ok := d.(*errdetails.BadRequest)
+		if !ok {
+			continue
+		}
+
+		for _, v := range badRequest.GetFieldViolations() {
+			if v.GetField() == field {
+				violations = append(violations, v.GetDescription())
+			}
+		}
+	}
+
+	return violations
+}
+
+// RetryDelay returns delay of RetryInfo detail of the status error
+func RetryDelay(err error) (time.Duration, bool) {
+	for _, d := range status.Convert(err).Details() {
+		if info, ok := d.(*errdetails.RetryInfo); ok && info.GetRetryDelay() != nil {
+			return info.GetRetryDelay().AsDuration(), true
+		}
+	}
+
+	return 0, false
+}
+
+func errorInfo(reason string, metadata map[string]string) *errdetails.ErrorInfo {
+	return &errdetails.ErrorInfo{
+		Reason:   reason,
+		Domain:   Domain,
+		Metadata: metadata,
+	}
+}
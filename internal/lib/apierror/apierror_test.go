@@ -0,0 +1,52 @@
+package apierror
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestError(t *testing.T) {
+	err := Error(codes.ResourceExhausted, ReasonTooManyAttempts, "too many attempts",
+		map[string]string{"email": "user@example.com"}, RetryInfo(3*time.Second))
+
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	assert.Equal(t, ReasonTooManyAttempts, Reason(err))
+	assert.Equal(t, "user@example.com", Info(err).GetMetadata()["email"])
+
+	delay, ok := RetryDelay(err)
+	assert.True(t, ok)
+	assert.Equal(t, 3*time.Second, delay)
+
+	// clients wrap status errors
+	assert.Equal(t, ReasonTooManyAttempts, Reason(fmt.Errorf("failed to login: %w", err)))
+}
+
+func TestFieldViolations(t *testing.T) {
+	err := Error(codes.InvalidArgument, ReasonInvalidVerifier, "invalid verifier", nil,
+		BadRequest("verifier", "salt is too short", "params are too weak"))
+
+	assert.Equal(t, []string{"salt is too short", "params are too weak"}, FieldViolations(err, "verifier"))
+	assert.Empty(t, FieldViolations(err, "email"))
+
+	err = FieldError("email", "email should not be empty")
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	assert.Equal(t, []string{"email should not be empty"}, FieldViolations(err, "email"))
+	assert.Empty(t, Reason(err))
+}
+
+func TestReason_OtherDomain(t *testing.T) {
+	st, _ := status.New(codes.Unavailable, "unavailable").WithDetails(&errdetails.ErrorInfo{
+		Reason: ReasonAccountLocked,
+		Domain: "googleapis.com",
+	})
+
+	assert.Empty(t, Reason(st.Err()))
+	assert.Empty(t, Reason(errors.New("not a status")))
+}
@@ -0,0 +1,131 @@
+// Package attachment describes files attached to records. The client encrypts every file with its own key
+// kept in the meta of the file, and the meta is encrypted with the vault key, so changing the password
+// re-encrypts only the meta and never the content of files.
+package attachment
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/nglmq/password-keeper/internal/lib/crypt"
+)
+
+// ChunkSize is the size of content of every chunk but the last one
+const ChunkSize = 256 << 10
+
+// ErrChecksumMismatch is returned for chunks and files not matching their checksums
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+// Meta describes content of the file, it is stored on the server encrypted with the vault key
+type Meta struct {
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+	// SHA256 is the checksum of the whole content, it is checked after download
+	SHA256 []byte `json:"sha256"`
+	// Key encrypts chunks of the file
+	Key []byte `json:"key"`
+}
+
+// NewMeta returns meta of the file with a new random key
+func NewMeta(name string, size int64, sum []byte) (Meta, error) {
+	key, err := crypt.GenerateKey()
+	if err != nil {
+		return Meta{}, err
+	}
+
+	return Meta{
+		Name:   name,
+		Size:   size,
+		SHA256: sum,
+		Key:    key,
+	}, nil
+}
+
+// Marshal serializes the meta
+func (m Meta) Marshal() (string, error) {
+	b, err := json.Marshal(m)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal meta: %w", err)
+	}
+
+	return string(b), nil
+}
+
+// ChunkCount returns number of chunks of the file
+func (m Meta) ChunkCount() int {
+	return int((m.Size + ChunkSize - 1) / ChunkSize)
+}
+
+// ParseMeta returns meta serialized with Marshal
+func ParseMeta(s string) (Meta, error) {
+	var m Meta
+	if err := json.Unmarshal([]byte(s), &m); err != nil {
+		return Meta{}, fmt.Errorf("failed to unmarshal meta: %w", err)
+	}
+
+	if len(m.Key) != crypt.KeySize {
+		return Meta{}, fmt.Errorf("invalid key size %d", len(m.Key))
+	}
+
+	return m, nil
+}
+
+// Checksum returns SHA-256 of the data
+func Checksum(data []byte) []byte {
+	sum := sha256.Sum256(data)
+
+	return sum[:]
+}
+
+// Cipher encrypts chunks of one file, every chunk is bound to the file and its position,
+// so chunks can not be reordered or moved to another file
+type Cipher struct {
+	crypt  crypt.Crypter
+	fileID int64
+}
+
+// NewCipher returns Cipher of chunks of the file with the key from meta
+func NewCipher(meta Meta, fileID int64) (*Cipher, error) {
+	c, err := crypt.NewCrypt(meta.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Cipher{crypt: c, fileID: fileID}, nil
+}
+
+// SealChunk encrypts content of the chunk and returns it with its checksum
+func (c *Cipher) SealChunk(index int, data []byte) (sealed, checksum []byte, err error) {
+	sealed, err = c.crypt.SealBytes(data, c.chunkAD(index))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to encrypt chunk: %w", err)
+	}
+
+	return sealed, Checksum(sealed), nil
+}
+
+// OpenChunk checks the checksum of the chunk and returns its content
+func (c *Cipher) OpenChunk(index int, sealed, checksum []byte) ([]byte, error) {
+	if !bytes.Equal(Checksum(sealed), checksum) {
+		return nil, fmt.Errorf("chunk %d: %w", index, ErrChecksumMismatch)
+	}
+
+	data, err := c.crypt.OpenBytes(sealed, c.chunkAD(index))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt chunk %d: %w", index, err)
+	}
+
+	return data, nil
+}
+
+func (c *Cipher) chunkAD(index int) []byte {
+	ad := make([]byte, 12)
+	binary.BigEndian.PutUint64(ad[:8], uint64(c.fileID))
+	binary.BigEndian.PutUint32(ad[8:], uint32(index))
+
+	return ad
+}
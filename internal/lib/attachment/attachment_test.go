@@ -0,0 +1,75 @@
+package attachment
+
+import (
+	"testing"
+
+	"github.com/nglmq/password-keeper/internal/lib/crypt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMeta_RoundTrip(t *testing.T) {
+	meta, err := NewMeta("backup.tar", 3*ChunkSize+1, Checksum([]byte("content")))
+	require.NoError(t, err)
+	assert.Len(t, meta.Key, crypt.KeySize)
+
+	s, err := meta.Marshal()
+	require.NoError(t, err)
+
+	got, err := ParseMeta(s)
+	require.NoError(t, err)
+	assert.Equal(t, meta, got)
+	assert.Equal(t, 4, got.ChunkCount())
+}
+
+func TestParseMeta_InvalidKey(t *testing.T) {
+	_, err := ParseMeta(`{"name":"a.txt","size":1,"key":"AAEC"}`)
+	assert.Error(t, err)
+}
+
+func TestMeta_ChunkCount(t *testing.T) {
+	tests := []struct {
+		size int64
+		want int
+	}{
+		{size: 1, want: 1},
+		{size: ChunkSize, want: 1},
+		{size: ChunkSize + 1, want: 2},
+		{size: 10 * ChunkSize, want: 10},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, Meta{Size: tt.size}.ChunkCount(), "size %d", tt.size)
+	}
+}
+
+func TestCipher_SealOpenChunk(t *testing.T) {
+	meta, err := NewMeta("a.txt", 5, nil)
+	require.NoError(t, err)
+
+	c, err := NewCipher(meta, 7)
+	require.NoError(t, err)
+
+	sealed, checksum, err := c.SealChunk(1, []byte("chunk"))
+	require.NoError(t, err)
+	assert.Equal(t, Checksum(sealed), checksum)
+
+	got, err := c.OpenChunk(1, sealed, checksum)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("chunk"), got)
+
+	// chunks are bound to their position and file
+	_, err = c.OpenChunk(2, sealed, checksum)
+	assert.ErrorIs(t, err, crypt.ErrIntegrity)
+
+	other, err := NewCipher(meta, 8)
+	require.NoError(t, err)
+
+	_, err = other.OpenChunk(1, sealed, checksum)
+	assert.ErrorIs(t, err, crypt.ErrIntegrity)
+
+	sealed[len(sealed)-1] ^= 1
+
+	_, err = c.OpenChunk(1, sealed, checksum)
+	assert.ErrorIs(t, err, ErrChecksumMismatch)
+}
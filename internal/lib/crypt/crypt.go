@@ -9,24 +9,30 @@ import (
 )
 
 type Crypter interface {
-	Encode(payload string) string
+	Encode(payload string) (string, error)
 	Decode(sha string) (string, error)
+	Seal(payload string, ad []byte) (string, error)
+	Open(sha string, ad []byte) (string, error)
+	OpenLegacy(sha string) (string, error)
+	SealBytes(src, ad []byte) ([]byte, error)
+	OpenBytes(src, ad []byte) ([]byte, error)
 }
 
-var (
-	key = []byte{4, 51, 71, 14, 63, 8, 95, 100, 44, 4, 19, 85, 57, 54, 23, 54, 26, 59, 24, 44, 47, 52, 63, 1, 84, 24,
-		23, 51, 3, 88, 72, 73}
-	nonce = []byte{4, 51, 71, 14, 63, 8, 95, 100, 44, 4, 19, 85}
-)
+// KeySize is the size of AES-256 keys used for data and master keys.
+const KeySize = 32
+
+// legacyNonce was reused for every record before envelopes were introduced.
+// It is only used to read records written by older versions.
+var legacyNonce = []byte{4, 51, 71, 14, 63, 8, 95, 100, 44, 4, 19, 85}
 
 type crypt struct {
 	aesGCM   cipher.AEAD
 	aesBlock cipher.Block
-	nonce    []byte
+	keyID    uint32
 }
 
-// NewCrypt - creates new Crypter instance.
-func NewCrypt() (*crypt, error) {
+// NewCrypt - creates new Crypter instance for the given key.
+func NewCrypt(key []byte) (*crypt, error) {
 	aesBlock, errBlock := aes.NewCipher(key)
 	if errBlock != nil {
 		return nil, fmt.Errorf("error in creating new cipher: %w", errBlock)
@@ -40,34 +46,101 @@ func NewCrypt() (*crypt, error) {
 	return &crypt{
 		aesGCM:   aesGCM,
 		aesBlock: aesBlock,
-		nonce:    nonce,
+		keyID:    KeyID(key),
 	}, nil
+}
 
+// Encode - returns hex of envelope with payload sealed by aesGCM with random nonce.
+func (c *crypt) Encode(payload string) (string, error) {
+	return c.Seal(payload, nil)
 }
 
-// Encode - returns sha of sealed payload by aesGCM.
-func (c *crypt) Encode(payload string) string {
-	src := []byte(payload)
+// Decode - returns decoded string by aesGCM from hex of envelope.
+func (c *crypt) Decode(sha string) (string, error) {
+	return c.Open(sha, nil)
+}
 
-	dst := c.aesGCM.Seal(nil, c.nonce, src, nil)
+// Seal - same as Encode, but binds the record to associated data ad which must be passed to Open.
+func (c *crypt) Seal(payload string, ad []byte) (string, error) {
+	dst, err := seal(c.aesGCM, c.keyID, []byte(payload), ad)
+	if err != nil {
+		return "", err
+	}
 
-	sha := hex.EncodeToString(dst)
+	return hex.EncodeToString(dst), nil
+}
 
-	return sha
+// SealBytes - same as Seal, but returns envelope bytes without hex encoding, used for file chunks.
+func (c *crypt) SealBytes(src, ad []byte) ([]byte, error) {
+	return seal(c.aesGCM, c.keyID, src, ad)
 }
 
-// Decode - returns decoded string by aesGCM from sha.
-func (c *crypt) Decode(sha string) (string, error) {
-	sha = strings.Trim(sha, "\"")
+// OpenBytes - returns content of envelope bytes sealed by SealBytes with the same associated data.
+// There are no legacy envelopes of bytes, so ErrUnknownKey is returned for envelopes of other keys.
+func (c *crypt) OpenBytes(src, ad []byte) ([]byte, error) {
+	env, err := parseEnvelope(src, c.aesGCM.NonceSize())
+	if err != nil {
+		return nil, err
+	}
 
-	dst, errDecode := hex.DecodeString(sha)
-	if errDecode != nil {
-		return "", fmt.Errorf("hex decode error: %w", errDecode)
+	if env.keyID != c.keyID {
+		return nil, fmt.Errorf("%w: %08x", ErrUnknownKey, env.keyID)
 	}
 
-	src, errGCM := c.aesGCM.Open(nil, c.nonce, dst, nil)
-	if errGCM != nil {
-		return "", errDecode
+	return open(c.aesGCM, env, ad)
+}
+
+// Open - returns decoded string from hex of envelope sealed with the same associated data.
+// ErrIntegrity is returned if the record was sealed with this key but was modified or sealed for other ad,
+// ErrUnknownKey if it was not sealed with this key, ErrUnbound if it was written before associated data
+// was introduced and has to be opened with OpenLegacy and sealed again.
+func (c *crypt) Open(sha string, ad []byte) (string, error) {
+	dst, err := hex.DecodeString(strings.Trim(sha, "\""))
+	if err != nil {
+		return "", fmt.Errorf("hex decode error: %w", err)
+	}
+
+	env, err := parseEnvelope(dst, c.aesGCM.NonceSize())
+	if err != nil {
+		return "", err
+	}
+
+	if env.keyID != c.keyID {
+		return "", fmt.Errorf("%w: %08x", ErrUnknownKey, env.keyID)
+	}
+
+	if env.version != envelopeV2 {
+		return "", ErrUnbound
+	}
+
+	src, err := open(c.aesGCM, env, ad)
+	if err != nil {
+		return "", err
+	}
+
+	return string(src), nil
+}
+
+// OpenLegacy - returns decoded string of the record written before associated data was introduced:
+// v1 envelope or record sealed with the fixed nonce before envelopes. Such records are not bound to anything,
+// so it is only used to seal them again.
+func (c *crypt) OpenLegacy(sha string) (string, error) {
+	dst, err := hex.DecodeString(strings.Trim(sha, "\""))
+	if err != nil {
+		return "", fmt.Errorf("hex decode error: %w", err)
+	}
+
+	env, errEnv := parseEnvelope(dst, c.aesGCM.NonceSize())
+	if errEnv == nil && env.keyID == c.keyID && env.version == envelopeV1 {
+		if src, err := open(c.aesGCM, env, nil); err == nil {
+			return string(src), nil
+		}
+	}
+
+	// record sealed with the fixed nonce can start with the envelope header by chance
+	src, err := c.aesGCM.Open(nil, legacyNonce, dst, nil)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrUnknownKey, err)
 	}
 
 	return string(src), nil
@@ -0,0 +1,165 @@
+package crypt
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCrypt_EncodeDecode(t *testing.T) {
+	key, err := GenerateKey()
+	require.NoError(t, err)
+
+	c, err := NewCrypt(key)
+	require.NoError(t, err)
+
+	first, err := c.Encode("secret")
+	require.NoError(t, err)
+
+	second, err := c.Encode("secret")
+	require.NoError(t, err)
+
+	assert.NotEqual(t, first, second, "every record must get own nonce")
+
+	raw, err := hex.DecodeString(first)
+	require.NoError(t, err)
+	assert.Equal(t, envelopeV2, raw[0])
+
+	got, err := c.Decode(`"` + first + `"`)
+	require.NoError(t, err)
+	assert.Equal(t, "secret", got)
+}
+
+func TestCrypt_OpenLegacy(t *testing.T) {
+	key, err := GenerateKey()
+	require.NoError(t, err)
+
+	c, err := NewCrypt(key)
+	require.NoError(t, err)
+
+	// record sealed with the fixed nonce as older versions did
+	legacy := hex.EncodeToString(c.aesGCM.Seal(nil, legacyNonce, []byte("old secret"), nil))
+
+	got, err := c.OpenLegacy(legacy)
+	require.NoError(t, err)
+	assert.Equal(t, "old secret", got)
+
+	// only sealed records are opened by Open
+	_, err = c.Decode(legacy)
+	assert.Error(t, err)
+
+	// envelope sealed with associated data gets no second attempt without it
+	sealed, err := c.Seal("secret", []byte("user:1;record:1"))
+	require.NoError(t, err)
+
+	_, err = c.OpenLegacy(sealed)
+	assert.ErrorIs(t, err, ErrUnknownKey)
+}
+
+func TestCrypt_DecodeUnknownKey(t *testing.T) {
+	key, err := GenerateKey()
+	require.NoError(t, err)
+
+	c, err := NewCrypt(key)
+	require.NoError(t, err)
+
+	sealed, err := c.Encode("secret")
+	require.NoError(t, err)
+
+	otherKey, err := GenerateKey()
+	require.NoError(t, err)
+
+	other, err := NewCrypt(otherKey)
+	require.NoError(t, err)
+
+	_, err = other.Decode(sealed)
+	assert.ErrorIs(t, err, ErrUnknownKey)
+}
+
+func TestCrypt_SealOpen(t *testing.T) {
+	key, err := GenerateKey()
+	require.NoError(t, err)
+
+	c, err := NewCrypt(key)
+	require.NoError(t, err)
+
+	sealed, err := c.Seal("secret", []byte("user:1;record:1"))
+	require.NoError(t, err)
+
+	got, err := c.Open(sealed, []byte("user:1;record:1"))
+	require.NoError(t, err)
+	assert.Equal(t, "secret", got)
+
+	_, err = c.Open(sealed, []byte("user:2;record:1"))
+	assert.ErrorIs(t, err, ErrIntegrity)
+
+	_, err = c.Open(sealed, nil)
+	assert.ErrorIs(t, err, ErrIntegrity)
+
+	// header is authenticated as well, so the record cannot be downgraded to envelope without ad
+	raw, err := hex.DecodeString(sealed)
+	require.NoError(t, err)
+	raw[0] = envelopeV1
+
+	_, err = c.Open(hex.EncodeToString(raw), nil)
+	assert.ErrorIs(t, err, ErrUnbound)
+
+	_, err = c.OpenLegacy(hex.EncodeToString(raw))
+	assert.Error(t, err)
+}
+
+func TestCrypt_OpenV1(t *testing.T) {
+	key, err := GenerateKey()
+	require.NoError(t, err)
+
+	c, err := NewCrypt(key)
+	require.NoError(t, err)
+
+	// envelope written before associated data was introduced
+	nonce := make([]byte, c.aesGCM.NonceSize())
+	header := []byte{envelopeV1, 0, 0, 0, 0}
+	binary.BigEndian.PutUint32(header[versionSize:], c.keyID)
+	raw := c.aesGCM.Seal(append(append([]byte{}, header...), nonce...), nonce, []byte("secret"), nil)
+
+	_, err = c.Decode(hex.EncodeToString(raw))
+	assert.ErrorIs(t, err, ErrUnbound)
+
+	got, err := c.OpenLegacy(hex.EncodeToString(raw))
+	require.NoError(t, err)
+	assert.Equal(t, "secret", got)
+}
+
+func TestCrypt_SealOpenBytes(t *testing.T) {
+	key, err := GenerateKey()
+	require.NoError(t, err)
+
+	c, err := NewCrypt(key)
+	require.NoError(t, err)
+
+	src := []byte{0, 1, 2, 255}
+
+	sealed, err := c.SealBytes(src, []byte("file:1;chunk:0"))
+	require.NoError(t, err)
+
+	got, err := c.OpenBytes(sealed, []byte("file:1;chunk:0"))
+	require.NoError(t, err)
+	assert.Equal(t, src, got)
+
+	_, err = c.OpenBytes(sealed, []byte("file:1;chunk:1"))
+	assert.ErrorIs(t, err, ErrIntegrity)
+
+	otherKey, err := GenerateKey()
+	require.NoError(t, err)
+
+	other, err := NewCrypt(otherKey)
+	require.NoError(t, err)
+
+	_, err = other.OpenBytes(sealed, []byte("file:1;chunk:0"))
+	assert.ErrorIs(t, err, ErrUnknownKey)
+
+	_, err = c.OpenBytes(sealed[:3], nil)
+	assert.ErrorIs(t, err, ErrInvalidEnvelope)
+}
@@ -0,0 +1,105 @@
+package crypt
+
+import (
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Layout of sealed records:
+// version (1 byte) | key id (4 bytes, big endian) | nonce (12 bytes) | ciphertext with GCM tag.
+//
+// envelopeV1 is sealed without associated data.
+// envelopeV2 is sealed with the header followed by caller's associated data as GCM additional data,
+// so neither the header nor the context the record was sealed for can be changed.
+const (
+	envelopeV1 byte = 1
+	envelopeV2 byte = 2
+
+	versionSize = 1
+	keyIDSize   = 4
+	headerSize  = versionSize + keyIDSize
+)
+
+var (
+	ErrInvalidEnvelope = errors.New("invalid envelope")
+	ErrUnknownKey      = errors.New("unknown key id")
+	ErrIntegrity       = errors.New("integrity check failed")
+	ErrUnbound         = errors.New("record is not bound to associated data")
+)
+
+// KeyID - returns identifier of the key which is stored in envelopes sealed with it.
+func KeyID(key []byte) uint32 {
+	sum := sha256.Sum256(key)
+
+	return binary.BigEndian.Uint32(sum[:keyIDSize])
+}
+
+// envelope is a parsed sealed record.
+type envelope struct {
+	header     []byte
+	version    byte
+	keyID      uint32
+	nonce      []byte
+	ciphertext []byte
+}
+
+// seal - encrypts src with random nonce and returns envelope bytes, ad is authenticated but not stored.
+func seal(aesGCM cipher.AEAD, keyID uint32, src, ad []byte) ([]byte, error) {
+	nonceSize := aesGCM.NonceSize()
+
+	dst := make([]byte, headerSize+nonceSize, headerSize+nonceSize+len(src)+aesGCM.Overhead())
+	dst[0] = envelopeV2
+	binary.BigEndian.PutUint32(dst[versionSize:headerSize], keyID)
+
+	nonce := dst[headerSize : headerSize+nonceSize]
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+
+	return aesGCM.Seal(dst, nonce, src, additionalData(dst[:headerSize], ad)), nil
+}
+
+// open - decrypts envelope sealed with the same ad.
+func open(aesGCM cipher.AEAD, env envelope, ad []byte) ([]byte, error) {
+	var additional []byte
+	if env.version == envelopeV2 {
+		additional = additionalData(env.header, ad)
+	}
+
+	src, err := aesGCM.Open(nil, env.nonce, env.ciphertext, additional)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrIntegrity, err)
+	}
+
+	return src, nil
+}
+
+func additionalData(header, ad []byte) []byte {
+	res := make([]byte, 0, len(header)+len(ad))
+	res = append(res, header...)
+
+	return append(res, ad...)
+}
+
+// parseEnvelope - splits envelope bytes into parts without decrypting them.
+func parseEnvelope(src []byte, nonceSize int) (envelope, error) {
+	if len(src) < headerSize+nonceSize {
+		return envelope{}, ErrInvalidEnvelope
+	}
+
+	if src[0] != envelopeV1 && src[0] != envelopeV2 {
+		return envelope{}, fmt.Errorf("%w: unsupported version %d", ErrInvalidEnvelope, src[0])
+	}
+
+	return envelope{
+		header:     src[:headerSize],
+		version:    src[0],
+		keyID:      binary.BigEndian.Uint32(src[versionSize:headerSize]),
+		nonce:      src[headerSize : headerSize+nonceSize],
+		ciphertext: src[headerSize+nonceSize:],
+	}, nil
+}
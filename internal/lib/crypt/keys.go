@@ -0,0 +1,147 @@
+package crypt
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var ErrInvalidKey = errors.New("invalid key")
+
+// GenerateKey - returns new random key suitable for NewCrypt.
+func GenerateKey() ([]byte, error) {
+	key := make([]byte, KeySize)
+
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("failed to generate key: %w", err)
+	}
+
+	return key, nil
+}
+
+// ParseKey - decodes hex encoded key and checks its size.
+func ParseKey(s string) ([]byte, error) {
+	key, err := hex.DecodeString(strings.TrimSpace(s))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidKey, err)
+	}
+
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("%w: expected %d bytes, got %d", ErrInvalidKey, KeySize, len(key))
+	}
+
+	return key, nil
+}
+
+// ParseKeys - decodes list of hex encoded keys.
+func ParseKeys(list []string) ([][]byte, error) {
+	keys := make([][]byte, 0, len(list))
+
+	for _, s := range list {
+		key, err := ParseKey(s)
+		if err != nil {
+			return nil, err
+		}
+
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
+
+// KeyWrapper wraps and unwraps per-user data keys with the server master key.
+// Keys wrapped by previous master keys can still be unwrapped while they are rotated.
+type KeyWrapper struct {
+	aesGCM cipher.AEAD
+	keyID  uint32
+	keys   map[uint32]cipher.AEAD
+}
+
+// NewKeyWrapper - creates new KeyWrapper for the given master key and still active previous master keys.
+func NewKeyWrapper(masterKey []byte, previousKeys ...[]byte) (*KeyWrapper, error) {
+	w := &KeyWrapper{
+		keyID: KeyID(masterKey),
+		keys:  make(map[uint32]cipher.AEAD, len(previousKeys)+1),
+	}
+
+	for _, key := range append([][]byte{masterKey}, previousKeys...) {
+		aesGCM, err := newGCM(key)
+		if err != nil {
+			return nil, err
+		}
+
+		w.keys[KeyID(key)] = aesGCM
+	}
+
+	w.aesGCM = w.keys[w.keyID]
+
+	return w, nil
+}
+
+// KeyID - returns id of the current master key.
+func (w *KeyWrapper) KeyID() uint32 {
+	return w.keyID
+}
+
+// Wrap - encrypts data key with the current master key into hex of envelope.
+func (w *KeyWrapper) Wrap(dataKey []byte) (string, error) {
+	dst, err := seal(w.aesGCM, w.keyID, dataKey, nil)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(dst), nil
+}
+
+// Unwrap - decrypts data key wrapped by Wrap with the current or one of previous master keys.
+func (w *KeyWrapper) Unwrap(wrapped string) ([]byte, error) {
+	src, err := hex.DecodeString(wrapped)
+	if err != nil {
+		return nil, fmt.Errorf("hex decode error: %w", err)
+	}
+
+	env, err := parseEnvelope(src, w.aesGCM.NonceSize())
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidKey, err)
+	}
+
+	aesGCM, ok := w.keys[env.keyID]
+	if !ok {
+		return nil, fmt.Errorf("%w: %08x", ErrUnknownKey, env.keyID)
+	}
+
+	dataKey, err := open(aesGCM, env, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidKey, err)
+	}
+
+	return dataKey, nil
+}
+
+// Rewrap - re-encrypts wrapped data key with the current master key.
+func (w *KeyWrapper) Rewrap(wrapped string) (string, error) {
+	dataKey, err := w.Unwrap(wrapped)
+	if err != nil {
+		return "", err
+	}
+
+	return w.Wrap(dataKey)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	aesBlock, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("error in creating new cipher: %w", err)
+	}
+
+	aesGCM, err := cipher.NewGCM(aesBlock)
+	if err != nil {
+		return nil, fmt.Errorf("error in creating GCM: %w", err)
+	}
+
+	return aesGCM, nil
+}
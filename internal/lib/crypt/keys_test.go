@@ -0,0 +1,111 @@
+package crypt
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyWrapper(t *testing.T) {
+	masterKey, err := GenerateKey()
+	require.NoError(t, err)
+
+	w, err := NewKeyWrapper(masterKey)
+	require.NoError(t, err)
+
+	dataKey, err := GenerateKey()
+	require.NoError(t, err)
+
+	wrapped, err := w.Wrap(dataKey)
+	require.NoError(t, err)
+
+	unwrapped, err := w.Unwrap(wrapped)
+	require.NoError(t, err)
+	assert.Equal(t, dataKey, unwrapped)
+
+	otherKey, err := GenerateKey()
+	require.NoError(t, err)
+
+	other, err := NewKeyWrapper(otherKey)
+	require.NoError(t, err)
+
+	_, err = other.Unwrap(wrapped)
+	assert.True(t, errors.Is(err, ErrUnknownKey))
+}
+
+func TestKeyWrapper_Rotation(t *testing.T) {
+	oldKey, err := GenerateKey()
+	require.NoError(t, err)
+
+	newKey, err := GenerateKey()
+	require.NoError(t, err)
+
+	old, err := NewKeyWrapper(oldKey)
+	require.NoError(t, err)
+
+	dataKey, err := GenerateKey()
+	require.NoError(t, err)
+
+	wrapped, err := old.Wrap(dataKey)
+	require.NoError(t, err)
+
+	w, err := NewKeyWrapper(newKey, oldKey)
+	require.NoError(t, err)
+	assert.Equal(t, KeyID(newKey), w.KeyID())
+
+	unwrapped, err := w.Unwrap(wrapped)
+	require.NoError(t, err)
+	assert.Equal(t, dataKey, unwrapped)
+
+	rewrapped, err := w.Rewrap(wrapped)
+	require.NoError(t, err)
+
+	// after rotation the old key is retired
+	retired, err := NewKeyWrapper(newKey)
+	require.NoError(t, err)
+
+	unwrapped, err = retired.Unwrap(rewrapped)
+	require.NoError(t, err)
+	assert.Equal(t, dataKey, unwrapped)
+
+	_, err = retired.Unwrap(wrapped)
+	assert.ErrorIs(t, err, ErrUnknownKey)
+}
+
+func TestParseKey(t *testing.T) {
+	tests := []struct {
+		name    string
+		key     string
+		wantErr bool
+	}{
+		{
+			name: "Valid key",
+			key:  "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f\n",
+		},
+		{
+			name:    "Short key",
+			key:     "0001020304",
+			wantErr: true,
+		},
+		{
+			name:    "Not hex",
+			key:     "not a key",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := ParseKey(tt.key)
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrInvalidKey)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Len(t, key, KeySize)
+		})
+	}
+}
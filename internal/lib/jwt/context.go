@@ -0,0 +1,17 @@
+package jwt
+
+import "context"
+
+type claimsKey struct{}
+
+// NewContext returns a copy of ctx carrying claims of the authenticated access token
+func NewContext(ctx context.Context, claims Claims) context.Context {
+	return context.WithValue(ctx, claimsKey{}, claims)
+}
+
+// FromContext returns claims stored in ctx by NewContext
+func FromContext(ctx context.Context) (Claims, bool) {
+	claims, ok := ctx.Value(claimsKey{}).(Claims)
+
+	return claims, ok
+}
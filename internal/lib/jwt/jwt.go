@@ -1,24 +1,116 @@
 package jwt
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/nglmq/password-keeper/internal/domain/models"
-	"time"
 )
 
-// superSecret is the secret key used to sign the JWT token
-const superSecret = "superSecret"
+var (
+	ErrUnknownKey   = errors.New("unknown signing key")
+	ErrNoUserID     = errors.New("token has no user id")
+	ErrNoIssuedAt   = errors.New("token has no issue time")
+	ErrTokenRevoked = errors.New("token revoked")
+)
+
+// IssuedAtPrecision is the precision of iat of issued tokens. Tokens of the user issued before a time are revoked
+// at once, with iat in seconds tokens issued earlier in the same second would survive it.
+const IssuedAtPrecision = time.Millisecond
+
+func init() {
+	// the library truncates times of claims to seconds by default, with microseconds iat in milliseconds
+	// is read back exactly after rounding, the float of the claim loses less than that
+	jwt.TimePrecision = time.Microsecond
+}
+
+// RevocationTime returns the time to revoke tokens of the user issued before with and waits until it comes,
+// so every token issued before the call returns has earlier iat and every token issued after it has not
+func RevocationTime() time.Time {
+	t := time.Now().Truncate(IssuedAtPrecision).Add(IssuedAtPrecision)
+	time.Sleep(time.Until(t))
+
+	return t
+}
+
+// RevocationList tells whether a token was revoked before it expired
+type RevocationList interface {
+	IsTokenRevoked(ctx context.Context, jti string, userID int64, issuedAt time.Time) (bool, error)
+}
+
+// Claims of tokens issued by Manager
+type Claims struct {
+	UID   int64  `json:"uid"`
+	Email string `json:"email"`
+	jwt.RegisteredClaims
+}
+
+// Manager issues and validates JWT tokens.
+// Tokens are signed with the signing key and validated with it or any of verification keys,
+// so tokens signed with the previous key stay valid while the key is rotated.
+type Manager struct {
+	issuer      string
+	audience    string
+	signingKey  Key
+	keys        map[string]Key
+	revocations RevocationList
+}
+
+// New creates a new Manager
+func New(issuer, audience string, signingKey Key, verificationKeys ...Key) (*Manager, error) {
+	if signingKey.signKey == nil {
+		return nil, fmt.Errorf("signing key %q has no private key", signingKey.ID)
+	}
+
+	m := &Manager{
+		issuer:     issuer,
+		audience:   audience,
+		signingKey: signingKey,
+		keys:       make(map[string]Key, len(verificationKeys)+1),
+	}
+
+	for _, key := range append([]Key{signingKey}, verificationKeys...) {
+		if _, ok := m.keys[key.ID]; ok {
+			return nil, fmt.Errorf("duplicate key id %q", key.ID)
+		}
+
+		m.keys[key.ID] = key
+	}
+
+	return m, nil
+}
+
+// SetRevocationList makes ValidateToken reject tokens revoked in the list
+func (m *Manager) SetRevocationList(list RevocationList) {
+	m.revocations = list
+}
 
 // NewToken creates a new JWT token for the given user
-func NewToken(user models.User, duration time.Duration) (string, error) {
-	token := jwt.New(jwt.SigningMethodHS256)
+func (m *Manager) NewToken(user models.User, duration time.Duration) (string, error) {
+	now := time.Now()
 
-	claims := token.Claims.(jwt.MapClaims)
-	claims["uid"] = user.ID
-	claims["email"] = user.Email
-	claims["exp"] = time.Now().Add(duration).Unix()
+	jti, err := newID()
+	if err != nil {
+		return "", err
+	}
 
-	tokenString, err := token.SignedString([]byte(superSecret))
+	token := jwt.NewWithClaims(m.signingKey.method, Claims{
+		UID:   user.ID,
+		Email: user.Email,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        jti,
+			Issuer:    m.issuer,
+			Audience:  jwt.ClaimStrings{m.audience},
+			IssuedAt:  jwt.NewNumericDate(now.Truncate(IssuedAtPrecision)),
+			ExpiresAt: jwt.NewNumericDate(now.Add(duration)),
+		},
+	})
+	token.Header["kid"] = m.signingKey.ID
+
+	tokenString, err := token.SignedString(m.signingKey.signKey)
 	if err != nil {
 		return "", err
 	}
@@ -26,24 +118,69 @@ func NewToken(user models.User, duration time.Duration) (string, error) {
 	return tokenString, nil
 }
 
-// ValidateToken validates the given JWT token and returns the user ID
-func ValidateToken(tokenString string) (int64, error) {
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		return []byte(superSecret), nil
-	})
+// ValidateToken validates the given JWT token as ParseToken does and returns the user ID
+func (m *Manager) ValidateToken(ctx context.Context, tokenString string) (int64, error) {
+	claims, err := m.ParseToken(ctx, tokenString)
 	if err != nil {
 		return 0, err
 	}
 
-	claims, ok := token.Claims.(jwt.MapClaims)
-	if !ok {
-		return 0, jwt.ErrTokenInvalidClaims
+	return claims.UID, nil
+}
+
+// ParseToken validates signature, exp, iat, iss and aud of the given JWT token,
+// checks it is not revoked and returns its claims
+func (m *Manager) ParseToken(ctx context.Context, tokenString string) (Claims, error) {
+	var claims Claims
+
+	_, err := jwt.ParseWithClaims(tokenString, &claims, m.keyFunc,
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithIssuer(m.issuer),
+		jwt.WithAudience(m.audience),
+	)
+	if err != nil {
+		return Claims{}, err
 	}
 
-	uid, ok := claims["uid"].(float64)
+	if claims.UID == 0 {
+		return Claims{}, ErrNoUserID
+	}
+
+	// iat is checked by the parser only when it is set, revocation needs it
+	if claims.IssuedAt == nil {
+		return Claims{}, ErrNoIssuedAt
+	}
+
+	if m.revocations != nil {
+		// fractions of seconds are not exact in the float of the claim
+		issuedAt := claims.IssuedAt.Time.Round(IssuedAtPrecision)
+
+		revoked, err := m.revocations.IsTokenRevoked(ctx, claims.ID, claims.UID, issuedAt)
+		if err != nil {
+			return Claims{}, fmt.Errorf("failed to check revocation: %w", err)
+		}
+
+		if revoked {
+			return Claims{}, ErrTokenRevoked
+		}
+	}
+
+	return claims, nil
+}
+
+// keyFunc returns verification key by kid header, the algorithm must match the key
+func (m *Manager) keyFunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+
+	key, ok := m.keys[kid]
 	if !ok {
-		return 0, jwt.ErrInvalidKeyType
+		return nil, fmt.Errorf("%w: %q", ErrUnknownKey, kid)
+	}
+
+	if token.Method.Alg() != key.method.Alg() {
+		return nil, fmt.Errorf("%w: unexpected algorithm %s for key %q", jwt.ErrTokenSignatureInvalid, token.Method.Alg(), kid)
 	}
 
-	return int64(uid), nil
+	return key.verifyKey, nil
 }
@@ -0,0 +1,225 @@
+package jwt
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/nglmq/password-keeper/internal/domain/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newEdDSAKey(t *testing.T, id string) (Key, Key) {
+	t.Helper()
+
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	return pemKeys(t, id, AlgEdDSA, priv, pub)
+}
+
+func newRSAKey(t *testing.T, id string) (Key, Key) {
+	t.Helper()
+
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	return pemKeys(t, id, AlgRS256, priv, &priv.PublicKey)
+}
+
+// pemKeys returns private signing key and public verification only key
+func pemKeys(t *testing.T, id, alg string, priv, pub interface{}) (Key, Key) {
+	t.Helper()
+
+	privDER, err := x509.MarshalPKCS8PrivateKey(priv)
+	require.NoError(t, err)
+
+	pubDER, err := x509.MarshalPKIXPublicKey(pub)
+	require.NoError(t, err)
+
+	signing, err := ParseKey(id, alg, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privDER}))
+	require.NoError(t, err)
+
+	verification, err := ParseKey(id, alg, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubDER}))
+	require.NoError(t, err)
+
+	return signing, verification
+}
+
+func TestManager(t *testing.T) {
+	user := models.User{ID: 42, Email: "user@example.com"}
+
+	edSigning, _ := newEdDSAKey(t, "ed-1")
+	rsaSigning, rsaVerification := newRSAKey(t, "rsa-1")
+
+	ed, err := New("keeper", "keeper-clients", edSigning)
+	require.NoError(t, err)
+
+	rs, err := New("keeper", "keeper-clients", rsaSigning)
+	require.NoError(t, err)
+
+	// after rotation the old key is kept for verification only
+	rotated, err := New("keeper", "keeper-clients", edSigning, rsaVerification)
+	require.NoError(t, err)
+
+	otherAudience, err := New("keeper", "other", edSigning)
+	require.NoError(t, err)
+
+	otherIssuer, err := New("other", "keeper-clients", edSigning)
+	require.NoError(t, err)
+
+	edToken, err := ed.NewToken(user, time.Hour)
+	require.NoError(t, err)
+
+	rsaToken, err := rs.NewToken(user, time.Hour)
+	require.NoError(t, err)
+
+	expired, err := ed.NewToken(user, -time.Minute)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		manager *Manager
+		token   string
+		wantErr error
+	}{
+		{name: "EdDSA", manager: ed, token: edToken},
+		{name: "RS256", manager: rs, token: rsaToken},
+		{name: "Previous key", manager: rotated, token: rsaToken},
+		{name: "Unknown kid", manager: ed, token: rsaToken, wantErr: ErrUnknownKey},
+		{name: "Expired", manager: ed, token: expired, wantErr: jwt.ErrTokenExpired},
+		{name: "Wrong audience", manager: otherAudience, token: edToken, wantErr: jwt.ErrTokenInvalidAudience},
+		{name: "Wrong issuer", manager: otherIssuer, token: edToken, wantErr: jwt.ErrTokenInvalidIssuer},
+		{name: "Garbage", manager: ed, token: "not.a.token", wantErr: jwt.ErrTokenMalformed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			uid, err := tt.manager.ValidateToken(context.Background(), tt.token)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, user.ID, uid)
+		})
+	}
+}
+
+func TestManager_AlgorithmMismatch(t *testing.T) {
+	_, verification := newRSAKey(t, "rsa-1")
+
+	secret, err := ParseKey("rsa-1", AlgHS256, []byte("0123456789abcdef0123456789abcdef"))
+	require.NoError(t, err)
+
+	forger, err := New("keeper", "keeper-clients", secret)
+	require.NoError(t, err)
+
+	signing, _ := newEdDSAKey(t, "ed-1")
+
+	m, err := New("keeper", "keeper-clients", signing, verification)
+	require.NoError(t, err)
+
+	// token with kid of RSA key but signed with HMAC must be rejected
+	forged, err := forger.NewToken(models.User{ID: 1}, time.Hour)
+	require.NoError(t, err)
+
+	_, err = m.ValidateToken(context.Background(), forged)
+	assert.ErrorIs(t, err, jwt.ErrTokenSignatureInvalid)
+}
+
+type revokedJTI map[string]bool
+
+func (r revokedJTI) IsTokenRevoked(_ context.Context, jti string, _ int64, _ time.Time) (bool, error) {
+	return r[jti], nil
+}
+
+func TestManager_Revocation(t *testing.T) {
+	signing, _ := newEdDSAKey(t, "ed-1")
+
+	m, err := New("keeper", "keeper-clients", signing)
+	require.NoError(t, err)
+
+	revoked := revokedJTI{}
+	m.SetRevocationList(revoked)
+
+	token, err := m.NewToken(models.User{ID: 1}, time.Hour)
+	require.NoError(t, err)
+
+	claims, err := m.ParseToken(context.Background(), token)
+	require.NoError(t, err)
+	require.NotEmpty(t, claims.ID)
+
+	revoked[claims.ID] = true
+
+	_, err = m.ValidateToken(context.Background(), token)
+	assert.ErrorIs(t, err, ErrTokenRevoked)
+}
+
+// revokedBefore revokes tokens issued before the time
+type revokedBefore struct {
+	t *time.Time
+}
+
+func (r revokedBefore) IsTokenRevoked(_ context.Context, _ string, _ int64, issuedAt time.Time) (bool, error) {
+	return r.t.After(issuedAt), nil
+}
+
+func TestRevocationTime(t *testing.T) {
+	signing, _ := newEdDSAKey(t, "ed-1")
+
+	m, err := New("keeper", "keeper-clients", signing)
+	require.NoError(t, err)
+
+	var validAfter time.Time
+	m.SetRevocationList(revokedBefore{t: &validAfter})
+
+	before, err := m.NewToken(models.User{ID: 1}, time.Hour)
+	require.NoError(t, err)
+
+	validAfter = RevocationTime()
+
+	after, err := m.NewToken(models.User{ID: 1}, time.Hour)
+	require.NoError(t, err)
+
+	// both tokens are issued within the same second, iat tells them apart
+	_, err = m.ValidateToken(context.Background(), before)
+	assert.ErrorIs(t, err, ErrTokenRevoked)
+
+	_, err = m.ValidateToken(context.Background(), after)
+	assert.NoError(t, err)
+}
+
+func TestManager_NoIssuedAt(t *testing.T) {
+	signing, _ := newEdDSAKey(t, "ed-1")
+
+	m, err := New("keeper", "keeper-clients", signing)
+	require.NoError(t, err)
+
+	m.SetRevocationList(revokedJTI{})
+
+	token := jwt.NewWithClaims(signing.method, Claims{
+		UID: 1,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        "jti",
+			Issuer:    "keeper",
+			Audience:  jwt.ClaimStrings{"keeper-clients"},
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		},
+	})
+	token.Header["kid"] = signing.ID
+
+	signed, err := token.SignedString(signing.signKey)
+	require.NoError(t, err)
+
+	_, err = m.ValidateToken(context.Background(), signed)
+	assert.ErrorIs(t, err, ErrNoIssuedAt)
+}
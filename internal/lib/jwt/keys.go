@@ -0,0 +1,120 @@
+package jwt
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Supported algorithms
+const (
+	AlgEdDSA = "EdDSA"
+	AlgRS256 = "RS256"
+	AlgHS256 = "HS256"
+)
+
+var ErrUnsupportedAlgorithm = errors.New("unsupported algorithm")
+
+// Key is a signing or verification key identified by kid header of tokens
+type Key struct {
+	ID        string
+	method    jwt.SigningMethod
+	signKey   interface{}
+	verifyKey interface{}
+}
+
+// LoadKey reads key from the file.
+// For EdDSA and RS256 the file holds PEM encoded PKCS#8 private key or, for verification only keys,
+// PKIX public key. For HS256 the file holds the shared secret.
+func LoadKey(id, alg, path string) (Key, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Key{}, fmt.Errorf("failed to read key %q: %w", id, err)
+	}
+
+	return ParseKey(id, alg, data)
+}
+
+// ParseKey parses key in the format described in LoadKey
+func ParseKey(id, alg string, data []byte) (Key, error) {
+	key := Key{ID: id}
+
+	switch alg {
+	case AlgHS256:
+		secret := bytes.TrimSpace(data)
+		if len(secret) < 32 {
+			return Key{}, fmt.Errorf("secret of key %q must be at least 32 bytes", id)
+		}
+
+		key.method = jwt.SigningMethodHS256
+		key.signKey = secret
+		key.verifyKey = secret
+
+		return key, nil
+	case AlgEdDSA:
+		key.method = jwt.SigningMethodEdDSA
+	case AlgRS256:
+		key.method = jwt.SigningMethodRS256
+	default:
+		return Key{}, fmt.Errorf("%w: %q", ErrUnsupportedAlgorithm, alg)
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return Key{}, fmt.Errorf("key %q is not PEM encoded", id)
+	}
+
+	switch block.Type {
+	case "PRIVATE KEY":
+		priv, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return Key{}, fmt.Errorf("failed to parse private key %q: %w", id, err)
+		}
+
+		signer, ok := priv.(crypto.Signer)
+		if !ok {
+			return Key{}, fmt.Errorf("key %q cannot sign", id)
+		}
+
+		key.signKey = priv
+		key.verifyKey = signer.Public()
+	case "PUBLIC KEY":
+		pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return Key{}, fmt.Errorf("failed to parse public key %q: %w", id, err)
+		}
+
+		key.verifyKey = pub
+	default:
+		return Key{}, fmt.Errorf("unexpected PEM block %q in key %q", block.Type, id)
+	}
+
+	if err := checkKeyType(alg, key.verifyKey); err != nil {
+		return Key{}, fmt.Errorf("key %q: %w", id, err)
+	}
+
+	return key, nil
+}
+
+func checkKeyType(alg string, pub interface{}) error {
+	switch pub.(type) {
+	case ed25519.PublicKey:
+		if alg == AlgEdDSA {
+			return nil
+		}
+	case *rsa.PublicKey:
+		if alg == AlgRS256 {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("%w: %T for %s", jwt.ErrInvalidKeyType, pub, alg)
+}
@@ -0,0 +1,43 @@
+package jwt
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+)
+
+// NewRefreshToken returns new random refresh token and its hash which is stored instead of the token
+func NewRefreshToken() (token string, hash string, err error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", fmt.Errorf("failed to generate refresh token: %w", err)
+	}
+
+	token = base64.RawURLEncoding.EncodeToString(b)
+
+	return token, HashRefreshToken(token), nil
+}
+
+// HashRefreshToken returns hash of the refresh token
+func HashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+
+	return hex.EncodeToString(sum[:])
+}
+
+// NewFamilyID returns new random ID of refresh token family
+func NewFamilyID() (string, error) {
+	return newID()
+}
+
+// newID returns random ID for families and jti claims
+func newID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate id: %w", err)
+	}
+
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,98 @@
+// Package mailer sends emails to users: verification and password reset codes.
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Message is a plain text email
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer sends emails
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// format returns message in RFC 5322 format
+func format(from string, msg Message, date time.Time) []byte {
+	var b strings.Builder
+
+	b.WriteString("From: " + from + "\r\n")
+	b.WriteString("To: " + msg.To + "\r\n")
+	b.WriteString("Subject: " + msg.Subject + "\r\n")
+	b.WriteString("Date: " + date.Format(time.RFC1123Z) + "\r\n")
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+
+	return []byte(b.String())
+}
+
+// File appends emails to a file instead of sending them, for local development and tests
+type File struct {
+	from string
+	path string
+	mu   sync.Mutex
+}
+
+// NewFile returns mailer appending emails to the file at path
+func NewFile(from, path string) (*File, error) {
+	if dir := filepath.Dir(path); dir != "" {
+		if err := os.MkdirAll(dir, 0o700); err != nil {
+			return nil, fmt.Errorf("failed to create mail directory: %w", err)
+		}
+	}
+
+	return &File{from: from, path: path}, nil
+}
+
+func (m *File) Send(_ context.Context, msg Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// the file holds reset codes, so only the owner can read it
+	f, err := os.OpenFile(m.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to open mail file: %w", err)
+	}
+	defer f.Close()
+
+	data := append(format(m.from, msg, time.Now()), "\r\n\r\n"...)
+	if _, err := f.Write(data); err != nil {
+		return fmt.Errorf("failed to write mail: %w", err)
+	}
+
+	return nil
+}
+
+// Log writes emails to the log instead of sending them, for local development
+type Log struct {
+	log *slog.Logger
+}
+
+// NewLog returns mailer writing emails to log
+func NewLog(log *slog.Logger) *Log {
+	return &Log{log: log}
+}
+
+func (m *Log) Send(_ context.Context, msg Message) error {
+	m.log.Info("email",
+		slog.String("to", msg.To),
+		slog.String("subject", msg.Subject),
+		slog.String("body", msg.Body),
+	)
+
+	return nil
+}
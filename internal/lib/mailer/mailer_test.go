@@ -0,0 +1,44 @@
+package mailer
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFile_Send(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mail", "outbox.eml")
+
+	m, err := NewFile("keeper@example.com", path)
+	require.NoError(t, err)
+
+	require.NoError(t, m.Send(context.Background(), Message{
+		To:      "user@example.com",
+		Subject: "Verify email",
+		Body:    "code: abc\nbye",
+	}))
+	require.NoError(t, m.Send(context.Background(), Message{
+		To:      "other@example.com",
+		Subject: "Reset password",
+		Body:    "code: def",
+	}))
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+
+	content := string(data)
+	assert.Contains(t, content, "From: keeper@example.com\r\n")
+	assert.Contains(t, content, "To: user@example.com\r\n")
+	assert.Contains(t, content, "Subject: Verify email\r\n")
+	assert.Contains(t, content, "code: abc\r\nbye")
+	assert.Equal(t, 2, strings.Count(content, "From: "))
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+}
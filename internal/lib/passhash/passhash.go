@@ -0,0 +1,173 @@
+// Package passhash derives keys of SRP verifiers from passwords with Argon2id and verifies password hashes
+// of legacy users, PHC strings like $argon2id$v=19$m=65536,t=3,p=2$<salt>$<hash> and bcrypt hashes.
+// New password hashes are not made, legacy hashes are replaced with verifiers on the next login.
+package passhash
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	// SaltSize is the size of random salts
+	SaltSize = 16
+	// KeySize is the size of hashes and keys
+	KeySize = 32
+)
+
+var (
+	ErrMismatch      = errors.New("password does not match the hash")
+	ErrInvalidHash   = errors.New("invalid password hash")
+	ErrInvalidParams = errors.New("invalid argon2id parameters")
+)
+
+// Params are Argon2id parameters of new verifiers
+type Params struct {
+	// Memory in KiB
+	Memory uint32
+	// Iterations over the memory
+	Iterations uint32
+	// Parallelism is the number of threads
+	Parallelism uint8
+}
+
+// String returns params in PHC form m=65536,t=3,p=4
+func (p Params) String() string {
+	return fmt.Sprintf("m=%d,t=%d,p=%d", p.Memory, p.Iterations, p.Parallelism)
+}
+
+// Weaker reports whether any of the params is lower than the one of other
+func (p Params) Weaker(other Params) bool {
+	return p.Memory < other.Memory || p.Iterations < other.Iterations || p.Parallelism < other.Parallelism
+}
+
+// ParseParams parses params in PHC form m=65536,t=3,p=4
+func ParseParams(s string) (Params, error) {
+	var p Params
+	if _, err := fmt.Sscanf(s, "m=%d,t=%d,p=%d", &p.Memory, &p.Iterations, &p.Parallelism); err != nil {
+		return Params{}, fmt.Errorf("%w: %v", ErrInvalidParams, err)
+	}
+
+	if p.Memory < 8*uint32(p.Parallelism) || p.Iterations < 1 || p.Parallelism < 1 {
+		return Params{}, ErrInvalidParams
+	}
+
+	return p, nil
+}
+
+// Key derives a key of KeySize from the password with Argon2id, the client derives its login key with it
+func Key(password string, salt []byte, params Params) []byte {
+	return argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Parallelism, KeySize)
+}
+
+// DefaultParams follow the second recommended option of RFC 9106
+var DefaultParams = Params{Memory: 64 * 1024, Iterations: 3, Parallelism: 4}
+
+// Hasher derives keys of verifiers and verifies legacy hashes. Every derivation takes Params.Memory, so the number
+// of derivations computed at once is limited to keep memory of the server bounded under a burst of logins.
+type Hasher struct {
+	params Params
+	slots  chan struct{}
+}
+
+// New returns Hasher deriving keys with params, at most concurrency keys are derived at once
+func New(params Params, concurrency int) (*Hasher, error) {
+	if _, err := ParseParams(params.String()); err != nil {
+		return nil, err
+	}
+
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	return &Hasher{
+		params: params,
+		slots:  make(chan struct{}, concurrency),
+	}, nil
+}
+
+// Verify checks the password against Argon2id or bcrypt hash. ErrMismatch is returned for a wrong password.
+// needsRehash is set if the hash is bcrypt or made with weaker parameters than the current ones.
+func (h *Hasher) Verify(password, hash string) (needsRehash bool, err error) {
+	if isBcrypt(hash) {
+		err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return false, ErrMismatch
+		}
+		if err != nil {
+			return false, fmt.Errorf("%w: %v", ErrInvalidHash, err)
+		}
+
+		return true, nil
+	}
+
+	params, salt, key, err := parse(hash)
+	if err != nil {
+		return false, err
+	}
+
+	if subtle.ConstantTimeCompare(h.key(password, salt, params, uint32(len(key))), key) != 1 {
+		return false, ErrMismatch
+	}
+
+	return params.Weaker(h.params) || len(key) < KeySize, nil
+}
+
+// Params returns parameters of new verifiers
+func (h *Hasher) Params() Params {
+	return h.params
+}
+
+// DeriveKey is Key limited by the concurrency of the hasher
+func (h *Hasher) DeriveKey(password string, salt []byte, params Params) []byte {
+	return h.key(password, salt, params, KeySize)
+}
+
+func (h *Hasher) key(password string, salt []byte, params Params, size uint32) []byte {
+	h.slots <- struct{}{}
+	defer func() { <-h.slots }()
+
+	return argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Parallelism, size)
+}
+
+func isBcrypt(hash string) bool {
+	return strings.HasPrefix(hash, "$2a$") || strings.HasPrefix(hash, "$2b$") || strings.HasPrefix(hash, "$2y$")
+}
+
+var b64 = base64.RawStdEncoding
+
+func parse(hash string) (Params, []byte, []byte, error) {
+	// "", "argon2id", "v=19", "m=..,t=..,p=..", salt, key
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[0] != "" || parts[1] != "argon2id" {
+		return Params{}, nil, nil, ErrInvalidHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return Params{}, nil, nil, fmt.Errorf("%w: unsupported version %q", ErrInvalidHash, parts[2])
+	}
+
+	params, err := ParseParams(parts[3])
+	if err != nil {
+		return Params{}, nil, nil, fmt.Errorf("%w: %v", ErrInvalidHash, err)
+	}
+
+	salt, err := b64.DecodeString(parts[4])
+	if err != nil {
+		return Params{}, nil, nil, fmt.Errorf("%w: %v", ErrInvalidHash, err)
+	}
+
+	key, err := b64.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return Params{}, nil, nil, fmt.Errorf("%w: bad key", ErrInvalidHash)
+	}
+
+	return params, salt, key, nil
+}
@@ -0,0 +1,94 @@
+package passhash
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var testParams = Params{Memory: 1024, Iterations: 1, Parallelism: 1}
+
+// phc returns Argon2id hash of the password in PHC form, as legacy users have them
+func phc(params Params, password string) string {
+	salt := []byte("legacy user salt")
+
+	return fmt.Sprintf("$argon2id$v=19$%s$%s$%s", params,
+		b64.EncodeToString(salt), b64.EncodeToString(Key(password, salt, params)))
+}
+
+func TestHasher_Verify(t *testing.T) {
+	h, err := New(testParams, 2)
+	require.NoError(t, err)
+
+	hash := phc(testParams, "secret")
+
+	needsRehash, err := h.Verify("secret", hash)
+	require.NoError(t, err)
+	assert.False(t, needsRehash)
+
+	_, err = h.Verify("wrong", hash)
+	assert.ErrorIs(t, err, ErrMismatch)
+}
+
+func TestHasher_Verify_Bcrypt(t *testing.T) {
+	h, err := New(testParams, 1)
+	require.NoError(t, err)
+
+	legacy, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	require.NoError(t, err)
+
+	needsRehash, err := h.Verify("secret", string(legacy))
+	require.NoError(t, err)
+	assert.True(t, needsRehash)
+
+	_, err = h.Verify("wrong", string(legacy))
+	assert.ErrorIs(t, err, ErrMismatch)
+}
+
+func TestHasher_Verify_RaisedParams(t *testing.T) {
+	hash := phc(testParams, "secret")
+
+	raised, err := New(Params{Memory: 2048, Iterations: 1, Parallelism: 1}, 1)
+	require.NoError(t, err)
+
+	// hashes keep their own parameters, so they are still verified after the change
+	needsRehash, err := raised.Verify("secret", hash)
+	require.NoError(t, err)
+	assert.True(t, needsRehash)
+
+	lowered, err := New(Params{Memory: 512, Iterations: 1, Parallelism: 1}, 1)
+	require.NoError(t, err)
+
+	needsRehash, err = lowered.Verify("secret", hash)
+	require.NoError(t, err)
+	assert.False(t, needsRehash)
+}
+
+func TestHasher_Verify_InvalidHash(t *testing.T) {
+	h, err := New(testParams, 1)
+	require.NoError(t, err)
+
+	for _, hash := range []string{
+		"",
+		"plain",
+		"$argon2i$v=19$m=1024,t=1,p=1$c2FsdA$a2V5",
+		"$argon2id$v=16$m=1024,t=1,p=1$c2FsdA$a2V5",
+		"$argon2id$v=19$m=1024,t=0,p=1$c2FsdA$a2V5",
+		"$argon2id$v=19$m=1024,t=1,p=1$c2FsdA$",
+		"$argon2id$v=19$m=1024,t=1,p=1$!!$a2V5",
+	} {
+		_, err := h.Verify("secret", hash)
+		assert.ErrorIs(t, err, ErrInvalidHash, "hash %q", hash)
+	}
+}
+
+func TestNew_InvalidParams(t *testing.T) {
+	_, err := New(Params{Memory: 1024, Iterations: 0, Parallelism: 1}, 1)
+	assert.ErrorIs(t, err, ErrInvalidParams)
+
+	_, err = New(Params{Memory: 4, Iterations: 1, Parallelism: 1}, 1)
+	assert.ErrorIs(t, err, ErrInvalidParams)
+}
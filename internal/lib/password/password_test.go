@@ -0,0 +1,122 @@
+package password
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEstimate(t *testing.T) {
+	tests := []struct {
+		password   string
+		maxScore   int
+		minScore   int
+		wantCommon bool
+	}{
+		{password: "password", maxScore: 0, wantCommon: true},
+		{password: "P@ssw0rd", maxScore: 1, wantCommon: true},
+		{password: "PASSWORD", maxScore: 1, wantCommon: true},
+		{password: "qwerty123", maxScore: 0, wantCommon: true},
+		{password: "abcdefgh", maxScore: 0},
+		{password: "987654321", maxScore: 0, wantCommon: true},
+		{password: "8765432", maxScore: 0},
+		{password: "aaaaaaaaaaaa", maxScore: 1},
+		{password: "asdfghjkl", maxScore: 1},
+		{password: "Summer2024!", maxScore: 1},
+		{password: "mypassword1", maxScore: 1},
+		{password: "kG9w2mQa", minScore: 3, maxScore: 3},
+		{password: "x7#Kq9!mZp2$", minScore: 4, maxScore: 4},
+		{password: "correct horse battery staple", minScore: 4, maxScore: 4},
+	}
+
+	for _, tt := range tests {
+		got := Estimate(tt.password)
+
+		assert.GreaterOrEqual(t, got.Score, tt.minScore, "password %q", tt.password)
+		assert.LessOrEqual(t, got.Score, tt.maxScore, "password %q", tt.password)
+		assert.Equal(t, tt.wantCommon, got.Common, "password %q", tt.password)
+	}
+}
+
+func TestEstimate_UserInputs(t *testing.T) {
+	withoutEmail := Estimate("ivanovpetr")
+	withEmail := Estimate("ivanovpetr", "ivanov@example.com")
+
+	assert.Less(t, withEmail.GuessesLog10, withoutEmail.GuessesLog10)
+	assert.Equal(t, 0, Estimate("ivanov", "ivanov@example.com").Score)
+}
+
+func TestEstimate_Empty(t *testing.T) {
+	assert.Equal(t, Strength{}, Estimate(""))
+}
+
+func TestPolicy_Check(t *testing.T) {
+	policy := Policy{MinLength: 8, MinScore: 3, RejectCommon: true}
+
+	rules := func(violations []Violation) []string {
+		var res []string
+		for _, v := range violations {
+			res = append(res, v.Rule)
+		}
+		return res
+	}
+
+	tests := []struct {
+		name     string
+		password string
+		want     []string
+	}{
+		{name: "strong", password: "x7#Kq9!mZp2$"},
+		{name: "short", password: "x7#Kq9", want: []string{RuleMinLength, RuleMinScore}},
+		{name: "common", password: "password1", want: []string{RuleCommon, RuleMinScore}},
+		{name: "email", password: "User@Example.com", want: []string{RuleEmail, RuleMinScore}},
+		{name: "local part", password: "user", want: []string{RuleMinLength, RuleEmail, RuleMinScore}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			violations, _ := policy.Check(tt.password, "user@example.com")
+			assert.Equal(t, tt.want, rules(violations))
+		})
+	}
+}
+
+func TestPolicy_Check_CommonAllowed(t *testing.T) {
+	policy := Policy{MinLength: 1}
+
+	violations, strength := policy.Check("password", "user@example.com")
+	assert.Empty(t, violations)
+	assert.True(t, strength.Common)
+}
+
+func TestPolicy_Validate(t *testing.T) {
+	policy := Policy{MinLength: 8, MinScore: 3, RejectCommon: true}
+
+	assert.NoError(t, policy.Validate("x7#Kq9!mZp2$", "user@example.com"))
+
+	err := policy.Validate("password1", "user@example.com")
+	assert.ErrorIs(t, err, ErrWeakPassword)
+
+	var policyErr *PolicyError
+	if assert.ErrorAs(t, err, &policyErr) {
+		assert.Len(t, policyErr.Violations, 2)
+	}
+}
+
+func TestPolicy_CheckReport(t *testing.T) {
+	policy := Policy{MinLength: 8, MinScore: 3, RejectCommon: true}
+
+	report := Measure("password1", "user@example.com")
+	assert.Equal(t, 9, report.Length)
+	assert.True(t, report.Common)
+	assert.False(t, report.Email)
+
+	// the server gets the same violations from the report as the client from the password
+	violations, _ := policy.Check("password1", "user@example.com")
+	assert.Equal(t, violations, policy.CheckReport(report))
+
+	assert.Empty(t, policy.CheckReport(Measure("x7#Kq9!mZp2$", "user@example.com")))
+
+	// the client sending no report gets all requirements it did not show to meet
+	assert.Len(t, policy.CheckReport(Report{}), 2)
+}
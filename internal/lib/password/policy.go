@@ -0,0 +1,142 @@
+package password
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// Rules a password can violate
+const (
+	RuleMinLength = "min_length"
+	RuleMinScore  = "min_score"
+	RuleEmail     = "email"
+	RuleCommon    = "common"
+)
+
+// ErrWeakPassword is wrapped by PolicyError
+var ErrWeakPassword = errors.New("password does not meet the policy")
+
+// PolicyError lists requirements of the policy the password does not meet
+type PolicyError struct {
+	Violations []Violation
+	Strength   Strength
+}
+
+func (e *PolicyError) Error() string {
+	descriptions := make([]string, 0, len(e.Violations))
+	for _, v := range e.Violations {
+		descriptions = append(descriptions, v.Description)
+	}
+
+	return fmt.Sprintf("%s: %s", ErrWeakPassword, strings.Join(descriptions, "; "))
+}
+
+func (e *PolicyError) Unwrap() error {
+	return ErrWeakPassword
+}
+
+// Policy is the set of requirements new passwords must meet
+type Policy struct {
+	// MinLength is the minimal number of characters
+	MinLength int
+	// MinScore is the minimal strength score from Estimate
+	MinScore int
+	// RejectCommon rejects passwords from the bundled list of common passwords
+	RejectCommon bool
+}
+
+// Violation is a requirement of the policy the password does not meet
+type Violation struct {
+	Rule        string
+	Description string
+}
+
+// Validate returns PolicyError if the password of the user with the email does not meet the policy
+func (p Policy) Validate(password, email string) error {
+	violations, strength := p.Check(password, email)
+	if len(violations) > 0 {
+		return &PolicyError{Violations: violations, Strength: strength}
+	}
+
+	return nil
+}
+
+// Report is what the client measured checking the new password, the server checks it against the policy
+// without knowing the password
+type Report struct {
+	// Length is the number of characters
+	Length int
+	// Score is the strength score with the email known
+	Score int
+	// Common is set if the password is one of the common passwords
+	Common bool
+	// Email is set if the password is the email or its local part
+	Email bool
+}
+
+// Measure returns report of the password of the user with the email
+func Measure(password, email string) Report {
+	return Report{
+		Length: utf8.RuneCountInString(password),
+		// the score with the email known, as an attacker targeting the account knows it
+		Score:  Estimate(password, email).Score,
+		Common: Estimate(password).Common,
+		Email:  isEmail(password, email),
+	}
+}
+
+// Check returns violations of the policy by the password of the user with the email, nil if there are none
+func (p Policy) Check(password, email string) ([]Violation, Strength) {
+	return p.CheckReport(Measure(password, email)), Estimate(password, email)
+}
+
+// CheckReport returns violations of the policy by the password the report is of, nil if there are none
+func (p Policy) CheckReport(r Report) []Violation {
+	var violations []Violation
+
+	if r.Length < p.MinLength {
+		violations = append(violations, Violation{
+			Rule:        RuleMinLength,
+			Description: fmt.Sprintf("password must be at least %d characters long", p.MinLength),
+		})
+	}
+
+	if r.Email {
+		violations = append(violations, Violation{
+			Rule:        RuleEmail,
+			Description: "password must not be the email",
+		})
+	}
+
+	if p.RejectCommon && r.Common {
+		violations = append(violations, Violation{
+			Rule:        RuleCommon,
+			Description: "password is one of the most common passwords",
+		})
+	}
+
+	if r.Score < p.MinScore {
+		violations = append(violations, Violation{
+			Rule:        RuleMinScore,
+			Description: fmt.Sprintf("password is too easy to guess, strength %d of %d is required", r.Score, p.MinScore),
+		})
+	}
+
+	return violations
+}
+
+// isEmail reports whether password is the email or its local part
+func isEmail(password, email string) bool {
+	password = strings.ToLower(strings.TrimSpace(password))
+	email = strings.ToLower(strings.TrimSpace(email))
+
+	if email == "" {
+		return false
+	}
+
+	local, _, _ := strings.Cut(email, "@")
+
+	return password == email || password == local
+}
@@ -0,0 +1,312 @@
+// Package password estimates strength of passwords and checks them against the password policy.
+//
+// The estimation follows the idea of zxcvbn: the password is split into patterns an attacker tries first
+// (common passwords, known user inputs, sequences, repeats, years) and characters guessed by brute force,
+// the score is derived from the logarithm of the number of guesses.
+package password
+
+import (
+	_ "embed"
+	"math"
+	"strings"
+	"unicode"
+)
+
+//go:embed common.txt
+var commonList string
+
+// common maps common passwords to their rank, 1 is the most common
+var common = parseCommon(commonList)
+
+func parseCommon(list string) map[string]int {
+	ranks := make(map[string]int)
+
+	for _, line := range strings.Split(list, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if _, ok := ranks[line]; !ok {
+			ranks[line] = len(ranks) + 1
+		}
+	}
+
+	return ranks
+}
+
+// Score thresholds in guesses, the same as zxcvbn uses
+var scoreGuesses = [...]float64{1e3, 1e6, 1e8, 1e10}
+
+// MaxScore is the score of the strongest passwords
+const MaxScore = 4
+
+// bruteforceCardinality is guesses per character not covered by patterns. As in zxcvbn it is
+// smaller than the alphabet, since people do not choose characters uniformly.
+const bruteforceCardinality = 10
+
+// minMatchLength is the shortest common word or user input matched inside the password
+const minMatchLength = 4
+
+// Strength is the estimated strength of a password
+type Strength struct {
+	// Score from 0 (guessed instantly) to MaxScore (very hard to guess)
+	Score int
+	// Guesses is the estimated number of guesses to find the password, as log10
+	GuessesLog10 float64
+	// Common is set if the password is a common password or a known user input
+	Common bool
+}
+
+// Estimate returns strength of the password, userInputs like email are treated as known to an attacker
+func Estimate(password string, userInputs ...string) Strength {
+	if password == "" {
+		return Strength{}
+	}
+
+	dict := userDictionary(userInputs)
+	runes := []rune(password)
+	lower := []rune(strings.ToLower(password))
+	bruteBits := math.Log2(bruteforceCardinality)
+
+	var (
+		bits     float64
+		patterns int
+		isCommon bool
+	)
+
+	for i := 0; i < len(runes); {
+		n, cost, whole := matchPattern(runes, lower, i, dict)
+		if n == 0 {
+			bits += bruteBits
+			i++
+
+			continue
+		}
+
+		if whole && n == len(runes) {
+			isCommon = true
+		}
+
+		bits += cost
+		patterns++
+		i += n
+	}
+
+	// the attacker also has to guess how the patterns are combined
+	if patterns > 1 {
+		bits += math.Log2(float64(patterns))
+	}
+
+	guessesLog10 := bits * math.Log10(2)
+
+	return Strength{
+		Score:        score(guessesLog10),
+		GuessesLog10: guessesLog10,
+		Common:       isCommon,
+	}
+}
+
+func score(guessesLog10 float64) int {
+	for s, limit := range scoreGuesses {
+		if guessesLog10 < math.Log10(limit) {
+			return s
+		}
+	}
+
+	return MaxScore
+}
+
+// matchPattern returns length and cost in bits of the longest pattern starting at i.
+// whole is set for dictionary matches, which make the password common if they cover it entirely.
+func matchPattern(runes, lower []rune, i int, dict map[string]int) (n int, cost float64, whole bool) {
+	if n, cost := matchDictionary(runes, lower, i, dict); n > 0 {
+		return n, cost, true
+	}
+
+	if n := matchRepeat(lower, i); n >= 3 {
+		return n, math.Log2(bruteforceCardinality) + math.Log2(float64(n)), false
+	}
+
+	if n := matchSequence(lower, i); n >= 3 {
+		// the start of the sequence and its length
+		return n, math.Log2(bruteforceCardinality) + math.Log2(float64(n)) + 1, false
+	}
+
+	if n := matchYear(lower, i); n > 0 {
+		return n, math.Log2(200), false
+	}
+
+	return 0, 0, false
+}
+
+// matchDictionary finds the longest common password or user input starting at i, leetspeak is undone
+func matchDictionary(runes, lower []rune, i int, dict map[string]int) (int, float64) {
+	best, bestCost := 0, 0.0
+
+	for end := len(lower); end-i >= minMatchLength; end-- {
+		word := string(lower[i:end])
+		unleeted := unleet(word)
+
+		rank, ok := dict[word]
+		if !ok {
+			rank, ok = dict[unleeted]
+		}
+		if !ok {
+			continue
+		}
+
+		cost := math.Log2(float64(rank))
+		if word != unleeted {
+			cost++
+		}
+		if string(runes[i:end]) != word {
+			// capitalization
+			cost++
+		}
+
+		best, bestCost = end-i, cost
+
+		break
+	}
+
+	return best, bestCost
+}
+
+// userDictionary is the common passwords with user inputs and their parts ranked first
+func userDictionary(userInputs []string) map[string]int {
+	var words []string
+
+	for _, input := range userInputs {
+		input = strings.ToLower(strings.TrimSpace(input))
+		if input == "" {
+			continue
+		}
+
+		words = append(words, input)
+
+		parts := strings.FieldsFunc(input, func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+		})
+		for _, part := range parts {
+			if len([]rune(part)) >= minMatchLength {
+				words = append(words, part)
+			}
+		}
+	}
+
+	if len(words) == 0 {
+		return common
+	}
+
+	dict := make(map[string]int, len(common)+len(words))
+	for word, rank := range common {
+		dict[word] = rank + len(words)
+	}
+
+	for i, word := range words {
+		if _, ok := dict[word]; !ok || dict[word] > i+1 {
+			dict[word] = i + 1
+		}
+	}
+
+	return dict
+}
+
+var leet = strings.NewReplacer("4", "a", "@", "a", "8", "b", "3", "e", "1", "i", "!", "i", "0", "o", "$", "s", "5", "s", "7", "t")
+
+func unleet(s string) string {
+	return leet.Replace(s)
+}
+
+func matchRepeat(lower []rune, i int) int {
+	n := 1
+	for i+n < len(lower) && lower[i+n] == lower[i] {
+		n++
+	}
+
+	return n
+}
+
+// matchSequence matches runs like abc, 987 or qwer where every next character is the neighbour of the previous
+func matchSequence(lower []rune, i int) int {
+	if i+1 >= len(lower) {
+		return 1
+	}
+
+	if n := matchRow(lower, i, alphabetRows); n >= 3 {
+		return n
+	}
+
+	return matchRow(lower, i, keyboardRows)
+}
+
+var (
+	alphabetRows = []string{"abcdefghijklmnopqrstuvwxyz", "01234567890"}
+	keyboardRows = []string{"`1234567890-=", "qwertyuiop[]", "asdfghjkl;'", "zxcvbnm,./", "йцукенгшщзхъ", "фывапролджэ", "ячсмитьбю"}
+)
+
+func matchRow(lower []rune, i int, rows []string) int {
+	best := 1
+
+	for _, row := range rows {
+		r := []rune(row)
+
+		for _, dir := range []int{1, -1} {
+			pos := indexRune(r, lower[i])
+			if pos < 0 {
+				continue
+			}
+
+			n := 1
+			for i+n < len(lower) {
+				pos += dir
+				if pos < 0 || pos >= len(r) || r[pos] != lower[i+n] {
+					break
+				}
+
+				n++
+			}
+
+			if n > best {
+				best = n
+			}
+		}
+	}
+
+	return best
+}
+
+func indexRune(r []rune, c rune) int {
+	for i, v := range r {
+		if v == c {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// matchYear matches years from 1900 to 2099
+func matchYear(lower []rune, i int) int {
+	if i+4 > len(lower) {
+		return 0
+	}
+
+	year := string(lower[i : i+4])
+	if (strings.HasPrefix(year, "19") || strings.HasPrefix(year, "20")) && isDigits(year) {
+		return 4
+	}
+
+	return 0
+}
+
+func isDigits(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,105 @@
+// Package record describes typed records of the vault. A record is serialized to JSON and the client encrypts it
+// with the vault key, so the server sees only its type and never the fields.
+package record
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Type of the record, it is stored on the server as data type of the record
+type Type string
+
+const (
+	TypeLogin  Type = "login"
+	TypeCard   Type = "card"
+	TypeNote   Type = "note"
+	TypeBinary Type = "binary"
+)
+
+// ErrUnknownType is returned by Unmarshal for records saved before typed records, they have free-form type
+// and content
+var ErrUnknownType = errors.New("unknown record type")
+
+// Record is content of a typed record
+type Record interface {
+	// Type returns type of the record
+	Type() Type
+	// Validate returns violations of the schema of the type, nil if there are none
+	Validate() []Violation
+}
+
+// Violation is a field of the record not matching the schema of its type
+type Violation struct {
+	Field       string
+	Description string
+}
+
+// ValidationError is returned for records with violations
+type ValidationError struct {
+	Violations []Violation
+}
+
+func (e *ValidationError) Error() string {
+	descriptions := make([]string, 0, len(e.Violations))
+	for _, v := range e.Violations {
+		descriptions = append(descriptions, v.Field+": "+v.Description)
+	}
+
+	return "invalid record: " + strings.Join(descriptions, "; ")
+}
+
+// Marshal validates the record and serializes it, ValidationError is returned for invalid records
+func Marshal(r Record) (string, error) {
+	if violations := r.Validate(); len(violations) > 0 {
+		return "", &ValidationError{Violations: violations}
+	}
+
+	b, err := json.Marshal(r)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal record: %w", err)
+	}
+
+	return string(b), nil
+}
+
+// New returns empty record of the type
+func New(t Type) (Record, error) {
+	switch t {
+	case TypeLogin:
+		return &Login{}, nil
+	case TypeCard:
+		return &Card{}, nil
+	case TypeNote:
+		return &Note{}, nil
+	case TypeBinary:
+		return &Binary{}, nil
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnknownType, t)
+	}
+}
+
+// Unmarshal returns record of the type serialized with Marshal
+func Unmarshal(t Type, content string) (Record, error) {
+	r, err := New(t)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal([]byte(content), r); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal %s record: %w", t, err)
+	}
+
+	return r, nil
+}
+
+// violation returns violations of the field by err, nil if err is nil
+func violation(field string, err error) []Violation {
+	if err == nil {
+		return nil
+	}
+
+	return []Violation{{Field: field, Description: err.Error()}}
+}
@@ -0,0 +1,97 @@
+package record
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMarshal_RoundTrip(t *testing.T) {
+	records := []Record{
+		&Login{URL: "https://example.com", Username: "user", Password: "secret"},
+		&Card{Number: "4242 4242 4242 4242", Holder: "IVAN IVANOV", Expiry: "12/99", CVV: "123"},
+		&Note{Text: "wifi password is on the fridge"},
+		&Binary{Name: "key.pem", Data: []byte{0, 1, 2, 255}},
+	}
+
+	for _, r := range records {
+		t.Run(string(r.Type()), func(t *testing.T) {
+			content, err := Marshal(r)
+			require.NoError(t, err)
+
+			got, err := Unmarshal(r.Type(), content)
+			require.NoError(t, err)
+			assert.Equal(t, r, got)
+		})
+	}
+}
+
+func TestMarshal_Invalid(t *testing.T) {
+	_, err := Marshal(&Login{URL: "example.com"})
+
+	var validationErr *ValidationError
+	require.ErrorAs(t, err, &validationErr)
+
+	var fields []string
+	for _, v := range validationErr.Violations {
+		fields = append(fields, v.Field)
+	}
+	assert.Equal(t, []string{"url", "username", "password"}, fields)
+}
+
+func TestUnmarshal_UnknownType(t *testing.T) {
+	_, err := Unmarshal("Password", "free-form text")
+	assert.True(t, errors.Is(err, ErrUnknownType))
+}
+
+func TestValidateCardNumber(t *testing.T) {
+	tests := []struct {
+		number  string
+		wantErr bool
+	}{
+		{number: "4242424242424242"},
+		{number: "4242-4242-4242-4242"},
+		{number: "5555 5555 5555 4444"},
+		{number: "378282246310005"},
+		{number: "4242424242424241", wantErr: true},
+		{number: "4242", wantErr: true},
+		{number: "4242a24242424242", wantErr: true},
+		{number: "", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		err := ValidateCardNumber(tt.number)
+		assert.Equal(t, tt.wantErr, err != nil, "number %q: %v", tt.number, err)
+	}
+}
+
+func TestValidateExpiry(t *testing.T) {
+	now := time.Date(2026, time.March, 15, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		expiry  string
+		wantErr bool
+	}{
+		{expiry: "03/26"},
+		{expiry: "12/30"},
+		{expiry: "02/26", wantErr: true},
+		{expiry: "13/27", wantErr: true},
+		{expiry: "00/27", wantErr: true},
+		{expiry: "3/27", wantErr: true},
+		{expiry: "03-27", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		err := validateExpiry(tt.expiry, now)
+		assert.Equal(t, tt.wantErr, err != nil, "expiry %q: %v", tt.expiry, err)
+	}
+}
+
+func TestBinary_Validate(t *testing.T) {
+	assert.Empty(t, (&Binary{Name: "a.txt", Data: []byte("a")}).Validate())
+	assert.Len(t, (&Binary{Name: "a.txt"}).Validate(), 1)
+	assert.Len(t, (&Binary{Name: "a.txt", Data: make([]byte, MaxBinarySize+1)}).Validate(), 1)
+}
@@ -0,0 +1,221 @@
+package record
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MaxBinarySize is the maximal size of a file kept in a binary record
+const MaxBinarySize = 1 << 20
+
+// Login is a login and password of a site or an application
+type Login struct {
+	URL      string `json:"url,omitempty"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+func (l *Login) Type() Type {
+	return TypeLogin
+}
+
+func (l *Login) Validate() []Violation {
+	var violations []Violation
+
+	violations = append(violations, violation("url", ValidateURL(l.URL))...)
+	violations = append(violations, violation("username", required("username", l.Username))...)
+	violations = append(violations, violation("password", required("password", l.Password))...)
+
+	return violations
+}
+
+// Card is a bank card
+type Card struct {
+	Number string `json:"number"`
+	Holder string `json:"holder,omitempty"`
+	// Expiry is the month the card expires after in MM/YY format
+	Expiry string `json:"expiry"`
+	CVV    string `json:"cvv"`
+}
+
+func (c *Card) Type() Type {
+	return TypeCard
+}
+
+func (c *Card) Validate() []Violation {
+	return c.validate(time.Now())
+}
+
+func (c *Card) validate(now time.Time) []Violation {
+	var violations []Violation
+
+	violations = append(violations, violation("number", ValidateCardNumber(c.Number))...)
+	violations = append(violations, violation("expiry", validateExpiry(c.Expiry, now))...)
+	violations = append(violations, violation("cvv", ValidateCVV(c.CVV))...)
+
+	return violations
+}
+
+// Note is a text
+type Note struct {
+	Text string `json:"text"`
+}
+
+func (n *Note) Type() Type {
+	return TypeNote
+}
+
+func (n *Note) Validate() []Violation {
+	return violation("text", required("text", n.Text))
+}
+
+// Binary is a small file, Data is encoded with base64 in JSON
+type Binary struct {
+	Name string `json:"name"`
+	Data []byte `json:"data"`
+}
+
+func (b *Binary) Type() Type {
+	return TypeBinary
+}
+
+func (b *Binary) Validate() []Violation {
+	var violations []Violation
+
+	violations = append(violations, violation("name", required("name", b.Name))...)
+	violations = append(violations, violation("data", ValidateBinarySize(len(b.Data)))...)
+
+	return violations
+}
+
+// ValidateURL checks the URL is empty or an absolute URL with a host
+func ValidateURL(s string) error {
+	if s == "" {
+		return nil
+	}
+
+	u, err := url.Parse(s)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return errors.New("url must be absolute, for example https://example.com")
+	}
+
+	return nil
+}
+
+// ValidateCardNumber checks the card number has 12 to 19 digits and a valid Luhn check digit,
+// spaces and dashes between digits are allowed
+func ValidateCardNumber(s string) error {
+	digits := NormalizeCardNumber(s)
+
+	if len(digits) < 12 || len(digits) > 19 {
+		return errors.New("card number must have 12 to 19 digits")
+	}
+
+	for _, r := range digits {
+		if r < '0' || r > '9' {
+			return errors.New("card number must contain only digits")
+		}
+	}
+
+	if !luhn(digits) {
+		return errors.New("card number is mistyped, check digit does not match")
+	}
+
+	return nil
+}
+
+// NormalizeCardNumber removes spaces and dashes from the card number
+func NormalizeCardNumber(s string) string {
+	return strings.NewReplacer(" ", "", "-", "").Replace(s)
+}
+
+// ValidateExpiry checks expiry of the card is a month in MM/YY format that has not passed yet
+func ValidateExpiry(s string) error {
+	return validateExpiry(s, time.Now())
+}
+
+func validateExpiry(s string, now time.Time) error {
+	month, year, ok := strings.Cut(s, "/")
+	if !ok || len(month) != 2 || len(year) != 2 {
+		return errors.New("expiry must be in MM/YY format")
+	}
+
+	m, err := strconv.Atoi(month)
+	if err != nil || m < 1 || m > 12 {
+		return errors.New("expiry month must be from 01 to 12")
+	}
+
+	y, err := strconv.Atoi(year)
+	if err != nil {
+		return errors.New("expiry must be in MM/YY format")
+	}
+
+	// the card is valid until the end of the expiry month
+	expires := time.Date(2000+y, time.Month(m)+1, 1, 0, 0, 0, 0, time.UTC)
+	if !now.Before(expires) {
+		return fmt.Errorf("card expired in %s", s)
+	}
+
+	return nil
+}
+
+// ValidateCVV checks the card security code has 3 or 4 digits
+func ValidateCVV(s string) error {
+	if len(s) != 3 && len(s) != 4 {
+		return errors.New("cvv must have 3 or 4 digits")
+	}
+
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return errors.New("cvv must contain only digits")
+		}
+	}
+
+	return nil
+}
+
+// ValidateBinarySize checks size of the file fits a binary record
+func ValidateBinarySize(size int) error {
+	if size == 0 {
+		return errors.New("file is empty")
+	}
+
+	if size > MaxBinarySize {
+		return fmt.Errorf("file must not be larger than %d KiB", MaxBinarySize/1024)
+	}
+
+	return nil
+}
+
+// luhn reports whether the check digit of the number is valid
+func luhn(digits string) bool {
+	sum := 0
+	double := false
+
+	for i := len(digits) - 1; i >= 0; i-- {
+		d := int(digits[i] - '0')
+		if double {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+
+		sum += d
+		double = !double
+	}
+
+	return sum%10 == 0
+}
+
+func required(field, s string) error {
+	if strings.TrimSpace(s) == "" {
+		return fmt.Errorf("%s is required", field)
+	}
+
+	return nil
+}
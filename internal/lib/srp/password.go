@@ -0,0 +1,35 @@
+package srp
+
+import (
+	"crypto/rand"
+	"fmt"
+	"math/big"
+
+	"github.com/nglmq/password-keeper/internal/lib/passhash"
+)
+
+// PasswordX derives the private key x from the password with Argon2id params in PHC form m=..,t=..,p=..
+func PasswordX(password string, salt []byte, params string) (*big.Int, error) {
+	p, err := passhash.ParseParams(params)
+	if err != nil {
+		return nil, err
+	}
+
+	return X(salt, passhash.Key(password, salt, p)), nil
+}
+
+// NewVerifier returns a random salt and the verifier of the password derived with params,
+// the client sends them on registration and password change instead of the password
+func NewVerifier(password, params string) (salt, verifier []byte, err error) {
+	salt = make([]byte, passhash.SaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, nil, fmt.Errorf("failed to generate salt: %w", err)
+	}
+
+	x, err := PasswordX(password, salt, params)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return salt, Verifier(x), nil
+}
@@ -0,0 +1,255 @@
+// Package srp implements SRP-6a (RFC 5054) augmented PAKE: the client proves knowledge of the password
+// without sending it, the server stores only a verifier and proves knowledge of it back.
+//
+// The private key x is derived from the password by the caller with a slow KDF, see X.
+package srp
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+var (
+	ErrInvalidPublic = errors.New("invalid public value")
+	ErrInvalidProof  = errors.New("invalid proof")
+)
+
+// 2048-bit group from RFC 5054 appendix A
+var (
+	n, _ = new(big.Int).SetString(""+
+		"AC6BDB41324A9A9BF166DE5E1389582FAF72B6651987EE07FC3192943DB56050"+
+		"A37329CBB4A099ED8193E0757767A13DD52312AB4B03310DCD7F48A9DA04FD50"+
+		"E8083969EDB767B0CF6095179A163AB3661A05FBD5FAAAE82918A9962F0B93B8"+
+		"55F97993EC975EEAA80D740ADBF4FF747359D041D5C33EA71D281E446B14773B"+
+		"CA97B43A23FB801676BD207A436C6481F1D2B9078717461A5B9D32E688F87748"+
+		"544523B524B0D57D5EA77A2775D2ECFA032CFBDBF52FB3786160279004E57AE6"+
+		"AF874E7303CE53299CCC041C7BC308D82A5698F3A8D0C38271AE35F8E9DBFBB6"+
+		"94B5C803D89F7AE435DE236D525F54759B65E372FCD68EF20FA7111F9E4AFF73", 16)
+	g = big.NewInt(2)
+	k = hashInts(n, g)
+)
+
+// size is the length of padded group elements in bytes
+var size = (n.BitLen() + 7) / 8
+
+// PublicSize is the length of public values A and B
+var PublicSize = size
+
+// X returns the private key from the salt and the key derived from the password
+func X(salt, passwordKey []byte) *big.Int {
+	return new(big.Int).SetBytes(hash(salt, passwordKey))
+}
+
+// Verifier returns the verifier v = g^x the server stores instead of the password
+func Verifier(x *big.Int) []byte {
+	return pad(new(big.Int).Exp(g, x, n))
+}
+
+// ValidVerifier reports whether v is a padded group element other than 0 and 1.
+// Verifiers come from the client, so the server checks them before they are stored.
+func ValidVerifier(v []byte) bool {
+	if len(v) != size {
+		return false
+	}
+
+	x := new(big.Int).SetBytes(v)
+
+	return x.Cmp(big.NewInt(1)) > 0 && x.Cmp(n) < 0
+}
+
+// Client is the client side of one login
+type Client struct {
+	identity string
+	a        *big.Int
+	bigA     *big.Int
+	key      []byte
+	proof    []byte
+}
+
+// NewClient starts login of identity, A is sent before the client knows its salt
+func NewClient(identity string) (*Client, error) {
+	a, err := randomExponent()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Client{
+		identity: identity,
+		a:        a,
+		bigA:     new(big.Int).Exp(g, a, n),
+	}, nil
+}
+
+// Public returns client public value A to send to the server
+func (c *Client) Public() []byte {
+	return pad(c.bigA)
+}
+
+// Proof returns client proof M1 for the salt and server public value B, x is the private key from X
+func (c *Client) Proof(salt []byte, x *big.Int, serverPublic []byte) ([]byte, error) {
+	bigB := new(big.Int).SetBytes(serverPublic)
+	if !validPublic(bigB) {
+		return nil, ErrInvalidPublic
+	}
+
+	u := hashInts(c.bigA, bigB)
+	if u.Sign() == 0 {
+		return nil, ErrInvalidPublic
+	}
+
+	// S = (B - k * g^x) ^ (a + u * x)
+	gx := new(big.Int).Exp(g, x, n)
+	base := new(big.Int).Sub(bigB, new(big.Int).Mul(k, gx))
+	base.Mod(base, n)
+	exp := new(big.Int).Add(c.a, new(big.Int).Mul(u, x))
+	s := new(big.Int).Exp(base, exp, n)
+
+	c.key = hash(pad(s))
+	c.proof = clientProof(c.identity, salt, c.bigA, bigB, c.key)
+
+	return c.proof, nil
+}
+
+// VerifyServer checks server proof M2, so the client knows the server has the verifier
+func (c *Client) VerifyServer(serverProof []byte) error {
+	if c.proof == nil {
+		return ErrInvalidProof
+	}
+
+	if subtle.ConstantTimeCompare(serverProof, hash(pad(c.bigA), c.proof, c.key)) != 1 {
+		return ErrInvalidProof
+	}
+
+	return nil
+}
+
+// Server is the server side of one login
+type Server struct {
+	identity string
+	salt     []byte
+	v        *big.Int
+	b        *big.Int
+	bigB     *big.Int
+}
+
+// NewServer starts login of identity with the stored salt and verifier
+func NewServer(identity string, salt, verifier []byte) (*Server, error) {
+	b, err := randomExponent()
+	if err != nil {
+		return nil, err
+	}
+
+	return RestoreServer(identity, salt, verifier, b.Bytes()), nil
+}
+
+// RestoreServer continues login with the secret saved from Server.Secret after the first round trip
+func RestoreServer(identity string, salt, verifier, secret []byte) *Server {
+	v := new(big.Int).SetBytes(verifier)
+	b := new(big.Int).SetBytes(secret)
+
+	// B = k * v + g^b
+	bigB := new(big.Int).Mul(k, v)
+	bigB.Add(bigB, new(big.Int).Exp(g, b, n))
+	bigB.Mod(bigB, n)
+
+	return &Server{
+		identity: identity,
+		salt:     salt,
+		v:        v,
+		b:        b,
+		bigB:     bigB,
+	}
+}
+
+// Public returns server public value B to send to the client
+func (s *Server) Public() []byte {
+	return pad(s.bigB)
+}
+
+// Secret returns the ephemeral secret b to keep until the client sends the proof
+func (s *Server) Secret() []byte {
+	return s.b.Bytes()
+}
+
+// Verify checks client proof M1 for client public value A and returns server proof M2
+func (s *Server) Verify(clientPublic, proof []byte) ([]byte, error) {
+	bigA := new(big.Int).SetBytes(clientPublic)
+	if !validPublic(bigA) {
+		return nil, ErrInvalidPublic
+	}
+
+	u := hashInts(bigA, s.bigB)
+	if u.Sign() == 0 {
+		return nil, ErrInvalidPublic
+	}
+
+	// S = (A * v^u) ^ b
+	base := new(big.Int).Mul(bigA, new(big.Int).Exp(s.v, u, n))
+	base.Mod(base, n)
+	secret := new(big.Int).Exp(base, s.b, n)
+
+	key := hash(pad(secret))
+
+	expected := clientProof(s.identity, s.salt, bigA, s.bigB, key)
+	if subtle.ConstantTimeCompare(proof, expected) != 1 {
+		return nil, ErrInvalidProof
+	}
+
+	return hash(pad(bigA), expected, key), nil
+}
+
+// FakeServerPublic returns a random value indistinguishable from B, for unknown identities
+func FakeServerPublic() ([]byte, error) {
+	b, err := randomExponent()
+	if err != nil {
+		return nil, err
+	}
+
+	return pad(new(big.Int).Exp(g, b, n)), nil
+}
+
+// clientProof is M1 = H(H(N) xor H(g) | H(I) | s | A | B | K) as in RFC 2945
+func clientProof(identity string, salt []byte, bigA, bigB *big.Int, key []byte) []byte {
+	hn := hash(n.Bytes())
+	hg := hash(g.Bytes())
+	for i := range hn {
+		hn[i] ^= hg[i]
+	}
+
+	return hash(hn, hash([]byte(identity)), salt, pad(bigA), pad(bigB), key)
+}
+
+// validPublic rejects A or B equal to 0 mod N, which would let the other side skip the password
+func validPublic(v *big.Int) bool {
+	return v.Sign() > 0 && new(big.Int).Mod(v, n).Sign() != 0
+}
+
+func randomExponent() (*big.Int, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return nil, fmt.Errorf("failed to generate secret: %w", err)
+	}
+
+	return new(big.Int).SetBytes(b), nil
+}
+
+func hash(parts ...[]byte) []byte {
+	h := sha256.New()
+	for _, p := range parts {
+		h.Write(p)
+	}
+
+	return h.Sum(nil)
+}
+
+func hashInts(a, b *big.Int) *big.Int {
+	return new(big.Int).SetBytes(hash(pad(a), pad(b)))
+}
+
+func pad(v *big.Int) []byte {
+	return v.FillBytes(make([]byte, size))
+}
@@ -0,0 +1,96 @@
+package srp
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func login(t *testing.T, salt []byte, clientKey, serverKey []byte) ([]byte, *Client, error) {
+	t.Helper()
+
+	verifier := Verifier(X(salt, serverKey))
+
+	server, err := NewServer("user@example.com", salt, verifier)
+	require.NoError(t, err)
+
+	client, err := NewClient("user@example.com")
+	require.NoError(t, err)
+
+	// the server keeps only its secret between round trips
+	restored := RestoreServer("user@example.com", salt, verifier, server.Secret())
+	assert.Equal(t, server.Public(), restored.Public())
+
+	proof, err := client.Proof(salt, X(salt, clientKey), server.Public())
+	require.NoError(t, err)
+
+	serverProof, err := restored.Verify(client.Public(), proof)
+
+	return serverProof, client, err
+}
+
+func TestLogin(t *testing.T) {
+	salt := []byte("0123456789abcdef")
+
+	serverProof, client, err := login(t, salt, []byte("key"), []byte("key"))
+	require.NoError(t, err)
+	assert.NoError(t, client.VerifyServer(serverProof))
+	assert.ErrorIs(t, client.VerifyServer([]byte("forged")), ErrInvalidProof)
+}
+
+func TestLogin_WrongPassword(t *testing.T) {
+	salt := []byte("0123456789abcdef")
+
+	_, _, err := login(t, salt, []byte("wrong"), []byte("key"))
+	assert.ErrorIs(t, err, ErrInvalidProof)
+}
+
+func TestServer_Verify_ZeroPublic(t *testing.T) {
+	salt := []byte("0123456789abcdef")
+	server, err := NewServer("user@example.com", salt, Verifier(X(salt, []byte("key"))))
+	require.NoError(t, err)
+
+	// A = 0 or A = N make the session key independent of the password
+	for _, a := range []*big.Int{big.NewInt(0), n, new(big.Int).Mul(n, big.NewInt(2))} {
+		_, err := server.Verify(a.Bytes(), []byte("proof"))
+		assert.ErrorIs(t, err, ErrInvalidPublic)
+	}
+}
+
+func TestClient_Proof_ZeroPublic(t *testing.T) {
+	client, err := NewClient("user@example.com")
+	require.NoError(t, err)
+
+	_, err = client.Proof([]byte("salt"), big.NewInt(42), pad(n))
+	assert.ErrorIs(t, err, ErrInvalidPublic)
+}
+
+func TestFakeServerPublic(t *testing.T) {
+	b, err := FakeServerPublic()
+	require.NoError(t, err)
+	assert.Len(t, b, PublicSize)
+}
+
+func TestValidVerifier(t *testing.T) {
+	assert.True(t, ValidVerifier(Verifier(X([]byte("salt"), []byte("key")))))
+
+	for _, v := range [][]byte{nil, big.NewInt(2).Bytes(), pad(big.NewInt(0)), pad(big.NewInt(1)), pad(n)} {
+		assert.False(t, ValidVerifier(v))
+	}
+}
+
+func TestNewVerifier(t *testing.T) {
+	salt, verifier, err := NewVerifier("correct horse battery staple", "m=1024,t=1,p=1")
+	require.NoError(t, err)
+	assert.Len(t, salt, 16)
+
+	// the server checks logins against the verifier with the salt and params it stores
+	x, err := PasswordX("correct horse battery staple", salt, "m=1024,t=1,p=1")
+	require.NoError(t, err)
+	assert.Equal(t, Verifier(x), verifier)
+
+	_, _, err = NewVerifier("password", "garbage")
+	assert.Error(t, err)
+}
@@ -0,0 +1,61 @@
+package totp
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// RecoveryCodesCount is the number of recovery codes issued when TOTP is enabled
+const RecoveryCodesCount = 10
+
+// recoveryAlphabet has no characters that are easy to confuse when typed from paper
+const recoveryAlphabet = "abcdefghjkmnpqrstuvwxyz23456789"
+
+// NewRecoveryCodes returns one-time recovery codes and their hashes, only the hashes are stored
+func NewRecoveryCodes() (codes, hashes []string, err error) {
+	codes = make([]string, RecoveryCodesCount)
+	hashes = make([]string, RecoveryCodesCount)
+
+	for i := range codes {
+		code, err := newRecoveryCode()
+		if err != nil {
+			return nil, nil, err
+		}
+
+		codes[i] = code
+		hashes[i] = HashRecoveryCode(code)
+	}
+
+	return codes, hashes, nil
+}
+
+// HashRecoveryCode returns hash of the code stored instead of the code.
+// Codes are random with ~50 bits of entropy, so a fast hash is enough.
+func HashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+	sum := sha256.Sum256([]byte(code))
+
+	return hex.EncodeToString(sum[:])
+}
+
+// newRecoveryCode returns code formatted as xxxxx-xxxxx
+func newRecoveryCode() (string, error) {
+	buf := make([]byte, 10)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate recovery code: %w", err)
+	}
+
+	code := make([]byte, 0, len(buf)+1)
+	for i, b := range buf {
+		if i == len(buf)/2 {
+			code = append(code, '-')
+		}
+		// 256 is not a multiple of the alphabet size, the bias is negligible for one-time codes
+		code = append(code, recoveryAlphabet[int(b)%len(recoveryAlphabet)])
+	}
+
+	return string(code), nil
+}
@@ -0,0 +1,104 @@
+package totp
+
+import (
+	"encoding/base32"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// rfcSecret is the SHA1 seed of RFC 6238 test vectors
+var rfcSecret = base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+
+func TestCode_RFC6238(t *testing.T) {
+	// RFC 6238 appendix B, truncated to 6 digits
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+
+	for _, tt := range tests {
+		got, err := Code(rfcSecret, Step(time.Unix(tt.unix, 0)))
+		require.NoError(t, err)
+		assert.Equal(t, tt.want, got, "time %d", tt.unix)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	secret, err := GenerateSecret()
+	require.NoError(t, err)
+
+	now := time.Unix(1700000000, 0)
+
+	current, err := Code(secret, Step(now))
+	require.NoError(t, err)
+
+	step, ok, err := Validate(secret, current, now)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, Step(now), step)
+
+	// clock of the phone is one period behind
+	previous, err := Code(secret, Step(now)-1)
+	require.NoError(t, err)
+
+	step, ok, err = Validate(secret, previous, now)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, Step(now)-1, step)
+
+	stale, err := Code(secret, Step(now)-3)
+	require.NoError(t, err)
+
+	_, ok, err = Validate(secret, stale, now)
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	_, ok, err = Validate(secret, "12345", now)
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	_, _, err = Validate("not base32!", current, now)
+	assert.ErrorIs(t, err, ErrInvalidSecret)
+}
+
+func TestURI(t *testing.T) {
+	uri := URI("password-keeper", "user@example.com", "JBSWY3DPEHPK3PXP")
+
+	u, err := url.Parse(uri)
+	require.NoError(t, err)
+
+	assert.Equal(t, "otpauth", u.Scheme)
+	assert.Equal(t, "totp", u.Host)
+	assert.Equal(t, "/password-keeper:user@example.com", u.Path)
+	assert.Equal(t, "JBSWY3DPEHPK3PXP", u.Query().Get("secret"))
+	assert.Equal(t, "password-keeper", u.Query().Get("issuer"))
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, hashes, err := NewRecoveryCodes()
+	require.NoError(t, err)
+	require.Len(t, codes, RecoveryCodesCount)
+	require.Len(t, hashes, RecoveryCodesCount)
+
+	seen := make(map[string]bool)
+	for i, code := range codes {
+		assert.Len(t, code, 11)
+		assert.Equal(t, hashes[i], HashRecoveryCode(code))
+		assert.False(t, seen[code])
+		seen[code] = true
+	}
+
+	// codes are accepted regardless of case and dash
+	code := codes[0]
+	assert.Equal(t, hashes[0], HashRecoveryCode(" "+code[:5]+code[6:]+" "))
+}
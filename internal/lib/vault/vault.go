@@ -0,0 +1,46 @@
+// Package vault derives the client side vault key from the master password.
+// The key never leaves the client, so the server stores only opaque blobs.
+package vault
+
+import (
+	"crypto/rand"
+	"fmt"
+
+	"github.com/nglmq/password-keeper/internal/lib/crypt"
+	"golang.org/x/crypto/argon2"
+)
+
+// SaltSize is the size of the salt stored on the server for every user.
+const SaltSize = 16
+
+// Argon2id parameters, changing them makes existing vaults unreadable.
+const (
+	argonTime    = 3
+	argonMemory  = 64 * 1024
+	argonThreads = 4
+)
+
+// NewSalt - returns new random salt for the vault key.
+func NewSalt() ([]byte, error) {
+	salt := make([]byte, SaltSize)
+
+	if _, err := rand.Read(salt); err != nil {
+		return nil, fmt.Errorf("failed to generate salt: %w", err)
+	}
+
+	return salt, nil
+}
+
+// DeriveKey - derives vault key from the master password with Argon2id.
+func DeriveKey(password string, salt []byte) []byte {
+	return argon2.IDKey([]byte(password), salt, argonTime, argonMemory, argonThreads, crypt.KeySize)
+}
+
+// Open - returns Crypter sealing records with the vault key derived from the master password.
+func Open(password string, salt []byte) (crypt.Crypter, error) {
+	if len(salt) == 0 {
+		return nil, fmt.Errorf("empty vault salt")
+	}
+
+	return crypt.NewCrypt(DeriveKey(password, salt))
+}
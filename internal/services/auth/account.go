@@ -0,0 +1,106 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/nglmq/password-keeper/internal/lib/logger/sl"
+	"github.com/nglmq/password-keeper/internal/storage"
+)
+
+type AccountStorage interface {
+	DeleteUser(ctx context.Context, userID int64, deletedAt time.Time) error
+	RestoreUser(ctx context.Context, userID int64, deletedAfter time.Time) error
+	PurgeDeletedUsers(ctx context.Context, deletedBefore time.Time) (int64, error)
+}
+
+// DeleteAccount marks the account as deleted and ends all its sessions, the password is proved with client
+// proof M1 of the session the client started with LoginStart. Login is blocked, the account can be restored
+// with RestoreAccount until the returned time when it is purged with all data.
+func (a *Auth) DeleteAccount(ctx context.Context, userID int64, session string, clientProof []byte) (time.Time, error) {
+	log := a.log.With(
+		slog.String("method", "DeleteAccount"),
+		slog.Int64("user_id", userID),
+	)
+
+	user, _, err := a.verifySRPProof(ctx, log, session, clientProof, "")
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	if user.ID != userID {
+		log.Warn("srp session of another user", slog.Int64("session_user_id", user.ID))
+
+		return time.Time{}, ErrInvalidCredentials
+	}
+
+	deletedAt := time.Now()
+
+	if err := a.accounts.DeleteUser(ctx, userID, deletedAt); err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			return time.Time{}, ErrAccountDeleted
+		}
+
+		log.Error("failed to delete user", sl.Err(err))
+
+		return time.Time{}, fmt.Errorf("failed to delete user: %w", err)
+	}
+
+	log.Info("account deleted")
+
+	return deletedAt.Add(a.deletionTTL), nil
+}
+
+// RestoreAccount restores account deleted less than the grace period ago, the user logs in after it as usual.
+// The password is proved with client proof M1 of the session the client started with LoginStart.
+func (a *Auth) RestoreAccount(ctx context.Context, session string, clientProof []byte, clientIP string) error {
+	log := a.log.With(
+		slog.String("method", "RestoreAccount"),
+		slog.String("ip", clientIP),
+	)
+
+	user, _, err := a.verifySRPProof(ctx, log, session, clientProof, clientIP)
+	if err != nil {
+		return err
+	}
+
+	log = log.With(slog.String("email", user.Email))
+
+	if !user.Deleted {
+		return ErrAccountNotDeleted
+	}
+
+	if err := a.accounts.RestoreUser(ctx, user.ID, time.Now().Add(-a.deletionTTL)); err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			// the grace period is over, the account waits to be purged
+			return ErrAccountNotDeleted
+		}
+
+		log.Error("failed to restore user", sl.Err(err))
+
+		return fmt.Errorf("failed to restore user: %w", err)
+	}
+
+	a.resetLoginFailures(ctx, user.Email)
+
+	log.Info("account restored")
+
+	return nil
+}
+
+// purgeDeletedUsers deletes accounts after the grace period
+func (a *Auth) purgeDeletedUsers(ctx context.Context, log *slog.Logger) {
+	purged, err := a.accounts.PurgeDeletedUsers(ctx, time.Now().Add(-a.deletionTTL))
+	if err != nil {
+		log.Error("failed to purge deleted users", sl.Err(err))
+
+		return
+	}
+
+	if purged > 0 {
+		log.Info("deleted users purged", slog.Int64("purged", purged))
+	}
+}
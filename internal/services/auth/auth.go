@@ -2,108 +2,337 @@ package auth
 
 import (
 	"context"
+	"encoding/binary"
 	"errors"
 	"fmt"
 	"github.com/nglmq/password-keeper/internal/lib/crypt"
 	"github.com/nglmq/password-keeper/internal/lib/jwt"
+	"github.com/nglmq/password-keeper/internal/lib/logger/sl"
+	"github.com/nglmq/password-keeper/internal/lib/mailer"
+	"github.com/nglmq/password-keeper/internal/lib/passhash"
+	"github.com/nglmq/password-keeper/internal/lib/password"
+	"github.com/nglmq/password-keeper/internal/lib/vault"
 	"log/slog"
 	"time"
 
 	"github.com/nglmq/password-keeper/internal/domain/models"
 	"github.com/nglmq/password-keeper/internal/storage"
-	"golang.org/x/crypto/bcrypt"
 )
 
 type Auth struct {
-	log        *slog.Logger
-	userSaver  Saver
-	userGetter Getter
+	log          *slog.Logger
+	tokens       *jwt.Manager
+	accessTTL    time.Duration
+	refreshTTL   time.Duration
+	userSaver    Saver
+	userGetter   Getter
+	tokenStorage TokenStorage
+	revocations  RevocationStorage
+	totpStorage  TOTPStorage
+	attempts     AttemptStorage
+	passwords    PasswordStorage
+	accounts     AccountStorage
+	dataGetter   DataGetter
+	emails       EmailStorage
+	srpSessions  SRPStorage
+	limits       LoginLimits
+	policy       password.Policy
+	hasher       *passhash.Hasher
+	prevParams   []passhash.Params
+	deletionTTL  time.Duration
+	keys         *Keys
+
+	mailer        mailer.Mailer
+	emailSettings EmailSettings
 }
 
 type Data struct {
 	log        *slog.Logger
 	dataSaver  DataSaver
 	dataGetter DataGetter
+	saltSaver  SaltSaver
+	saltGetter SaltGetter
+	files      FileStorage
+	blobs      storage.BlobStore
+	keys       *Keys
+	// gcWake wakes RunBlobGC after records with files are deleted
+	gcWake chan struct{}
 }
 
 type Saver interface {
-	SaveUser(ctx context.Context, email string, passHash []byte) (models.User, error)
+	SaveUser(ctx context.Context, email string, verifier models.SRPVerifier) (models.User, error)
 }
 
 type Getter interface {
 	User(ctx context.Context, email string) (models.User, error)
+	UserByID(ctx context.Context, id int64) (models.User, error)
+}
+
+type TokenStorage interface {
+	SaveRefreshToken(ctx context.Context, token models.RefreshToken) error
+	UseRefreshToken(ctx context.Context, hash string) (models.RefreshToken, error)
+	RefreshToken(ctx context.Context, hash string) (models.RefreshToken, error)
+	RevokeTokenFamily(ctx context.Context, familyID string) error
+}
+
+type RevocationStorage interface {
+	RevokeToken(ctx context.Context, jti string, userID int64, expiresAt time.Time) error
+	RevokeUserTokens(ctx context.Context, userID int64, before time.Time) error
+	DeleteExpiredTokens(ctx context.Context) (int64, error)
+}
+
+type TOTPStorage interface {
+	SaveTOTP(ctx context.Context, userID int64, secret string) error
+	TOTP(ctx context.Context, userID int64) (models.TOTP, error)
+	EnableTOTP(ctx context.Context, userID int64, step int64, recoveryHashes []string) error
+	UseTOTPStep(ctx context.Context, userID int64, step int64) error
+	UseRecoveryCode(ctx context.Context, userID int64, hash string) error
+	SaveLoginChallenge(ctx context.Context, challenge models.LoginChallenge) error
+	UseLoginChallenge(ctx context.Context, hash string, maxAttempts int) (models.LoginChallenge, error)
+	DeleteLoginChallenge(ctx context.Context, hash string) error
+}
+
+// AuthStorage is everything Auth service needs from the storage
+type AuthStorage interface {
+	Saver
+	Getter
+	TokenStorage
+	RevocationStorage
+	TOTPStorage
+	AttemptStorage
+	PasswordStorage
+	AccountStorage
+	DataGetter
+	EmailStorage
+	SRPStorage
 }
 
 type DataSaver interface {
-	SaveData(ctx context.Context, userID int64, dataType, data string) error
+	NextDataID(ctx context.Context) (int64, error)
+	SaveData(ctx context.Context, userID int64, record models.Data) error
+	UpdateData(ctx context.Context, userID int64, record models.Data) error
+	DeleteData(ctx context.Context, userID, id int64) error
 }
 
 type DataGetter interface {
 	GetData(ctx context.Context, userID int64) ([]models.Data, error)
 }
 
-// NewAuth returns a new instanse of Auth service
-func NewAuth(log *slog.Logger, userSaver Saver, userGetter Getter) *Auth {
+type SaltSaver interface {
+	SaveVaultSalt(ctx context.Context, userID int64, salt []byte) error
+}
+
+type SaltGetter interface {
+	VaultSalt(ctx context.Context, userID int64) ([]byte, error)
+}
+
+// DataStorage is everything Data service needs from the storage
+type DataStorage interface {
+	DataSaver
+	DataGetter
+	SaltSaver
+	SaltGetter
+	FileStorage
+}
+
+// NewAuth returns a new instanse of Auth service, access tokens live for accessTTL and refresh tokens for refreshTTL.
+// TOTP secrets of users are encrypted with their data keys from keys. Failed logins are limited with limits.
+// Clients check new passwords against policy and derive their SRP verifiers with params of hasher,
+// hasher checks passwords of legacy users. Fake login challenges take previousParams as stale verifiers do.
+// Deleted accounts can be restored for deletionTTL. Verification and password reset tokens are sent with mailer.
+func NewAuth(
+	log *slog.Logger,
+	tokens *jwt.Manager,
+	keys *Keys,
+	accessTTL time.Duration,
+	refreshTTL time.Duration,
+	limits LoginLimits,
+	policy password.Policy,
+	hasher *passhash.Hasher,
+	previousParams []passhash.Params,
+	deletionTTL time.Duration,
+	mailer mailer.Mailer,
+	emailSettings EmailSettings,
+	authStorage AuthStorage,
+) *Auth {
 	return &Auth{
-		log:        log,
-		userSaver:  userSaver,
-		userGetter: userGetter,
+		log:          log,
+		tokens:       tokens,
+		accessTTL:    accessTTL,
+		refreshTTL:   refreshTTL,
+		userSaver:    authStorage,
+		userGetter:   authStorage,
+		tokenStorage: authStorage,
+		revocations:  authStorage,
+		totpStorage:  authStorage,
+		attempts:     authStorage,
+		passwords:    authStorage,
+		accounts:     authStorage,
+		dataGetter:   authStorage,
+		emails:       authStorage,
+		srpSessions:  authStorage,
+		limits:       limits,
+		policy:       policy,
+		hasher:       hasher,
+		prevParams:   previousParams,
+		deletionTTL:  deletionTTL,
+		keys:         keys,
+
+		mailer:        mailer,
+		emailSettings: emailSettings,
 	}
 }
 
-func NewData(log *slog.Logger, dataSaver DataSaver, dataGetter DataGetter) *Data {
+// NewData returns a new instance of Data service, records are encrypted with data keys of users from keys
+func NewData(
+	log *slog.Logger,
+	keys *Keys,
+	dataStorage DataStorage,
+	blobs storage.BlobStore,
+) *Data {
 	return &Data{
 		log:        log,
-		dataSaver:  dataSaver,
-		dataGetter: dataGetter,
+		dataSaver:  dataStorage,
+		dataGetter: dataStorage,
+		saltSaver:  dataStorage,
+		saltGetter: dataStorage,
+		files:      dataStorage,
+		blobs:      blobs,
+		keys:       keys,
+		gcWake:     make(chan struct{}, 1),
 	}
 }
 
 var (
 	ErrInvalidCredentials = errors.New("wrong login or password")
 	ErrUserAlreadyExists  = errors.New("user already exists")
+	ErrDataIntegrity      = errors.New("data integrity check failed")
+	ErrInvalidToken       = errors.New("invalid token")
+	ErrInvalidChallenge   = errors.New("invalid or expired login challenge")
+	ErrInvalidTOTPCode    = errors.New("invalid totp code")
+	ErrTOTPAlreadyEnabled = errors.New("totp already enabled")
+	ErrTOTPNotEnrolled    = errors.New("totp not enrolled")
+	ErrVaultChanged       = errors.New("records changed, sync and retry")
+	ErrInvalidVaultSalt   = errors.New("invalid vault salt")
+	ErrInvalidVerifier    = errors.New("invalid password verifier")
+	ErrAccountDeleted     = errors.New("account deleted")
+	ErrAccountNotDeleted  = errors.New("account is not deleted or can not be restored")
+	ErrRecordNotFound     = errors.New("record not found")
+	ErrInvalidReservation = errors.New("record id is not reserved")
 )
 
-// Login check credentials and if user exists
-func (a *Auth) Login(ctx context.Context, email string, password string) (string, error) {
+// Login checks the password sent by the client, it is used once by users who have no SRP verifier yet
+// and replaces their password hash with a verifier. Users who have a verifier are rejected as with a wrong password,
+// they log in with LoginStart and LoginFinish.
+// If the user has TOTP enabled no tokens are issued, the result holds challenge to complete with LoginTOTP.
+// After failed attempts next attempts of the email and from clientIP are delayed, LimitError is returned then.
+func (a *Auth) Login(ctx context.Context, email, password, clientIP string) (models.LoginResult, error) {
 	log := a.log.With(
 		slog.String("method", "Login"),
 		slog.String("email", email),
+		slog.String("ip", clientIP),
 	)
 
 	log.Info("logging in")
 
+	if err := a.checkLoginAllowed(ctx, email, clientIP); err != nil {
+		log.Warn("login attempt rejected", sl.Err(err))
+
+		return models.LoginResult{}, err
+	}
+
 	user, err := a.userGetter.User(ctx, email)
 	if err != nil {
 		if errors.Is(err, storage.ErrUserNotFound) {
-			log.Error("user not found", err)
+			log.Error("user not found", sl.Err(err))
+			a.recordLoginFailure(ctx, email, clientIP)
+
+			return models.LoginResult{}, ErrInvalidCredentials
+		}
+
+		log.Error("failed to get user", sl.Err(err))
+
+		return models.LoginResult{}, fmt.Errorf("failed to get user: %w", err)
+	}
+
+	if err := a.verifyPassword(ctx, user, password); err != nil {
+		if !errors.Is(err, ErrInvalidCredentials) {
+			log.Error("failed to verify password", sl.Err(err))
+
+			return models.LoginResult{}, err
+		}
+
+		log.Info("invalid credentials")
+		a.recordLoginFailure(ctx, email, clientIP)
+
+		return models.LoginResult{}, ErrInvalidCredentials
+	}
+
+	return a.completeLogin(ctx, log, user)
+}
 
-			return "", ErrInvalidCredentials
+// completeLogin finishes login of the user whose password is checked, it is shared by Login and LoginFinish
+func (a *Auth) completeLogin(ctx context.Context, log *slog.Logger, user models.User) (models.LoginResult, error) {
+	// checked after the password, so only the owner learns the account is deleted
+	if user.Deleted {
+		log.Info("account deleted")
+
+		return models.LoginResult{}, ErrAccountDeleted
+	}
+
+	if a.emailSettings.RequireVerified && !user.EmailVerified {
+		log.Info("email not verified")
+
+		// the password is right, so the owner gets a new verification email if the previous one was lost
+		if err := a.sendEmailToken(ctx, user, models.EmailTokenVerify); err != nil && !errors.Is(err, ErrTooManyAttempts) {
+			log.Error("failed to send verification email", sl.Err(err))
 		}
 
-		log.Error("failed to get user", err)
+		return models.LoginResult{}, ErrEmailNotVerified
+	}
+
+	totpEnabled, err := a.totpEnabled(ctx, user.ID)
+	if err != nil {
+		log.Error("failed to get totp", sl.Err(err))
 
-		return "", fmt.Errorf("failed to get user: %w", err)
+		return models.LoginResult{}, err
 	}
 
-	if err := bcrypt.CompareHashAndPassword(user.PassHash, []byte(password)); err != nil {
-		a.log.Info("invalid credentials", err)
+	if totpEnabled {
+		challenge, err := a.newLoginChallenge(ctx, user.ID)
+		if err != nil {
+			log.Error("failed to create login challenge", sl.Err(err))
+
+			return models.LoginResult{}, err
+		}
+
+		// failures are not reset until the second factor is passed, so codes can not be guessed endlessly
+		log.Info("second factor required")
 
-		return "", fmt.Errorf("%w", ErrInvalidCredentials)
+		return models.LoginResult{Challenge: challenge}, nil
 	}
 
-	token, err := jwt.NewToken(user, 3*time.Hour)
+	tokens, err := a.newTokenPair(ctx, user, "")
 	if err != nil {
-		a.log.Error("failed to generate token", err)
+		log.Error("failed to generate tokens", sl.Err(err))
 
-		return "", fmt.Errorf("%w", err)
+		return models.LoginResult{}, err
 	}
 
-	return token, nil
+	a.resetLoginFailures(ctx, user.Email)
+
+	return models.LoginResult{Tokens: tokens}, nil
 }
 
-// RegisterNewUser register new user and returns user ID
-func (a *Auth) RegisterNewUser(ctx context.Context, email string, password string) (string, error) {
+// RegisterNewUser register new user with SRP verifier computed by the client and returns tokens of the user,
+// a verification token is sent to the email. The password is checked against the policy with the report
+// the client sent.
+func (a *Auth) RegisterNewUser(
+	ctx context.Context,
+	email string,
+	verifier models.SRPVerifier,
+	report password.Report,
+) (models.TokenPair, error) {
 	log := a.log.With(
 		slog.String("method", "RegisterNewUser"),
 		slog.String("email", email),
@@ -111,107 +340,286 @@ func (a *Auth) RegisterNewUser(ctx context.Context, email string, password strin
 
 	log.Info("registering new user")
 
-	passHash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	email, err := validateEmail(email)
 	if err != nil {
-		log.Error("failed to hash password", err)
+		log.Info("invalid email")
+
+		return models.TokenPair{}, err
+	}
+
+	if err := a.checkPasswordReport(report); err != nil {
+		log.Info("password rejected by policy", sl.Err(err))
+
+		return models.TokenPair{}, err
+	}
+
+	if err := a.checkVerifier(verifier); err != nil {
+		log.Info("invalid verifier", sl.Err(err))
 
-		return "", fmt.Errorf("failed to hash password: %w", err)
+		return models.TokenPair{}, err
 	}
 
-	user, err := a.userSaver.SaveUser(ctx, email, passHash)
+	user, err := a.userSaver.SaveUser(ctx, email, verifier)
 	if err != nil {
 		if errors.Is(err, storage.ErrUserExists) {
-			log.Error("user already exists", err)
+			log.Error("user already exists", sl.Err(err))
 
-			return "", ErrUserAlreadyExists
+			return models.TokenPair{}, ErrUserAlreadyExists
 		}
-		log.Error("failed to save user", err)
+		log.Error("failed to save user", sl.Err(err))
+
+		return models.TokenPair{}, fmt.Errorf("failed to save user: %w", err)
+	}
 
-		return "", fmt.Errorf("failed to save user: %w", err)
+	// the account works without verification unless it is required, so a failed email does not fail registration
+	if err := a.sendEmailToken(ctx, user, models.EmailTokenVerify); err != nil {
+		log.Error("failed to send verification email", sl.Err(err))
 	}
 
-	token, err := jwt.NewToken(user, 3*time.Hour)
+	tokens, err := a.newTokenPair(ctx, user, "")
 	if err != nil {
-		a.log.Error("failed to generate token", err)
+		log.Error("failed to generate tokens", sl.Err(err))
+
+		return models.TokenPair{}, err
+	}
+
+	return tokens, nil
+}
 
-		return "", fmt.Errorf("%w", err)
+// ReserveDataID returns ID of a new record of the user and the reservation SaveData checks,
+// the client binds the content of the record to the ID before it is saved
+func (d *Data) ReserveDataID(ctx context.Context, userID int64) (int64, string, error) {
+	log := d.log.With(
+		slog.String("method", "ReserveDataID"),
+		slog.Int64("user_id", userID),
+	)
+
+	id, err := d.dataSaver.NextDataID(ctx)
+	if err != nil {
+		log.Error("failed to get data id", sl.Err(err))
+		return 0, "", fmt.Errorf("failed to get data id: %w", err)
 	}
 
-	return token, nil
+	return id, d.keys.Reservation(userID, id), nil
 }
 
-func (d *Data) SaveData(ctx context.Context, token, dataType, data string) (string, error) {
+// SaveData saves a new record of the user with the ID reserved by ReserveDataID and returns it without content,
+// ErrInvalidReservation is returned if the ID was not reserved for the user
+func (d *Data) SaveData(ctx context.Context, userID, id int64, reservation, dataType, data string) (models.Data, error) {
 	log := d.log.With(
 		slog.String("method", "SaveData"),
+		slog.Int64("user_id", userID),
+		slog.Int64("id", id),
 		slog.String("dataType", dataType),
 	)
 
-	userID, err := jwt.ValidateToken(token)
+	if !d.keys.ValidReservation(userID, id, reservation) {
+		log.Info("invalid reservation")
+		return models.Data{}, ErrInvalidReservation
+	}
+
+	cr, err := d.keys.UserCrypt(ctx, userID)
 	if err != nil {
-		log.Error("failed to validate token", err)
+		log.Error("failed to create crypt", sl.Err(err))
+		return models.Data{}, fmt.Errorf("failed to create crypt: %w", err)
+	}
 
-		return "", fmt.Errorf("failed to validate token: %w", err)
+	// Данные уже зашифрованы ключом хранилища на клиенте, дополнительно шифруем их ключом пользователя
+	encryptedData, err := cr.Seal(data, recordAD(userID, id, dataType))
+	if err != nil {
+		log.Error("failed to encode data", sl.Err(err))
+		return models.Data{}, fmt.Errorf("failed to encode data: %w", err)
 	}
 
-	cr, err := crypt.NewCrypt()
+	log.Info("saving data")
+
+	now := time.Now().UTC()
+	record := models.Data{
+		ID:        id,
+		DataType:  dataType,
+		Content:   encryptedData,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+
+	err = d.dataSaver.SaveData(ctx, userID, record)
 	if err != nil {
-		log.Error("failed to create crypt", err)
-		return "", fmt.Errorf("failed to create crypt: %w", err)
+		log.Error("failed to save data", sl.Err(err))
+
+		return models.Data{}, fmt.Errorf("failed to save data: %w", err)
 	}
 
-	// Шифруем данные перед сохранением
-	encryptedData := cr.Encode(data)
+	record.Content = ""
 
-	log.Info("saving data")
+	return record, nil
+}
+
+// UpdateData replaces type and content of the record of the user, records of other users are not found
+func (d *Data) UpdateData(ctx context.Context, userID, id int64, dataType, data string) (models.Data, error) {
+	log := d.log.With(
+		slog.String("method", "UpdateData"),
+		slog.Int64("user_id", userID),
+		slog.Int64("id", id),
+		slog.String("dataType", dataType),
+	)
+
+	cr, err := d.keys.UserCrypt(ctx, userID)
+	if err != nil {
+		log.Error("failed to create crypt", sl.Err(err))
+		return models.Data{}, fmt.Errorf("failed to create crypt: %w", err)
+	}
+
+	// the record keeps its ID, the new ciphertext is bound to it and to the new type
+	encryptedData, err := cr.Seal(data, recordAD(userID, id, dataType))
+	if err != nil {
+		log.Error("failed to encode data", sl.Err(err))
+		return models.Data{}, fmt.Errorf("failed to encode data: %w", err)
+	}
+
+	log.Info("updating data")
+
+	record := models.Data{
+		ID:        id,
+		DataType:  dataType,
+		Content:   encryptedData,
+		UpdatedAt: time.Now().UTC(),
+	}
 
-	err = d.dataSaver.SaveData(ctx, userID, dataType, encryptedData)
+	err = d.dataSaver.UpdateData(ctx, userID, record)
 	if err != nil {
-		log.Error("failed to save data", err)
+		if errors.Is(err, storage.ErrDataNotFound) {
+			log.Warn("record not found")
+
+			return models.Data{}, ErrRecordNotFound
+		}
+
+		log.Error("failed to update data", sl.Err(err))
 
-		return "", fmt.Errorf("failed to save data: %w", err)
+		return models.Data{}, fmt.Errorf("failed to update data: %w", err)
 	}
 
-	return token, nil
+	record.Content = ""
+
+	return record, nil
 }
 
-func (d *Data) GetData(ctx context.Context, token string) (string, []models.Data, error) {
+// DeleteData deletes the record of the user, records of other users are not found
+func (d *Data) DeleteData(ctx context.Context, userID, id int64) error {
 	log := d.log.With(
-		slog.String("method", "GetData"),
-		slog.String("token", token),
+		slog.String("method", "DeleteData"),
+		slog.Int64("user_id", userID),
+		slog.Int64("id", id),
 	)
 
-	userID, err := jwt.ValidateToken(token)
+	log.Info("deleting data")
+
+	err := d.dataSaver.DeleteData(ctx, userID, id)
 	if err != nil {
-		log.Error("failed to validate token", err)
+		if errors.Is(err, storage.ErrDataNotFound) {
+			log.Warn("record not found")
 
-		return "", []models.Data{}, fmt.Errorf("failed to validate token: %w", err)
+			return ErrRecordNotFound
+		}
+
+		log.Error("failed to delete data", sl.Err(err))
+
+		return fmt.Errorf("failed to delete data: %w", err)
 	}
 
+	// chunks of files attached to the record are left in the blob store
+	d.wakeBlobGC()
+
+	return nil
+}
+
+func (d *Data) GetData(ctx context.Context, userID int64) ([]models.Data, error) {
+	log := d.log.With(
+		slog.String("method", "GetData"),
+		slog.Int64("user_id", userID),
+	)
+
 	log.Info("getting data")
 
 	data, err := d.dataGetter.GetData(ctx, userID)
 	if err != nil {
-		log.Error("failed to get data", err)
+		log.Error("failed to get data", sl.Err(err))
 		if errors.Is(err, storage.ErrDataNotFound) {
-			return token, []models.Data{}, nil
+			return []models.Data{}, nil
 		}
 
-		return token, []models.Data{}, err
+		return []models.Data{}, err
 	}
 
-	cr, err := crypt.NewCrypt()
+	cr, err := d.keys.UserCrypt(ctx, userID)
 	if err != nil {
-		log.Error("failed to create crypt", err)
-		return token, []models.Data{}, fmt.Errorf("failed to create crypt: %w", err)
+		log.Error("failed to create crypt", sl.Err(err))
+		return []models.Data{}, fmt.Errorf("failed to create crypt: %w", err)
 	}
 
 	for i := range data {
-		data[i].Content, err = cr.Decode(data[i].Content)
+		if data[i].Legacy {
+			// the record is not bound to its owner until keeper-admin reseal-records seals it again
+			log.Error("record is not resealed", slog.Int64("id", data[i].ID))
+			return []models.Data{}, fmt.Errorf("%w: record %d: %v", ErrDataIntegrity, data[i].ID, crypt.ErrUnbound)
+		}
+
+		content, err := cr.Open(data[i].Content, recordAD(userID, data[i].ID, data[i].DataType))
 		if err != nil {
-			log.Error("failed to decode data", err)
-			return token, []models.Data{}, fmt.Errorf("failed to decode data: %w", err)
+			// the record was modified or moved from another user or record
+			log.Error("failed to decode data", slog.Int64("id", data[i].ID), sl.Err(err))
+			return []models.Data{}, fmt.Errorf("%w: record %d: %v", ErrDataIntegrity, data[i].ID, err)
+		}
+
+		data[i].Content = content
+
+		if err := openFilesMeta(cr, userID, data[i].Files); err != nil {
+			log.Error("failed to decode meta", slog.Int64("id", data[i].ID), sl.Err(err))
+			return []models.Data{}, err
 		}
 	}
 
-	return token, data, nil
+	return data, nil
+}
+
+// GetVaultSalt returns salt the client derives vault key with, the salt is generated on first use
+func (d *Data) GetVaultSalt(ctx context.Context, userID int64) ([]byte, error) {
+	log := d.log.With(
+		slog.String("method", "GetVaultSalt"),
+		slog.Int64("user_id", userID),
+	)
+
+	salt, err := d.saltGetter.VaultSalt(ctx, userID)
+	if errors.Is(err, storage.ErrSaltNotFound) {
+		salt, err = vault.NewSalt()
+		if err != nil {
+			log.Error("failed to generate salt", sl.Err(err))
+
+			return nil, err
+		}
+
+		if err := d.saltSaver.SaveVaultSalt(ctx, userID, salt); err != nil {
+			log.Error("failed to save salt", sl.Err(err))
+
+			return nil, fmt.Errorf("failed to save salt: %w", err)
+		}
+
+		// concurrent request could save its own salt first, so read back the stored one
+		salt, err = d.saltGetter.VaultSalt(ctx, userID)
+	}
+	if err != nil {
+		log.Error("failed to get salt", sl.Err(err))
+
+		return nil, fmt.Errorf("failed to get salt: %w", err)
+	}
+
+	return salt, nil
+}
+
+// recordAD returns associated data binding encrypted record to its owner, ID and type
+func recordAD(userID, id int64, dataType string) []byte {
+	ad := make([]byte, 16, 16+len(dataType))
+	binary.BigEndian.PutUint64(ad[:8], uint64(userID))
+	binary.BigEndian.PutUint64(ad[8:], uint64(id))
+
+	return append(ad, dataType...)
 }
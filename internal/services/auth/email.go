@@ -0,0 +1,341 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/mail"
+	"strings"
+	"time"
+
+	"github.com/nglmq/password-keeper/internal/domain/models"
+	"github.com/nglmq/password-keeper/internal/lib/jwt"
+	"github.com/nglmq/password-keeper/internal/lib/logger/sl"
+	"github.com/nglmq/password-keeper/internal/lib/mailer"
+	"github.com/nglmq/password-keeper/internal/lib/password"
+	"github.com/nglmq/password-keeper/internal/storage"
+)
+
+// sendTimeout limits sending of one email in background
+const sendTimeout = time.Minute
+
+var (
+	ErrInvalidEmail         = errors.New("invalid email")
+	ErrInvalidEmailToken    = errors.New("invalid or expired email token")
+	ErrEmailNotVerified     = errors.New("email not verified")
+	ErrEmailAlreadyVerified = errors.New("email already verified")
+)
+
+type EmailStorage interface {
+	SaveEmailToken(ctx context.Context, token models.EmailToken) error
+	LatestEmailToken(ctx context.Context, userID int64, purpose string) (models.EmailToken, error)
+	EmailToken(ctx context.Context, hash, purpose string) (models.EmailToken, error)
+	VerifyEmail(ctx context.Context, hash string) (int64, error)
+	ResetPassword(ctx context.Context, hash string, verifier models.SRPVerifier, validAfter time.Time) (int64, error)
+}
+
+// EmailSettings configures tokens sent to users by email
+type EmailSettings struct {
+	// VerificationTTL is the time the email verification token is valid
+	VerificationTTL time.Duration
+	// ResetTTL is the time the password reset token is valid
+	ResetTTL time.Duration
+	// ResendInterval is the minimal time between two emails of the same purpose to the user
+	ResendInterval time.Duration
+	// RequireVerified blocks login until the email is verified
+	RequireVerified bool
+}
+
+// validateEmail returns the address if email is a bare address like user@example.com
+func validateEmail(email string) (string, error) {
+	email = strings.TrimSpace(email)
+
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email {
+		return "", ErrInvalidEmail
+	}
+
+	return addr.Address, nil
+}
+
+// SendVerificationEmail sends a new verification token to the email of the signed in user
+func (a *Auth) SendVerificationEmail(ctx context.Context, userID int64) error {
+	log := a.log.With(
+		slog.String("method", "SendVerificationEmail"),
+		slog.Int64("user_id", userID),
+	)
+
+	user, err := a.userGetter.UserByID(ctx, userID)
+	if err != nil {
+		log.Error("failed to get user", sl.Err(err))
+
+		return fmt.Errorf("failed to get user: %w", err)
+	}
+
+	if user.EmailVerified {
+		return ErrEmailAlreadyVerified
+	}
+
+	return a.sendEmailToken(ctx, user, models.EmailTokenVerify)
+}
+
+// VerifyEmail marks email of the user as verified with the token from the verification email
+func (a *Auth) VerifyEmail(ctx context.Context, code string) error {
+	log := a.log.With(
+		slog.String("method", "VerifyEmail"),
+	)
+
+	userID, err := a.emails.VerifyEmail(ctx, jwt.HashRefreshToken(strings.TrimSpace(code)))
+	if err != nil {
+		if errors.Is(err, storage.ErrTokenNotFound) {
+			log.Info("invalid verification token")
+
+			return ErrInvalidEmailToken
+		}
+
+		log.Error("failed to verify email", sl.Err(err))
+
+		return fmt.Errorf("failed to verify email: %w", err)
+	}
+
+	log.Info("email verified", slog.Int64("user_id", userID))
+
+	return nil
+}
+
+// RequestPasswordReset sends password reset token to the email if there is an account with it.
+// The result does not depend on whether the account exists, so accounts can not be enumerated with it.
+func (a *Auth) RequestPasswordReset(ctx context.Context, email string) error {
+	log := a.log.With(
+		slog.String("method", "RequestPasswordReset"),
+		slog.String("email", email),
+	)
+
+	email, err := validateEmail(email)
+	if err != nil {
+		return err
+	}
+
+	user, err := a.userGetter.User(ctx, email)
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			log.Info("password reset for unknown email")
+
+			return nil
+		}
+
+		log.Error("failed to get user", sl.Err(err))
+
+		return fmt.Errorf("failed to get user: %w", err)
+	}
+
+	if user.Deleted {
+		log.Info("password reset for deleted account")
+
+		return nil
+	}
+
+	err = a.sendEmailToken(ctx, user, models.EmailTokenReset)
+	var limitErr *LimitError
+	if errors.As(err, &limitErr) {
+		// too frequent requests are dropped silently, telling about them would reveal the account
+		log.Info("password reset requested too often")
+
+		return nil
+	}
+
+	return err
+}
+
+// ResetPassword sets new password of the user with the token from the reset email, the client sends
+// SRP verifier of the password instead of the password with its report checked against the policy. Records of the user are
+// encrypted end-to-end with the vault key of the forgotten password, nobody can decrypt them anymore, so they
+// are deleted and a new vault is started. All sessions are revoked, the user logs in with the new password.
+// If the user has TOTP enabled, totpCode must be a valid TOTP or recovery code, so the email alone is not enough.
+func (a *Auth) ResetPassword(
+	ctx context.Context,
+	code string,
+	verifier models.SRPVerifier,
+	report password.Report,
+	totpCode, clientIP string,
+) error {
+	log := a.log.With(
+		slog.String("method", "ResetPassword"),
+		slog.String("ip", clientIP),
+	)
+
+	hash := jwt.HashRefreshToken(strings.TrimSpace(code))
+
+	token, err := a.emails.EmailToken(ctx, hash, models.EmailTokenReset)
+	if err != nil {
+		if errors.Is(err, storage.ErrTokenNotFound) {
+			log.Info("invalid reset token")
+
+			return ErrInvalidEmailToken
+		}
+
+		log.Error("failed to get reset token", sl.Err(err))
+
+		return fmt.Errorf("failed to get reset token: %w", err)
+	}
+
+	log = log.With(slog.Int64("user_id", token.UserID))
+
+	user, err := a.userGetter.UserByID(ctx, token.UserID)
+	if err != nil {
+		log.Error("failed to get user", sl.Err(err))
+
+		return fmt.Errorf("failed to get user: %w", err)
+	}
+
+	if user.Deleted {
+		return ErrAccountDeleted
+	}
+
+	if err := a.checkPasswordReport(report); err != nil {
+		log.Info("new password rejected by policy", sl.Err(err))
+
+		return err
+	}
+
+	if err := a.checkVerifier(verifier); err != nil {
+		log.Info("invalid verifier", sl.Err(err))
+
+		return err
+	}
+
+	totpEnabled, err := a.totpEnabled(ctx, user.ID)
+	if err != nil {
+		log.Error("failed to get totp", sl.Err(err))
+
+		return err
+	}
+
+	if totpEnabled {
+		if err := a.checkLoginAllowed(ctx, user.Email, clientIP); err != nil {
+			log.Warn("reset attempt rejected", sl.Err(err))
+
+			return err
+		}
+
+		if err := a.verifySecondFactor(ctx, user.ID, totpCode); err != nil {
+			if errors.Is(err, ErrInvalidTOTPCode) {
+				log.Info("invalid totp code")
+				a.recordLoginFailure(ctx, user.Email, clientIP)
+			} else {
+				log.Error("failed to verify totp code", sl.Err(err))
+			}
+
+			return err
+		}
+	}
+
+	if _, err := a.emails.ResetPassword(ctx, hash, verifier, jwt.RevocationTime()); err != nil {
+		if errors.Is(err, storage.ErrTokenNotFound) {
+			// used by a concurrent request
+			return ErrInvalidEmailToken
+		}
+
+		log.Error("failed to reset password", sl.Err(err))
+
+		return fmt.Errorf("failed to reset password: %w", err)
+	}
+
+	// the owner proved access to the email, the lockout of password guessing is lifted
+	a.resetLoginFailures(ctx, user.Email)
+
+	log.Info("password reset")
+
+	return nil
+}
+
+// sendEmailToken saves a new token of the purpose and emails it to the user in background.
+// LimitError is returned if the previous email was sent less than ResendInterval ago.
+func (a *Auth) sendEmailToken(ctx context.Context, user models.User, purpose string) error {
+	log := a.log.With(
+		slog.String("method", "sendEmailToken"),
+		slog.Int64("user_id", user.ID),
+		slog.String("purpose", purpose),
+	)
+
+	now := time.Now()
+
+	last, err := a.emails.LatestEmailToken(ctx, user.ID, purpose)
+	switch {
+	case errors.Is(err, storage.ErrTokenNotFound):
+	case err != nil:
+		log.Error("failed to get latest email token", sl.Err(err))
+
+		return fmt.Errorf("failed to get latest email token: %w", err)
+	default:
+		if wait := last.CreatedAt.Add(a.emailSettings.ResendInterval).Sub(now); wait > 0 {
+			return &LimitError{RetryAfter: wait}
+		}
+	}
+
+	// email tokens are random opaque tokens as refresh tokens are
+	code, hash, err := jwt.NewRefreshToken()
+	if err != nil {
+		return err
+	}
+
+	ttl := a.emailSettings.VerificationTTL
+	if purpose == models.EmailTokenReset {
+		ttl = a.emailSettings.ResetTTL
+	}
+
+	err = a.emails.SaveEmailToken(ctx, models.EmailToken{
+		Hash:      hash,
+		UserID:    user.ID,
+		Purpose:   purpose,
+		ExpiresAt: now.Add(ttl),
+		CreatedAt: now,
+	})
+	if err != nil {
+		log.Error("failed to save email token", sl.Err(err))
+
+		return fmt.Errorf("failed to save email token: %w", err)
+	}
+
+	msg := emailTokenMessage(user.Email, purpose, code, ttl)
+
+	// sending takes long and only for existing accounts, so it is not waited for
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), sendTimeout)
+		defer cancel()
+
+		if err := a.mailer.Send(ctx, msg); err != nil {
+			log.Error("failed to send email", sl.Err(err))
+
+			return
+		}
+
+		log.Info("email sent")
+	}()
+
+	return nil
+}
+
+func emailTokenMessage(to, purpose, code string, ttl time.Duration) mailer.Message {
+	if purpose == models.EmailTokenReset {
+		return mailer.Message{
+			To:      to,
+			Subject: "Password Keeper: password reset",
+			Body: "Somebody asked to reset the password of your Password Keeper account.\n" +
+				"Choose \"Forgot password\" in the client and enter this code:\n\n" +
+				code + "\n\n" +
+				"The code is valid for " + ttl.String() + ". Your records are encrypted with the old password,\n" +
+				"they are deleted when the password is reset. If you remember the password, ignore this email.\n",
+		}
+	}
+
+	return mailer.Message{
+		To:      to,
+		Subject: "Password Keeper: verify your email",
+		Body: "Welcome to Password Keeper!\n" +
+			"Choose \"Verify email\" in the client and enter this code:\n\n" +
+			code + "\n\n" +
+			"The code is valid for " + ttl.String() + ".\n",
+	}
+}
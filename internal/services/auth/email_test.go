@@ -0,0 +1,35 @@
+package auth
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_validateEmail(t *testing.T) {
+	tests := []struct {
+		email   string
+		want    string
+		wantErr bool
+	}{
+		{email: "user@example.com", want: "user@example.com"},
+		{email: " user@example.com ", want: "user@example.com"},
+		{email: "user", wantErr: true},
+		{email: "user@", wantErr: true},
+		{email: "User <user@example.com>", wantErr: true},
+		{email: "a@b@example.com", wantErr: true},
+		{email: "", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := validateEmail(tt.email)
+		if tt.wantErr {
+			assert.ErrorIs(t, err, ErrInvalidEmail, "email %q", tt.email)
+
+			continue
+		}
+
+		assert.NoError(t, err, "email %q", tt.email)
+		assert.Equal(t, tt.want, got)
+	}
+}
@@ -0,0 +1,475 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nglmq/password-keeper/internal/domain/models"
+	"github.com/nglmq/password-keeper/internal/lib/crypt"
+	"github.com/nglmq/password-keeper/internal/lib/logger/sl"
+	"github.com/nglmq/password-keeper/internal/storage"
+)
+
+// Limits of files attached to records
+const (
+	// MaxChunkSize is the maximal size of a chunk encrypted by the client
+	MaxChunkSize = 1 << 20
+	// MaxFileChunks is the maximal number of chunks of a file
+	MaxFileChunks = 4096
+)
+
+var (
+	ErrFileNotFound     = errors.New("file not found")
+	ErrChecksumMismatch = errors.New("chunk checksum mismatch")
+	ErrUnexpectedChunk  = errors.New("unexpected chunk")
+	ErrEmptyUpload      = errors.New("no chunks uploaded")
+	ErrUploadIncomplete = errors.New("upload is not completed")
+	ErrQuotaExceeded    = errors.New("quota exceeded")
+)
+
+type FileStorage interface {
+	NextFileID(ctx context.Context) (int64, error)
+	SaveFile(ctx context.Context, userID int64, file models.File) error
+	File(ctx context.Context, userID, id int64) (models.File, error)
+	SaveFileChunk(ctx context.Context, userID int64, chunk models.FileChunk, uploadedAt time.Time) (models.File, error)
+	FileChunk(ctx context.Context, fileID int64, index int) (models.FileChunk, error)
+	ExistingFiles(ctx context.Context, ids []int64) (map[int64]bool, error)
+}
+
+// blobPrefix is the prefix of keys of all chunks in the blob store
+const blobPrefix = "files/"
+
+// CreateUpload attaches a new file of chunkCount chunks to the record of the user, the chunks are uploaded
+// with UploadFile after it. Meta is encrypted by the client, it is returned as is with the other files of the record.
+// ErrQuotaExceeded is returned for files of more than MaxFileChunks chunks.
+func (d *Data) CreateUpload(ctx context.Context, userID, recordID int64, meta string, chunkCount int) (models.File, error) {
+	log := d.log.With(
+		slog.String("method", "CreateUpload"),
+		slog.Int64("user_id", userID),
+		slog.Int64("record_id", recordID),
+	)
+
+	if chunkCount > MaxFileChunks {
+		log.Info("file too large", slog.Int("chunks", chunkCount))
+		return models.File{}, fmt.Errorf("%w: file of %d chunks, at most %d", ErrQuotaExceeded, chunkCount, MaxFileChunks)
+	}
+
+	cr, err := d.keys.UserCrypt(ctx, userID)
+	if err != nil {
+		log.Error("failed to create crypt", sl.Err(err))
+		return models.File{}, fmt.Errorf("failed to create crypt: %w", err)
+	}
+
+	id, err := d.files.NextFileID(ctx)
+	if err != nil {
+		log.Error("failed to get file id", sl.Err(err))
+		return models.File{}, fmt.Errorf("failed to get file id: %w", err)
+	}
+
+	sealedMeta, err := cr.Seal(meta, fileAD(userID, recordID, id))
+	if err != nil {
+		log.Error("failed to encode meta", sl.Err(err))
+		return models.File{}, fmt.Errorf("failed to encode meta: %w", err)
+	}
+
+	file := models.File{
+		ID:         id,
+		RecordID:   recordID,
+		Meta:       sealedMeta,
+		ChunkCount: chunkCount,
+		CreatedAt:  time.Now().UTC(),
+	}
+
+	err = d.files.SaveFile(ctx, userID, file)
+	if err != nil {
+		if errors.Is(err, storage.ErrDataNotFound) {
+			log.Warn("record not found")
+
+			return models.File{}, ErrRecordNotFound
+		}
+
+		log.Error("failed to save file", sl.Err(err))
+
+		return models.File{}, fmt.Errorf("failed to save file: %w", err)
+	}
+
+	log.Info("upload created", slog.Int64("file_id", id), slog.Int("chunks", chunkCount))
+
+	file.Meta = meta
+
+	return file, nil
+}
+
+// UploadFile saves chunks returned by recv until it returns io.EOF and returns the file with them counted.
+// All chunks must belong to one file of the user and come in order starting from the first chunk not uploaded yet,
+// so an interrupted upload is continued from GetUpload. The checksum of every chunk is checked before it is saved.
+func (d *Data) UploadFile(ctx context.Context, userID int64, recv func() (models.FileChunk, error)) (models.File, error) {
+	log := d.log.With(
+		slog.String("method", "UploadFile"),
+		slog.Int64("user_id", userID),
+	)
+
+	cr, err := d.keys.UserCrypt(ctx, userID)
+	if err != nil {
+		log.Error("failed to create crypt", sl.Err(err))
+		return models.File{}, fmt.Errorf("failed to create crypt: %w", err)
+	}
+
+	var file models.File
+
+	for {
+		chunk, err := recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return models.File{}, err
+		}
+
+		if file.ID != 0 && chunk.FileID != file.ID {
+			return models.File{}, ErrUnexpectedChunk
+		}
+
+		sum := sha256.Sum256(chunk.Data)
+		if !bytes.Equal(sum[:], chunk.Checksum) {
+			log.Warn("chunk checksum mismatch", slog.Int64("file_id", chunk.FileID), slog.Int("index", chunk.Index))
+
+			return models.File{}, ErrChecksumMismatch
+		}
+
+		// the chunk is already encrypted by the client, it is additionally encrypted with the key of the user
+		sealed, err := cr.SealBytes(chunk.Data, chunkAD(userID, chunk.FileID, chunk.Index))
+		if err != nil {
+			log.Error("failed to encode chunk", sl.Err(err))
+			return models.File{}, fmt.Errorf("failed to encode chunk: %w", err)
+		}
+
+		// the chunk is put to the blob store before it is counted, so a counted chunk is always there
+		key := blobKey(chunk.FileID, chunk.Index, chunk.Checksum)
+		if err := d.blobs.Put(ctx, key, sealed); err != nil {
+			log.Error("failed to put chunk", sl.Err(err))
+			return models.File{}, fmt.Errorf("failed to put chunk: %w", err)
+		}
+
+		chunk.Data = nil
+
+		file, err = d.files.SaveFileChunk(ctx, userID, chunk, time.Now())
+		if err != nil {
+			if errors.Is(err, storage.ErrFileNotFound) {
+				log.Warn("file not found", slog.Int64("file_id", chunk.FileID))
+
+				d.deleteBlob(ctx, log, key)
+
+				return models.File{}, ErrFileNotFound
+			}
+
+			if errors.Is(err, storage.ErrChunkOutOfOrder) {
+				log.Warn("chunk out of order", slog.Int64("file_id", chunk.FileID), slog.Int("index", chunk.Index),
+					slog.Int("uploaded", file.UploadedChunks))
+
+				// a chunk uploaded before has the same key only if its content is the same, so it is kept
+				if chunk.Index >= file.UploadedChunks {
+					d.deleteBlob(ctx, log, key)
+				}
+
+				return models.File{}, ErrUnexpectedChunk
+			}
+
+			log.Error("failed to save chunk", sl.Err(err))
+
+			return models.File{}, fmt.Errorf("failed to save chunk: %w", err)
+		}
+	}
+
+	if file.ID == 0 {
+		return models.File{}, ErrEmptyUpload
+	}
+
+	if file.Completed() {
+		log.Info("upload completed", slog.Int64("file_id", file.ID))
+	}
+
+	file.Meta = ""
+
+	return file, nil
+}
+
+// GetUpload returns the file of the user with number of chunks uploaded so far
+func (d *Data) GetUpload(ctx context.Context, userID, fileID int64) (models.File, error) {
+	log := d.log.With(
+		slog.String("method", "GetUpload"),
+		slog.Int64("user_id", userID),
+		slog.Int64("file_id", fileID),
+	)
+
+	file, err := d.files.File(ctx, userID, fileID)
+	if err != nil {
+		if errors.Is(err, storage.ErrFileNotFound) {
+			log.Warn("file not found")
+
+			return models.File{}, ErrFileNotFound
+		}
+
+		log.Error("failed to get file", sl.Err(err))
+
+		return models.File{}, fmt.Errorf("failed to get file: %w", err)
+	}
+
+	cr, err := d.keys.UserCrypt(ctx, userID)
+	if err != nil {
+		log.Error("failed to create crypt", sl.Err(err))
+		return models.File{}, fmt.Errorf("failed to create crypt: %w", err)
+	}
+
+	files := []models.File{file}
+	if err := openFilesMeta(cr, userID, files); err != nil {
+		log.Error("failed to decode meta", sl.Err(err))
+		return models.File{}, err
+	}
+
+	return files[0], nil
+}
+
+// DownloadFile passes chunks of the completed file of the user to send in order starting from the chunk from
+func (d *Data) DownloadFile(
+	ctx context.Context,
+	userID, fileID int64,
+	from int,
+	send func(models.FileChunk) error,
+) error {
+	log := d.log.With(
+		slog.String("method", "DownloadFile"),
+		slog.Int64("user_id", userID),
+		slog.Int64("file_id", fileID),
+	)
+
+	file, err := d.files.File(ctx, userID, fileID)
+	if err != nil {
+		if errors.Is(err, storage.ErrFileNotFound) {
+			log.Warn("file not found")
+
+			return ErrFileNotFound
+		}
+
+		log.Error("failed to get file", sl.Err(err))
+
+		return fmt.Errorf("failed to get file: %w", err)
+	}
+
+	if !file.Completed() {
+		return ErrUploadIncomplete
+	}
+
+	cr, err := d.keys.UserCrypt(ctx, userID)
+	if err != nil {
+		log.Error("failed to create crypt", sl.Err(err))
+		return fmt.Errorf("failed to create crypt: %w", err)
+	}
+
+	// chunks are read one by one, so the whole file is never kept in memory
+	for i := from; i < file.ChunkCount; i++ {
+		chunk, err := d.files.FileChunk(ctx, fileID, i)
+		if err != nil {
+			log.Error("failed to get chunk", slog.Int("index", i), sl.Err(err))
+			return fmt.Errorf("failed to get chunk %d: %w", i, err)
+		}
+
+		// chunks uploaded before the blob store was added are kept in the database
+		if chunk.Data == nil {
+			chunk.Data, err = d.blobs.Get(ctx, blobKey(fileID, i, chunk.Checksum))
+			if err != nil {
+				log.Error("failed to get chunk blob", slog.Int("index", i), sl.Err(err))
+				return fmt.Errorf("failed to get chunk %d: %w", i, err)
+			}
+		}
+
+		chunk.Data, err = cr.OpenBytes(chunk.Data, chunkAD(userID, fileID, i))
+		if err != nil {
+			// the chunk was modified or moved from another file
+			log.Error("failed to decode chunk", slog.Int("index", i), sl.Err(err))
+			return fmt.Errorf("%w: file %d chunk %d: %v", ErrDataIntegrity, fileID, i, err)
+		}
+
+		if err := send(chunk); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// CollectOrphanBlobs deletes chunks of files deleted with their records or accounts
+// from the blob store and returns the number of deleted chunks
+func (d *Data) CollectOrphanBlobs(ctx context.Context) (int, error) {
+	log := d.log.With(
+		slog.String("method", "CollectOrphanBlobs"),
+	)
+
+	keys, err := d.blobs.List(ctx, blobPrefix)
+	if err != nil {
+		log.Error("failed to list blobs", sl.Err(err))
+		return 0, fmt.Errorf("failed to list blobs: %w", err)
+	}
+
+	keysByFile := make(map[int64][]string)
+	for _, key := range keys {
+		fileID, ok := blobFileID(key)
+		if !ok {
+			log.Warn("unexpected blob key", slog.String("key", key))
+			continue
+		}
+
+		keysByFile[fileID] = append(keysByFile[fileID], key)
+	}
+
+	if len(keysByFile) == 0 {
+		return 0, nil
+	}
+
+	ids := make([]int64, 0, len(keysByFile))
+	for id := range keysByFile {
+		ids = append(ids, id)
+	}
+
+	// files are never restored, so the blobs listed before the check stay orphans
+	existing, err := d.files.ExistingFiles(ctx, ids)
+	if err != nil {
+		log.Error("failed to check files", sl.Err(err))
+		return 0, fmt.Errorf("failed to check files: %w", err)
+	}
+
+	var deleted int
+
+	for id, keys := range keysByFile {
+		if existing[id] {
+			continue
+		}
+
+		for _, key := range keys {
+			if err := d.blobs.Delete(ctx, key); err != nil {
+				log.Error("failed to delete blob", slog.String("key", key), sl.Err(err))
+				return deleted, fmt.Errorf("failed to delete blob: %w", err)
+			}
+
+			deleted++
+		}
+	}
+
+	return deleted, nil
+}
+
+// RunBlobGC collects orphan blobs every interval and after records are deleted until ctx is done
+func (d *Data) RunBlobGC(ctx context.Context, interval time.Duration) {
+	log := d.log.With(
+		slog.String("method", "RunBlobGC"),
+	)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-d.gcWake:
+		}
+
+		deleted, err := d.CollectOrphanBlobs(ctx)
+		if err != nil {
+			continue
+		}
+
+		if deleted > 0 {
+			log.Info("orphan blobs deleted", slog.Int("deleted", deleted))
+		}
+	}
+}
+
+// wakeBlobGC asks RunBlobGC to collect orphan blobs, a request made while one is pending is dropped
+func (d *Data) wakeBlobGC() {
+	select {
+	case d.gcWake <- struct{}{}:
+	default:
+	}
+}
+
+// deleteBlob deletes the blob of a chunk that was not saved, a failure leaves it to the next upload of the chunk
+// or to the garbage collection after the file is deleted
+func (d *Data) deleteBlob(ctx context.Context, log *slog.Logger, key string) {
+	if err := d.blobs.Delete(ctx, key); err != nil {
+		log.Error("failed to delete blob", slog.String("key", key), sl.Err(err))
+	}
+}
+
+// blobKey returns key of the chunk in the blob store. The checksum of the content keeps concurrent uploads
+// of different content of the same chunk from overwriting each other.
+func blobKey(fileID int64, index int, checksum []byte) string {
+	return fmt.Sprintf("%s%d/%d-%s", blobPrefix, fileID, index, hex.EncodeToString(checksum))
+}
+
+// blobFileID returns ID of the file of the chunk with the key
+func blobFileID(key string) (int64, bool) {
+	rest, ok := strings.CutPrefix(key, blobPrefix)
+	if !ok {
+		return 0, false
+	}
+
+	id, _, ok := strings.Cut(rest, "/")
+	if !ok {
+		return 0, false
+	}
+
+	fileID, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+
+	return fileID, true
+}
+
+// openFilesMeta decrypts meta of the files of the user sealed with fileAD
+func openFilesMeta(cr crypt.Crypter, userID int64, files []models.File) error {
+	for i := range files {
+		meta, err := cr.Open(files[i].Meta, fileAD(userID, files[i].RecordID, files[i].ID))
+		if err != nil {
+			return fmt.Errorf("%w: file %d: %v", ErrDataIntegrity, files[i].ID, err)
+		}
+
+		files[i].Meta = meta
+	}
+
+	return nil
+}
+
+// fileAD returns associated data binding encrypted meta of the file to its owner, record and ID
+func fileAD(userID, recordID, id int64) []byte {
+	ad := make([]byte, 25)
+	ad[0] = 'f'
+	binary.BigEndian.PutUint64(ad[1:9], uint64(userID))
+	binary.BigEndian.PutUint64(ad[9:17], uint64(recordID))
+	binary.BigEndian.PutUint64(ad[17:], uint64(id))
+
+	return ad
+}
+
+// chunkAD returns associated data binding encrypted chunk to its owner, file and position in the file
+func chunkAD(userID, fileID int64, index int) []byte {
+	ad := make([]byte, 21)
+	ad[0] = 'c'
+	binary.BigEndian.PutUint64(ad[1:9], uint64(userID))
+	binary.BigEndian.PutUint64(ad[9:17], uint64(fileID))
+	binary.BigEndian.PutUint32(ad[17:], uint32(index))
+
+	return ad
+}
@@ -0,0 +1,124 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"io"
+	"log/slog"
+	"testing"
+
+	"github.com/nglmq/password-keeper/internal/domain/models"
+	"github.com/nglmq/password-keeper/internal/lib/crypt"
+	"github.com/nglmq/password-keeper/internal/storage/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// chunks returns recv of UploadFile passing the chunks of the file
+func chunks(fileID int64, from int, data ...[]byte) func() (models.FileChunk, error) {
+	i := 0
+
+	return func() (models.FileChunk, error) {
+		if i == len(data) {
+			return models.FileChunk{}, io.EOF
+		}
+
+		sum := sha256.Sum256(data[i])
+		chunk := models.FileChunk{FileID: fileID, Index: from + i, Data: data[i], Checksum: sum[:]}
+		i++
+
+		return chunk, nil
+	}
+}
+
+func TestData_Files(t *testing.T) {
+	ctx := context.Background()
+	st := memory.New()
+
+	user, err := st.SaveUser(ctx, "user@example.com", models.SRPVerifier{})
+	require.NoError(t, err)
+
+	other, err := st.SaveUser(ctx, "other@example.com", models.SRPVerifier{})
+	require.NoError(t, err)
+
+	masterKey, err := crypt.GenerateKey()
+	require.NoError(t, err)
+
+	keys, err := NewKeys(masterKey, nil, st)
+	require.NoError(t, err)
+
+	blobs := st.Blobs()
+	d := NewData(slog.New(slog.NewTextHandler(io.Discard, nil)), keys, st, blobs)
+
+	id, reservation, err := d.ReserveDataID(ctx, user.ID)
+	require.NoError(t, err)
+
+	_, err = d.SaveData(ctx, other.ID, id, reservation, "binary", "content")
+	assert.ErrorIs(t, err, ErrInvalidReservation, "the id is reserved for another user")
+
+	record, err := d.SaveData(ctx, user.ID, id, reservation, "binary", "content")
+	require.NoError(t, err)
+	assert.Equal(t, id, record.ID)
+
+	_, err = d.CreateUpload(ctx, other.ID, record.ID, "meta", 2)
+	assert.ErrorIs(t, err, ErrRecordNotFound)
+
+	_, err = d.CreateUpload(ctx, user.ID, record.ID, "meta", MaxFileChunks+1)
+	assert.ErrorIs(t, err, ErrQuotaExceeded)
+
+	file, err := d.CreateUpload(ctx, user.ID, record.ID, "meta", 2)
+	require.NoError(t, err)
+	assert.Equal(t, "meta", file.Meta)
+
+	// the upload is interrupted after the first chunk
+	got, err := d.UploadFile(ctx, user.ID, chunks(file.ID, 0, []byte("first")))
+	require.NoError(t, err)
+	assert.Equal(t, 1, got.UploadedChunks)
+
+	err = d.DownloadFile(ctx, user.ID, file.ID, 0, func(models.FileChunk) error { return nil })
+	assert.ErrorIs(t, err, ErrUploadIncomplete)
+
+	got, err = d.GetUpload(ctx, user.ID, file.ID)
+	require.NoError(t, err)
+	assert.Equal(t, 1, got.UploadedChunks)
+	assert.Equal(t, "meta", got.Meta)
+
+	_, err = d.GetUpload(ctx, other.ID, file.ID)
+	assert.ErrorIs(t, err, ErrFileNotFound)
+
+	_, err = d.UploadFile(ctx, user.ID, chunks(file.ID, 0, []byte("first")))
+	assert.ErrorIs(t, err, ErrUnexpectedChunk)
+
+	_, err = d.UploadFile(ctx, user.ID, func() (models.FileChunk, error) {
+		return models.FileChunk{FileID: file.ID, Index: 1, Data: []byte("second"), Checksum: []byte("wrong")}, nil
+	})
+	assert.ErrorIs(t, err, ErrChecksumMismatch)
+
+	got, err = d.UploadFile(ctx, user.ID, chunks(file.ID, 1, []byte("second")))
+	require.NoError(t, err)
+	assert.True(t, got.Completed())
+
+	var downloaded []string
+
+	err = d.DownloadFile(ctx, user.ID, file.ID, 0, func(chunk models.FileChunk) error {
+		downloaded = append(downloaded, string(chunk.Data))
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"first", "second"}, downloaded)
+
+	keysBefore, err := blobs.List(ctx, blobPrefix)
+	require.NoError(t, err)
+	assert.Len(t, keysBefore, 2, "the repeated chunk is kept once")
+
+	// chunks of the deleted record are collected
+	require.NoError(t, d.DeleteData(ctx, user.ID, record.ID))
+
+	collected, err := d.CollectOrphanBlobs(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 2, collected)
+
+	keysAfter, err := blobs.List(ctx, blobPrefix)
+	require.NoError(t, err)
+	assert.Empty(t, keysAfter)
+}
@@ -0,0 +1,126 @@
+package auth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"github.com/nglmq/password-keeper/internal/lib/crypt"
+	"github.com/nglmq/password-keeper/internal/storage"
+)
+
+type KeySaver interface {
+	SaveUserKey(ctx context.Context, userID int64, wrappedKey string, keyID uint32) error
+}
+
+type KeyGetter interface {
+	UserKey(ctx context.Context, userID int64) (string, error)
+}
+
+// KeyStorage stores data keys of users wrapped with the master key
+type KeyStorage interface {
+	KeySaver
+	KeyGetter
+}
+
+// Keys gives access to data keys of users, the keys are shared by services encrypting users' secrets
+type Keys struct {
+	keySaver   KeySaver
+	keyGetter  KeyGetter
+	keyWrapper *crypt.KeyWrapper
+	saltKey    []byte
+	formKey    []byte
+	reserveKey []byte
+}
+
+// NewKeys returns a new instance of Keys, data keys of users are wrapped with masterKey.
+// Keys still wrapped with previousKeys can be read until they are rotated.
+func NewKeys(masterKey []byte, previousKeys [][]byte, keyStorage KeyStorage) (*Keys, error) {
+	keyWrapper, err := crypt.NewKeyWrapper(masterKey, previousKeys...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create key wrapper: %w", err)
+	}
+
+	return &Keys{
+		keySaver:   keyStorage,
+		keyGetter:  keyStorage,
+		keyWrapper: keyWrapper,
+		saltKey:    mac(masterKey, []byte("srp fake salt")),
+		formKey:    mac(masterKey, []byte("srp fake form")),
+		reserveKey: mac(masterKey, []byte("record id reservation")),
+	}, nil
+}
+
+// FakeSalt returns salt of size n for SRP login of the unknown email, it is the same on every attempt,
+// so unknown emails can not be told from registered ones by changing salts
+func (k *Keys) FakeSalt(email string, n int) []byte {
+	return mac(k.saltKey, []byte(email))[:n]
+}
+
+// FakeChoice returns one of n forms of the fake SRP login of the unknown email for the kind of choice,
+// it is the same on every attempt as FakeSalt is
+func (k *Keys) FakeChoice(email, kind string, n int) int {
+	sum := mac(k.formKey, []byte(kind+"\x00"+email))
+
+	return int(binary.BigEndian.Uint32(sum) % uint32(n))
+}
+
+// Reservation returns proof that the record ID was reserved for the user, the server does not keep reservations
+func (k *Keys) Reservation(userID, id int64) string {
+	msg := make([]byte, 16)
+	binary.BigEndian.PutUint64(msg, uint64(userID))
+	binary.BigEndian.PutUint64(msg[8:], uint64(id))
+
+	return hex.EncodeToString(mac(k.reserveKey, msg))
+}
+
+// ValidReservation reports whether the reservation was returned by Reservation for the user and ID
+func (k *Keys) ValidReservation(userID, id int64, reservation string) bool {
+	return hmac.Equal([]byte(k.Reservation(userID, id)), []byte(reservation))
+}
+
+func mac(key, msg []byte) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write(msg)
+
+	return h.Sum(nil)
+}
+
+// UserCrypt returns crypt with the data key of the user, the key is generated on first use
+func (k *Keys) UserCrypt(ctx context.Context, userID int64) (crypt.Crypter, error) {
+	wrappedKey, err := k.keyGetter.UserKey(ctx, userID)
+	if errors.Is(err, storage.ErrKeyNotFound) {
+		var dataKey []byte
+
+		dataKey, err = crypt.GenerateKey()
+		if err != nil {
+			return nil, err
+		}
+
+		wrappedKey, err = k.keyWrapper.Wrap(dataKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to wrap data key: %w", err)
+		}
+
+		if err = k.keySaver.SaveUserKey(ctx, userID, wrappedKey, k.keyWrapper.KeyID()); err != nil {
+			return nil, fmt.Errorf("failed to save data key: %w", err)
+		}
+
+		// concurrent request could save its own key first, so read back the stored one
+		wrappedKey, err = k.keyGetter.UserKey(ctx, userID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get data key: %w", err)
+	}
+
+	dataKey, err := k.keyWrapper.Unwrap(wrappedKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap data key: %w", err)
+	}
+
+	return crypt.NewCrypt(dataKey)
+}
@@ -0,0 +1,174 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/nglmq/password-keeper/internal/domain/models"
+	"github.com/nglmq/password-keeper/internal/lib/logger/sl"
+)
+
+var (
+	// ErrTooManyAttempts is wrapped by LimitError when attempts are delayed
+	ErrTooManyAttempts = errors.New("too many login attempts")
+	// ErrAccountLocked is wrapped by LimitError when the account is locked after too many failures
+	ErrAccountLocked = errors.New("account temporarily locked")
+)
+
+// LimitError is returned by Login when the attempt is rejected without checking the password
+type LimitError struct {
+	RetryAfter time.Duration
+	Locked     bool
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("%v, retry after %v", e.Unwrap(), e.RetryAfter)
+}
+
+func (e *LimitError) Unwrap() error {
+	if e.Locked {
+		return ErrAccountLocked
+	}
+
+	return ErrTooManyAttempts
+}
+
+// LoginLimits configures delays and lockouts after failed logins, see config.LoginLimits
+type LoginLimits struct {
+	FreeAttempts    int
+	BaseDelay       time.Duration
+	MaxDelay        time.Duration
+	MaxFailures     int
+	IPMaxFailures   int
+	LockoutDuration time.Duration
+	ResetAfter      time.Duration
+}
+
+type AttemptStorage interface {
+	LoginAttempts(ctx context.Context, key string) (models.LoginAttempts, error)
+	RecordLoginFailure(ctx context.Context, key string, resetBefore time.Time) (int, error)
+	BlockLogin(ctx context.Context, key string, until time.Time) error
+	ResetLoginAttempts(ctx context.Context, key string) error
+	DeleteStaleLoginAttempts(ctx context.Context, before time.Time) (int64, error)
+}
+
+func emailKey(email string) string {
+	return "email:" + email
+}
+
+func ipKey(ip string) string {
+	return "ip:" + ip
+}
+
+// checkLoginAllowed returns LimitError if logins of the email or from the client IP are blocked
+func (a *Auth) checkLoginAllowed(ctx context.Context, email, clientIP string) error {
+	now := time.Now()
+
+	byEmail, err := a.attempts.LoginAttempts(ctx, emailKey(email))
+	if err != nil {
+		return fmt.Errorf("failed to get login attempts: %w", err)
+	}
+
+	if now.Before(byEmail.BlockedUntil) {
+		return &LimitError{
+			RetryAfter: byEmail.BlockedUntil.Sub(now),
+			Locked:     byEmail.Failures >= a.limits.MaxFailures,
+		}
+	}
+
+	if clientIP == "" {
+		return nil
+	}
+
+	byIP, err := a.attempts.LoginAttempts(ctx, ipKey(clientIP))
+	if err != nil {
+		return fmt.Errorf("failed to get login attempts: %w", err)
+	}
+
+	if now.Before(byIP.BlockedUntil) {
+		return &LimitError{RetryAfter: byIP.BlockedUntil.Sub(now)}
+	}
+
+	return nil
+}
+
+// recordLoginFailure counts the failure and blocks next attempts of the email and the client IP if needed,
+// empty email or clientIP is skipped
+func (a *Auth) recordLoginFailure(ctx context.Context, email, clientIP string) {
+	log := a.log.With(
+		slog.String("method", "recordLoginFailure"),
+		slog.String("email", email),
+		slog.String("ip", clientIP),
+	)
+
+	now := time.Now()
+	resetBefore := now.Add(-a.limits.ResetAfter)
+
+	// email is unknown when SRP login fails on an unknown session
+	if email != "" {
+		a.recordEmailFailure(ctx, log, email, now, resetBefore)
+	}
+
+	if clientIP == "" {
+		return
+	}
+
+	failures, err := a.attempts.RecordLoginFailure(ctx, ipKey(clientIP), resetBefore)
+	if err != nil {
+		log.Error("failed to record login failure", sl.Err(err))
+	} else if failures >= a.limits.IPMaxFailures {
+		log.Warn("client ip blocked", slog.Int("failures", failures))
+
+		if err := a.attempts.BlockLogin(ctx, ipKey(clientIP), now.Add(a.limits.LockoutDuration)); err != nil {
+			log.Error("failed to block login", sl.Err(err))
+		}
+	}
+}
+
+func (a *Auth) recordEmailFailure(ctx context.Context, log *slog.Logger, email string, now, resetBefore time.Time) {
+	failures, err := a.attempts.RecordLoginFailure(ctx, emailKey(email), resetBefore)
+	if err != nil {
+		log.Error("failed to record login failure", sl.Err(err))
+
+		return
+	}
+
+	if delay := a.limits.emailDelay(failures); delay > 0 {
+		if failures >= a.limits.MaxFailures {
+			log.Warn("account locked", slog.Int("failures", failures))
+		}
+
+		if err := a.attempts.BlockLogin(ctx, emailKey(email), now.Add(delay)); err != nil {
+			log.Error("failed to block login", sl.Err(err))
+		}
+	}
+}
+
+// resetLoginFailures forgets failures of the email after successful login.
+// Failures of the client IP are kept, so an attacker can not reset them by logging in to own account.
+func (a *Auth) resetLoginFailures(ctx context.Context, email string) {
+	if err := a.attempts.ResetLoginAttempts(ctx, emailKey(email)); err != nil {
+		a.log.Error("failed to reset login attempts", slog.String("email", email), sl.Err(err))
+	}
+}
+
+// emailDelay returns how long next attempts are blocked after the given number of failures in a row
+func (l LoginLimits) emailDelay(failures int) time.Duration {
+	if failures >= l.MaxFailures {
+		return l.LockoutDuration
+	}
+
+	if failures <= l.FreeAttempts {
+		return 0
+	}
+
+	delay := l.BaseDelay
+	for i := l.FreeAttempts + 1; i < failures && delay < l.MaxDelay; i++ {
+		delay *= 2
+	}
+
+	return min(delay, l.MaxDelay)
+}
@@ -0,0 +1,38 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoginLimits_emailDelay(t *testing.T) {
+	limits := LoginLimits{
+		FreeAttempts:    3,
+		BaseDelay:       time.Second,
+		MaxDelay:        10 * time.Second,
+		MaxFailures:     10,
+		LockoutDuration: 15 * time.Minute,
+	}
+
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{1, 0},
+		{3, 0},
+		{4, time.Second},
+		{5, 2 * time.Second},
+		{6, 4 * time.Second},
+		{7, 8 * time.Second},
+		{8, 10 * time.Second},
+		{9, 10 * time.Second},
+		{10, 15 * time.Minute},
+		{25, 15 * time.Minute},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, limits.emailDelay(tt.failures), "failures %d", tt.failures)
+	}
+}
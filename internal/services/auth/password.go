@@ -0,0 +1,330 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/nglmq/password-keeper/internal/domain/models"
+	"github.com/nglmq/password-keeper/internal/lib/jwt"
+	"github.com/nglmq/password-keeper/internal/lib/logger/sl"
+	"github.com/nglmq/password-keeper/internal/lib/passhash"
+	"github.com/nglmq/password-keeper/internal/lib/password"
+	"github.com/nglmq/password-keeper/internal/lib/srp"
+	"github.com/nglmq/password-keeper/internal/lib/vault"
+	"github.com/nglmq/password-keeper/internal/storage"
+)
+
+type PasswordStorage interface {
+	MigrateToVerifier(ctx context.Context, userID int64, oldHash []byte, verifier models.SRPVerifier) error
+	UpgradeVerifier(ctx context.Context, userID int64, oldVerifier []byte, verifier models.SRPVerifier) error
+	ChangePassword(
+		ctx context.Context,
+		userID int64,
+		verifier models.SRPVerifier,
+		vaultSalt []byte,
+		records []models.Data,
+		validAfter time.Time,
+	) error
+}
+
+// PasswordSettings returns the policy the client checks new passwords against and Argon2id params
+// it derives keys of new verifiers with
+func (a *Auth) PasswordSettings(_ context.Context) models.PasswordSettings {
+	return models.PasswordSettings{
+		Params:       a.hasher.Params().String(),
+		MinLength:    a.policy.MinLength,
+		MinScore:     a.policy.MinScore,
+		RejectCommon: a.policy.RejectCommon,
+	}
+}
+
+// ChangePassword changes password of the user to the one of verifier computed by the client. The old password
+// is proved with client proof M1 of the session the client started with LoginStart, server proof M2 is returned.
+// The new password is checked against the policy with the report the client sent.
+// Records are end-to-end encrypted with the key derived from the password, so the client sends all of them
+// re-encrypted with the key derived from the new password and vaultSalt. Verifier, salt and records are replaced
+// at once, all sessions of the user are revoked and tokens of a new session are returned.
+func (a *Auth) ChangePassword(
+	ctx context.Context,
+	userID int64,
+	session string,
+	clientProof []byte,
+	verifier models.SRPVerifier,
+	report password.Report,
+	vaultSalt []byte,
+	records []models.Data,
+) (models.TokenPair, []byte, error) {
+	log := a.log.With(
+		slog.String("method", "ChangePassword"),
+		slog.Int64("user_id", userID),
+	)
+
+	user, serverProof, err := a.verifySRPProof(ctx, log, session, clientProof, "")
+	if err != nil {
+		return models.TokenPair{}, nil, err
+	}
+
+	if user.ID != userID {
+		log.Warn("srp session of another user", slog.Int64("session_user_id", user.ID))
+
+		return models.TokenPair{}, nil, ErrInvalidCredentials
+	}
+
+	if err := a.checkPasswordReport(report); err != nil {
+		log.Info("new password rejected by policy", sl.Err(err))
+
+		return models.TokenPair{}, nil, err
+	}
+
+	if err := a.checkVerifier(verifier); err != nil {
+		log.Info("invalid verifier", sl.Err(err))
+
+		return models.TokenPair{}, nil, err
+	}
+
+	if len(vaultSalt) != vault.SaltSize {
+		return models.TokenPair{}, nil, ErrInvalidVaultSalt
+	}
+
+	sealed, err := a.sealRekeyedRecords(ctx, userID, records)
+	if err != nil {
+		if !errors.Is(err, ErrVaultChanged) {
+			log.Error("failed to seal records", sl.Err(err))
+		}
+
+		return models.TokenPair{}, nil, err
+	}
+
+	// tokens of the new session are issued after it, so they stay valid
+	validAfter := jwt.RevocationTime()
+
+	err = a.passwords.ChangePassword(ctx, userID, verifier, vaultSalt, sealed, validAfter)
+	if err != nil {
+		if errors.Is(err, storage.ErrDataChanged) {
+			log.Info("records changed during password change")
+
+			return models.TokenPair{}, nil, ErrVaultChanged
+		}
+
+		log.Error("failed to change password", sl.Err(err))
+
+		return models.TokenPair{}, nil, fmt.Errorf("failed to change password: %w", err)
+	}
+
+	log.Info("password changed, sessions revoked")
+
+	tokens, err := a.newTokenPair(ctx, user, "")
+	if err != nil {
+		log.Error("failed to generate tokens", sl.Err(err))
+
+		return models.TokenPair{}, nil, err
+	}
+
+	return tokens, serverProof, nil
+}
+
+// checkPasswordReport returns PolicyError if the report of the new password does not meet the policy.
+// The password never reaches the server, so it relies on the check the client made.
+func (a *Auth) checkPasswordReport(report password.Report) error {
+	if violations := a.policy.CheckReport(report); len(violations) > 0 {
+		return &password.PolicyError{
+			Violations: violations,
+			Strength:   password.Strength{Score: report.Score, Common: report.Common},
+		}
+	}
+
+	return nil
+}
+
+// checkVerifier checks the verifier computed by the client: the salt has the size of generated salts,
+// the key is derived with params not weaker than the current ones and the verifier is an element of the group
+func (a *Auth) checkVerifier(verifier models.SRPVerifier) error {
+	if len(verifier.Salt) != passhash.SaltSize {
+		return fmt.Errorf("%w: salt must be %d bytes", ErrInvalidVerifier, passhash.SaltSize)
+	}
+
+	params, err := passhash.ParseParams(verifier.Params)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidVerifier, err)
+	}
+
+	if params.Weaker(a.hasher.Params()) {
+		return fmt.Errorf("%w: params %s are weaker than %s", ErrInvalidVerifier, params, a.hasher.Params())
+	}
+
+	if !srp.ValidVerifier(verifier.Verifier) {
+		return fmt.Errorf("%w: verifier is not an element of the group", ErrInvalidVerifier)
+	}
+
+	return nil
+}
+
+// newVerifier returns SRP verifier of the password to store, the key is derived with a new salt and current params.
+// Only legacy users send the password once to have their hash replaced, others send verifiers computed by the client.
+func (a *Auth) newVerifier(password string) (models.SRPVerifier, error) {
+	salt := make([]byte, passhash.SaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return models.SRPVerifier{}, fmt.Errorf("failed to generate salt: %w", err)
+	}
+
+	params := a.hasher.Params()
+	key := a.hasher.DeriveKey(password, salt, params)
+
+	return models.SRPVerifier{
+		Salt:     salt,
+		Params:   params.String(),
+		Verifier: srp.Verifier(srp.X(salt, key)),
+	}, nil
+}
+
+// verifyPassword checks the password of the user against the password hash and replaces the hash with SRP verifier,
+// ErrInvalidCredentials is returned if it is wrong. Users who have a verifier prove the password with SRP only,
+// so they are rejected without checking the password.
+func (a *Auth) verifyPassword(ctx context.Context, user models.User, password string) error {
+	if len(user.SRP.Verifier) > 0 {
+		return ErrInvalidCredentials
+	}
+
+	if _, err := a.hasher.Verify(password, string(user.PassHash)); err != nil {
+		if errors.Is(err, passhash.ErrMismatch) {
+			return ErrInvalidCredentials
+		}
+
+		return fmt.Errorf("failed to verify password: %w", err)
+	}
+
+	a.migrateToVerifier(ctx, user, password)
+
+	return nil
+}
+
+// migrateToVerifier replaces password hash of the user with SRP verifier,
+// failures only postpone the migration to the next login
+func (a *Auth) migrateToVerifier(ctx context.Context, user models.User, password string) {
+	log := a.log.With(
+		slog.String("method", "migrateToVerifier"),
+		slog.Int64("user_id", user.ID),
+	)
+
+	verifier, err := a.newVerifier(password)
+	if err != nil {
+		log.Error("failed to create verifier", sl.Err(err))
+
+		return
+	}
+
+	// the hash is replaced only if the password was not changed meanwhile
+	if err := a.passwords.MigrateToVerifier(ctx, user.ID, user.PassHash, verifier); err != nil {
+		log.Error("failed to save verifier", sl.Err(err))
+
+		return
+	}
+
+	log.Info("password hash replaced with verifier")
+}
+
+// verifierStale reports whether the verifier of the user is derived with params weaker than current ones
+func (a *Auth) verifierStale(user models.User) bool {
+	if len(user.SRP.Verifier) == 0 {
+		return false
+	}
+
+	params, err := passhash.ParseParams(user.SRP.Params)
+
+	return err == nil && params.Weaker(a.hasher.Params())
+}
+
+// upgradeVerifier replaces the verifier derived with stale params,
+// failures only postpone the upgrade to the next login
+func (a *Auth) upgradeVerifier(ctx context.Context, user models.User, verifier models.SRPVerifier) {
+	log := a.log.With(
+		slog.String("method", "upgradeVerifier"),
+		slog.Int64("user_id", user.ID),
+	)
+
+	// the verifier is replaced only if the password was not changed meanwhile
+	if err := a.passwords.UpgradeVerifier(ctx, user.ID, user.SRP.Verifier, verifier); err != nil {
+		log.Error("failed to save verifier", sl.Err(err))
+
+		return
+	}
+
+	log.Info("verifier upgraded", slog.String("from", user.SRP.Params), slog.String("to", verifier.Params))
+}
+
+// sealRekeyedRecords checks the client sent every record of the user once and encrypts them for storage.
+// Types of records are taken from the storage, the client can change only the content and meta of files.
+func (a *Auth) sealRekeyedRecords(ctx context.Context, userID int64, records []models.Data) ([]models.Data, error) {
+	stored, err := a.dataGetter.GetData(ctx, userID)
+	if err != nil && !errors.Is(err, storage.ErrDataNotFound) {
+		return nil, fmt.Errorf("failed to get data: %w", err)
+	}
+
+	if len(stored) != len(records) {
+		return nil, ErrVaultChanged
+	}
+
+	types := make(map[int64]string, len(stored))
+	files := make(map[int64]int, len(stored))
+	for _, d := range stored {
+		types[d.ID] = d.DataType
+		files[d.ID] = len(d.Files)
+	}
+
+	cr, err := a.keys.UserCrypt(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create crypt: %w", err)
+	}
+
+	sealed := make([]models.Data, 0, len(records))
+	seenFiles := make(map[int64]bool)
+
+	for _, record := range records {
+		dataType, ok := types[record.ID]
+		if !ok {
+			// unknown or duplicated record
+			return nil, ErrVaultChanged
+		}
+
+		delete(types, record.ID)
+
+		content, err := cr.Seal(record.Content, recordAD(userID, record.ID, dataType))
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode data: %w", err)
+		}
+
+		// files keep their content, only their meta with the file keys is encrypted with the vault key
+		if len(record.Files) != files[record.ID] {
+			return nil, ErrVaultChanged
+		}
+
+		sealedFiles := make([]models.File, 0, len(record.Files))
+		for _, file := range record.Files {
+			if seenFiles[file.ID] {
+				return nil, ErrVaultChanged
+			}
+
+			seenFiles[file.ID] = true
+
+			meta, err := cr.Seal(file.Meta, fileAD(userID, record.ID, file.ID))
+			if err != nil {
+				return nil, fmt.Errorf("failed to encode meta: %w", err)
+			}
+
+			sealedFiles = append(sealedFiles, models.File{ID: file.ID, RecordID: record.ID, Meta: meta})
+		}
+
+		sealed = append(sealed, models.Data{
+			ID:       record.ID,
+			DataType: dataType,
+			Content:  content,
+			Files:    sealedFiles,
+		})
+	}
+
+	return sealed, nil
+}
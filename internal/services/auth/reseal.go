@@ -0,0 +1,137 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/nglmq/password-keeper/internal/domain/models"
+	"github.com/nglmq/password-keeper/internal/lib/crypt"
+	"github.com/nglmq/password-keeper/internal/lib/logger/sl"
+	"github.com/nglmq/password-keeper/internal/storage"
+)
+
+// ResealStorage gives access to records sealed before they were bound to their owner, ID and type
+type ResealStorage interface {
+	LegacyRecords(ctx context.Context, afterID int64, limit int) ([]models.LegacyRecord, error)
+	ResealRecord(ctx context.Context, userID, id int64, content string) error
+}
+
+// Resealer seals legacy records again with the data key of their owner bound to the owner, ID and type,
+// the server does not return legacy records until they are resealed
+type Resealer struct {
+	log       *slog.Logger
+	keys      *Keys
+	records   ResealStorage
+	legacy    crypt.Crypter
+	batchSize int
+}
+
+// ResealResult is a summary of the reseal run
+type ResealResult struct {
+	Resealed int
+	Failed   int
+}
+
+// NewResealer returns a new instance of Resealer, legacy is the crypt records were sealed with
+// before per-user keys, it is nil when there are no such records
+func NewResealer(log *slog.Logger, keys *Keys, records ResealStorage, legacy crypt.Crypter, batchSize int) *Resealer {
+	return &Resealer{
+		log:       log,
+		keys:      keys,
+		records:   records,
+		legacy:    legacy,
+		batchSize: batchSize,
+	}
+}
+
+// Run reseals legacy records in batches. Every record is updated on its own, so an interrupted run
+// can be started again and continues with the records which are not resealed yet.
+func (r *Resealer) Run(ctx context.Context) (ResealResult, error) {
+	log := r.log.With(slog.String("method", "Reseal"))
+
+	log.Info("resealing legacy records")
+
+	var (
+		res    ResealResult
+		cursor int64
+	)
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return res, err
+		}
+
+		records, err := r.records.LegacyRecords(ctx, cursor, r.batchSize)
+		if err != nil {
+			log.Error("failed to get records", sl.Err(err))
+
+			return res, fmt.Errorf("failed to get records: %w", err)
+		}
+
+		if len(records) == 0 {
+			break
+		}
+
+		for _, record := range records {
+			cursor = record.ID
+
+			if err := r.reseal(ctx, record); err != nil {
+				// records which cannot be opened stay legacy and are left for the next run
+				log.Error("failed to reseal record", slog.Int64("id", record.ID), sl.Err(err))
+				res.Failed++
+
+				continue
+			}
+
+			res.Resealed++
+		}
+
+		log.Info("batch done", slog.Int("resealed", res.Resealed), slog.Int("failed", res.Failed))
+	}
+
+	return res, nil
+}
+
+func (r *Resealer) reseal(ctx context.Context, record models.LegacyRecord) error {
+	cr, err := r.keys.UserCrypt(ctx, record.UserID)
+	if err != nil {
+		return fmt.Errorf("failed to create crypt: %w", err)
+	}
+
+	ad := recordAD(record.UserID, record.ID, record.DataType)
+
+	content, err := r.open(cr, record.Content, ad)
+	if err != nil {
+		return err
+	}
+
+	sealed, err := cr.Seal(content, ad)
+	if err != nil {
+		return fmt.Errorf("failed to seal record: %w", err)
+	}
+
+	err = r.records.ResealRecord(ctx, record.UserID, record.ID, sealed)
+	if errors.Is(err, storage.ErrDataNotFound) {
+		// record was deleted, updated or resealed concurrently
+		return nil
+	}
+
+	return err
+}
+
+// open returns content of the legacy record, it is tried as the bound envelope first,
+// since the record could be updated by the server which marked it before
+func (r *Resealer) open(cr crypt.Crypter, content string, ad []byte) (string, error) {
+	if plain, err := cr.Open(content, ad); err == nil {
+		return plain, nil
+	}
+
+	plain, err := cr.OpenLegacy(content)
+	if err == nil || r.legacy == nil {
+		return plain, err
+	}
+
+	return r.legacy.OpenLegacy(content)
+}
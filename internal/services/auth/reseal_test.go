@@ -0,0 +1,115 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"sort"
+	"testing"
+
+	"github.com/nglmq/password-keeper/internal/domain/models"
+	"github.com/nglmq/password-keeper/internal/lib/crypt"
+	"github.com/nglmq/password-keeper/internal/storage"
+	"github.com/nglmq/password-keeper/internal/storage/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeResealStorage struct {
+	records map[int64]models.LegacyRecord
+	sealed  map[int64]string
+}
+
+func (f *fakeResealStorage) LegacyRecords(_ context.Context, afterID int64, limit int) ([]models.LegacyRecord, error) {
+	var res []models.LegacyRecord
+	for _, r := range f.records {
+		if r.ID > afterID {
+			res = append(res, r)
+		}
+	}
+
+	sort.Slice(res, func(i, j int) bool { return res[i].ID < res[j].ID })
+
+	if len(res) > limit {
+		res = res[:limit]
+	}
+
+	return res, nil
+}
+
+func (f *fakeResealStorage) ResealRecord(_ context.Context, userID, id int64, content string) error {
+	r, ok := f.records[id]
+	if !ok || r.UserID != userID {
+		return storage.ErrDataNotFound
+	}
+
+	delete(f.records, id)
+	f.sealed[id] = content
+
+	return nil
+}
+
+// legacyCrypt opens records sealed with the key compiled into older versions
+type legacyCrypt struct {
+	crypt.Crypter
+	content map[string]string
+}
+
+func (c legacyCrypt) OpenLegacy(sha string) (string, error) {
+	content, ok := c.content[sha]
+	if !ok {
+		return "", errors.New("not a legacy record")
+	}
+
+	return content, nil
+}
+
+func TestResealer_Run(t *testing.T) {
+	ctx := context.Background()
+	st := memory.New()
+
+	user, err := st.SaveUser(ctx, "user@example.com", models.SRPVerifier{})
+	require.NoError(t, err)
+
+	masterKey, err := crypt.GenerateKey()
+	require.NoError(t, err)
+
+	keys, err := NewKeys(masterKey, nil, st)
+	require.NoError(t, err)
+
+	cr, err := keys.UserCrypt(ctx, user.ID)
+	require.NoError(t, err)
+
+	bound, err := cr.Seal("bound", recordAD(user.ID, 1, "text"))
+	require.NoError(t, err)
+
+	// sealed for another record, so it is neither bound to this one nor legacy
+	moved, err := cr.Seal("moved", recordAD(user.ID, 4, "text"))
+	require.NoError(t, err)
+
+	records := &fakeResealStorage{
+		records: map[int64]models.LegacyRecord{
+			1: {ID: 1, UserID: user.ID, DataType: "text", Content: bound},
+			2: {ID: 2, UserID: user.ID, DataType: "text", Content: "old"},
+			3: {ID: 3, UserID: user.ID, DataType: "text", Content: moved},
+		},
+		sealed: map[int64]string{},
+	}
+
+	legacy := legacyCrypt{content: map[string]string{"old": "legacy"}}
+
+	res, err := NewResealer(slog.New(slog.NewTextHandler(io.Discard, nil)), keys, records, legacy, 2).Run(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, ResealResult{Resealed: 2, Failed: 1}, res)
+
+	want := map[int64]string{1: "bound", 2: "legacy"}
+	for id, content := range want {
+		got, err := cr.Open(records.sealed[id], recordAD(user.ID, id, "text"))
+		require.NoError(t, err)
+		assert.Equal(t, content, got)
+	}
+
+	// the record which cannot be opened stays legacy for the next run
+	assert.Contains(t, records.records, int64(3))
+}
@@ -0,0 +1,290 @@
+package auth
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/nglmq/password-keeper/internal/domain/models"
+	"github.com/nglmq/password-keeper/internal/lib/jwt"
+	"github.com/nglmq/password-keeper/internal/lib/logger/sl"
+	"github.com/nglmq/password-keeper/internal/lib/passhash"
+	"github.com/nglmq/password-keeper/internal/lib/srp"
+	"github.com/nglmq/password-keeper/internal/storage"
+)
+
+// srpSessionTTL is the time the client has to send its proof after LoginStart
+const srpSessionTTL = time.Minute
+
+type SRPStorage interface {
+	SaveSRPSession(ctx context.Context, session models.SRPSession) error
+	UseSRPSession(ctx context.Context, hash string) (models.SRPSession, error)
+}
+
+// LoginStart starts SRP login of the email with client public value A, the password never leaves the client.
+// Unknown emails get a fake challenge and fail on LoginFinish as a wrong password does.
+// Users who still have a password hash get Legacy challenge, their first Login replaces the hash with a verifier.
+// Fake challenges take the legacy and stale forms as well, so the form does not tell whether the email is registered.
+func (a *Auth) LoginStart(ctx context.Context, email string, clientPublic []byte, clientIP string) (models.SRPChallenge, error) {
+	log := a.log.With(
+		slog.String("method", "LoginStart"),
+		slog.String("email", email),
+		slog.String("ip", clientIP),
+	)
+
+	if err := a.checkLoginAllowed(ctx, email, clientIP); err != nil {
+		log.Warn("login attempt rejected", sl.Err(err))
+
+		return models.SRPChallenge{}, err
+	}
+
+	user, err := a.userGetter.User(ctx, email)
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			log.Info("user not found")
+
+			return a.fakeSRPChallenge(email)
+		}
+
+		log.Error("failed to get user", sl.Err(err))
+
+		return models.SRPChallenge{}, fmt.Errorf("failed to get user: %w", err)
+	}
+
+	if len(user.SRP.Verifier) == 0 {
+		log.Info("user has no verifier yet")
+
+		return models.SRPChallenge{Legacy: true}, nil
+	}
+
+	server, err := srp.NewServer(user.Email, user.SRP.Salt, user.SRP.Verifier)
+	if err != nil {
+		log.Error("failed to start srp", sl.Err(err))
+
+		return models.SRPChallenge{}, fmt.Errorf("failed to start srp: %w", err)
+	}
+
+	session, hash, err := jwt.NewRefreshToken()
+	if err != nil {
+		log.Error("failed to generate session", sl.Err(err))
+
+		return models.SRPChallenge{}, err
+	}
+
+	cr, err := a.keys.UserCrypt(ctx, user.ID)
+	if err != nil {
+		log.Error("failed to create crypt", sl.Err(err))
+
+		return models.SRPChallenge{}, fmt.Errorf("failed to create crypt: %w", err)
+	}
+
+	secret, err := cr.Seal(base64.StdEncoding.EncodeToString(server.Secret()), srpSessionAD(user.ID, hash))
+	if err != nil {
+		log.Error("failed to encrypt server secret", sl.Err(err))
+
+		return models.SRPChallenge{}, fmt.Errorf("failed to encrypt server secret: %w", err)
+	}
+
+	err = a.srpSessions.SaveSRPSession(ctx, models.SRPSession{
+		Hash:         hash,
+		UserID:       user.ID,
+		ClientPublic: clientPublic,
+		ServerSecret: secret,
+		ExpiresAt:    time.Now().Add(srpSessionTTL),
+	})
+	if err != nil {
+		log.Error("failed to save srp session", sl.Err(err))
+
+		return models.SRPChallenge{}, fmt.Errorf("failed to save srp session: %w", err)
+	}
+
+	challenge := models.SRPChallenge{
+		Session:      session,
+		Salt:         user.SRP.Salt,
+		Params:       user.SRP.Params,
+		ServerPublic: server.Public(),
+	}
+
+	if a.verifierStale(user) {
+		challenge.UpgradeParams = a.hasher.Params().String()
+	}
+
+	return challenge, nil
+}
+
+// LoginFinish checks client proof M1 of the session started with LoginStart and completes login as Login does.
+// The result holds server proof M2, the client checks with it the server knows the verifier.
+// newVerifier is sent by the client asked for it with UpgradeParams of the challenge, it replaces the verifier
+// derived with stale params and is ignored otherwise.
+func (a *Auth) LoginFinish(
+	ctx context.Context,
+	session string,
+	clientProof []byte,
+	newVerifier *models.SRPVerifier,
+	clientIP string,
+) (models.LoginResult, error) {
+	log := a.log.With(
+		slog.String("method", "LoginFinish"),
+		slog.String("ip", clientIP),
+	)
+
+	user, serverProof, err := a.verifySRPProof(ctx, log, session, clientProof, clientIP)
+	if err != nil {
+		return models.LoginResult{}, err
+	}
+
+	if newVerifier != nil && a.verifierStale(user) {
+		if err := a.checkVerifier(*newVerifier); err != nil {
+			log.Info("invalid verifier", sl.Err(err))
+
+			return models.LoginResult{}, err
+		}
+
+		a.upgradeVerifier(ctx, user, *newVerifier)
+	}
+
+	result, err := a.completeLogin(ctx, log.With(slog.String("email", user.Email)), user)
+	if err != nil {
+		return models.LoginResult{}, err
+	}
+
+	result.ServerProof = serverProof
+
+	return result, nil
+}
+
+// verifySRPProof checks client proof M1 of the session started with LoginStart with the same limits as on login.
+// It returns the user of the session and server proof M2, the session can be used once.
+func (a *Auth) verifySRPProof(
+	ctx context.Context,
+	log *slog.Logger,
+	session string,
+	clientProof []byte,
+	clientIP string,
+) (models.User, []byte, error) {
+	hash := jwt.HashRefreshToken(session)
+
+	srpSession, err := a.srpSessions.UseSRPSession(ctx, hash)
+	if err != nil {
+		if errors.Is(err, storage.ErrSessionNotFound) {
+			// sessions of unknown emails are never saved, so this is also a failed login
+			log.Info("srp session not found")
+			a.recordLoginFailure(ctx, "", clientIP)
+
+			return models.User{}, nil, ErrInvalidCredentials
+		}
+
+		log.Error("failed to get srp session", sl.Err(err))
+
+		return models.User{}, nil, fmt.Errorf("failed to get srp session: %w", err)
+	}
+
+	user, err := a.userGetter.UserByID(ctx, srpSession.UserID)
+	if err != nil {
+		log.Error("failed to get user", sl.Err(err))
+
+		return models.User{}, nil, fmt.Errorf("failed to get user: %w", err)
+	}
+
+	log = log.With(slog.String("email", user.Email))
+
+	// limits could be reached by attempts made after LoginStart of this session
+	if err := a.checkLoginAllowed(ctx, user.Email, clientIP); err != nil {
+		log.Warn("login attempt rejected", sl.Err(err))
+
+		return models.User{}, nil, err
+	}
+
+	secret, err := a.srpSecret(ctx, srpSession)
+	if err != nil {
+		log.Error("failed to decrypt server secret", sl.Err(err))
+
+		return models.User{}, nil, err
+	}
+
+	server := srp.RestoreServer(user.Email, user.SRP.Salt, user.SRP.Verifier, secret)
+
+	serverProof, err := server.Verify(srpSession.ClientPublic, clientProof)
+	if err != nil {
+		log.Info("invalid credentials", sl.Err(err))
+		a.recordLoginFailure(ctx, user.Email, clientIP)
+
+		return models.User{}, nil, ErrInvalidCredentials
+	}
+
+	return user, serverProof, nil
+}
+
+// fakeLegacyForms is the share of fake challenges looking like the ones of users who have no verifier yet, one in it
+const fakeLegacyForms = 8
+
+// fakeSRPChallenge returns challenge for the unknown email looking like a challenge of a registered user.
+// Its form is stable for the email: some fakes are legacy challenges and some have verifiers derived
+// with previous params and ask to upgrade them, as challenges of registered users do.
+func (a *Auth) fakeSRPChallenge(email string) (models.SRPChallenge, error) {
+	if a.keys.FakeChoice(email, "legacy", fakeLegacyForms) == 0 {
+		return models.SRPChallenge{Legacy: true}, nil
+	}
+
+	session, _, err := jwt.NewRefreshToken()
+	if err != nil {
+		return models.SRPChallenge{}, err
+	}
+
+	serverPublic, err := srp.FakeServerPublic()
+	if err != nil {
+		return models.SRPChallenge{}, err
+	}
+
+	challenge := models.SRPChallenge{
+		Session:      session,
+		Salt:         a.keys.FakeSalt(email, passhash.SaltSize),
+		Params:       a.hasher.Params().String(),
+		ServerPublic: serverPublic,
+	}
+
+	if i := a.keys.FakeChoice(email, "params", len(a.prevParams)+1); i > 0 {
+		params := a.prevParams[i-1]
+		challenge.Params = params.String()
+
+		if params.Weaker(a.hasher.Params()) {
+			challenge.UpgradeParams = a.hasher.Params().String()
+		}
+	}
+
+	return challenge, nil
+}
+
+// srpSecret decrypts server secret of the session
+func (a *Auth) srpSecret(ctx context.Context, session models.SRPSession) ([]byte, error) {
+	cr, err := a.keys.UserCrypt(ctx, session.UserID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create crypt: %w", err)
+	}
+
+	encoded, err := cr.Open(session.ServerSecret, srpSessionAD(session.UserID, session.Hash))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt server secret: %w", err)
+	}
+
+	secret, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode server secret: %w", err)
+	}
+
+	return secret, nil
+}
+
+// srpSessionAD returns associated data binding encrypted server secret to its session
+func srpSessionAD(userID int64, hash string) []byte {
+	ad := make([]byte, 8, 8+len("srp")+len(hash))
+	binary.BigEndian.PutUint64(ad, uint64(userID))
+
+	ad = append(ad, "srp"...)
+
+	return append(ad, hash...)
+}
@@ -0,0 +1,125 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/nglmq/password-keeper/internal/domain/models"
+	"github.com/nglmq/password-keeper/internal/lib/passhash"
+	"github.com/nglmq/password-keeper/internal/lib/srp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuth_newVerifier(t *testing.T) {
+	hasher, err := passhash.New(passhash.Params{Memory: 1024, Iterations: 1, Parallelism: 1}, 1)
+	require.NoError(t, err)
+
+	a := &Auth{hasher: hasher}
+
+	verifier, err := a.newVerifier("correct horse battery staple")
+	require.NoError(t, err)
+	assert.Len(t, verifier.Salt, passhash.SaltSize)
+	assert.Equal(t, "m=1024,t=1,p=1", verifier.Params)
+
+	// the client derives the same verifier from the password, salt and params sent by LoginStart
+	x, err := srp.PasswordX("correct horse battery staple", verifier.Salt, verifier.Params)
+	require.NoError(t, err)
+	assert.Equal(t, verifier.Verifier, srp.Verifier(x))
+
+	// users who have a verifier log in with SRP only, even the right password is not accepted
+	user := models.User{ID: 1, SRP: verifier}
+
+	assert.ErrorIs(t, a.verifyPassword(context.Background(), user, "correct horse battery staple"), ErrInvalidCredentials)
+}
+
+func TestKeys_FakeSalt(t *testing.T) {
+	keys, err := NewKeys(make([]byte, 32), nil, nil)
+	require.NoError(t, err)
+
+	salt := keys.FakeSalt("user@example.com", passhash.SaltSize)
+	assert.Len(t, salt, passhash.SaltSize)
+	assert.Equal(t, salt, keys.FakeSalt("user@example.com", passhash.SaltSize))
+	assert.NotEqual(t, salt, keys.FakeSalt("other@example.com", passhash.SaltSize))
+}
+
+func TestAuth_checkVerifier(t *testing.T) {
+	hasher, err := passhash.New(passhash.Params{Memory: 1024, Iterations: 1, Parallelism: 1}, 1)
+	require.NoError(t, err)
+
+	a := &Auth{hasher: hasher}
+
+	salt, verifier, err := srp.NewVerifier("correct horse battery staple", "m=1024,t=1,p=1")
+	require.NoError(t, err)
+
+	valid := models.SRPVerifier{Salt: salt, Params: "m=1024,t=1,p=1", Verifier: verifier}
+	assert.NoError(t, a.checkVerifier(valid))
+
+	stronger := valid
+	stronger.Params = "m=2048,t=2,p=1"
+	assert.NoError(t, a.checkVerifier(stronger))
+
+	tests := map[string]func(v *models.SRPVerifier){
+		"short salt":     func(v *models.SRPVerifier) { v.Salt = v.Salt[:8] },
+		"invalid params": func(v *models.SRPVerifier) { v.Params = "m=1024" },
+		"weaker params":  func(v *models.SRPVerifier) { v.Params = "m=512,t=1,p=1" },
+		"short verifier": func(v *models.SRPVerifier) { v.Verifier = v.Verifier[1:] },
+		"zero verifier":  func(v *models.SRPVerifier) { v.Verifier = make([]byte, len(verifier)) },
+	}
+
+	for name, modify := range tests {
+		t.Run(name, func(t *testing.T) {
+			v := valid
+			modify(&v)
+			assert.ErrorIs(t, a.checkVerifier(v), ErrInvalidVerifier)
+		})
+	}
+}
+
+func TestAuth_fakeSRPChallenge(t *testing.T) {
+	hasher, err := passhash.New(passhash.Params{Memory: 2048, Iterations: 1, Parallelism: 1}, 1)
+	require.NoError(t, err)
+
+	keys, err := NewKeys(make([]byte, 32), nil, nil)
+	require.NoError(t, err)
+
+	previous := passhash.Params{Memory: 1024, Iterations: 1, Parallelism: 1}
+	a := &Auth{hasher: hasher, keys: keys, prevParams: []passhash.Params{previous}}
+
+	forms := map[string]int{}
+
+	for i := 0; i < 200; i++ {
+		email := fmt.Sprintf("user%d@example.com", i)
+
+		challenge, err := a.fakeSRPChallenge(email)
+		require.NoError(t, err)
+
+		again, err := a.fakeSRPChallenge(email)
+		require.NoError(t, err)
+
+		// the form is the same on every attempt, only the session and the server public value change
+		assert.Equal(t, challenge.Legacy, again.Legacy)
+		assert.Equal(t, challenge.Salt, again.Salt)
+		assert.Equal(t, challenge.Params, again.Params)
+		assert.Equal(t, challenge.UpgradeParams, again.UpgradeParams)
+
+		switch {
+		case challenge.Legacy:
+			// legacy challenges of registered users carry nothing else
+			assert.Equal(t, models.SRPChallenge{Legacy: true}, challenge)
+			forms["legacy"]++
+		case challenge.Params == previous.String():
+			assert.Equal(t, "m=2048,t=1,p=1", challenge.UpgradeParams)
+			forms["stale"]++
+		default:
+			assert.Equal(t, "m=2048,t=1,p=1", challenge.Params)
+			assert.Empty(t, challenge.UpgradeParams)
+			forms["current"]++
+		}
+	}
+
+	assert.NotZero(t, forms["legacy"])
+	assert.NotZero(t, forms["stale"])
+	assert.NotZero(t, forms["current"])
+}
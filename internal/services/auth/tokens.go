@@ -0,0 +1,197 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/nglmq/password-keeper/internal/domain/models"
+	"github.com/nglmq/password-keeper/internal/lib/jwt"
+	"github.com/nglmq/password-keeper/internal/lib/logger/sl"
+	"github.com/nglmq/password-keeper/internal/storage"
+)
+
+// Refresh exchanges refresh token for a new token pair. Every refresh token can be used once,
+// reuse of a token means it was stolen, so the whole family issued from the same login is revoked.
+func (a *Auth) Refresh(ctx context.Context, refreshToken string) (models.TokenPair, error) {
+	log := a.log.With(
+		slog.String("method", "Refresh"),
+	)
+
+	token, err := a.tokenStorage.UseRefreshToken(ctx, jwt.HashRefreshToken(refreshToken))
+	if err != nil {
+		switch {
+		case errors.Is(err, storage.ErrTokenReused):
+			log.Warn("refresh token reuse detected, revoking family",
+				slog.Int64("user_id", token.UserID), slog.String("family_id", token.FamilyID))
+
+			if err := a.tokenStorage.RevokeTokenFamily(ctx, token.FamilyID); err != nil {
+				log.Error("failed to revoke token family", sl.Err(err))
+
+				return models.TokenPair{}, fmt.Errorf("failed to revoke token family: %w", err)
+			}
+
+			return models.TokenPair{}, ErrInvalidToken
+		case errors.Is(err, storage.ErrTokenNotFound), errors.Is(err, storage.ErrTokenRevoked):
+			log.Info("invalid refresh token", sl.Err(err))
+
+			return models.TokenPair{}, ErrInvalidToken
+		}
+
+		log.Error("failed to use refresh token", sl.Err(err))
+
+		return models.TokenPair{}, fmt.Errorf("failed to use refresh token: %w", err)
+	}
+
+	if time.Now().After(token.ExpiresAt) {
+		log.Info("refresh token expired", slog.Int64("user_id", token.UserID))
+
+		return models.TokenPair{}, ErrInvalidToken
+	}
+
+	user, err := a.userGetter.UserByID(ctx, token.UserID)
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			return models.TokenPair{}, ErrInvalidToken
+		}
+
+		log.Error("failed to get user", sl.Err(err))
+
+		return models.TokenPair{}, fmt.Errorf("failed to get user: %w", err)
+	}
+
+	tokens, err := a.newTokenPair(ctx, user, token.FamilyID)
+	if err != nil {
+		log.Error("failed to generate tokens", sl.Err(err))
+
+		return models.TokenPair{}, err
+	}
+
+	return tokens, nil
+}
+
+// newTokenPair issues access token and refresh token of the family, new family is started if familyID is empty
+func (a *Auth) newTokenPair(ctx context.Context, user models.User, familyID string) (models.TokenPair, error) {
+	accessToken, err := a.tokens.NewToken(user, a.accessTTL)
+	if err != nil {
+		return models.TokenPair{}, fmt.Errorf("failed to generate access token: %w", err)
+	}
+
+	if familyID == "" {
+		familyID, err = jwt.NewFamilyID()
+		if err != nil {
+			return models.TokenPair{}, err
+		}
+	}
+
+	refreshToken, hash, err := jwt.NewRefreshToken()
+	if err != nil {
+		return models.TokenPair{}, err
+	}
+
+	err = a.tokenStorage.SaveRefreshToken(ctx, models.RefreshToken{
+		Hash:      hash,
+		UserID:    user.ID,
+		FamilyID:  familyID,
+		ExpiresAt: time.Now().Add(a.refreshTTL),
+	})
+	if err != nil {
+		return models.TokenPair{}, fmt.Errorf("failed to save refresh token: %w", err)
+	}
+
+	return models.TokenPair{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		ExpiresAt:    time.Now().Add(a.accessTTL),
+	}, nil
+}
+
+// Logout revokes the access token with claims and the refresh token family of the session.
+// If allDevices is set all tokens of the user are revoked.
+func (a *Auth) Logout(ctx context.Context, claims jwt.Claims, refreshToken string, allDevices bool) error {
+	log := a.log.With(
+		slog.String("method", "Logout"),
+		slog.Bool("all_devices", allDevices),
+		slog.Int64("user_id", claims.UID),
+	)
+
+	if allDevices {
+		if err := a.revocations.RevokeUserTokens(ctx, claims.UID, jwt.RevocationTime()); err != nil {
+			log.Error("failed to revoke user tokens", sl.Err(err))
+
+			return fmt.Errorf("failed to revoke user tokens: %w", err)
+		}
+
+		log.Info("logged out from all devices")
+
+		return nil
+	}
+
+	if err := a.revocations.RevokeToken(ctx, claims.ID, claims.UID, claims.ExpiresAt.Time); err != nil {
+		log.Error("failed to revoke token", sl.Err(err))
+
+		return fmt.Errorf("failed to revoke token: %w", err)
+	}
+
+	if refreshToken != "" {
+		rt, err := a.tokenStorage.RefreshToken(ctx, jwt.HashRefreshToken(refreshToken))
+		switch {
+		case errors.Is(err, storage.ErrTokenNotFound), err == nil && rt.UserID != claims.UID:
+			log.Info("unknown refresh token")
+		case err != nil:
+			log.Error("failed to get refresh token", sl.Err(err))
+
+			return fmt.Errorf("failed to get refresh token: %w", err)
+		default:
+			if err := a.tokenStorage.RevokeTokenFamily(ctx, rt.FamilyID); err != nil {
+				log.Error("failed to revoke token family", sl.Err(err))
+
+				return fmt.Errorf("failed to revoke token family: %w", err)
+			}
+		}
+	}
+
+	log.Info("logged out")
+
+	return nil
+}
+
+// RunCleanup deletes expired revoked and refresh tokens, stale login attempts and accounts deleted
+// longer than the grace period ago every interval until ctx is done
+func (a *Auth) RunCleanup(ctx context.Context, interval time.Duration) {
+	log := a.log.With(
+		slog.String("method", "RunCleanup"),
+	)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			a.purgeDeletedUsers(ctx, log)
+
+			deleted, err := a.revocations.DeleteExpiredTokens(ctx)
+			if err != nil {
+				log.Error("failed to delete expired tokens", sl.Err(err))
+
+				continue
+			}
+
+			log.Info("expired tokens deleted", slog.Int64("deleted", deleted))
+
+			deleted, err = a.attempts.DeleteStaleLoginAttempts(ctx, time.Now().Add(-a.limits.ResetAfter))
+			if err != nil {
+				log.Error("failed to delete stale login attempts", sl.Err(err))
+
+				continue
+			}
+
+			log.Info("stale login attempts deleted", slog.Int64("deleted", deleted))
+		}
+	}
+}
@@ -0,0 +1,309 @@
+package auth
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/nglmq/password-keeper/internal/domain/models"
+	"github.com/nglmq/password-keeper/internal/lib/jwt"
+	"github.com/nglmq/password-keeper/internal/lib/logger/sl"
+	"github.com/nglmq/password-keeper/internal/lib/totp"
+	"github.com/nglmq/password-keeper/internal/storage"
+)
+
+const (
+	// totpIssuer is shown by authenticator apps next to the account
+	totpIssuer = "password-keeper"
+	// challengeTTL is the time the user has to enter TOTP code after the password
+	challengeTTL = 5 * time.Minute
+	// maxChallengeAttempts is the number of codes that can be tried with one challenge
+	maxChallengeAttempts = 5
+)
+
+// EnrollTOTP generates a new TOTP secret of the user. The secret is not required on login
+// until it is confirmed with ConfirmTOTP, enrolling again replaces an unconfirmed secret.
+func (a *Auth) EnrollTOTP(ctx context.Context, userID int64) (models.TOTPEnrollment, error) {
+	log := a.log.With(
+		slog.String("method", "EnrollTOTP"),
+		slog.Int64("user_id", userID),
+	)
+
+	user, err := a.userGetter.UserByID(ctx, userID)
+	if err != nil {
+		log.Error("failed to get user", sl.Err(err))
+
+		return models.TOTPEnrollment{}, fmt.Errorf("failed to get user: %w", err)
+	}
+
+	enabled, err := a.totpEnabled(ctx, userID)
+	if err != nil {
+		log.Error("failed to get totp", sl.Err(err))
+
+		return models.TOTPEnrollment{}, err
+	}
+
+	if enabled {
+		return models.TOTPEnrollment{}, ErrTOTPAlreadyEnabled
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		log.Error("failed to generate secret", sl.Err(err))
+
+		return models.TOTPEnrollment{}, err
+	}
+
+	cr, err := a.keys.UserCrypt(ctx, userID)
+	if err != nil {
+		log.Error("failed to create crypt", sl.Err(err))
+
+		return models.TOTPEnrollment{}, fmt.Errorf("failed to create crypt: %w", err)
+	}
+
+	sealed, err := cr.Seal(secret, totpAD(userID))
+	if err != nil {
+		log.Error("failed to encrypt secret", sl.Err(err))
+
+		return models.TOTPEnrollment{}, fmt.Errorf("failed to encrypt secret: %w", err)
+	}
+
+	if err := a.totpStorage.SaveTOTP(ctx, userID, sealed); err != nil {
+		log.Error("failed to save totp", sl.Err(err))
+
+		return models.TOTPEnrollment{}, fmt.Errorf("failed to save totp: %w", err)
+	}
+
+	log.Info("totp enrolled")
+
+	return models.TOTPEnrollment{
+		Secret: secret,
+		URI:    totp.URI(totpIssuer, user.Email, secret),
+	}, nil
+}
+
+// ConfirmTOTP enables enrolled TOTP secret if the code matches it and returns one-time recovery codes
+func (a *Auth) ConfirmTOTP(ctx context.Context, userID int64, code string) ([]string, error) {
+	log := a.log.With(
+		slog.String("method", "ConfirmTOTP"),
+		slog.Int64("user_id", userID),
+	)
+
+	userTOTP, err := a.totpStorage.TOTP(ctx, userID)
+	if err != nil {
+		if errors.Is(err, storage.ErrTOTPNotFound) {
+			return nil, ErrTOTPNotEnrolled
+		}
+
+		log.Error("failed to get totp", sl.Err(err))
+
+		return nil, fmt.Errorf("failed to get totp: %w", err)
+	}
+
+	if userTOTP.Enabled {
+		return nil, ErrTOTPAlreadyEnabled
+	}
+
+	step, ok, err := a.checkTOTPCode(ctx, userTOTP, code)
+	if err != nil {
+		log.Error("failed to check code", sl.Err(err))
+
+		return nil, err
+	}
+
+	if !ok {
+		log.Info("invalid totp code")
+
+		return nil, ErrInvalidTOTPCode
+	}
+
+	codes, hashes, err := totp.NewRecoveryCodes()
+	if err != nil {
+		log.Error("failed to generate recovery codes", sl.Err(err))
+
+		return nil, err
+	}
+
+	if err := a.totpStorage.EnableTOTP(ctx, userID, step, hashes); err != nil {
+		if errors.Is(err, storage.ErrTOTPNotFound) {
+			// confirmed concurrently
+			return nil, ErrTOTPAlreadyEnabled
+		}
+
+		log.Error("failed to enable totp", sl.Err(err))
+
+		return nil, fmt.Errorf("failed to enable totp: %w", err)
+	}
+
+	log.Info("totp enabled")
+
+	return codes, nil
+}
+
+// LoginTOTP completes login started with Login using TOTP code or one of recovery codes.
+// Wrong codes count as failed logins of the user.
+func (a *Auth) LoginTOTP(ctx context.Context, challenge, code, clientIP string) (models.TokenPair, error) {
+	log := a.log.With(
+		slog.String("method", "LoginTOTP"),
+		slog.String("ip", clientIP),
+	)
+
+	hash := jwt.HashRefreshToken(challenge)
+
+	loginChallenge, err := a.totpStorage.UseLoginChallenge(ctx, hash, maxChallengeAttempts)
+	if err != nil {
+		if errors.Is(err, storage.ErrChallengeNotFound) {
+			log.Info("invalid login challenge")
+
+			return models.TokenPair{}, ErrInvalidChallenge
+		}
+
+		log.Error("failed to use login challenge", sl.Err(err))
+
+		return models.TokenPair{}, fmt.Errorf("failed to use login challenge: %w", err)
+	}
+
+	log = log.With(slog.Int64("user_id", loginChallenge.UserID))
+
+	user, err := a.userGetter.UserByID(ctx, loginChallenge.UserID)
+	if err != nil {
+		log.Error("failed to get user", sl.Err(err))
+
+		return models.TokenPair{}, fmt.Errorf("failed to get user: %w", err)
+	}
+
+	if err := a.checkLoginAllowed(ctx, user.Email, clientIP); err != nil {
+		log.Warn("login attempt rejected", sl.Err(err))
+
+		return models.TokenPair{}, err
+	}
+
+	if err := a.verifySecondFactor(ctx, loginChallenge.UserID, code); err != nil {
+		if errors.Is(err, ErrInvalidTOTPCode) {
+			log.Info("invalid second factor code")
+			a.recordLoginFailure(ctx, user.Email, clientIP)
+		} else {
+			log.Error("failed to verify second factor", sl.Err(err))
+		}
+
+		return models.TokenPair{}, err
+	}
+
+	// deleting the challenge makes sure only one request completes it
+	if err := a.totpStorage.DeleteLoginChallenge(ctx, hash); err != nil {
+		if errors.Is(err, storage.ErrChallengeNotFound) {
+			return models.TokenPair{}, ErrInvalidChallenge
+		}
+
+		log.Error("failed to delete login challenge", sl.Err(err))
+
+		return models.TokenPair{}, fmt.Errorf("failed to delete login challenge: %w", err)
+	}
+
+	tokens, err := a.newTokenPair(ctx, user, "")
+	if err != nil {
+		log.Error("failed to generate tokens", sl.Err(err))
+
+		return models.TokenPair{}, err
+	}
+
+	a.resetLoginFailures(ctx, user.Email)
+
+	log.Info("logged in with second factor")
+
+	return tokens, nil
+}
+
+// verifySecondFactor checks TOTP code or recovery code of the user, every code is accepted once
+func (a *Auth) verifySecondFactor(ctx context.Context, userID int64, code string) error {
+	userTOTP, err := a.totpStorage.TOTP(ctx, userID)
+	if err != nil {
+		return fmt.Errorf("failed to get totp: %w", err)
+	}
+
+	if !userTOTP.Enabled {
+		return ErrInvalidTOTPCode
+	}
+
+	step, ok, err := a.checkTOTPCode(ctx, userTOTP, code)
+	if err != nil {
+		return err
+	}
+
+	if ok {
+		err := a.totpStorage.UseTOTPStep(ctx, userID, step)
+		if errors.Is(err, storage.ErrTOTPCodeUsed) {
+			return ErrInvalidTOTPCode
+		}
+
+		return err
+	}
+
+	err = a.totpStorage.UseRecoveryCode(ctx, userID, totp.HashRecoveryCode(code))
+	if errors.Is(err, storage.ErrRecoveryCodeNotFound) {
+		return ErrInvalidTOTPCode
+	}
+
+	return err
+}
+
+// checkTOTPCode decrypts the secret and checks the code, the matched step is returned
+func (a *Auth) checkTOTPCode(ctx context.Context, userTOTP models.TOTP, code string) (int64, bool, error) {
+	cr, err := a.keys.UserCrypt(ctx, userTOTP.UserID)
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to create crypt: %w", err)
+	}
+
+	secret, err := cr.Open(userTOTP.Secret, totpAD(userTOTP.UserID))
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to decrypt secret: %w", err)
+	}
+
+	return totp.Validate(secret, code, time.Now())
+}
+
+// totpEnabled tells whether the user confirmed TOTP secret
+func (a *Auth) totpEnabled(ctx context.Context, userID int64) (bool, error) {
+	userTOTP, err := a.totpStorage.TOTP(ctx, userID)
+	if err != nil {
+		if errors.Is(err, storage.ErrTOTPNotFound) {
+			return false, nil
+		}
+
+		return false, fmt.Errorf("failed to get totp: %w", err)
+	}
+
+	return userTOTP.Enabled, nil
+}
+
+// newLoginChallenge issues challenge of the login waiting for the second factor, only its hash is stored
+func (a *Auth) newLoginChallenge(ctx context.Context, userID int64) (string, error) {
+	// challenges are random opaque tokens as refresh tokens are
+	challenge, hash, err := jwt.NewRefreshToken()
+	if err != nil {
+		return "", err
+	}
+
+	err = a.totpStorage.SaveLoginChallenge(ctx, models.LoginChallenge{
+		Hash:      hash,
+		UserID:    userID,
+		ExpiresAt: time.Now().Add(challengeTTL),
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to save login challenge: %w", err)
+	}
+
+	return challenge, nil
+}
+
+// totpAD returns associated data binding encrypted TOTP secret to its owner.
+// It is shorter than associated data of records, so secrets and records can not be swapped.
+func totpAD(userID int64) []byte {
+	ad := make([]byte, 8, 8+len("totp"))
+	binary.BigEndian.PutUint64(ad, uint64(userID))
+
+	return append(ad, "totp"...)
+}
@@ -0,0 +1,114 @@
+package rotation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/nglmq/password-keeper/internal/domain/models"
+	"github.com/nglmq/password-keeper/internal/lib/crypt"
+	"github.com/nglmq/password-keeper/internal/lib/logger/sl"
+	"github.com/nglmq/password-keeper/internal/storage"
+)
+
+// Rotator re-wraps data keys of users with the current master key.
+// Data keys themselves are not changed, so users_data rows are left untouched.
+type Rotator struct {
+	log        *slog.Logger
+	keyWrapper *crypt.KeyWrapper
+	keyStorage KeyStorage
+	batchSize  int
+}
+
+type KeyStorage interface {
+	UserKeysToRewrap(ctx context.Context, keyID uint32, afterUserID int64, limit int) ([]models.UserKey, error)
+	UpdateUserKey(ctx context.Context, userID int64, oldWrappedKey, wrappedKey string, keyID uint32) error
+}
+
+// Result is a summary of the rotation run
+type Result struct {
+	Rewrapped int
+	Failed    int
+}
+
+// New returns a new instance of Rotator, keys wrapped with previousKeys are re-wrapped with masterKey
+func New(log *slog.Logger, masterKey []byte, previousKeys [][]byte, keyStorage KeyStorage, batchSize int) (*Rotator, error) {
+	keyWrapper, err := crypt.NewKeyWrapper(masterKey, previousKeys...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create key wrapper: %w", err)
+	}
+
+	return &Rotator{
+		log:        log,
+		keyWrapper: keyWrapper,
+		keyStorage: keyStorage,
+		batchSize:  batchSize,
+	}, nil
+}
+
+// Run re-wraps keys in batches. Every key is updated on its own, so an interrupted run
+// can be started again and continues with the keys which are not re-wrapped yet.
+func (r *Rotator) Run(ctx context.Context) (Result, error) {
+	log := r.log.With(
+		slog.String("method", "Run"),
+		slog.String("key_id", fmt.Sprintf("%08x", r.keyWrapper.KeyID())),
+	)
+
+	log.Info("rotating data keys")
+
+	var (
+		res    Result
+		cursor int64
+	)
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return res, err
+		}
+
+		keys, err := r.keyStorage.UserKeysToRewrap(ctx, r.keyWrapper.KeyID(), cursor, r.batchSize)
+		if err != nil {
+			log.Error("failed to get keys", sl.Err(err))
+
+			return res, fmt.Errorf("failed to get keys: %w", err)
+		}
+
+		if len(keys) == 0 {
+			break
+		}
+
+		for _, key := range keys {
+			cursor = key.UserID
+
+			if err := r.rewrap(ctx, key); err != nil {
+				// keys which cannot be unwrapped are skipped and left for the next run
+				log.Error("failed to rewrap key", slog.Int64("user_id", key.UserID), sl.Err(err))
+				res.Failed++
+
+				continue
+			}
+
+			res.Rewrapped++
+		}
+
+		log.Info("batch done", slog.Int("rewrapped", res.Rewrapped), slog.Int("failed", res.Failed))
+	}
+
+	return res, nil
+}
+
+func (r *Rotator) rewrap(ctx context.Context, key models.UserKey) error {
+	wrappedKey, err := r.keyWrapper.Rewrap(key.WrappedKey)
+	if err != nil {
+		return err
+	}
+
+	err = r.keyStorage.UpdateUserKey(ctx, key.UserID, key.WrappedKey, wrappedKey, r.keyWrapper.KeyID())
+	if errors.Is(err, storage.ErrKeyNotFound) {
+		// key was deleted or re-wrapped concurrently by another run
+		return nil
+	}
+
+	return err
+}
@@ -0,0 +1,111 @@
+package rotation
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"sort"
+	"testing"
+
+	"github.com/nglmq/password-keeper/internal/domain/models"
+	"github.com/nglmq/password-keeper/internal/lib/crypt"
+	"github.com/nglmq/password-keeper/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeKeyStorage struct {
+	keys map[int64]models.UserKey
+}
+
+func (f *fakeKeyStorage) UserKeysToRewrap(_ context.Context, keyID uint32, afterUserID int64, limit int) ([]models.UserKey, error) {
+	var res []models.UserKey
+	for _, k := range f.keys {
+		if k.KeyID != keyID && k.UserID > afterUserID {
+			res = append(res, k)
+		}
+	}
+
+	sort.Slice(res, func(i, j int) bool { return res[i].UserID < res[j].UserID })
+
+	if len(res) > limit {
+		res = res[:limit]
+	}
+
+	return res, nil
+}
+
+func (f *fakeKeyStorage) UpdateUserKey(_ context.Context, userID int64, oldWrappedKey, wrappedKey string, keyID uint32) error {
+	k, ok := f.keys[userID]
+	if !ok || k.WrappedKey != oldWrappedKey {
+		return storage.ErrKeyNotFound
+	}
+
+	f.keys[userID] = models.UserKey{UserID: userID, WrappedKey: wrappedKey, KeyID: keyID}
+
+	return nil
+}
+
+func TestRotator_Run(t *testing.T) {
+	oldKey, err := crypt.GenerateKey()
+	require.NoError(t, err)
+
+	newKey, err := crypt.GenerateKey()
+	require.NoError(t, err)
+
+	lostKey, err := crypt.GenerateKey()
+	require.NoError(t, err)
+
+	old, err := crypt.NewKeyWrapper(oldKey)
+	require.NoError(t, err)
+
+	lost, err := crypt.NewKeyWrapper(lostKey)
+	require.NoError(t, err)
+
+	st := &fakeKeyStorage{keys: map[int64]models.UserKey{}}
+	dataKeys := map[int64][]byte{}
+
+	for id := int64(1); id <= 7; id++ {
+		dataKey, err := crypt.GenerateKey()
+		require.NoError(t, err)
+
+		w := old
+		if id == 4 {
+			// key wrapped with a master key which is not configured anymore
+			w = lost
+		}
+
+		wrapped, err := w.Wrap(dataKey)
+		require.NoError(t, err)
+
+		st.keys[id] = models.UserKey{UserID: id, WrappedKey: wrapped, KeyID: w.KeyID()}
+		dataKeys[id] = dataKey
+	}
+
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	r, err := New(log, newKey, [][]byte{oldKey}, st, 3)
+	require.NoError(t, err)
+
+	res, err := r.Run(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, Result{Rewrapped: 6, Failed: 1}, res)
+
+	current, err := crypt.NewKeyWrapper(newKey)
+	require.NoError(t, err)
+
+	for id, dataKey := range dataKeys {
+		if id == 4 {
+			continue
+		}
+
+		got, err := current.Unwrap(st.keys[id].WrappedKey)
+		require.NoError(t, err)
+		assert.Equal(t, dataKey, got)
+	}
+
+	// second run only retries keys which failed before
+	res, err = r.Run(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, Result{Rewrapped: 0, Failed: 1}, res)
+}
@@ -0,0 +1,58 @@
+package fs
+
+import (
+	"context"
+	"testing"
+
+	"github.com/nglmq/password-keeper/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStore(t *testing.T) {
+	ctx := context.Background()
+
+	s, err := New(t.TempDir())
+	require.NoError(t, err)
+
+	require.NoError(t, s.Put(ctx, "files/1/0-aa", []byte("first")))
+	require.NoError(t, s.Put(ctx, "files/1/1-bb", []byte("second")))
+	require.NoError(t, s.Put(ctx, "files/2/0-cc", []byte("other")))
+
+	got, err := s.Get(ctx, "files/1/0-aa")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("first"), got)
+
+	// blobs are replaced
+	require.NoError(t, s.Put(ctx, "files/1/0-aa", []byte("replaced")))
+
+	got, err = s.Get(ctx, "files/1/0-aa")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("replaced"), got)
+
+	keys, err := s.List(ctx, "files/1/")
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"files/1/0-aa", "files/1/1-bb"}, keys)
+
+	require.NoError(t, s.Delete(ctx, "files/1/0-aa"))
+	require.NoError(t, s.Delete(ctx, "files/1/0-aa"), "deleting a missing blob is not an error")
+
+	_, err = s.Get(ctx, "files/1/0-aa")
+	assert.ErrorIs(t, err, storage.ErrBlobNotFound)
+
+	keys, err = s.List(ctx, "files/")
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"files/1/1-bb", "files/2/0-cc"}, keys)
+}
+
+func TestStore_InvalidKey(t *testing.T) {
+	ctx := context.Background()
+
+	s, err := New(t.TempDir())
+	require.NoError(t, err)
+
+	for _, key := range []string{"", "../escape", "/abs", "files/../../escape", "files//1", "files/./1"} {
+		err := s.Put(ctx, key, []byte("data"))
+		assert.ErrorIs(t, err, ErrInvalidKey, "key %q", key)
+	}
+}
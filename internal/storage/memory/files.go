@@ -0,0 +1,213 @@
+package memory
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nglmq/password-keeper/internal/domain/models"
+	"github.com/nglmq/password-keeper/internal/storage"
+)
+
+type file struct {
+	userID int64
+	file   models.File
+}
+
+// NextFileID reserves ID for a new file
+func (s *Storage) NextFileID(_ context.Context) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.nextFileID++
+
+	return s.nextFileID, nil
+}
+
+// SaveFile saves a new file of the user with ID reserved by NextFileID and no chunks uploaded,
+// ErrDataNotFound is returned if the user has no record the file is attached to
+func (s *Storage) SaveFile(_ context.Context, userID int64, f models.File) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r, ok := s.records[f.RecordID]
+	if !ok || r.userID != userID {
+		return storage.ErrDataNotFound
+	}
+
+	if _, ok := s.files[f.ID]; ok {
+		return fmt.Errorf("file %d already exists", f.ID)
+	}
+
+	f.UploadedChunks = 0
+	f.CreatedAt = f.CreatedAt.UTC()
+	f.CompletedAt = time.Time{}
+
+	s.files[f.ID] = &file{userID: userID, file: f}
+	s.chunks[f.ID] = make(map[int]models.FileChunk)
+
+	return nil
+}
+
+// File returns the file of the user, ErrFileNotFound is returned if the user has no file with the ID
+func (s *Storage) File(_ context.Context, userID, id int64) (models.File, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, ok := s.files[id]
+	if !ok || f.userID != userID {
+		return models.File{}, storage.ErrFileNotFound
+	}
+
+	return f.file, nil
+}
+
+// SaveFileChunk saves the next chunk of the file of the user and returns the file with the chunk counted,
+// the upload is completed at uploadedAt with the last chunk. ErrFileNotFound is returned if the user has
+// no file with the ID, ErrChunkOutOfOrder with the file as is if the chunk is not the next one.
+func (s *Storage) SaveFileChunk(
+	_ context.Context,
+	userID int64,
+	chunk models.FileChunk,
+	uploadedAt time.Time,
+) (models.File, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, ok := s.files[chunk.FileID]
+	if !ok || f.userID != userID {
+		return models.File{}, storage.ErrFileNotFound
+	}
+
+	if chunk.Index != f.file.UploadedChunks || f.file.Completed() {
+		return f.file, storage.ErrChunkOutOfOrder
+	}
+
+	chunk.Data = bytes.Clone(chunk.Data)
+	chunk.Checksum = bytes.Clone(chunk.Checksum)
+
+	s.chunks[chunk.FileID][chunk.Index] = chunk
+
+	f.file.UploadedChunks++
+	if f.file.Completed() {
+		f.file.CompletedAt = uploadedAt.UTC()
+	}
+
+	return f.file, nil
+}
+
+// FileChunk returns the chunk of the file, ErrFileNotFound is returned if the chunk is not uploaded.
+// Data of chunks kept in the blob store is nil.
+func (s *Storage) FileChunk(_ context.Context, fileID int64, index int) (models.FileChunk, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	chunk, ok := s.chunks[fileID][index]
+	if !ok {
+		return models.FileChunk{}, storage.ErrFileNotFound
+	}
+
+	chunk.Data = bytes.Clone(chunk.Data)
+	chunk.Checksum = bytes.Clone(chunk.Checksum)
+
+	return chunk, nil
+}
+
+// ExistingFiles returns those of the IDs that belong to files not deleted yet
+func (s *Storage) ExistingFiles(_ context.Context, ids []int64) (map[int64]bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing := make(map[int64]bool)
+
+	for _, id := range ids {
+		if _, ok := s.files[id]; ok {
+			existing[id] = true
+		}
+	}
+
+	return existing, nil
+}
+
+// Blobs returns the blob store kept in memory together with the storage
+func (s *Storage) Blobs() *Blobs {
+	return s.blobs
+}
+
+// recordFiles returns files attached to the record ordered by ID
+func (s *Storage) recordFiles(recordID int64) []models.File {
+	var files []models.File
+
+	for _, f := range s.files {
+		if f.file.RecordID == recordID {
+			files = append(files, f.file)
+		}
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].ID < files[j].ID
+	})
+
+	return files
+}
+
+// Blobs is storage.BlobStore in memory
+type Blobs struct {
+	mu    sync.Mutex
+	blobs map[string][]byte
+}
+
+func NewBlobs() *Blobs {
+	return &Blobs{blobs: make(map[string][]byte)}
+}
+
+func (b *Blobs) Put(_ context.Context, key string, data []byte) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.blobs[key] = bytes.Clone(data)
+
+	return nil
+}
+
+func (b *Blobs) Get(_ context.Context, key string) ([]byte, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	data, ok := b.blobs[key]
+	if !ok {
+		return nil, storage.ErrBlobNotFound
+	}
+
+	return bytes.Clone(data), nil
+}
+
+func (b *Blobs) Delete(_ context.Context, key string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	delete(b.blobs, key)
+
+	return nil
+}
+
+func (b *Blobs) List(_ context.Context, prefix string) ([]string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var keys []string
+
+	for key := range b.blobs {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+
+	sort.Strings(keys)
+
+	return keys, nil
+}
@@ -0,0 +1,756 @@
+// Package memory keeps everything the server stores in memory, it is meant for tests and demo mode.
+// Its methods behave like the ones of storage/pg and return the same errors, the storagetest suite checks it.
+// All data is lost when the process exits.
+package memory
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/nglmq/password-keeper/internal/domain/models"
+	"github.com/nglmq/password-keeper/internal/storage"
+)
+
+type Storage struct {
+	mu  sync.Mutex
+	now func() time.Time
+
+	nextUserID int64
+	nextDataID int64
+	nextFileID int64
+
+	users       map[int64]*user
+	userIDs     map[string]int64
+	records     map[int64]*record
+	keys        map[int64]models.UserKey
+	files       map[int64]*file
+	chunks      map[int64]map[int]models.FileChunk
+	blobs       *Blobs
+	refresh     map[string]*refreshToken
+	revoked     map[string]revokedToken
+	totp        map[int64]*totp
+	recovery    map[int64]map[string]bool
+	challenges  map[string]*challenge
+	attempts    map[string]*loginAttempts
+	emailTokens map[string]*emailToken
+	srpSessions map[string]models.SRPSession
+}
+
+// user is a row of users, fields of models.User are kept as is
+type user struct {
+	models.User
+	vaultSalt        []byte
+	tokensValidAfter time.Time
+}
+
+// record is a data record of the user, its files are kept separately
+type record struct {
+	userID int64
+	data   models.Data
+}
+
+func New() *Storage {
+	return &Storage{
+		now:         time.Now,
+		users:       make(map[int64]*user),
+		userIDs:     make(map[string]int64),
+		records:     make(map[int64]*record),
+		keys:        make(map[int64]models.UserKey),
+		files:       make(map[int64]*file),
+		chunks:      make(map[int64]map[int]models.FileChunk),
+		blobs:       NewBlobs(),
+		refresh:     make(map[string]*refreshToken),
+		revoked:     make(map[string]revokedToken),
+		totp:        make(map[int64]*totp),
+		recovery:    make(map[int64]map[string]bool),
+		challenges:  make(map[string]*challenge),
+		attempts:    make(map[string]*loginAttempts),
+		emailTokens: make(map[string]*emailToken),
+		srpSessions: make(map[string]models.SRPSession),
+	}
+}
+
+// SaveUser saves a new user with SRP verifier of the password, no password hash is stored
+func (s *Storage) SaveUser(_ context.Context, email string, verifier models.SRPVerifier) (models.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.userIDs[email]; ok {
+		return models.User{}, storage.ErrUserExists
+	}
+
+	s.nextUserID++
+
+	u := &user{User: models.User{
+		ID:    s.nextUserID,
+		Email: email,
+		SRP:   cloneVerifier(verifier),
+	}}
+
+	s.users[u.ID] = u
+	s.userIDs[email] = u.ID
+
+	return cloneUser(u.User), nil
+}
+
+func (s *Storage) User(_ context.Context, email string) (models.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id, ok := s.userIDs[email]
+	if !ok {
+		return models.User{}, fmt.Errorf("user not found: %w", storage.ErrUserNotFound)
+	}
+
+	return cloneUser(s.users[id].User), nil
+}
+
+// UserByID returns user by ID
+func (s *Storage) UserByID(_ context.Context, id int64) (models.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, ok := s.users[id]
+	if !ok {
+		return models.User{}, fmt.Errorf("user not found: %w", storage.ErrUserNotFound)
+	}
+
+	return cloneUser(u.User), nil
+}
+
+// NextDataID reserves ID for a new data record
+func (s *Storage) NextDataID(_ context.Context) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.nextDataID++
+
+	return s.nextDataID, nil
+}
+
+// SaveData saves a new record of the user with ID reserved by NextDataID
+func (s *Storage) SaveData(_ context.Context, userID int64, data models.Data) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[userID]; !ok {
+		return fmt.Errorf("failed to save data: %w", storage.ErrUserNotFound)
+	}
+
+	if _, ok := s.records[data.ID]; ok {
+		return fmt.Errorf("record %d already exists", data.ID)
+	}
+
+	data.CreatedAt = data.CreatedAt.UTC()
+	data.UpdatedAt = data.UpdatedAt.UTC()
+	data.Files = nil
+	data.Legacy = false
+
+	s.records[data.ID] = &record{userID: userID, data: data}
+
+	return nil
+}
+
+// UpdateData replaces type and content of the record of the user, ErrDataNotFound is returned
+// if the user has no record with the ID
+func (s *Storage) UpdateData(_ context.Context, userID int64, data models.Data) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r, ok := s.records[data.ID]
+	if !ok || r.userID != userID {
+		return storage.ErrDataNotFound
+	}
+
+	r.data.DataType = data.DataType
+	r.data.Content = data.Content
+	r.data.UpdatedAt = data.UpdatedAt.UTC()
+	r.data.Legacy = false
+
+	return nil
+}
+
+// DeleteData deletes the record of the user, ErrDataNotFound is returned if the user has no record with the ID
+func (s *Storage) DeleteData(_ context.Context, userID, id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r, ok := s.records[id]
+	if !ok || r.userID != userID {
+		return storage.ErrDataNotFound
+	}
+
+	s.deleteRecord(id)
+
+	return nil
+}
+
+// GetData returns records of the user ordered by ID with their files, ErrDataNotFound is returned if there are none
+func (s *Storage) GetData(_ context.Context, userID int64) ([]models.Data, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var allData []models.Data
+
+	for _, r := range s.records {
+		if r.userID == userID {
+			allData = append(allData, r.data)
+		}
+	}
+
+	if len(allData) == 0 {
+		return nil, storage.ErrDataNotFound
+	}
+
+	sort.Slice(allData, func(i, j int) bool {
+		return allData[i].ID < allData[j].ID
+	})
+
+	for i := range allData {
+		allData[i].Files = s.recordFiles(allData[i].ID)
+	}
+
+	return allData, nil
+}
+
+// LegacyRecords returns records sealed before they were bound to their owner, ID and type
+// with IDs greater than afterID ordered by ID
+func (s *Storage) LegacyRecords(_ context.Context, afterID int64, limit int) ([]models.LegacyRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var records []models.LegacyRecord
+
+	for _, r := range s.records {
+		if r.data.Legacy && r.data.ID > afterID {
+			records = append(records, models.LegacyRecord{
+				ID:       r.data.ID,
+				UserID:   r.userID,
+				DataType: r.data.DataType,
+				Content:  r.data.Content,
+			})
+		}
+	}
+
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].ID < records[j].ID
+	})
+
+	if len(records) > limit {
+		records = records[:limit]
+	}
+
+	return records, nil
+}
+
+// ResealRecord replaces content of the legacy record with the sealed one, ErrDataNotFound is returned
+// if the record was deleted or changed meanwhile
+func (s *Storage) ResealRecord(_ context.Context, userID, id int64, content string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r, ok := s.records[id]
+	if !ok || r.userID != userID || !r.data.Legacy {
+		return storage.ErrDataNotFound
+	}
+
+	r.data.Content = content
+	r.data.Legacy = false
+
+	return nil
+}
+
+// SaveUserKey saves wrapped data key of the user, an already saved key is kept as is
+func (s *Storage) SaveUserKey(_ context.Context, userID int64, wrappedKey string, keyID uint32) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[userID]; !ok {
+		return fmt.Errorf("failed to save key: %w", storage.ErrUserNotFound)
+	}
+
+	if _, ok := s.keys[userID]; !ok {
+		s.keys[userID] = models.UserKey{UserID: userID, WrappedKey: wrappedKey, KeyID: keyID}
+	}
+
+	return nil
+}
+
+// UserKey returns wrapped data key of the user
+func (s *Storage) UserKey(_ context.Context, userID int64) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key, ok := s.keys[userID]
+	if !ok {
+		return "", storage.ErrKeyNotFound
+	}
+
+	return key.WrappedKey, nil
+}
+
+// UserKeysToRewrap returns up to limit data keys wrapped not by the master key keyID, ordered by user ID after afterUserID
+func (s *Storage) UserKeysToRewrap(_ context.Context, keyID uint32, afterUserID int64, limit int) ([]models.UserKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var keys []models.UserKey
+
+	for _, key := range s.keys {
+		if key.KeyID != keyID && key.UserID > afterUserID {
+			keys = append(keys, models.UserKey{UserID: key.UserID, WrappedKey: key.WrappedKey})
+		}
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].UserID < keys[j].UserID
+	})
+
+	if len(keys) > limit {
+		keys = keys[:limit]
+	}
+
+	return keys, nil
+}
+
+// UpdateUserKey replaces wrapped data key of the user if it was not changed since oldWrappedKey was read
+func (s *Storage) UpdateUserKey(_ context.Context, userID int64, oldWrappedKey, wrappedKey string, keyID uint32) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key, ok := s.keys[userID]
+	if !ok || key.WrappedKey != oldWrappedKey {
+		return storage.ErrKeyNotFound
+	}
+
+	s.keys[userID] = models.UserKey{UserID: userID, WrappedKey: wrappedKey, KeyID: keyID}
+
+	return nil
+}
+
+// SaveVaultSalt saves salt of the user vault key, an already saved salt is kept as is
+func (s *Storage) SaveVaultSalt(_ context.Context, userID int64, salt []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if u, ok := s.users[userID]; ok && u.vaultSalt == nil {
+		u.vaultSalt = bytes.Clone(salt)
+	}
+
+	return nil
+}
+
+// VaultSalt returns salt of the user vault key
+func (s *Storage) VaultSalt(_ context.Context, userID int64) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, ok := s.users[userID]
+	if !ok {
+		return nil, storage.ErrUserNotFound
+	}
+
+	if len(u.vaultSalt) == 0 {
+		return nil, storage.ErrSaltNotFound
+	}
+
+	return bytes.Clone(u.vaultSalt), nil
+}
+
+// MigrateToVerifier replaces password hash of the user with SRP verifier if the hash is still oldHash
+func (s *Storage) MigrateToVerifier(_ context.Context, userID int64, oldHash []byte, verifier models.SRPVerifier) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, ok := s.users[userID]
+	if ok && bytes.Equal(u.PassHash, oldHash) && u.SRP.Verifier == nil {
+		u.PassHash = nil
+		u.SRP = cloneVerifier(verifier)
+	}
+
+	return nil
+}
+
+// UpgradeVerifier replaces SRP verifier of the user derived with stale params if the verifier is still oldVerifier
+func (s *Storage) UpgradeVerifier(_ context.Context, userID int64, oldVerifier []byte, verifier models.SRPVerifier) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, ok := s.users[userID]
+	if ok && u.SRP.Verifier != nil && bytes.Equal(u.SRP.Verifier, oldVerifier) {
+		u.SRP = cloneVerifier(verifier)
+	}
+
+	return nil
+}
+
+// ChangePassword replaces SRP verifier, vault salt and content of all records of the user at once
+// and revokes tokens issued before validAfter. Meta of files attached to records is replaced as well.
+// Records and their files must be exactly the ones the user has, otherwise ErrDataChanged is returned
+// and nothing is changed.
+func (s *Storage) ChangePassword(
+	_ context.Context,
+	userID int64,
+	verifier models.SRPVerifier,
+	vaultSalt []byte,
+	records []models.Data,
+	validAfter time.Time,
+) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var count, filesCount, recordsFiles int
+
+	for _, r := range s.records {
+		if r.userID == userID {
+			count++
+		}
+	}
+
+	for _, f := range s.files {
+		if f.userID == userID {
+			filesCount++
+		}
+	}
+
+	if count != len(records) {
+		return storage.ErrDataChanged
+	}
+
+	for _, data := range records {
+		r, ok := s.records[data.ID]
+		if !ok || r.userID != userID {
+			return storage.ErrDataChanged
+		}
+
+		for _, f := range data.Files {
+			stored, ok := s.files[f.ID]
+			if !ok || stored.userID != userID || stored.file.RecordID != data.ID {
+				return storage.ErrDataChanged
+			}
+		}
+
+		recordsFiles += len(data.Files)
+	}
+
+	if filesCount != recordsFiles {
+		return storage.ErrDataChanged
+	}
+
+	// everything is checked, so the changes are applied at once as the transaction of storage/pg does
+	for _, data := range records {
+		s.records[data.ID].data.Content = data.Content
+		s.records[data.ID].data.Legacy = false
+
+		for _, f := range data.Files {
+			s.files[f.ID].file.Meta = f.Meta
+		}
+	}
+
+	if u, ok := s.users[userID]; ok {
+		u.PassHash = nil
+		u.SRP = cloneVerifier(verifier)
+		u.vaultSalt = bytes.Clone(vaultSalt)
+		u.tokensValidAfter = validAfter.UTC()
+	}
+
+	s.revokeUserRefreshTokens(userID)
+
+	return nil
+}
+
+// DeleteUser marks the user as deleted and revokes all tokens of the user
+func (s *Storage) DeleteUser(_ context.Context, userID int64, deletedAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, ok := s.users[userID]
+	if !ok || u.Deleted {
+		return storage.ErrUserNotFound
+	}
+
+	u.Deleted = true
+	u.DeletedAt = deletedAt.UTC()
+	u.tokensValidAfter = deletedAt.UTC()
+
+	s.revokeUserRefreshTokens(userID)
+
+	return nil
+}
+
+// RestoreUser clears deleted mark of the user deleted after deletedAfter
+func (s *Storage) RestoreUser(_ context.Context, userID int64, deletedAfter time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, ok := s.users[userID]
+	if !ok || !u.Deleted || !u.DeletedAt.After(deletedAfter) {
+		return storage.ErrUserNotFound
+	}
+
+	u.Deleted = false
+	u.DeletedAt = time.Time{}
+
+	return nil
+}
+
+// PurgeDeletedUsers deletes users marked as deleted before the given time together with all their data
+func (s *Storage) PurgeDeletedUsers(_ context.Context, deletedBefore time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var purged int64
+
+	for id, u := range s.users {
+		if u.Deleted && u.DeletedAt.Before(deletedBefore) {
+			s.deleteUser(id)
+			purged++
+		}
+	}
+
+	return purged, nil
+}
+
+// SaveEmailToken saves hash of the token sent to the user, unused tokens of the same purpose are deleted,
+// so only the latest email works
+func (s *Storage) SaveEmailToken(_ context.Context, token models.EmailToken) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[token.UserID]; !ok {
+		return fmt.Errorf("failed to save token: %w", storage.ErrUserNotFound)
+	}
+
+	for hash, t := range s.emailTokens {
+		if t.UserID == token.UserID && t.Purpose == token.Purpose && !t.used {
+			delete(s.emailTokens, hash)
+		}
+	}
+
+	if _, ok := s.emailTokens[token.Hash]; ok {
+		return fmt.Errorf("email token already exists")
+	}
+
+	token.ExpiresAt = token.ExpiresAt.UTC()
+	token.CreatedAt = token.CreatedAt.UTC()
+
+	s.emailTokens[token.Hash] = &emailToken{EmailToken: token}
+
+	return nil
+}
+
+// LatestEmailToken returns the last token of the purpose sent to the user, used or not
+func (s *Storage) LatestEmailToken(_ context.Context, userID int64, purpose string) (models.EmailToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var latest *emailToken
+
+	for _, t := range s.emailTokens {
+		if t.UserID == userID && t.Purpose == purpose && (latest == nil || t.CreatedAt.After(latest.CreatedAt)) {
+			latest = t
+		}
+	}
+
+	if latest == nil {
+		return models.EmailToken{}, storage.ErrTokenNotFound
+	}
+
+	return latest.EmailToken, nil
+}
+
+// EmailToken returns unused and not expired token of the purpose
+func (s *Storage) EmailToken(_ context.Context, hash, purpose string) (models.EmailToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, ok := s.validEmailToken(hash, purpose)
+	if !ok {
+		return models.EmailToken{}, storage.ErrTokenNotFound
+	}
+
+	return t.EmailToken, nil
+}
+
+// VerifyEmail uses the verification token and marks email of its user as verified
+func (s *Storage) VerifyEmail(_ context.Context, hash string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, ok := s.validEmailToken(hash, models.EmailTokenVerify)
+	if !ok {
+		return 0, storage.ErrTokenNotFound
+	}
+
+	t.used = true
+
+	if u, ok := s.users[t.UserID]; ok {
+		u.EmailVerified = true
+	}
+
+	return t.UserID, nil
+}
+
+// ResetPassword uses the reset token and sets SRP verifier of the new password of its user.
+// Records of the user are encrypted with the vault key of the old password and can not be read anymore,
+// so they are deleted together with the vault salt. Tokens issued before validAfter are revoked.
+func (s *Storage) ResetPassword(
+	_ context.Context,
+	hash string,
+	verifier models.SRPVerifier,
+	validAfter time.Time,
+) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, ok := s.validEmailToken(hash, models.EmailTokenReset)
+	if !ok {
+		return 0, storage.ErrTokenNotFound
+	}
+
+	t.used = true
+
+	if u, ok := s.users[t.UserID]; ok {
+		u.PassHash = nil
+		u.SRP = cloneVerifier(verifier)
+		u.vaultSalt = nil
+		u.tokensValidAfter = validAfter.UTC()
+		u.EmailVerified = true
+	}
+
+	for id, r := range s.records {
+		if r.userID == t.UserID {
+			s.deleteRecord(id)
+		}
+	}
+
+	s.revokeUserRefreshTokens(t.UserID)
+
+	return t.UserID, nil
+}
+
+// SaveSRPSession saves the first round of SRP login
+func (s *Storage) SaveSRPSession(_ context.Context, session models.SRPSession) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[session.UserID]; !ok {
+		return fmt.Errorf("failed to save session: %w", storage.ErrUserNotFound)
+	}
+
+	if _, ok := s.srpSessions[session.Hash]; ok {
+		return fmt.Errorf("login session already exists")
+	}
+
+	session.ClientPublic = bytes.Clone(session.ClientPublic)
+	session.ExpiresAt = session.ExpiresAt.UTC()
+
+	s.srpSessions[session.Hash] = session
+
+	return nil
+}
+
+// UseSRPSession deletes and returns the session, so a proof can be checked against it once.
+// ErrSessionNotFound is returned if the session expired or was already used.
+func (s *Storage) UseSRPSession(_ context.Context, hash string) (models.SRPSession, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, ok := s.srpSessions[hash]
+	if !ok || !session.ExpiresAt.After(s.now()) {
+		return models.SRPSession{}, storage.ErrSessionNotFound
+	}
+
+	delete(s.srpSessions, hash)
+
+	return session, nil
+}
+
+// deleteUser deletes the user with everything referencing it as ON DELETE CASCADE of storage/pg does
+func (s *Storage) deleteUser(userID int64) {
+	for id, r := range s.records {
+		if r.userID == userID {
+			s.deleteRecord(id)
+		}
+	}
+
+	for hash, t := range s.refresh {
+		if t.UserID == userID {
+			delete(s.refresh, hash)
+		}
+	}
+
+	for jti, t := range s.revoked {
+		if t.userID == userID {
+			delete(s.revoked, jti)
+		}
+	}
+
+	for hash, c := range s.challenges {
+		if c.UserID == userID {
+			delete(s.challenges, hash)
+		}
+	}
+
+	for hash, t := range s.emailTokens {
+		if t.UserID == userID {
+			delete(s.emailTokens, hash)
+		}
+	}
+
+	for hash, session := range s.srpSessions {
+		if session.UserID == userID {
+			delete(s.srpSessions, hash)
+		}
+	}
+
+	delete(s.keys, userID)
+	delete(s.totp, userID)
+	delete(s.recovery, userID)
+	delete(s.userIDs, s.users[userID].Email)
+	delete(s.users, userID)
+}
+
+// deleteRecord deletes the record with its files
+func (s *Storage) deleteRecord(id int64) {
+	for fileID, f := range s.files {
+		if f.file.RecordID == id {
+			delete(s.files, fileID)
+			delete(s.chunks, fileID)
+		}
+	}
+
+	delete(s.records, id)
+}
+
+func (s *Storage) validEmailToken(hash, purpose string) (*emailToken, bool) {
+	t, ok := s.emailTokens[hash]
+	if !ok || t.Purpose != purpose || t.used || !t.ExpiresAt.After(s.now()) {
+		return nil, false
+	}
+
+	return t, true
+}
+
+type emailToken struct {
+	models.EmailToken
+	used bool
+}
+
+func cloneUser(u models.User) models.User {
+	u.PassHash = bytes.Clone(u.PassHash)
+	u.SRP = cloneVerifier(u.SRP)
+
+	return u
+}
+
+func cloneVerifier(v models.SRPVerifier) models.SRPVerifier {
+	v.Salt = bytes.Clone(v.Salt)
+	v.Verifier = bytes.Clone(v.Verifier)
+
+	return v
+}
@@ -0,0 +1,20 @@
+package memory
+
+import (
+	"testing"
+
+	"github.com/nglmq/password-keeper/internal/storage"
+	"github.com/nglmq/password-keeper/internal/storage/storagetest"
+)
+
+func TestStorage(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storagetest.Storage {
+		return New()
+	})
+}
+
+func TestBlobs(t *testing.T) {
+	storagetest.RunBlobStore(t, func(t *testing.T) storage.BlobStore {
+		return NewBlobs()
+	})
+}
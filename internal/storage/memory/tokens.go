@@ -0,0 +1,428 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/nglmq/password-keeper/internal/domain/models"
+	"github.com/nglmq/password-keeper/internal/storage"
+)
+
+type refreshToken struct {
+	models.RefreshToken
+	used    bool
+	revoked bool
+}
+
+type revokedToken struct {
+	userID    int64
+	expiresAt time.Time
+}
+
+type totp struct {
+	secret   string
+	enabled  bool
+	lastStep int64
+	// stepUsed is false until the first code is used, the same as NULL last_step of storage/pg
+	stepUsed bool
+}
+
+type challenge struct {
+	models.LoginChallenge
+	attempts int
+}
+
+type loginAttempts struct {
+	failures      int
+	lastFailureAt time.Time
+	blockedUntil  time.Time
+}
+
+// SaveRefreshToken saves hash of the issued refresh token
+func (s *Storage) SaveRefreshToken(_ context.Context, token models.RefreshToken) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[token.UserID]; !ok {
+		return fmt.Errorf("failed to save token: %w", storage.ErrUserNotFound)
+	}
+
+	if _, ok := s.refresh[token.Hash]; ok {
+		return fmt.Errorf("refresh token already exists")
+	}
+
+	token.ExpiresAt = token.ExpiresAt.UTC()
+
+	s.refresh[token.Hash] = &refreshToken{RefreshToken: token}
+
+	return nil
+}
+
+// UseRefreshToken marks refresh token as used and returns it.
+// ErrTokenReused is returned together with the token if it was already used.
+func (s *Storage) UseRefreshToken(_ context.Context, hash string) (models.RefreshToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, ok := s.refresh[hash]
+	if !ok {
+		return models.RefreshToken{}, storage.ErrTokenNotFound
+	}
+
+	if t.revoked {
+		return t.RefreshToken, storage.ErrTokenRevoked
+	}
+
+	if t.used {
+		return t.RefreshToken, storage.ErrTokenReused
+	}
+
+	t.used = true
+
+	return t.RefreshToken, nil
+}
+
+// RevokeTokenFamily revokes all refresh tokens of the family
+func (s *Storage) RevokeTokenFamily(_ context.Context, familyID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, t := range s.refresh {
+		if t.FamilyID == familyID {
+			t.revoked = true
+		}
+	}
+
+	return nil
+}
+
+// RefreshToken returns refresh token by hash
+func (s *Storage) RefreshToken(_ context.Context, hash string) (models.RefreshToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, ok := s.refresh[hash]
+	if !ok {
+		return models.RefreshToken{}, storage.ErrTokenNotFound
+	}
+
+	return t.RefreshToken, nil
+}
+
+// RevokeToken adds access token to the revocation list until it expires
+func (s *Storage) RevokeToken(_ context.Context, jti string, userID int64, expiresAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[userID]; !ok {
+		return fmt.Errorf("failed to revoke token: %w", storage.ErrUserNotFound)
+	}
+
+	if _, ok := s.revoked[jti]; !ok {
+		s.revoked[jti] = revokedToken{userID: userID, expiresAt: expiresAt.UTC()}
+	}
+
+	return nil
+}
+
+// RevokeUserTokens revokes all refresh tokens of the user and access tokens issued before the given time
+func (s *Storage) RevokeUserTokens(_ context.Context, userID int64, before time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if u, ok := s.users[userID]; ok {
+		u.tokensValidAfter = before.UTC()
+	}
+
+	s.revokeUserRefreshTokens(userID)
+
+	return nil
+}
+
+// IsTokenRevoked reports whether access token was revoked by itself or with all tokens of the user
+func (s *Storage) IsTokenRevoked(_ context.Context, jti string, userID int64, issuedAt time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.revoked[jti]; ok {
+		return true, nil
+	}
+
+	u, ok := s.users[userID]
+
+	return ok && u.tokensValidAfter.After(issuedAt), nil
+}
+
+// DeleteExpiredTokens deletes revoked access tokens and refresh tokens which are expired anyway
+func (s *Storage) DeleteExpiredTokens(_ context.Context) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+
+	var deleted int64
+
+	for jti, t := range s.revoked {
+		if t.expiresAt.Before(now) {
+			delete(s.revoked, jti)
+			deleted++
+		}
+	}
+
+	for hash, t := range s.refresh {
+		if t.ExpiresAt.Before(now) {
+			delete(s.refresh, hash)
+			deleted++
+		}
+	}
+
+	for hash, c := range s.challenges {
+		if c.ExpiresAt.Before(now) {
+			delete(s.challenges, hash)
+			deleted++
+		}
+	}
+
+	for hash, t := range s.emailTokens {
+		if t.ExpiresAt.Before(now) {
+			delete(s.emailTokens, hash)
+			deleted++
+		}
+	}
+
+	for hash, session := range s.srpSessions {
+		if session.ExpiresAt.Before(now) {
+			delete(s.srpSessions, hash)
+			deleted++
+		}
+	}
+
+	return deleted, nil
+}
+
+// SaveTOTP saves not yet confirmed TOTP secret of the user replacing the previous unconfirmed one.
+// Secret of the user with enabled TOTP is not replaced.
+func (s *Storage) SaveTOTP(_ context.Context, userID int64, secret string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[userID]; !ok {
+		return fmt.Errorf("failed to save totp: %w", storage.ErrUserNotFound)
+	}
+
+	if t, ok := s.totp[userID]; ok && t.enabled {
+		return nil
+	}
+
+	s.totp[userID] = &totp{secret: secret}
+
+	return nil
+}
+
+// TOTP returns TOTP secret of the user
+func (s *Storage) TOTP(_ context.Context, userID int64) (models.TOTP, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, ok := s.totp[userID]
+	if !ok {
+		return models.TOTP{}, storage.ErrTOTPNotFound
+	}
+
+	return models.TOTP{UserID: userID, Secret: t.secret, Enabled: t.enabled}, nil
+}
+
+// EnableTOTP enables confirmed TOTP secret, marks the confirming step as used
+// and replaces recovery codes of the user with the given hashes
+func (s *Storage) EnableTOTP(_ context.Context, userID int64, step int64, recoveryHashes []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, ok := s.totp[userID]
+	if !ok || t.enabled {
+		return storage.ErrTOTPNotFound
+	}
+
+	t.enabled = true
+	t.lastStep = step
+	t.stepUsed = true
+
+	codes := make(map[string]bool, len(recoveryHashes))
+	for _, hash := range recoveryHashes {
+		codes[hash] = false
+	}
+
+	s.recovery[userID] = codes
+
+	return nil
+}
+
+// UseTOTPStep marks time step of the code as used, codes of the same or earlier steps can not be used again
+func (s *Storage) UseTOTPStep(_ context.Context, userID int64, step int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, ok := s.totp[userID]
+	if !ok || !t.enabled || (t.stepUsed && t.lastStep >= step) {
+		return storage.ErrTOTPCodeUsed
+	}
+
+	t.lastStep = step
+	t.stepUsed = true
+
+	return nil
+}
+
+// UseRecoveryCode marks recovery code of the user as used
+func (s *Storage) UseRecoveryCode(_ context.Context, userID int64, hash string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	used, ok := s.recovery[userID][hash]
+	if !ok || used {
+		return storage.ErrRecoveryCodeNotFound
+	}
+
+	s.recovery[userID][hash] = true
+
+	return nil
+}
+
+// SaveLoginChallenge saves hash of the issued login challenge
+func (s *Storage) SaveLoginChallenge(_ context.Context, c models.LoginChallenge) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[c.UserID]; !ok {
+		return fmt.Errorf("failed to save challenge: %w", storage.ErrUserNotFound)
+	}
+
+	if _, ok := s.challenges[c.Hash]; ok {
+		return fmt.Errorf("login challenge already exists")
+	}
+
+	c.ExpiresAt = c.ExpiresAt.UTC()
+
+	s.challenges[c.Hash] = &challenge{LoginChallenge: c}
+
+	return nil
+}
+
+// UseLoginChallenge counts an attempt to complete the challenge and returns it.
+// ErrChallengeNotFound is returned if the challenge expired or has no attempts left.
+func (s *Storage) UseLoginChallenge(_ context.Context, hash string, maxAttempts int) (models.LoginChallenge, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.challenges[hash]
+	if !ok || c.attempts >= maxAttempts || !c.ExpiresAt.After(s.now()) {
+		return models.LoginChallenge{}, storage.ErrChallengeNotFound
+	}
+
+	c.attempts++
+
+	return c.LoginChallenge, nil
+}
+
+// DeleteLoginChallenge deletes completed login challenge,
+// ErrChallengeNotFound is returned if the challenge was already completed by a concurrent request
+func (s *Storage) DeleteLoginChallenge(_ context.Context, hash string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.challenges[hash]; !ok {
+		return storage.ErrChallengeNotFound
+	}
+
+	delete(s.challenges, hash)
+
+	return nil
+}
+
+// LoginAttempts returns failed login attempts of the key, zero attempts are returned for unknown keys
+func (s *Storage) LoginAttempts(_ context.Context, key string) (models.LoginAttempts, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	attempts := models.LoginAttempts{Key: key}
+
+	if a, ok := s.attempts[key]; ok {
+		attempts.Failures = a.failures
+		attempts.BlockedUntil = a.blockedUntil
+	}
+
+	return attempts, nil
+}
+
+// RecordLoginFailure counts failed login attempt of the key and returns the number of failures in a row.
+// Failures are counted from scratch if the previous one was before resetBefore.
+func (s *Storage) RecordLoginFailure(_ context.Context, key string, resetBefore time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	a, ok := s.attempts[key]
+	if !ok {
+		a = &loginAttempts{}
+		s.attempts[key] = a
+	}
+
+	if a.lastFailureAt.Before(resetBefore) {
+		a.failures = 0
+	}
+
+	a.failures++
+	a.lastFailureAt = s.now().UTC()
+
+	return a.failures, nil
+}
+
+// BlockLogin rejects login attempts of the key until the given time
+func (s *Storage) BlockLogin(_ context.Context, key string, until time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if a, ok := s.attempts[key]; ok {
+		a.blockedUntil = until.UTC()
+	}
+
+	return nil
+}
+
+// ResetLoginAttempts forgets failed login attempts of the key
+func (s *Storage) ResetLoginAttempts(_ context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.attempts, key)
+
+	return nil
+}
+
+// DeleteStaleLoginAttempts deletes not blocked attempts with the last failure before the given time
+func (s *Storage) DeleteStaleLoginAttempts(_ context.Context, before time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+
+	var deleted int64
+
+	for key, a := range s.attempts {
+		if a.lastFailureAt.Before(before) && (a.blockedUntil.IsZero() || a.blockedUntil.Before(now)) {
+			delete(s.attempts, key)
+			deleted++
+		}
+	}
+
+	return deleted, nil
+}
+
+// revokeUserRefreshTokens revokes all refresh tokens of the user
+func (s *Storage) revokeUserRefreshTokens(userID int64) {
+	for _, t := range s.refresh {
+		if t.UserID == userID {
+			t.revoked = true
+		}
+	}
+}
@@ -0,0 +1,372 @@
+// Package migrate applies numbered SQL migrations to a database and records them in the schema_migrations table.
+//
+// Migrations are files NNNN_name.up.sql with NNNN_name.down.sql reverting them, versions start from 1
+// and have no gaps. Every migration is applied in its own transaction together with its record,
+// the version of the schema is read in that transaction.
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+var (
+	// ErrSchemaTooNew is returned when the database has migrations the binary does not know,
+	// it was migrated by a newer version of the server
+	ErrSchemaTooNew = errors.New("database schema is newer than the server")
+	ErrNoMigrations = errors.New("no migrations to revert")
+)
+
+var fileName = regexp.MustCompile(`^(\d+)_([a-z0-9_]+)\.(up|down)\.sql$`)
+
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// Status of a migration, AppliedAt is zero for pending migrations
+type Status struct {
+	Version   int
+	Name      string
+	AppliedAt time.Time
+	// Unknown migrations are applied to the database by a newer server
+	Unknown bool
+}
+
+func (s Status) Applied() bool {
+	return !s.AppliedAt.IsZero()
+}
+
+// Locker takes a lock serializing migrations of concurrent servers, the lock is held on conn
+// until unlock is called
+type Locker func(ctx context.Context, conn *sql.Conn) (unlock func() error, err error)
+
+type Migrator struct {
+	db         *sql.DB
+	migrations []Migration
+	lock       Locker
+}
+
+// Load reads migrations from the root of fsys sorted by versions, every migration must have both files
+func Load(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read migrations: %w", err)
+	}
+
+	byVersion := make(map[int]*Migration)
+
+	for _, e := range entries {
+		m := fileName.FindStringSubmatch(e.Name())
+		if m == nil {
+			return nil, fmt.Errorf("unexpected migration file %q", e.Name())
+		}
+
+		version, err := strconv.Atoi(m[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid version of migration %q: %w", e.Name(), err)
+		}
+
+		content, err := fs.ReadFile(fsys, e.Name())
+		if err != nil {
+			return nil, fmt.Errorf("failed to read migration: %w", err)
+		}
+
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: m[2]}
+			byVersion[version] = migration
+		}
+
+		if migration.Name != m[2] {
+			return nil, fmt.Errorf("migration %d has names %q and %q", version, migration.Name, m[2])
+		}
+
+		if m[3] == "up" {
+			migration.Up = string(content)
+		} else {
+			migration.Down = string(content)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		migrations = append(migrations, *m)
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	for i, m := range migrations {
+		if m.Version != i+1 {
+			return nil, fmt.Errorf("migration %d is missing", i+1)
+		}
+
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migration %d must have up and down files", m.Version)
+		}
+	}
+
+	return migrations, nil
+}
+
+// New returns Migrator of the database, lock may be nil for databases used by one process only
+func New(db *sql.DB, migrations []Migration, lock Locker) *Migrator {
+	return &Migrator{
+		db:         db,
+		migrations: migrations,
+		lock:       lock,
+	}
+}
+
+// Latest returns the version of the last known migration
+func (m *Migrator) Latest() int {
+	return len(m.migrations)
+}
+
+// Up applies all pending migrations and returns their number,
+// ErrSchemaTooNew is returned if the database has unknown migrations
+func (m *Migrator) Up(ctx context.Context) (int, error) {
+	var applied int
+
+	err := m.locked(ctx, func(conn *sql.Conn) error {
+		for {
+			var migration *Migration
+
+			// the version is read in the transaction of the migration, so a migration applied meanwhile
+			// by another process is seen even without the lock
+			err := inTx(ctx, conn, func(tx *sql.Tx) error {
+				version, err := m.version(ctx, tx)
+				if err != nil || version == m.Latest() {
+					return err
+				}
+
+				migration = &m.migrations[version]
+
+				if _, err := tx.ExecContext(ctx, migration.Up); err != nil {
+					return fmt.Errorf("failed to apply migration %d_%s: %w", migration.Version, migration.Name, err)
+				}
+
+				_, err = tx.ExecContext(ctx,
+					"INSERT INTO schema_migrations(version, name, applied_at) VALUES ($1, $2, $3)",
+					migration.Version, migration.Name, time.Now().UTC())
+				if err != nil {
+					return fmt.Errorf("failed to apply migration %d_%s: %w", migration.Version, migration.Name, err)
+				}
+
+				return nil
+			})
+			if err != nil || migration == nil {
+				return err
+			}
+
+			applied++
+		}
+	})
+
+	return applied, err
+}
+
+// Down reverts up to steps last applied migrations and returns their number,
+// ErrNoMigrations is returned if none is applied
+func (m *Migrator) Down(ctx context.Context, steps int) (int, error) {
+	var reverted int
+
+	err := m.locked(ctx, func(conn *sql.Conn) error {
+		for reverted < steps {
+			var migration *Migration
+
+			// the version is read in the transaction of the migration as Up does
+			err := inTx(ctx, conn, func(tx *sql.Tx) error {
+				version, err := m.version(ctx, tx)
+				if err != nil {
+					return err
+				}
+
+				if version == 0 {
+					if reverted == 0 {
+						return ErrNoMigrations
+					}
+
+					return nil
+				}
+
+				migration = &m.migrations[version-1]
+
+				if _, err := tx.ExecContext(ctx, migration.Down); err != nil {
+					return fmt.Errorf("failed to revert migration %d_%s: %w", migration.Version, migration.Name, err)
+				}
+
+				_, err = tx.ExecContext(ctx, "DELETE FROM schema_migrations WHERE version = $1", migration.Version)
+				if err != nil {
+					return fmt.Errorf("failed to revert migration %d_%s: %w", migration.Version, migration.Name, err)
+				}
+
+				return nil
+			})
+			if err != nil || migration == nil {
+				return err
+			}
+
+			reverted++
+		}
+
+		return nil
+	})
+
+	return reverted, err
+}
+
+// Status returns known migrations with times they were applied at followed by unknown applied migrations
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get connection: %w", err)
+	}
+	defer conn.Close()
+
+	if err := createTable(ctx, conn); err != nil {
+		return nil, err
+	}
+
+	applied, err := appliedMigrations(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]Status, 0, len(m.migrations))
+	for _, migration := range m.migrations {
+		s := applied[migration.Version]
+		s.Version, s.Name = migration.Version, migration.Name
+
+		statuses = append(statuses, s)
+		delete(applied, migration.Version)
+	}
+
+	var unknown []Status
+	for _, s := range applied {
+		s.Unknown = true
+		unknown = append(unknown, s)
+	}
+
+	sort.Slice(unknown, func(i, j int) bool {
+		return unknown[i].Version < unknown[j].Version
+	})
+
+	return append(statuses, unknown...), nil
+}
+
+// version returns the number of applied migrations after checking they are the first known ones
+func (m *Migrator) version(ctx context.Context, q querier) (int, error) {
+	applied, err := appliedMigrations(ctx, q)
+	if err != nil {
+		return 0, err
+	}
+
+	for version := range applied {
+		if version > m.Latest() {
+			return 0, fmt.Errorf("%w: database has migration %d, the server knows %d", ErrSchemaTooNew,
+				version, m.Latest())
+		}
+	}
+
+	for version := 1; version <= len(applied); version++ {
+		if _, ok := applied[version]; !ok {
+			return 0, fmt.Errorf("migration %d is not applied but later ones are", version)
+		}
+	}
+
+	return len(applied), nil
+}
+
+// locked runs f with the lock taken on a connection of the database
+func (m *Migrator) locked(ctx context.Context, f func(conn *sql.Conn) error) error {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get connection: %w", err)
+	}
+	defer conn.Close()
+
+	if m.lock != nil {
+		unlock, err := m.lock(ctx, conn)
+		if err != nil {
+			return fmt.Errorf("failed to lock migrations: %w", err)
+		}
+		defer unlock()
+	}
+
+	if err := createTable(ctx, conn); err != nil {
+		return err
+	}
+
+	return f(conn)
+}
+
+func createTable(ctx context.Context, conn *sql.Conn) error {
+	_, err := conn.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS schema_migrations(
+		version INT PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at TIMESTAMP NOT NULL)`)
+	if err != nil {
+		return fmt.Errorf("failed to create migrations table: %w", err)
+	}
+
+	return nil
+}
+
+// querier is a connection or a transaction
+type querier interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+// appliedMigrations returns statuses of applied migrations by versions
+func appliedMigrations(ctx context.Context, q querier) (map[int]Status, error) {
+	rows, err := q.QueryContext(ctx, "SELECT version, name, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute query: %w", err)
+	}
+	defer rows.Close()
+
+	applied := make(map[int]Status)
+
+	for rows.Next() {
+		var s Status
+
+		if err := rows.Scan(&s.Version, &s.Name, &s.AppliedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+
+		applied[s.Version] = s
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("row iteration error: %w", err)
+	}
+
+	return applied, nil
+}
+
+func inTx(ctx context.Context, conn *sql.Conn, f func(tx *sql.Tx) error) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := f(tx); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,73 @@
+package migrate
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func file(content string) *fstest.MapFile {
+	return &fstest.MapFile{Data: []byte(content)}
+}
+
+func TestLoad(t *testing.T) {
+	migrations, err := Load(fstest.MapFS{
+		"0002_files.up.sql":   file("CREATE TABLE files();"),
+		"0002_files.down.sql": file("DROP TABLE files;"),
+		"0001_init.up.sql":    file("CREATE TABLE users();"),
+		"0001_init.down.sql":  file("DROP TABLE users;"),
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, []Migration{
+		{Version: 1, Name: "init", Up: "CREATE TABLE users();", Down: "DROP TABLE users;"},
+		{Version: 2, Name: "files", Up: "CREATE TABLE files();", Down: "DROP TABLE files;"},
+	}, migrations)
+}
+
+func TestLoad_Invalid(t *testing.T) {
+	tests := []struct {
+		name  string
+		files fstest.MapFS
+	}{
+		{
+			name: "gap",
+			files: fstest.MapFS{
+				"0001_init.up.sql":    file("up"),
+				"0001_init.down.sql":  file("down"),
+				"0003_files.up.sql":   file("up"),
+				"0003_files.down.sql": file("down"),
+			},
+		},
+		{
+			name: "no down",
+			files: fstest.MapFS{
+				"0001_init.up.sql": file("up"),
+			},
+		},
+		{
+			name: "different names",
+			files: fstest.MapFS{
+				"0001_init.up.sql":    file("up"),
+				"0001_users.down.sql": file("down"),
+			},
+		},
+		{
+			name: "unexpected file",
+			files: fstest.MapFS{
+				"0001_init.up.sql":   file("up"),
+				"0001_init.down.sql": file("down"),
+				"README.md":          file("readme"),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Load(tt.files)
+			assert.Error(t, err)
+		})
+	}
+}
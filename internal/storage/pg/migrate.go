@@ -0,0 +1,48 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+
+	"github.com/nglmq/password-keeper/internal/storage/migrate"
+)
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// migrationsLockID is the key of the advisory lock taken while migrations are applied
+const migrationsLockID = 7351203548120
+
+// Migrator returns Migrator of the database with embedded migrations
+func (s *Storage) Migrator() *migrate.Migrator {
+	return migrate.New(s.db, mustLoadMigrations(), lockMigrations)
+}
+
+func mustLoadMigrations() []migrate.Migration {
+	dir, err := fs.Sub(migrationFiles, "migrations")
+	if err != nil {
+		panic(err)
+	}
+
+	migrations, err := migrate.Load(dir)
+	if err != nil {
+		panic(err)
+	}
+
+	return migrations
+}
+
+// lockMigrations takes the advisory lock, so servers started at once apply migrations one after another
+func lockMigrations(ctx context.Context, conn *sql.Conn) (func() error, error) {
+	if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", migrationsLockID); err != nil {
+		return nil, fmt.Errorf("failed to take advisory lock: %w", err)
+	}
+
+	return func() error {
+		_, err := conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", migrationsLockID)
+		return err
+	}, nil
+}
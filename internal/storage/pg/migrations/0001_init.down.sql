@@ -0,0 +1,11 @@
+DROP TABLE IF EXISTS srp_sessions;
+DROP TABLE IF EXISTS email_tokens;
+DROP TABLE IF EXISTS login_attempts;
+DROP TABLE IF EXISTS login_challenges;
+DROP TABLE IF EXISTS recovery_codes;
+DROP TABLE IF EXISTS users_totp;
+DROP TABLE IF EXISTS revoked_tokens;
+DROP TABLE IF EXISTS refresh_tokens;
+DROP TABLE IF EXISTS users_keys;
+DROP TABLE IF EXISTS users_data;
+DROP TABLE IF EXISTS users;
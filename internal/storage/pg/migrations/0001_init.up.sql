@@ -0,0 +1,92 @@
+-- Tables of databases created before migrations exist already, so the statements are idempotent.
+
+CREATE TABLE IF NOT EXISTS users(
+    id SERIAL PRIMARY KEY,
+    email TEXT NOT NULL UNIQUE,
+    passHash TEXT NOT NULL,
+    deleted BOOLEAN NOT NULL DEFAULT false,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP);
+CREATE INDEX IF NOT EXISTS idx_email ON users(email);
+
+CREATE TABLE IF NOT EXISTS users_data(
+    id SERIAL PRIMARY KEY,
+    user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    data_type TEXT NOT NULL,
+    data JSONB NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    deleted BOOLEAN DEFAULT false);
+
+CREATE TABLE IF NOT EXISTS users_keys(
+    user_id INT PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    data_key TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP);
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS vault_salt BYTEA;
+ALTER TABLE users_keys ADD COLUMN IF NOT EXISTS key_id BIGINT;
+
+CREATE TABLE IF NOT EXISTS refresh_tokens(
+    token_hash TEXT PRIMARY KEY,
+    user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    family_id TEXT NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    used_at TIMESTAMP,
+    revoked_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP);
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_family ON refresh_tokens(family_id);
+
+CREATE TABLE IF NOT EXISTS revoked_tokens(
+    jti TEXT PRIMARY KEY,
+    user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    expires_at TIMESTAMP NOT NULL);
+ALTER TABLE users ADD COLUMN IF NOT EXISTS tokens_valid_after TIMESTAMP;
+
+CREATE TABLE IF NOT EXISTS users_totp(
+    user_id INT PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    secret TEXT NOT NULL,
+    enabled BOOLEAN NOT NULL DEFAULT false,
+    last_step BIGINT,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP);
+
+CREATE TABLE IF NOT EXISTS recovery_codes(
+    user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    code_hash TEXT NOT NULL,
+    used_at TIMESTAMP,
+    PRIMARY KEY (user_id, code_hash));
+
+CREATE TABLE IF NOT EXISTS login_challenges(
+    challenge_hash TEXT PRIMARY KEY,
+    user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    expires_at TIMESTAMP NOT NULL,
+    attempts INT NOT NULL DEFAULT 0);
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP;
+
+CREATE TABLE IF NOT EXISTS login_attempts(
+    attempt_key TEXT PRIMARY KEY,
+    failures INT NOT NULL,
+    last_failure_at TIMESTAMP NOT NULL,
+    blocked_until TIMESTAMP);
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS email_verified BOOLEAN NOT NULL DEFAULT false;
+
+CREATE TABLE IF NOT EXISTS email_tokens(
+    token_hash TEXT PRIMARY KEY,
+    user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    purpose TEXT NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    used_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL);
+CREATE INDEX IF NOT EXISTS idx_email_tokens_user ON email_tokens(user_id, purpose);
+
+ALTER TABLE users_data ADD COLUMN IF NOT EXISTS updated_at TIMESTAMP;
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS srp_salt BYTEA;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS srp_params TEXT;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS srp_verifier BYTEA;
+
+CREATE TABLE IF NOT EXISTS srp_sessions(
+    session_hash TEXT PRIMARY KEY,
+    user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    client_public BYTEA NOT NULL,
+    server_secret TEXT NOT NULL,
+    expires_at TIMESTAMP NOT NULL);
@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS files(
+    id BIGSERIAL PRIMARY KEY,
+    user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    record_id INT NOT NULL REFERENCES users_data(id) ON DELETE CASCADE,
+    meta TEXT NOT NULL,
+    chunk_count INT NOT NULL,
+    uploaded_chunks INT NOT NULL DEFAULT 0,
+    created_at TIMESTAMP NOT NULL,
+    completed_at TIMESTAMP);
+CREATE INDEX IF NOT EXISTS idx_files_user ON files(user_id);
+
+CREATE TABLE IF NOT EXISTS file_chunks(
+    file_id BIGINT NOT NULL REFERENCES files(id) ON DELETE CASCADE,
+    idx INT NOT NULL,
+    data BYTEA NOT NULL,
+    checksum BYTEA NOT NULL,
+    PRIMARY KEY (file_id, idx));
@@ -0,0 +1,4 @@
+-- Fails while chunks are kept in the blob store, they have no data in the database.
+ALTER TABLE file_chunks ALTER COLUMN data SET NOT NULL;
+
+DROP TABLE IF EXISTS blobs;
@@ -0,0 +1,6 @@
+-- Chunks are kept in the blob store, data is left only in chunks uploaded before it.
+ALTER TABLE file_chunks ALTER COLUMN data DROP NOT NULL;
+
+CREATE TABLE IF NOT EXISTS blobs(
+    key TEXT PRIMARY KEY,
+    data BYTEA NOT NULL);
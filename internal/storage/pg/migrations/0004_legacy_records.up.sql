@@ -0,0 +1,5 @@
+-- Records written before they were bound to their owner, ID and type are marked, they are not returned
+-- until keeper-admin reseal-records seals them again.
+ALTER TABLE users_data ADD COLUMN IF NOT EXISTS legacy BOOLEAN NOT NULL DEFAULT false;
+
+UPDATE users_data SET legacy = true;
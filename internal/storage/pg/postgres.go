@@ -6,56 +6,59 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"github.com/jackc/pgx/v5/pgconn"
 	_ "github.com/jackc/pgx/v5/stdlib"
-	"github.com/lib/pq"
 	"github.com/nglmq/password-keeper/internal/domain/models"
 	"github.com/nglmq/password-keeper/internal/storage"
+	"time"
 )
 
 type Storage struct {
 	db *sql.DB
 }
 
+// New opens the database and applies pending migrations, it fails if the schema is newer than the server
 func New(storagePath string) (*Storage, error) {
-	db, err := sql.Open("pgx", storagePath)
+	s, err := Open(storagePath)
 	if err != nil {
-		return nil, fmt.Errorf("failed to open connection: %w", err)
+		return nil, err
+	}
+
+	if _, err := s.Migrator().Up(context.Background()); err != nil {
+		s.Close()
+		return nil, fmt.Errorf("failed to migrate database: %w", err)
 	}
 
-	_, err = db.Exec(`
-		CREATE TABLE IF NOT EXISTS users(
- 		id SERIAL PRIMARY KEY,
- 		email TEXT NOT NULL UNIQUE,
-		passHash TEXT NOT NULL,
-		deleted BOOLEAN NOT NULL DEFAULT false,
-		created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP);
-		CREATE INDEX IF NOT EXISTS idx_email ON users(email);
-
-		CREATE TABLE IF NOT EXISTS users_data(
-     	id SERIAL PRIMARY KEY,
-     	user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
-     	data_type TEXT NOT NULL,
-     	data JSONB NOT NULL,
-     	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-     	deleted BOOLEAN DEFAULT false);
-	`)
+	return s, nil
+}
+
+// Open opens the database as is, the schema is changed with Migrator
+func Open(storagePath string) (*Storage, error) {
+	db, err := sql.Open("pgx", storagePath)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("failed to open connection: %w", err)
 	}
 
 	return &Storage{db: db}, nil
 }
 
-func (s *Storage) SaveUser(ctx context.Context, email string, passHash []byte) (models.User, error) {
-	stmt, err := s.db.Prepare("INSERT INTO users(email, passHash) VALUES ($1, $2)")
+func (s *Storage) Close() error {
+	return s.db.Close()
+}
+
+// SaveUser saves a new user with SRP verifier of the password, no password hash is stored
+func (s *Storage) SaveUser(ctx context.Context, email string, verifier models.SRPVerifier) (models.User, error) {
+	stmt, err := s.db.Prepare(`
+		INSERT INTO users(email, passHash, srp_salt, srp_params, srp_verifier) VALUES ($1, '', $2, $3, $4)`)
 	if err != nil {
 		return models.User{}, fmt.Errorf("failed to prepare statement: %w", err)
 	}
 
-	_, err = stmt.ExecContext(ctx, email, passHash)
+	_, err = stmt.ExecContext(ctx, email, verifier.Salt, verifier.Params, verifier.Verifier)
 	if err != nil {
-		var pgErr *pq.Error
+		var pgErr *pgconn.PgError
 
+		// unique_violation
 		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
 			return models.User{}, storage.ErrUserExists
 		}
@@ -72,16 +75,65 @@ func (s *Storage) SaveUser(ctx context.Context, email string, passHash []byte) (
 }
 
 func (s *Storage) User(ctx context.Context, email string) (models.User, error) {
-	stmt, err := s.db.Prepare("SELECT id, email, passHash FROM users WHERE email = $1")
+	stmt, err := s.db.Prepare(`
+		SELECT id, email, passHash, srp_salt, srp_params, srp_verifier, email_verified, deleted, deleted_at
+		FROM users WHERE email = $1`)
 	if err != nil {
 		return models.User{}, fmt.Errorf("failed to prepare statement: %w", err)
 	}
 
-	row := stmt.QueryRowContext(ctx, email)
+	user, err := scanUser(stmt.QueryRowContext(ctx, email))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.User{}, fmt.Errorf("user not found: %w", storage.ErrUserNotFound)
+		}
+
+		return models.User{}, fmt.Errorf("failed to execute statement: %w", err)
+	}
+
+	return user, nil
+}
 
+// scanUser scans user selected by User and UserByID
+func scanUser(row *sql.Row) (models.User, error) {
 	var user models.User
+	var srpParams sql.NullString
+	var deletedAt sql.NullTime
+
+	err := row.Scan(&user.ID, &user.Email, &user.PassHash, &user.SRP.Salt, &srpParams, &user.SRP.Verifier,
+		&user.EmailVerified, &user.Deleted, &deletedAt)
+	if err != nil {
+		return models.User{}, err
+	}
+
+	user.SRP.Params = srpParams.String
+	user.DeletedAt = deletedAt.Time
+
+	return user, nil
+}
+
+// NextDataID reserves ID for a new data record
+func (s *Storage) NextDataID(ctx context.Context) (int64, error) {
+	var id int64
+
+	err := s.db.QueryRowContext(ctx, "SELECT nextval(pg_get_serial_sequence('users_data', 'id'))").Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("failed to execute query: %w", err)
+	}
+
+	return id, nil
+}
+
+// UserByID returns user by ID
+func (s *Storage) UserByID(ctx context.Context, id int64) (models.User, error) {
+	stmt, err := s.db.Prepare(`
+		SELECT id, email, passHash, srp_salt, srp_params, srp_verifier, email_verified, deleted, deleted_at
+		FROM users WHERE id = $1`)
+	if err != nil {
+		return models.User{}, fmt.Errorf("failed to prepare statement: %w", err)
+	}
 
-	err = row.Scan(&user.ID, &user.Email, &user.PassHash)
+	user, err := scanUser(stmt.QueryRowContext(ctx, id))
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return models.User{}, fmt.Errorf("user not found: %w", storage.ErrUserNotFound)
@@ -93,27 +145,77 @@ func (s *Storage) User(ctx context.Context, email string) (models.User, error) {
 	return user, nil
 }
 
-func (s *Storage) SaveData(ctx context.Context, userID int64, dataType string, data string) error {
-	stmt, err := s.db.Prepare("INSERT INTO users_data(user_id, data_type, data) VALUES ($1, $2, $3)")
+// SaveData saves a new record of the user with ID reserved by NextDataID
+func (s *Storage) SaveData(ctx context.Context, userID int64, record models.Data) error {
+	stmt, err := s.db.Prepare(`
+		INSERT INTO users_data(id, user_id, data_type, data, created_at, updated_at) VALUES ($1, $2, $3, $4, $5, $6)`)
 	if err != nil {
 		return fmt.Errorf("failed to prepare statement: %w", err)
 	}
 
-	dataJSON, err := json.Marshal(data)
+	dataJSON, err := json.Marshal(record.Content)
+	if err != nil {
+		return fmt.Errorf("failed to marshal data: %w", err)
+	}
+
+	_, err = stmt.ExecContext(ctx, record.ID, userID, record.DataType, dataJSON, record.CreatedAt.UTC(), record.UpdatedAt.UTC())
+	if err != nil {
+		return fmt.Errorf("failed to execute statement: %w", err)
+	}
+
+	return nil
+}
+
+// UpdateData replaces type and content of the record of the user, ErrDataNotFound is returned
+// if the user has no record with the ID
+func (s *Storage) UpdateData(ctx context.Context, userID int64, record models.Data) error {
+	dataJSON, err := json.Marshal(record.Content)
 	if err != nil {
 		return fmt.Errorf("failed to marshal data: %w", err)
 	}
 
-	_, err = stmt.ExecContext(ctx, userID, dataType, dataJSON)
+	res, err := s.db.ExecContext(ctx, `
+		UPDATE users_data SET data_type = $3, data = $4, updated_at = $5, legacy = false
+		WHERE id = $1 AND user_id = $2`, record.ID, userID, record.DataType, dataJSON, record.UpdatedAt.UTC())
+	if err != nil {
+		return fmt.Errorf("failed to execute statement: %w", err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get affected rows: %w", err)
+	}
+
+	if n == 0 {
+		return storage.ErrDataNotFound
+	}
+
+	return nil
+}
+
+// DeleteData deletes the record of the user, ErrDataNotFound is returned if the user has no record with the ID
+func (s *Storage) DeleteData(ctx context.Context, userID, id int64) error {
+	res, err := s.db.ExecContext(ctx, "DELETE FROM users_data WHERE id = $1 AND user_id = $2", id, userID)
 	if err != nil {
 		return fmt.Errorf("failed to execute statement: %w", err)
 	}
 
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get affected rows: %w", err)
+	}
+
+	if n == 0 {
+		return storage.ErrDataNotFound
+	}
+
 	return nil
 }
 
 func (s *Storage) GetData(ctx context.Context, userID int64) ([]models.Data, error) {
-	rows, err := s.db.QueryContext(ctx, "SELECT data_type, data FROM users_data WHERE user_id = $1", userID)
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, data_type, data, created_at, COALESCE(updated_at, created_at), legacy
+		FROM users_data WHERE user_id = $1 ORDER BY id`, userID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to execute query: %w", err)
 	}
@@ -124,7 +226,7 @@ func (s *Storage) GetData(ctx context.Context, userID int64) ([]models.Data, err
 	for rows.Next() {
 		var data models.Data
 
-		err := rows.Scan(&data.DataType, &data.Content)
+		err := rows.Scan(&data.ID, &data.DataType, &data.Content, &data.CreatedAt, &data.UpdatedAt, &data.Legacy)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan row: %w", err)
 		}
@@ -140,5 +242,1310 @@ func (s *Storage) GetData(ctx context.Context, userID int64) ([]models.Data, err
 		return nil, storage.ErrDataNotFound
 	}
 
+	files, err := s.files(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range allData {
+		allData[i].Files = files[allData[i].ID]
+	}
+
 	return allData, nil
 }
+
+// LegacyRecords returns records sealed before they were bound to their owner, ID and type
+// with IDs greater than afterID ordered by ID
+func (s *Storage) LegacyRecords(ctx context.Context, afterID int64, limit int) ([]models.LegacyRecord, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, user_id, data_type, data FROM users_data
+		WHERE legacy AND id > $1 ORDER BY id LIMIT $2`, afterID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute query: %w", err)
+	}
+	defer rows.Close()
+
+	var records []models.LegacyRecord
+
+	for rows.Next() {
+		var r models.LegacyRecord
+
+		if err := rows.Scan(&r.ID, &r.UserID, &r.DataType, &r.Content); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+
+		records = append(records, r)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("row iteration error: %w", err)
+	}
+
+	return records, nil
+}
+
+// ResealRecord replaces content of the legacy record with the sealed one, ErrDataNotFound is returned
+// if the record was deleted or changed meanwhile
+func (s *Storage) ResealRecord(ctx context.Context, userID, id int64, content string) error {
+	dataJSON, err := json.Marshal(content)
+	if err != nil {
+		return fmt.Errorf("failed to marshal data: %w", err)
+	}
+
+	res, err := s.db.ExecContext(ctx, `
+		UPDATE users_data SET data = $3, legacy = false
+		WHERE id = $1 AND user_id = $2 AND legacy`, id, userID, dataJSON)
+	if err != nil {
+		return fmt.Errorf("failed to execute statement: %w", err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get affected rows: %w", err)
+	}
+
+	if n == 0 {
+		return storage.ErrDataNotFound
+	}
+
+	return nil
+}
+
+// files returns files of the user by IDs of records they are attached to
+func (s *Storage) files(ctx context.Context, userID int64) (map[int64][]models.File, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, record_id, meta, chunk_count, uploaded_chunks, created_at, completed_at
+		FROM files WHERE user_id = $1 ORDER BY id`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute query: %w", err)
+	}
+	defer rows.Close()
+
+	files := make(map[int64][]models.File)
+
+	for rows.Next() {
+		file, err := scanFile(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+
+		files[file.RecordID] = append(files[file.RecordID], file)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("row iteration error: %w", err)
+	}
+
+	return files, nil
+}
+
+// NextFileID reserves ID for a new file
+func (s *Storage) NextFileID(ctx context.Context) (int64, error) {
+	var id int64
+
+	err := s.db.QueryRowContext(ctx, "SELECT nextval(pg_get_serial_sequence('files', 'id'))").Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("failed to execute query: %w", err)
+	}
+
+	return id, nil
+}
+
+// SaveFile saves a new file of the user with ID reserved by NextFileID and no chunks uploaded,
+// ErrDataNotFound is returned if the user has no record the file is attached to
+func (s *Storage) SaveFile(ctx context.Context, userID int64, file models.File) error {
+	res, err := s.db.ExecContext(ctx, `
+		INSERT INTO files(id, user_id, record_id, meta, chunk_count, created_at)
+		SELECT $1, user_id, id, $4, $5, $6 FROM users_data WHERE id = $3 AND user_id = $2`,
+		file.ID, userID, file.RecordID, file.Meta, file.ChunkCount, file.CreatedAt.UTC())
+	if err != nil {
+		return fmt.Errorf("failed to execute statement: %w", err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get affected rows: %w", err)
+	}
+
+	if n == 0 {
+		return storage.ErrDataNotFound
+	}
+
+	return nil
+}
+
+// File returns the file of the user, ErrFileNotFound is returned if the user has no file with the ID
+func (s *Storage) File(ctx context.Context, userID, id int64) (models.File, error) {
+	file, err := scanFile(s.db.QueryRowContext(ctx, `
+		SELECT id, record_id, meta, chunk_count, uploaded_chunks, created_at, completed_at
+		FROM files WHERE id = $1 AND user_id = $2`, id, userID))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.File{}, storage.ErrFileNotFound
+		}
+
+		return models.File{}, fmt.Errorf("failed to execute statement: %w", err)
+	}
+
+	return file, nil
+}
+
+// SaveFileChunk saves the next chunk of the file of the user and returns the file with the chunk counted,
+// the upload is completed at uploadedAt with the last chunk. ErrFileNotFound is returned if the user has
+// no file with the ID, ErrChunkOutOfOrder with the file as is if the chunk is not the next one.
+func (s *Storage) SaveFileChunk(
+	ctx context.Context,
+	userID int64,
+	chunk models.FileChunk,
+	uploadedAt time.Time,
+) (models.File, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return models.File{}, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// the lock keeps concurrent uploads of the same file from saving the same chunk
+	file, err := scanFile(tx.QueryRowContext(ctx, `
+		SELECT id, record_id, meta, chunk_count, uploaded_chunks, created_at, completed_at
+		FROM files WHERE id = $1 AND user_id = $2 FOR UPDATE`, chunk.FileID, userID))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.File{}, storage.ErrFileNotFound
+		}
+
+		return models.File{}, fmt.Errorf("failed to execute statement: %w", err)
+	}
+
+	if chunk.Index != file.UploadedChunks || file.Completed() {
+		return file, storage.ErrChunkOutOfOrder
+	}
+
+	_, err = tx.ExecContext(ctx, "INSERT INTO file_chunks(file_id, idx, data, checksum) VALUES ($1, $2, $3, $4)",
+		chunk.FileID, chunk.Index, chunk.Data, chunk.Checksum)
+	if err != nil {
+		return models.File{}, fmt.Errorf("failed to execute statement: %w", err)
+	}
+
+	file.UploadedChunks++
+	if file.Completed() {
+		file.CompletedAt = uploadedAt.UTC()
+	}
+
+	_, err = tx.ExecContext(ctx, "UPDATE files SET uploaded_chunks = $2, completed_at = $3 WHERE id = $1",
+		file.ID, file.UploadedChunks, sql.NullTime{Time: file.CompletedAt, Valid: file.Completed()})
+	if err != nil {
+		return models.File{}, fmt.Errorf("failed to execute statement: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return models.File{}, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return file, nil
+}
+
+// FileChunk returns the chunk of the file, ErrFileNotFound is returned if the chunk is not uploaded.
+// Data of chunks kept in the blob store is nil.
+func (s *Storage) FileChunk(ctx context.Context, fileID int64, index int) (models.FileChunk, error) {
+	chunk := models.FileChunk{FileID: fileID, Index: index}
+
+	err := s.db.QueryRowContext(ctx, "SELECT data, checksum FROM file_chunks WHERE file_id = $1 AND idx = $2",
+		fileID, index).Scan(&chunk.Data, &chunk.Checksum)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.FileChunk{}, storage.ErrFileNotFound
+		}
+
+		return models.FileChunk{}, fmt.Errorf("failed to execute statement: %w", err)
+	}
+
+	return chunk, nil
+}
+
+// ExistingFiles returns those of the IDs that belong to files not deleted yet
+func (s *Storage) ExistingFiles(ctx context.Context, ids []int64) (map[int64]bool, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT id FROM files WHERE id = ANY($1)", ids)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute query: %w", err)
+	}
+	defer rows.Close()
+
+	existing := make(map[int64]bool)
+
+	for rows.Next() {
+		var id int64
+
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+
+		existing[id] = true
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("row iteration error: %w", err)
+	}
+
+	return existing, nil
+}
+
+// Blobs returns the blob store keeping blobs in the blobs table of the database
+func (s *Storage) Blobs() *Blobs {
+	return &Blobs{db: s.db}
+}
+
+// Blobs is storage.BlobStore in the database, it needs no other service but grows the database
+type Blobs struct {
+	db *sql.DB
+}
+
+func (b *Blobs) Put(ctx context.Context, key string, data []byte) error {
+	_, err := b.db.ExecContext(ctx, `
+		INSERT INTO blobs(key, data) VALUES ($1, $2) ON CONFLICT (key) DO UPDATE SET data = EXCLUDED.data`,
+		key, data)
+	if err != nil {
+		return fmt.Errorf("failed to execute statement: %w", err)
+	}
+
+	return nil
+}
+
+func (b *Blobs) Get(ctx context.Context, key string) ([]byte, error) {
+	var data []byte
+
+	err := b.db.QueryRowContext(ctx, "SELECT data FROM blobs WHERE key = $1", key).Scan(&data)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, storage.ErrBlobNotFound
+		}
+
+		return nil, fmt.Errorf("failed to execute statement: %w", err)
+	}
+
+	return data, nil
+}
+
+func (b *Blobs) Delete(ctx context.Context, key string) error {
+	_, err := b.db.ExecContext(ctx, "DELETE FROM blobs WHERE key = $1", key)
+	if err != nil {
+		return fmt.Errorf("failed to execute statement: %w", err)
+	}
+
+	return nil
+}
+
+func (b *Blobs) List(ctx context.Context, prefix string) ([]string, error) {
+	rows, err := b.db.QueryContext(ctx, "SELECT key FROM blobs WHERE starts_with(key, $1) ORDER BY key", prefix)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute query: %w", err)
+	}
+	defer rows.Close()
+
+	var keys []string
+
+	for rows.Next() {
+		var key string
+
+		if err := rows.Scan(&key); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+
+		keys = append(keys, key)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("row iteration error: %w", err)
+	}
+
+	return keys, nil
+}
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanFile(row rowScanner) (models.File, error) {
+	var (
+		file        models.File
+		completedAt sql.NullTime
+	)
+
+	err := row.Scan(&file.ID, &file.RecordID, &file.Meta, &file.ChunkCount, &file.UploadedChunks,
+		&file.CreatedAt, &completedAt)
+	if err != nil {
+		return models.File{}, err
+	}
+
+	file.CompletedAt = completedAt.Time
+
+	return file, nil
+}
+
+// SaveUserKey saves wrapped data key of the user, an already saved key is kept as is
+func (s *Storage) SaveUserKey(ctx context.Context, userID int64, wrappedKey string, keyID uint32) error {
+	stmt, err := s.db.Prepare("INSERT INTO users_keys(user_id, data_key, key_id) VALUES ($1, $2, $3) ON CONFLICT (user_id) DO NOTHING")
+	if err != nil {
+		return fmt.Errorf("failed to prepare statement: %w", err)
+	}
+
+	_, err = stmt.ExecContext(ctx, userID, wrappedKey, int64(keyID))
+	if err != nil {
+		return fmt.Errorf("failed to execute statement: %w", err)
+	}
+
+	return nil
+}
+
+// UserKey returns wrapped data key of the user
+func (s *Storage) UserKey(ctx context.Context, userID int64) (string, error) {
+	stmt, err := s.db.Prepare("SELECT data_key FROM users_keys WHERE user_id = $1")
+	if err != nil {
+		return "", fmt.Errorf("failed to prepare statement: %w", err)
+	}
+
+	var wrappedKey string
+
+	err = stmt.QueryRowContext(ctx, userID).Scan(&wrappedKey)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", storage.ErrKeyNotFound
+		}
+
+		return "", fmt.Errorf("failed to execute statement: %w", err)
+	}
+
+	return wrappedKey, nil
+}
+
+// UserKeysToRewrap returns up to limit data keys wrapped not by the master key keyID, ordered by user ID after afterUserID
+func (s *Storage) UserKeysToRewrap(ctx context.Context, keyID uint32, afterUserID int64, limit int) ([]models.UserKey, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT user_id, data_key FROM users_keys
+		WHERE key_id IS DISTINCT FROM $1 AND user_id > $2
+		ORDER BY user_id
+		LIMIT $3`, int64(keyID), afterUserID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute query: %w", err)
+	}
+	defer rows.Close()
+
+	var keys []models.UserKey
+
+	for rows.Next() {
+		var key models.UserKey
+
+		if err := rows.Scan(&key.UserID, &key.WrappedKey); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+
+		keys = append(keys, key)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("row iteration error: %w", err)
+	}
+
+	return keys, nil
+}
+
+// UpdateUserKey replaces wrapped data key of the user if it was not changed since oldWrappedKey was read
+func (s *Storage) UpdateUserKey(ctx context.Context, userID int64, oldWrappedKey, wrappedKey string, keyID uint32) error {
+	stmt, err := s.db.Prepare("UPDATE users_keys SET data_key = $3, key_id = $4 WHERE user_id = $1 AND data_key = $2")
+	if err != nil {
+		return fmt.Errorf("failed to prepare statement: %w", err)
+	}
+
+	res, err := stmt.ExecContext(ctx, userID, oldWrappedKey, wrappedKey, int64(keyID))
+	if err != nil {
+		return fmt.Errorf("failed to execute statement: %w", err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get affected rows: %w", err)
+	}
+
+	if n == 0 {
+		return storage.ErrKeyNotFound
+	}
+
+	return nil
+}
+
+// SaveVaultSalt saves salt of the user vault key, an already saved salt is kept as is
+func (s *Storage) SaveVaultSalt(ctx context.Context, userID int64, salt []byte) error {
+	stmt, err := s.db.Prepare("UPDATE users SET vault_salt = $2 WHERE id = $1 AND vault_salt IS NULL")
+	if err != nil {
+		return fmt.Errorf("failed to prepare statement: %w", err)
+	}
+
+	_, err = stmt.ExecContext(ctx, userID, salt)
+	if err != nil {
+		return fmt.Errorf("failed to execute statement: %w", err)
+	}
+
+	return nil
+}
+
+// VaultSalt returns salt of the user vault key
+func (s *Storage) VaultSalt(ctx context.Context, userID int64) ([]byte, error) {
+	stmt, err := s.db.Prepare("SELECT vault_salt FROM users WHERE id = $1")
+	if err != nil {
+		return nil, fmt.Errorf("failed to prepare statement: %w", err)
+	}
+
+	var salt []byte
+
+	err = stmt.QueryRowContext(ctx, userID).Scan(&salt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, storage.ErrUserNotFound
+		}
+
+		return nil, fmt.Errorf("failed to execute statement: %w", err)
+	}
+
+	if len(salt) == 0 {
+		return nil, storage.ErrSaltNotFound
+	}
+
+	return salt, nil
+}
+
+// SaveRefreshToken saves hash of the issued refresh token
+func (s *Storage) SaveRefreshToken(ctx context.Context, token models.RefreshToken) error {
+	stmt, err := s.db.Prepare("INSERT INTO refresh_tokens(token_hash, user_id, family_id, expires_at) VALUES ($1, $2, $3, $4)")
+	if err != nil {
+		return fmt.Errorf("failed to prepare statement: %w", err)
+	}
+
+	_, err = stmt.ExecContext(ctx, token.Hash, token.UserID, token.FamilyID, token.ExpiresAt.UTC())
+	if err != nil {
+		return fmt.Errorf("failed to execute statement: %w", err)
+	}
+
+	return nil
+}
+
+// UseRefreshToken marks refresh token as used and returns it.
+// ErrTokenReused is returned together with the token if it was already used.
+func (s *Storage) UseRefreshToken(ctx context.Context, hash string) (models.RefreshToken, error) {
+	token := models.RefreshToken{Hash: hash}
+
+	err := s.db.QueryRowContext(ctx, `
+		UPDATE refresh_tokens SET used_at = now()
+		WHERE token_hash = $1 AND used_at IS NULL AND revoked_at IS NULL
+		RETURNING user_id, family_id, expires_at`, hash).Scan(&token.UserID, &token.FamilyID, &token.ExpiresAt)
+	if err == nil {
+		return token, nil
+	}
+
+	if !errors.Is(err, sql.ErrNoRows) {
+		return models.RefreshToken{}, fmt.Errorf("failed to execute statement: %w", err)
+	}
+
+	var usedAt, revokedAt sql.NullTime
+
+	err = s.db.QueryRowContext(ctx, `
+		SELECT user_id, family_id, expires_at, used_at, revoked_at FROM refresh_tokens
+		WHERE token_hash = $1`, hash).Scan(&token.UserID, &token.FamilyID, &token.ExpiresAt, &usedAt, &revokedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.RefreshToken{}, storage.ErrTokenNotFound
+		}
+
+		return models.RefreshToken{}, fmt.Errorf("failed to execute statement: %w", err)
+	}
+
+	if revokedAt.Valid {
+		return token, storage.ErrTokenRevoked
+	}
+
+	return token, storage.ErrTokenReused
+}
+
+// RevokeTokenFamily revokes all refresh tokens of the family
+func (s *Storage) RevokeTokenFamily(ctx context.Context, familyID string) error {
+	stmt, err := s.db.Prepare("UPDATE refresh_tokens SET revoked_at = now() WHERE family_id = $1 AND revoked_at IS NULL")
+	if err != nil {
+		return fmt.Errorf("failed to prepare statement: %w", err)
+	}
+
+	_, err = stmt.ExecContext(ctx, familyID)
+	if err != nil {
+		return fmt.Errorf("failed to execute statement: %w", err)
+	}
+
+	return nil
+}
+
+// RefreshToken returns refresh token by hash
+func (s *Storage) RefreshToken(ctx context.Context, hash string) (models.RefreshToken, error) {
+	token := models.RefreshToken{Hash: hash}
+
+	err := s.db.QueryRowContext(ctx, "SELECT user_id, family_id, expires_at FROM refresh_tokens WHERE token_hash = $1", hash).
+		Scan(&token.UserID, &token.FamilyID, &token.ExpiresAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.RefreshToken{}, storage.ErrTokenNotFound
+		}
+
+		return models.RefreshToken{}, fmt.Errorf("failed to execute statement: %w", err)
+	}
+
+	return token, nil
+}
+
+// RevokeToken adds access token to the revocation list until it expires
+func (s *Storage) RevokeToken(ctx context.Context, jti string, userID int64, expiresAt time.Time) error {
+	stmt, err := s.db.Prepare("INSERT INTO revoked_tokens(jti, user_id, expires_at) VALUES ($1, $2, $3) ON CONFLICT (jti) DO NOTHING")
+	if err != nil {
+		return fmt.Errorf("failed to prepare statement: %w", err)
+	}
+
+	_, err = stmt.ExecContext(ctx, jti, userID, expiresAt.UTC())
+	if err != nil {
+		return fmt.Errorf("failed to execute statement: %w", err)
+	}
+
+	return nil
+}
+
+// RevokeUserTokens revokes all refresh tokens of the user and access tokens issued before the given time
+func (s *Storage) RevokeUserTokens(ctx context.Context, userID int64, before time.Time) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, "UPDATE users SET tokens_valid_after = $2 WHERE id = $1", userID, before.UTC())
+	if err != nil {
+		return fmt.Errorf("failed to execute statement: %w", err)
+	}
+
+	_, err = tx.ExecContext(ctx, "UPDATE refresh_tokens SET revoked_at = now() WHERE user_id = $1 AND revoked_at IS NULL", userID)
+	if err != nil {
+		return fmt.Errorf("failed to execute statement: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// IsTokenRevoked reports whether access token was revoked by itself or with all tokens of the user
+func (s *Storage) IsTokenRevoked(ctx context.Context, jti string, userID int64, issuedAt time.Time) (bool, error) {
+	var revoked bool
+
+	err := s.db.QueryRowContext(ctx, `
+		SELECT EXISTS(SELECT 1 FROM revoked_tokens WHERE jti = $1)
+		OR EXISTS(SELECT 1 FROM users WHERE id = $2 AND tokens_valid_after > $3)`,
+		jti, userID, issuedAt.UTC()).Scan(&revoked)
+	if err != nil {
+		return false, fmt.Errorf("failed to execute statement: %w", err)
+	}
+
+	return revoked, nil
+}
+
+// DeleteExpiredTokens deletes revoked access tokens and refresh tokens which are expired anyway
+func (s *Storage) DeleteExpiredTokens(ctx context.Context) (int64, error) {
+	var deleted int64
+
+	for _, query := range []string{
+		"DELETE FROM revoked_tokens WHERE expires_at < now() AT TIME ZONE 'UTC'",
+		"DELETE FROM refresh_tokens WHERE expires_at < now() AT TIME ZONE 'UTC'",
+		"DELETE FROM login_challenges WHERE expires_at < now() AT TIME ZONE 'UTC'",
+		"DELETE FROM email_tokens WHERE expires_at < now() AT TIME ZONE 'UTC'",
+		"DELETE FROM srp_sessions WHERE expires_at < now() AT TIME ZONE 'UTC'",
+	} {
+		res, err := s.db.ExecContext(ctx, query)
+		if err != nil {
+			return deleted, fmt.Errorf("failed to execute statement: %w", err)
+		}
+
+		n, err := res.RowsAffected()
+		if err != nil {
+			return deleted, fmt.Errorf("failed to get affected rows: %w", err)
+		}
+
+		deleted += n
+	}
+
+	return deleted, nil
+}
+
+// SaveTOTP saves not yet confirmed TOTP secret of the user replacing the previous unconfirmed one.
+// Secret of the user with enabled TOTP is not replaced.
+func (s *Storage) SaveTOTP(ctx context.Context, userID int64, secret string) error {
+	stmt, err := s.db.Prepare(`
+		INSERT INTO users_totp(user_id, secret) VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE SET secret = EXCLUDED.secret, last_step = NULL, created_at = now()
+		WHERE users_totp.enabled = false`)
+	if err != nil {
+		return fmt.Errorf("failed to prepare statement: %w", err)
+	}
+
+	_, err = stmt.ExecContext(ctx, userID, secret)
+	if err != nil {
+		return fmt.Errorf("failed to execute statement: %w", err)
+	}
+
+	return nil
+}
+
+// TOTP returns TOTP secret of the user
+func (s *Storage) TOTP(ctx context.Context, userID int64) (models.TOTP, error) {
+	totp := models.TOTP{UserID: userID}
+
+	err := s.db.QueryRowContext(ctx, "SELECT secret, enabled FROM users_totp WHERE user_id = $1", userID).
+		Scan(&totp.Secret, &totp.Enabled)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.TOTP{}, storage.ErrTOTPNotFound
+		}
+
+		return models.TOTP{}, fmt.Errorf("failed to execute statement: %w", err)
+	}
+
+	return totp, nil
+}
+
+// EnableTOTP enables confirmed TOTP secret, marks the confirming step as used
+// and replaces recovery codes of the user with the given hashes
+func (s *Storage) EnableTOTP(ctx context.Context, userID int64, step int64, recoveryHashes []string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, "UPDATE users_totp SET enabled = true, last_step = $2 WHERE user_id = $1 AND enabled = false", userID, step)
+	if err != nil {
+		return fmt.Errorf("failed to execute statement: %w", err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get affected rows: %w", err)
+	}
+
+	if n == 0 {
+		return storage.ErrTOTPNotFound
+	}
+
+	_, err = tx.ExecContext(ctx, "DELETE FROM recovery_codes WHERE user_id = $1", userID)
+	if err != nil {
+		return fmt.Errorf("failed to execute statement: %w", err)
+	}
+
+	for _, hash := range recoveryHashes {
+		_, err = tx.ExecContext(ctx, "INSERT INTO recovery_codes(user_id, code_hash) VALUES ($1, $2)", userID, hash)
+		if err != nil {
+			return fmt.Errorf("failed to execute statement: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// UseTOTPStep marks time step of the code as used, codes of the same or earlier steps can not be used again
+func (s *Storage) UseTOTPStep(ctx context.Context, userID int64, step int64) error {
+	res, err := s.db.ExecContext(ctx, `
+		UPDATE users_totp SET last_step = $2
+		WHERE user_id = $1 AND enabled = true AND (last_step IS NULL OR last_step < $2)`, userID, step)
+	if err != nil {
+		return fmt.Errorf("failed to execute statement: %w", err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get affected rows: %w", err)
+	}
+
+	if n == 0 {
+		return storage.ErrTOTPCodeUsed
+	}
+
+	return nil
+}
+
+// UseRecoveryCode marks recovery code of the user as used
+func (s *Storage) UseRecoveryCode(ctx context.Context, userID int64, hash string) error {
+	res, err := s.db.ExecContext(ctx, `
+		UPDATE recovery_codes SET used_at = now()
+		WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL`, userID, hash)
+	if err != nil {
+		return fmt.Errorf("failed to execute statement: %w", err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get affected rows: %w", err)
+	}
+
+	if n == 0 {
+		return storage.ErrRecoveryCodeNotFound
+	}
+
+	return nil
+}
+
+// SaveLoginChallenge saves hash of the issued login challenge
+func (s *Storage) SaveLoginChallenge(ctx context.Context, challenge models.LoginChallenge) error {
+	stmt, err := s.db.Prepare("INSERT INTO login_challenges(challenge_hash, user_id, expires_at) VALUES ($1, $2, $3)")
+	if err != nil {
+		return fmt.Errorf("failed to prepare statement: %w", err)
+	}
+
+	_, err = stmt.ExecContext(ctx, challenge.Hash, challenge.UserID, challenge.ExpiresAt.UTC())
+	if err != nil {
+		return fmt.Errorf("failed to execute statement: %w", err)
+	}
+
+	return nil
+}
+
+// UseLoginChallenge counts an attempt to complete the challenge and returns it.
+// ErrChallengeNotFound is returned if the challenge expired or has no attempts left.
+func (s *Storage) UseLoginChallenge(ctx context.Context, hash string, maxAttempts int) (models.LoginChallenge, error) {
+	challenge := models.LoginChallenge{Hash: hash}
+
+	err := s.db.QueryRowContext(ctx, `
+		UPDATE login_challenges SET attempts = attempts + 1
+		WHERE challenge_hash = $1 AND attempts < $2 AND expires_at > now() AT TIME ZONE 'UTC'
+		RETURNING user_id, expires_at`, hash, maxAttempts).Scan(&challenge.UserID, &challenge.ExpiresAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.LoginChallenge{}, storage.ErrChallengeNotFound
+		}
+
+		return models.LoginChallenge{}, fmt.Errorf("failed to execute statement: %w", err)
+	}
+
+	return challenge, nil
+}
+
+// DeleteLoginChallenge deletes completed login challenge,
+// ErrChallengeNotFound is returned if the challenge was already completed by a concurrent request
+func (s *Storage) DeleteLoginChallenge(ctx context.Context, hash string) error {
+	res, err := s.db.ExecContext(ctx, "DELETE FROM login_challenges WHERE challenge_hash = $1", hash)
+	if err != nil {
+		return fmt.Errorf("failed to execute statement: %w", err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get affected rows: %w", err)
+	}
+
+	if n == 0 {
+		return storage.ErrChallengeNotFound
+	}
+
+	return nil
+}
+
+// LoginAttempts returns failed login attempts of the key, zero attempts are returned for unknown keys
+func (s *Storage) LoginAttempts(ctx context.Context, key string) (models.LoginAttempts, error) {
+	attempts := models.LoginAttempts{Key: key}
+
+	var blockedUntil sql.NullTime
+
+	err := s.db.QueryRowContext(ctx, "SELECT failures, blocked_until FROM login_attempts WHERE attempt_key = $1", key).
+		Scan(&attempts.Failures, &blockedUntil)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return attempts, nil
+		}
+
+		return models.LoginAttempts{}, fmt.Errorf("failed to execute statement: %w", err)
+	}
+
+	if blockedUntil.Valid {
+		attempts.BlockedUntil = blockedUntil.Time
+	}
+
+	return attempts, nil
+}
+
+// RecordLoginFailure counts failed login attempt of the key and returns the number of failures in a row.
+// Failures are counted from scratch if the previous one was before resetBefore.
+func (s *Storage) RecordLoginFailure(ctx context.Context, key string, resetBefore time.Time) (int, error) {
+	var failures int
+
+	err := s.db.QueryRowContext(ctx, `
+		INSERT INTO login_attempts(attempt_key, failures, last_failure_at) VALUES ($1, 1, now() AT TIME ZONE 'UTC')
+		ON CONFLICT (attempt_key) DO UPDATE SET
+		failures = CASE WHEN login_attempts.last_failure_at < $2 THEN 1 ELSE login_attempts.failures + 1 END,
+		last_failure_at = EXCLUDED.last_failure_at
+		RETURNING failures`, key, resetBefore.UTC()).Scan(&failures)
+	if err != nil {
+		return 0, fmt.Errorf("failed to execute statement: %w", err)
+	}
+
+	return failures, nil
+}
+
+// BlockLogin rejects login attempts of the key until the given time
+func (s *Storage) BlockLogin(ctx context.Context, key string, until time.Time) error {
+	_, err := s.db.ExecContext(ctx, "UPDATE login_attempts SET blocked_until = $2 WHERE attempt_key = $1", key, until.UTC())
+	if err != nil {
+		return fmt.Errorf("failed to execute statement: %w", err)
+	}
+
+	return nil
+}
+
+// ResetLoginAttempts forgets failed login attempts of the key
+func (s *Storage) ResetLoginAttempts(ctx context.Context, key string) error {
+	_, err := s.db.ExecContext(ctx, "DELETE FROM login_attempts WHERE attempt_key = $1", key)
+	if err != nil {
+		return fmt.Errorf("failed to execute statement: %w", err)
+	}
+
+	return nil
+}
+
+// DeleteStaleLoginAttempts deletes not blocked attempts with the last failure before the given time
+func (s *Storage) DeleteStaleLoginAttempts(ctx context.Context, before time.Time) (int64, error) {
+	res, err := s.db.ExecContext(ctx, `
+		DELETE FROM login_attempts
+		WHERE last_failure_at < $1 AND (blocked_until IS NULL OR blocked_until < now() AT TIME ZONE 'UTC')`, before.UTC())
+	if err != nil {
+		return 0, fmt.Errorf("failed to execute statement: %w", err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get affected rows: %w", err)
+	}
+
+	return n, nil
+}
+
+// MigrateToVerifier replaces password hash of the user with SRP verifier if the hash is still oldHash
+func (s *Storage) MigrateToVerifier(ctx context.Context, userID int64, oldHash []byte, verifier models.SRPVerifier) error {
+	_, err := s.db.ExecContext(ctx, `
+		UPDATE users SET passHash = '', srp_salt = $3, srp_params = $4, srp_verifier = $5
+		WHERE id = $1 AND passHash = $2 AND srp_verifier IS NULL`,
+		userID, oldHash, verifier.Salt, verifier.Params, verifier.Verifier)
+	if err != nil {
+		return fmt.Errorf("failed to execute statement: %w", err)
+	}
+
+	return nil
+}
+
+// UpgradeVerifier replaces SRP verifier of the user derived with stale params if the verifier is still oldVerifier
+func (s *Storage) UpgradeVerifier(ctx context.Context, userID int64, oldVerifier []byte, verifier models.SRPVerifier) error {
+	_, err := s.db.ExecContext(ctx, `
+		UPDATE users SET srp_salt = $3, srp_params = $4, srp_verifier = $5
+		WHERE id = $1 AND srp_verifier = $2`,
+		userID, oldVerifier, verifier.Salt, verifier.Params, verifier.Verifier)
+	if err != nil {
+		return fmt.Errorf("failed to execute statement: %w", err)
+	}
+
+	return nil
+}
+
+// ChangePassword replaces SRP verifier, vault salt and content of all records of the user in one transaction
+// and revokes tokens issued before validAfter. Meta of files attached to records is replaced as well.
+// Records and their files must be exactly the ones the user has, otherwise ErrDataChanged is returned
+// and nothing is changed.
+func (s *Storage) ChangePassword(
+	ctx context.Context,
+	userID int64,
+	verifier models.SRPVerifier,
+	vaultSalt []byte,
+	records []models.Data,
+	validAfter time.Time,
+) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// FOR UPDATE conflicts with the key share lock taken by inserts of records and files,
+	// so records and files of the user can not be added until commit
+	_, err = tx.ExecContext(ctx, "SELECT id FROM users WHERE id = $1 FOR UPDATE", userID)
+	if err != nil {
+		return fmt.Errorf("failed to execute statement: %w", err)
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE users SET passHash = '', srp_salt = $2, srp_params = $3, srp_verifier = $4,
+		vault_salt = $5, tokens_valid_after = $6
+		WHERE id = $1`, userID, verifier.Salt, verifier.Params, verifier.Verifier, vaultSalt, validAfter.UTC())
+	if err != nil {
+		return fmt.Errorf("failed to execute statement: %w", err)
+	}
+
+	var count int
+
+	err = tx.QueryRowContext(ctx, "SELECT count(*) FROM users_data WHERE user_id = $1", userID).Scan(&count)
+	if err != nil {
+		return fmt.Errorf("failed to execute statement: %w", err)
+	}
+
+	if count != len(records) {
+		return storage.ErrDataChanged
+	}
+
+	for _, record := range records {
+		dataJSON, err := json.Marshal(record.Content)
+		if err != nil {
+			return fmt.Errorf("failed to marshal data: %w", err)
+		}
+
+		res, err := tx.ExecContext(ctx, "UPDATE users_data SET data = $3, legacy = false WHERE id = $1 AND user_id = $2",
+			record.ID, userID, dataJSON)
+		if err != nil {
+			return fmt.Errorf("failed to execute statement: %w", err)
+		}
+
+		n, err := res.RowsAffected()
+		if err != nil {
+			return fmt.Errorf("failed to get affected rows: %w", err)
+		}
+
+		if n == 0 {
+			return storage.ErrDataChanged
+		}
+
+		if err := updateFilesMeta(ctx, tx, userID, record); err != nil {
+			return err
+		}
+	}
+
+	var filesCount, recordsFiles int
+
+	err = tx.QueryRowContext(ctx, "SELECT count(*) FROM files WHERE user_id = $1", userID).Scan(&filesCount)
+	if err != nil {
+		return fmt.Errorf("failed to execute statement: %w", err)
+	}
+
+	for _, record := range records {
+		recordsFiles += len(record.Files)
+	}
+
+	if filesCount != recordsFiles {
+		return storage.ErrDataChanged
+	}
+
+	_, err = tx.ExecContext(ctx, "UPDATE refresh_tokens SET revoked_at = now() WHERE user_id = $1 AND revoked_at IS NULL", userID)
+	if err != nil {
+		return fmt.Errorf("failed to execute statement: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// updateFilesMeta replaces meta of files attached to the record, ErrDataChanged is returned
+// if a file is not attached to the record
+func updateFilesMeta(ctx context.Context, tx *sql.Tx, userID int64, record models.Data) error {
+	for _, file := range record.Files {
+		res, err := tx.ExecContext(ctx, "UPDATE files SET meta = $4 WHERE id = $1 AND record_id = $2 AND user_id = $3",
+			file.ID, record.ID, userID, file.Meta)
+		if err != nil {
+			return fmt.Errorf("failed to execute statement: %w", err)
+		}
+
+		n, err := res.RowsAffected()
+		if err != nil {
+			return fmt.Errorf("failed to get affected rows: %w", err)
+		}
+
+		if n == 0 {
+			return storage.ErrDataChanged
+		}
+	}
+
+	return nil
+}
+
+// DeleteUser marks the user as deleted and revokes all tokens of the user in one transaction
+func (s *Storage) DeleteUser(ctx context.Context, userID int64, deletedAt time.Time) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, `
+		UPDATE users SET deleted = true, deleted_at = $2, tokens_valid_after = $2
+		WHERE id = $1 AND deleted = false`, userID, deletedAt.UTC())
+	if err != nil {
+		return fmt.Errorf("failed to execute statement: %w", err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get affected rows: %w", err)
+	}
+
+	if n == 0 {
+		return storage.ErrUserNotFound
+	}
+
+	_, err = tx.ExecContext(ctx, "UPDATE refresh_tokens SET revoked_at = now() WHERE user_id = $1 AND revoked_at IS NULL", userID)
+	if err != nil {
+		return fmt.Errorf("failed to execute statement: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// RestoreUser clears deleted mark of the user deleted after deletedAfter
+func (s *Storage) RestoreUser(ctx context.Context, userID int64, deletedAfter time.Time) error {
+	res, err := s.db.ExecContext(ctx, `
+		UPDATE users SET deleted = false, deleted_at = NULL
+		WHERE id = $1 AND deleted = true AND deleted_at > $2`, userID, deletedAfter.UTC())
+	if err != nil {
+		return fmt.Errorf("failed to execute statement: %w", err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get affected rows: %w", err)
+	}
+
+	if n == 0 {
+		return storage.ErrUserNotFound
+	}
+
+	return nil
+}
+
+// PurgeDeletedUsers deletes users marked as deleted before the given time, their data is deleted by cascade
+func (s *Storage) PurgeDeletedUsers(ctx context.Context, deletedBefore time.Time) (int64, error) {
+	res, err := s.db.ExecContext(ctx, "DELETE FROM users WHERE deleted = true AND deleted_at < $1", deletedBefore.UTC())
+	if err != nil {
+		return 0, fmt.Errorf("failed to execute statement: %w", err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get affected rows: %w", err)
+	}
+
+	return n, nil
+}
+
+// SaveEmailToken saves hash of the token sent to the user, unused tokens of the same purpose are deleted,
+// so only the latest email works
+func (s *Storage) SaveEmailToken(ctx context.Context, token models.EmailToken) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, "DELETE FROM email_tokens WHERE user_id = $1 AND purpose = $2 AND used_at IS NULL",
+		token.UserID, token.Purpose)
+	if err != nil {
+		return fmt.Errorf("failed to execute statement: %w", err)
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO email_tokens(token_hash, user_id, purpose, expires_at, created_at) VALUES ($1, $2, $3, $4, $5)`,
+		token.Hash, token.UserID, token.Purpose, token.ExpiresAt.UTC(), token.CreatedAt.UTC())
+	if err != nil {
+		return fmt.Errorf("failed to execute statement: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// LatestEmailToken returns the last token of the purpose sent to the user, used or not
+func (s *Storage) LatestEmailToken(ctx context.Context, userID int64, purpose string) (models.EmailToken, error) {
+	token := models.EmailToken{UserID: userID, Purpose: purpose}
+
+	err := s.db.QueryRowContext(ctx, `
+		SELECT token_hash, expires_at, created_at FROM email_tokens
+		WHERE user_id = $1 AND purpose = $2 ORDER BY created_at DESC LIMIT 1`,
+		userID, purpose).Scan(&token.Hash, &token.ExpiresAt, &token.CreatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.EmailToken{}, storage.ErrTokenNotFound
+		}
+
+		return models.EmailToken{}, fmt.Errorf("failed to execute statement: %w", err)
+	}
+
+	return token, nil
+}
+
+// EmailToken returns unused and not expired token of the purpose
+func (s *Storage) EmailToken(ctx context.Context, hash, purpose string) (models.EmailToken, error) {
+	token := models.EmailToken{Hash: hash, Purpose: purpose}
+
+	err := s.db.QueryRowContext(ctx, `
+		SELECT user_id, expires_at, created_at FROM email_tokens
+		WHERE token_hash = $1 AND purpose = $2 AND used_at IS NULL AND expires_at > now() AT TIME ZONE 'UTC'`,
+		hash, purpose).Scan(&token.UserID, &token.ExpiresAt, &token.CreatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.EmailToken{}, storage.ErrTokenNotFound
+		}
+
+		return models.EmailToken{}, fmt.Errorf("failed to execute statement: %w", err)
+	}
+
+	return token, nil
+}
+
+// useEmailToken marks unused and not expired token of the purpose as used and returns its user
+func useEmailToken(ctx context.Context, tx *sql.Tx, hash, purpose string) (int64, error) {
+	var userID int64
+
+	err := tx.QueryRowContext(ctx, `
+		UPDATE email_tokens SET used_at = now() AT TIME ZONE 'UTC'
+		WHERE token_hash = $1 AND purpose = $2 AND used_at IS NULL AND expires_at > now() AT TIME ZONE 'UTC'
+		RETURNING user_id`, hash, purpose).Scan(&userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, storage.ErrTokenNotFound
+		}
+
+		return 0, fmt.Errorf("failed to execute statement: %w", err)
+	}
+
+	return userID, nil
+}
+
+// VerifyEmail uses the verification token and marks email of its user as verified
+func (s *Storage) VerifyEmail(ctx context.Context, hash string) (int64, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	userID, err := useEmailToken(ctx, tx, hash, models.EmailTokenVerify)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.ExecContext(ctx, "UPDATE users SET email_verified = true WHERE id = $1", userID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to execute statement: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return userID, nil
+}
+
+// ResetPassword uses the reset token and sets SRP verifier of the new password of its user in one transaction.
+// Records of the user are encrypted with the vault key of the old password and can not be read anymore,
+// so they are deleted together with the vault salt. Tokens issued before validAfter are revoked.
+func (s *Storage) ResetPassword(
+	ctx context.Context,
+	hash string,
+	verifier models.SRPVerifier,
+	validAfter time.Time,
+) (int64, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	userID, err := useEmailToken(ctx, tx, hash, models.EmailTokenReset)
+	if err != nil {
+		return 0, err
+	}
+
+	// the lock keeps records from being added with the old vault key until commit
+	_, err = tx.ExecContext(ctx, "SELECT id FROM users WHERE id = $1 FOR UPDATE", userID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to execute statement: %w", err)
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE users SET passHash = '', srp_salt = $2, srp_params = $3, srp_verifier = $4,
+		vault_salt = NULL, tokens_valid_after = $5, email_verified = true
+		WHERE id = $1`, userID, verifier.Salt, verifier.Params, verifier.Verifier, validAfter.UTC())
+	if err != nil {
+		return 0, fmt.Errorf("failed to execute statement: %w", err)
+	}
+
+	_, err = tx.ExecContext(ctx, "DELETE FROM users_data WHERE user_id = $1", userID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to execute statement: %w", err)
+	}
+
+	_, err = tx.ExecContext(ctx, "UPDATE refresh_tokens SET revoked_at = now() WHERE user_id = $1 AND revoked_at IS NULL", userID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to execute statement: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return userID, nil
+}
+
+// SaveSRPSession saves the first round of SRP login
+func (s *Storage) SaveSRPSession(ctx context.Context, session models.SRPSession) error {
+	stmt, err := s.db.Prepare(`
+		INSERT INTO srp_sessions(session_hash, user_id, client_public, server_secret, expires_at)
+		VALUES ($1, $2, $3, $4, $5)`)
+	if err != nil {
+		return fmt.Errorf("failed to prepare statement: %w", err)
+	}
+
+	_, err = stmt.ExecContext(ctx, session.Hash, session.UserID, session.ClientPublic, session.ServerSecret,
+		session.ExpiresAt.UTC())
+	if err != nil {
+		return fmt.Errorf("failed to execute statement: %w", err)
+	}
+
+	return nil
+}
+
+// UseSRPSession deletes and returns the session, so a proof can be checked against it once.
+// ErrSessionNotFound is returned if the session expired or was already used.
+func (s *Storage) UseSRPSession(ctx context.Context, hash string) (models.SRPSession, error) {
+	session := models.SRPSession{Hash: hash}
+
+	err := s.db.QueryRowContext(ctx, `
+		DELETE FROM srp_sessions WHERE session_hash = $1 AND expires_at > now() AT TIME ZONE 'UTC'
+		RETURNING user_id, client_public, server_secret, expires_at`, hash).
+		Scan(&session.UserID, &session.ClientPublic, &session.ServerSecret, &session.ExpiresAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.SRPSession{}, storage.ErrSessionNotFound
+		}
+
+		return models.SRPSession{}, fmt.Errorf("failed to execute statement: %w", err)
+	}
+
+	return session, nil
+}
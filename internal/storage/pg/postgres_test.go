@@ -0,0 +1,46 @@
+package postgres
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/nglmq/password-keeper/internal/storage"
+	"github.com/nglmq/password-keeper/internal/storage/storagetest"
+	"github.com/stretchr/testify/require"
+)
+
+// newStorage returns the storage of the database TEST_DATABASE_DSN points to with all tables emptied,
+// the test is skipped if it is not set
+func newStorage(t *testing.T) *Storage {
+	t.Helper()
+
+	dsn := os.Getenv("TEST_DATABASE_DSN")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_DSN is not set")
+	}
+
+	s, err := New(dsn)
+	require.NoError(t, err)
+
+	t.Cleanup(func() {
+		s.Close()
+	})
+
+	_, err = s.db.ExecContext(context.Background(), "TRUNCATE users, login_attempts, blobs RESTART IDENTITY CASCADE")
+	require.NoError(t, err)
+
+	return s
+}
+
+func TestStorage(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storagetest.Storage {
+		return newStorage(t)
+	})
+}
+
+func TestBlobs(t *testing.T) {
+	storagetest.RunBlobStore(t, func(t *testing.T) storage.BlobStore {
+		return newStorage(t).Blobs()
+	})
+}
@@ -0,0 +1,329 @@
+// Package s3 keeps blobs as objects of a bucket of an S3 compatible service (AWS S3, MinIO, Ceph and others).
+// Requests are signed with AWS Signature Version 4.
+package s3
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/nglmq/password-keeper/internal/storage"
+)
+
+const (
+	algorithm = "AWS4-HMAC-SHA256"
+	service   = "s3"
+
+	amzDateFormat = "20060102T150405Z"
+	dateFormat    = "20060102"
+)
+
+// Config of the bucket
+type Config struct {
+	// Endpoint is the URL of the service, for example https://s3.eu-central-1.amazonaws.com or http://localhost:9000
+	Endpoint  string
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	// PathStyle puts the bucket into the path instead of the host name, MinIO and most self-hosted services need it
+	PathStyle bool
+}
+
+type Store struct {
+	cfg      Config
+	endpoint *url.URL
+	client   *http.Client
+	now      func() time.Time
+}
+
+// New returns Store of the bucket, requests are sent with client or http.DefaultClient if it is nil
+func New(cfg Config, client *http.Client) (*Store, error) {
+	endpoint, err := url.Parse(cfg.Endpoint)
+	if err != nil || endpoint.Scheme == "" || endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid s3 endpoint %q", cfg.Endpoint)
+	}
+
+	if cfg.Bucket == "" {
+		return nil, fmt.Errorf("s3 bucket is not set")
+	}
+
+	if cfg.Region == "" {
+		cfg.Region = "us-east-1"
+	}
+
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	return &Store{
+		cfg:      cfg,
+		endpoint: endpoint,
+		client:   client,
+		now:      time.Now,
+	}, nil
+}
+
+func (s *Store) Put(ctx context.Context, key string, data []byte) error {
+	resp, err := s.do(ctx, http.MethodPut, key, nil, data)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return responseError(resp)
+	}
+
+	return nil
+}
+
+func (s *Store) Get(ctx context.Context, key string) ([]byte, error) {
+	resp, err := s.do(ctx, http.MethodGet, key, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, storage.ErrBlobNotFound
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, responseError(resp)
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read object: %w", err)
+	}
+
+	return data, nil
+}
+
+// Delete deletes the object, S3 reports success for missing objects as well
+func (s *Store) Delete(ctx context.Context, key string) error {
+	resp, err := s.do(ctx, http.MethodDelete, key, nil, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK &&
+		resp.StatusCode != http.StatusNotFound {
+		return responseError(resp)
+	}
+
+	return nil
+}
+
+// listResult is the response of ListObjectsV2
+type listResult struct {
+	Contents []struct {
+		Key string `xml:"Key"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+// List lists objects with ListObjectsV2 page by page
+func (s *Store) List(ctx context.Context, prefix string) ([]string, error) {
+	var (
+		keys  []string
+		token string
+	)
+
+	for {
+		query := url.Values{
+			"list-type": {"2"},
+			"prefix":    {prefix},
+		}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+
+		page, err := s.listPage(ctx, query)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, c := range page.Contents {
+			keys = append(keys, c.Key)
+		}
+
+		if !page.IsTruncated || page.NextContinuationToken == "" {
+			return keys, nil
+		}
+
+		token = page.NextContinuationToken
+	}
+}
+
+func (s *Store) listPage(ctx context.Context, query url.Values) (listResult, error) {
+	resp, err := s.do(ctx, http.MethodGet, "", query, nil)
+	if err != nil {
+		return listResult{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return listResult{}, responseError(resp)
+	}
+
+	var page listResult
+	if err := xml.NewDecoder(resp.Body).Decode(&page); err != nil {
+		return listResult{}, fmt.Errorf("failed to decode list of objects: %w", err)
+	}
+
+	return page, nil
+}
+
+// do sends signed request to the object with the key or to the bucket if the key is empty
+func (s *Store) do(ctx context.Context, method, key string, query url.Values, body []byte) (*http.Response, error) {
+	u := *s.endpoint
+
+	segments := []string{strings.TrimSuffix(u.Path, "/")}
+	if s.cfg.PathStyle {
+		segments = append(segments, s.cfg.Bucket)
+	} else {
+		u.Host = s.cfg.Bucket + "." + u.Host
+	}
+	segments = append(segments, key)
+
+	u.Path = strings.Join(segments, "/")
+	u.RawPath = escapePath(u.Path)
+	u.RawQuery = canonicalQuery(query)
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.ContentLength = int64(len(body))
+
+	sum := sha256.Sum256(body)
+	sign(req, hex.EncodeToString(sum[:]), s.cfg, s.now())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+
+	return resp, nil
+}
+
+// sign adds x-amz-date, x-amz-content-sha256 and Authorization headers of Signature Version 4 to the request,
+// all headers set before are signed
+func sign(req *http.Request, payloadHash string, cfg Config, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format(amzDateFormat)
+	scope := strings.Join([]string{now.Format(dateFormat), cfg.Region, service, "aws4_request"}, "/")
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{algorithm, amzDate, scope, hex.EncodeToString(requestHash[:])}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+cfg.SecretKey), now.Format(dateFormat))
+	key = hmacSHA256(key, cfg.Region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		algorithm, cfg.AccessKey, scope, signedHeaders, signature))
+}
+
+// canonicalQuery returns query sorted by names with names and values escaped as Signature Version 4 requires
+func canonicalQuery(query url.Values) string {
+	names := make([]string, 0, len(query))
+	for name := range query {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var params []string
+	for _, name := range names {
+		for _, value := range query[name] {
+			params = append(params, escape(name)+"="+escape(value))
+		}
+	}
+
+	return strings.Join(params, "&")
+}
+
+// escapePath escapes segments of the path, slashes are kept
+func escapePath(p string) string {
+	segments := strings.Split(p, "/")
+	for i, segment := range segments {
+		segments[i] = escape(segment)
+	}
+
+	return strings.Join(segments, "/")
+}
+
+// escape percent-encodes everything but unreserved characters of RFC 3986
+func escape(s string) string {
+	return strings.ReplaceAll(url.QueryEscape(s), "+", "%20")
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+
+	return h.Sum(nil)
+}
+
+// errorResponse is the body of failed requests
+type errorResponse struct {
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+func responseError(resp *http.Response) error {
+	var e errorResponse
+	if err := xml.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&e); err != nil || e.Code == "" {
+		return fmt.Errorf("s3 request failed with status %d", resp.StatusCode)
+	}
+
+	return fmt.Errorf("s3 request failed with status %d: %s: %s", resp.StatusCode, e.Code, e.Message)
+}
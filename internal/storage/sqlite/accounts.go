@@ -0,0 +1,261 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/nglmq/password-keeper/internal/domain/models"
+	"github.com/nglmq/password-keeper/internal/storage"
+)
+
+// MigrateToVerifier replaces password hash of the user with SRP verifier if the hash is still oldHash
+func (s *Storage) MigrateToVerifier(ctx context.Context, userID int64, oldHash []byte, verifier models.SRPVerifier) error {
+	_, err := s.db.ExecContext(ctx, `
+		UPDATE users SET passHash = '', srp_salt = $3, srp_params = $4, srp_verifier = $5
+		WHERE id = $1 AND passHash = $2 AND srp_verifier IS NULL`,
+		userID, string(oldHash), verifier.Salt, verifier.Params, verifier.Verifier)
+	if err != nil {
+		return fmt.Errorf("failed to execute statement: %w", err)
+	}
+
+	return nil
+}
+
+// UpgradeVerifier replaces SRP verifier of the user derived with stale params if the verifier is still oldVerifier
+func (s *Storage) UpgradeVerifier(ctx context.Context, userID int64, oldVerifier []byte, verifier models.SRPVerifier) error {
+	_, err := s.db.ExecContext(ctx, `
+		UPDATE users SET srp_salt = $3, srp_params = $4, srp_verifier = $5
+		WHERE id = $1 AND srp_verifier = $2`,
+		userID, oldVerifier, verifier.Salt, verifier.Params, verifier.Verifier)
+	if err != nil {
+		return fmt.Errorf("failed to execute statement: %w", err)
+	}
+
+	return nil
+}
+
+// ChangePassword replaces SRP verifier, vault salt and content of all records of the user in one transaction
+// and revokes tokens issued before validAfter. Meta of files attached to records is replaced as well.
+// Records and their files must be exactly the ones the user has, otherwise ErrDataChanged is returned
+// and nothing is changed.
+func (s *Storage) ChangePassword(
+	ctx context.Context,
+	userID int64,
+	verifier models.SRPVerifier,
+	vaultSalt []byte,
+	records []models.Data,
+	validAfter time.Time,
+) error {
+	// the transaction holds the write lock, so records and files of the user can not be added until commit
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE users SET passHash = '', srp_salt = $2, srp_params = $3, srp_verifier = $4,
+		vault_salt = $5, tokens_valid_after = $6
+		WHERE id = $1`, userID, verifier.Salt, verifier.Params, verifier.Verifier, vaultSalt, validAfter.UTC())
+	if err != nil {
+		return fmt.Errorf("failed to execute statement: %w", err)
+	}
+
+	var count int
+
+	err = tx.QueryRowContext(ctx, "SELECT count(*) FROM users_data WHERE user_id = $1", userID).Scan(&count)
+	if err != nil {
+		return fmt.Errorf("failed to execute statement: %w", err)
+	}
+
+	if count != len(records) {
+		return storage.ErrDataChanged
+	}
+
+	for _, record := range records {
+		res, err := tx.ExecContext(ctx, "UPDATE users_data SET data = $3, legacy = false WHERE id = $1 AND user_id = $2",
+			record.ID, userID, record.Content)
+		if err != nil {
+			return fmt.Errorf("failed to execute statement: %w", err)
+		}
+
+		if err := affected(res, storage.ErrDataChanged); err != nil {
+			return err
+		}
+
+		if err := updateFilesMeta(ctx, tx, userID, record); err != nil {
+			return err
+		}
+	}
+
+	var filesCount, recordsFiles int
+
+	err = tx.QueryRowContext(ctx, "SELECT count(*) FROM files WHERE user_id = $1", userID).Scan(&filesCount)
+	if err != nil {
+		return fmt.Errorf("failed to execute statement: %w", err)
+	}
+
+	for _, record := range records {
+		recordsFiles += len(record.Files)
+	}
+
+	if filesCount != recordsFiles {
+		return storage.ErrDataChanged
+	}
+
+	if err := revokeRefreshTokens(ctx, tx, userID); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// updateFilesMeta replaces meta of files attached to the record, ErrDataChanged is returned
+// if a file is not attached to the record
+func updateFilesMeta(ctx context.Context, tx *sql.Tx, userID int64, record models.Data) error {
+	for _, file := range record.Files {
+		res, err := tx.ExecContext(ctx, "UPDATE files SET meta = $4 WHERE id = $1 AND record_id = $2 AND user_id = $3",
+			file.ID, record.ID, userID, file.Meta)
+		if err != nil {
+			return fmt.Errorf("failed to execute statement: %w", err)
+		}
+
+		if err := affected(res, storage.ErrDataChanged); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// DeleteUser marks the user as deleted and revokes all tokens of the user in one transaction
+func (s *Storage) DeleteUser(ctx context.Context, userID int64, deletedAt time.Time) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, `
+		UPDATE users SET deleted = true, deleted_at = $2, tokens_valid_after = $2
+		WHERE id = $1 AND deleted = false`, userID, deletedAt.UTC())
+	if err != nil {
+		return fmt.Errorf("failed to execute statement: %w", err)
+	}
+
+	if err := affected(res, storage.ErrUserNotFound); err != nil {
+		return err
+	}
+
+	if err := revokeRefreshTokens(ctx, tx, userID); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// RestoreUser clears deleted mark of the user deleted after deletedAfter
+func (s *Storage) RestoreUser(ctx context.Context, userID int64, deletedAfter time.Time) error {
+	res, err := s.db.ExecContext(ctx, `
+		UPDATE users SET deleted = false, deleted_at = NULL
+		WHERE id = $1 AND deleted = true AND deleted_at > $2`, userID, deletedAfter.UTC())
+	if err != nil {
+		return fmt.Errorf("failed to execute statement: %w", err)
+	}
+
+	return affected(res, storage.ErrUserNotFound)
+}
+
+// PurgeDeletedUsers deletes users marked as deleted before the given time, their data is deleted by cascade
+func (s *Storage) PurgeDeletedUsers(ctx context.Context, deletedBefore time.Time) (int64, error) {
+	res, err := s.db.ExecContext(ctx, "DELETE FROM users WHERE deleted = true AND deleted_at < $1", deletedBefore.UTC())
+	if err != nil {
+		return 0, fmt.Errorf("failed to execute statement: %w", err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get affected rows: %w", err)
+	}
+
+	return n, nil
+}
+
+// VerifyEmail uses the verification token and marks email of its user as verified
+func (s *Storage) VerifyEmail(ctx context.Context, hash string) (int64, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	userID, err := useEmailToken(ctx, tx, hash, models.EmailTokenVerify)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.ExecContext(ctx, "UPDATE users SET email_verified = true WHERE id = $1", userID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to execute statement: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return userID, nil
+}
+
+// ResetPassword uses the reset token and sets SRP verifier of the new password of its user in one transaction.
+// Records of the user are encrypted with the vault key of the old password and can not be read anymore,
+// so they are deleted together with the vault salt. Tokens issued before validAfter are revoked.
+func (s *Storage) ResetPassword(
+	ctx context.Context,
+	hash string,
+	verifier models.SRPVerifier,
+	validAfter time.Time,
+) (int64, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	userID, err := useEmailToken(ctx, tx, hash, models.EmailTokenReset)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE users SET passHash = '', srp_salt = $2, srp_params = $3, srp_verifier = $4,
+		vault_salt = NULL, tokens_valid_after = $5, email_verified = true
+		WHERE id = $1`, userID, verifier.Salt, verifier.Params, verifier.Verifier, validAfter.UTC())
+	if err != nil {
+		return 0, fmt.Errorf("failed to execute statement: %w", err)
+	}
+
+	_, err = tx.ExecContext(ctx, "DELETE FROM users_data WHERE user_id = $1", userID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to execute statement: %w", err)
+	}
+
+	if err := revokeRefreshTokens(ctx, tx, userID); err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return userID, nil
+}
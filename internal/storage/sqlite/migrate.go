@@ -0,0 +1,32 @@
+package sqlite
+
+import (
+	"embed"
+	"io/fs"
+
+	"github.com/nglmq/password-keeper/internal/storage/migrate"
+)
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// Migrator returns Migrator of the database with embedded migrations. Migrations need no lock,
+// their transactions begin immediately with the write lock of the database file, and the version
+// of the schema is read in them, so servers starting on the same file at once apply every migration once.
+func (s *Storage) Migrator() *migrate.Migrator {
+	return migrate.New(s.db, mustLoadMigrations(), nil)
+}
+
+func mustLoadMigrations() []migrate.Migration {
+	dir, err := fs.Sub(migrationFiles, "migrations")
+	if err != nil {
+		panic(err)
+	}
+
+	migrations, err := migrate.Load(dir)
+	if err != nil {
+		panic(err)
+	}
+
+	return migrations
+}
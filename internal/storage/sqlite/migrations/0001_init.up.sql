@@ -0,0 +1,93 @@
+-- Times are kept as text in UTC written by the driver in one format, so they are compared as strings.
+
+CREATE TABLE users(
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    email TEXT NOT NULL UNIQUE,
+    passHash TEXT NOT NULL,
+    deleted BOOLEAN NOT NULL DEFAULT false,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    vault_salt BLOB,
+    tokens_valid_after TIMESTAMP,
+    deleted_at TIMESTAMP,
+    email_verified BOOLEAN NOT NULL DEFAULT false,
+    srp_salt BLOB,
+    srp_params TEXT,
+    srp_verifier BLOB);
+
+-- sequences reserve IDs of records and files before they are encrypted
+CREATE TABLE sequences(
+    name TEXT PRIMARY KEY,
+    value INTEGER NOT NULL);
+INSERT INTO sequences(name, value) VALUES ('users_data', 0);
+
+CREATE TABLE users_data(
+    id INTEGER PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    data_type TEXT NOT NULL,
+    data TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL);
+CREATE INDEX idx_users_data_user ON users_data(user_id);
+
+CREATE TABLE users_keys(
+    user_id INTEGER PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    data_key TEXT NOT NULL,
+    key_id INTEGER,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP);
+
+CREATE TABLE refresh_tokens(
+    token_hash TEXT PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    family_id TEXT NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    used_at TIMESTAMP,
+    revoked_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP);
+CREATE INDEX idx_refresh_tokens_family ON refresh_tokens(family_id);
+CREATE INDEX idx_refresh_tokens_user ON refresh_tokens(user_id);
+
+CREATE TABLE revoked_tokens(
+    jti TEXT PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    expires_at TIMESTAMP NOT NULL);
+
+CREATE TABLE users_totp(
+    user_id INTEGER PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    secret TEXT NOT NULL,
+    enabled BOOLEAN NOT NULL DEFAULT false,
+    last_step INTEGER,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP);
+
+CREATE TABLE recovery_codes(
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    code_hash TEXT NOT NULL,
+    used_at TIMESTAMP,
+    PRIMARY KEY (user_id, code_hash));
+
+CREATE TABLE login_challenges(
+    challenge_hash TEXT PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    expires_at TIMESTAMP NOT NULL,
+    attempts INTEGER NOT NULL DEFAULT 0);
+
+CREATE TABLE login_attempts(
+    attempt_key TEXT PRIMARY KEY,
+    failures INTEGER NOT NULL,
+    last_failure_at TIMESTAMP NOT NULL,
+    blocked_until TIMESTAMP);
+
+CREATE TABLE email_tokens(
+    token_hash TEXT PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    purpose TEXT NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    used_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL);
+CREATE INDEX idx_email_tokens_user ON email_tokens(user_id, purpose);
+
+CREATE TABLE srp_sessions(
+    session_hash TEXT PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    client_public BLOB NOT NULL,
+    server_secret TEXT NOT NULL,
+    expires_at TIMESTAMP NOT NULL);
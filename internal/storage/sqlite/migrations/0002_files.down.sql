@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS file_chunks;
+DROP TABLE IF EXISTS files;
+
+DELETE FROM sequences WHERE name = 'files';
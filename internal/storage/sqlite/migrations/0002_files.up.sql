@@ -0,0 +1,20 @@
+INSERT INTO sequences(name, value) VALUES ('files', 0);
+
+CREATE TABLE files(
+    id INTEGER PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    record_id INTEGER NOT NULL REFERENCES users_data(id) ON DELETE CASCADE,
+    meta TEXT NOT NULL,
+    chunk_count INTEGER NOT NULL,
+    uploaded_chunks INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMP NOT NULL,
+    completed_at TIMESTAMP);
+CREATE INDEX idx_files_user ON files(user_id);
+CREATE INDEX idx_files_record ON files(record_id);
+
+CREATE TABLE file_chunks(
+    file_id INTEGER NOT NULL REFERENCES files(id) ON DELETE CASCADE,
+    idx INTEGER NOT NULL,
+    data BLOB NOT NULL,
+    checksum BLOB NOT NULL,
+    PRIMARY KEY (file_id, idx));
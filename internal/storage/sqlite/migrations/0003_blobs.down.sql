@@ -0,0 +1,12 @@
+-- Fails while chunks are kept in the blob store, they have no data in the database.
+CREATE TABLE file_chunks_old(
+    file_id INTEGER NOT NULL REFERENCES files(id) ON DELETE CASCADE,
+    idx INTEGER NOT NULL,
+    data BLOB NOT NULL,
+    checksum BLOB NOT NULL,
+    PRIMARY KEY (file_id, idx));
+INSERT INTO file_chunks_old(file_id, idx, data, checksum) SELECT file_id, idx, data, checksum FROM file_chunks;
+DROP TABLE file_chunks;
+ALTER TABLE file_chunks_old RENAME TO file_chunks;
+
+DROP TABLE IF EXISTS blobs;
@@ -0,0 +1,15 @@
+-- Chunks are kept in the blob store, data is left only in chunks uploaded before it.
+-- SQLite can not drop NOT NULL of a column, so the table is rebuilt.
+CREATE TABLE file_chunks_new(
+    file_id INTEGER NOT NULL REFERENCES files(id) ON DELETE CASCADE,
+    idx INTEGER NOT NULL,
+    data BLOB,
+    checksum BLOB NOT NULL,
+    PRIMARY KEY (file_id, idx));
+INSERT INTO file_chunks_new(file_id, idx, data, checksum) SELECT file_id, idx, data, checksum FROM file_chunks;
+DROP TABLE file_chunks;
+ALTER TABLE file_chunks_new RENAME TO file_chunks;
+
+CREATE TABLE blobs(
+    key TEXT PRIMARY KEY,
+    data BLOB NOT NULL);
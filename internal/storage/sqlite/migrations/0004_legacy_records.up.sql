@@ -0,0 +1,3 @@
+-- SQLite storage was added after records were bound to their owner, ID and type,
+-- so no record is marked as legacy, the column keeps the schema the same as in Postgres.
+ALTER TABLE users_data ADD COLUMN legacy BOOLEAN NOT NULL DEFAULT false;
@@ -0,0 +1,701 @@
+// Package sqlite keeps everything the server stores in an SQLite database file, so a server for one person
+// or a small self-hosted setup needs no database server. The driver is pure Go and needs no cgo.
+// Its methods behave like the ones of storage/pg and return the same errors, the storagetest suite checks it.
+//
+// The database is used through one connection, SQLite lets only one writer in at a time anyway,
+// so transactions of the server never wait for each other on locks of the database file.
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/nglmq/password-keeper/internal/domain/models"
+	"github.com/nglmq/password-keeper/internal/storage"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// Scheme is the prefix of DSNs of SQLite databases, for example sqlite:///var/lib/keeper/keeper.db
+// or sqlite://keeper.db relative to the working directory. sqlite://:memory: is a database in memory.
+const Scheme = "sqlite://"
+
+type Storage struct {
+	db *sql.DB
+}
+
+// IsDSN reports whether dsn points to an SQLite database
+func IsDSN(dsn string) bool {
+	return strings.HasPrefix(dsn, Scheme)
+}
+
+// New opens the database creating the file if needed and applies pending migrations,
+// it fails if the schema is newer than the server
+func New(dsn string) (*Storage, error) {
+	s, err := Open(dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := s.Migrator().Up(context.Background()); err != nil {
+		s.Close()
+		return nil, fmt.Errorf("failed to migrate database: %w", err)
+	}
+
+	return s, nil
+}
+
+// Open opens the database as is, the schema is changed with Migrator
+func Open(dsn string) (*Storage, error) {
+	if !IsDSN(dsn) {
+		return nil, fmt.Errorf("dsn of sqlite database must start with %s", Scheme)
+	}
+
+	path, query, _ := strings.Cut(strings.TrimPrefix(dsn, Scheme), "?")
+	if path == "" {
+		return nil, fmt.Errorf("path of sqlite database is not set")
+	}
+
+	params, err := url.ParseQuery(query)
+	if err != nil {
+		return nil, fmt.Errorf("invalid sqlite dsn parameters: %w", err)
+	}
+
+	// foreign keys are off by default, records and tokens of users are deleted by cascade
+	params.Add("_pragma", "foreign_keys(1)")
+	params.Add("_pragma", "journal_mode(WAL)")
+	params.Add("_pragma", "busy_timeout(5000)")
+	// other processes (keeper-admin) wait for the write lock at the start of transactions instead of failing
+	params.Set("_txlock", "immediate")
+
+	db, err := sql.Open("sqlite", path+"?"+params.Encode())
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+
+	// the only connection keeps :memory: databases alive as well
+	db.SetMaxOpenConns(1)
+	db.SetConnMaxLifetime(0)
+	db.SetConnMaxIdleTime(0)
+
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+
+	return &Storage{db: db}, nil
+}
+
+func (s *Storage) Close() error {
+	return s.db.Close()
+}
+
+// now is the current time. All times are passed to the database in UTC, the driver writes them as text
+// in one format ending with UTC, so they are compared as strings and read back in UTC.
+func now() time.Time {
+	return time.Now().UTC()
+}
+
+// SaveUser saves a new user with SRP verifier of the password, no password hash is stored
+func (s *Storage) SaveUser(ctx context.Context, email string, verifier models.SRPVerifier) (models.User, error) {
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO users(email, passHash, srp_salt, srp_params, srp_verifier) VALUES ($1, '', $2, $3, $4)`,
+		email, verifier.Salt, verifier.Params, verifier.Verifier)
+	if err != nil {
+		var sqliteErr *sqlite.Error
+
+		if errors.As(err, &sqliteErr) && sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_UNIQUE {
+			return models.User{}, storage.ErrUserExists
+		}
+
+		return models.User{}, fmt.Errorf("failed to execute statement: %w", err)
+	}
+
+	user, err := s.User(ctx, email)
+	if err != nil {
+		return models.User{}, fmt.Errorf("failed to get user: %w", err)
+	}
+
+	return user, nil
+}
+
+func (s *Storage) User(ctx context.Context, email string) (models.User, error) {
+	user, err := scanUser(s.db.QueryRowContext(ctx, `
+		SELECT id, email, passHash, srp_salt, srp_params, srp_verifier, email_verified, deleted, deleted_at
+		FROM users WHERE email = $1`, email))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.User{}, fmt.Errorf("user not found: %w", storage.ErrUserNotFound)
+		}
+
+		return models.User{}, fmt.Errorf("failed to execute statement: %w", err)
+	}
+
+	return user, nil
+}
+
+// UserByID returns user by ID
+func (s *Storage) UserByID(ctx context.Context, id int64) (models.User, error) {
+	user, err := scanUser(s.db.QueryRowContext(ctx, `
+		SELECT id, email, passHash, srp_salt, srp_params, srp_verifier, email_verified, deleted, deleted_at
+		FROM users WHERE id = $1`, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.User{}, fmt.Errorf("user not found: %w", storage.ErrUserNotFound)
+		}
+
+		return models.User{}, fmt.Errorf("failed to execute statement: %w", err)
+	}
+
+	return user, nil
+}
+
+// scanUser scans user selected by User and UserByID
+func scanUser(row *sql.Row) (models.User, error) {
+	var user models.User
+	var passHash string
+	var srpParams sql.NullString
+	var deletedAt sql.NullTime
+
+	err := row.Scan(&user.ID, &user.Email, &passHash, &user.SRP.Salt, &srpParams, &user.SRP.Verifier,
+		&user.EmailVerified, &user.Deleted, &deletedAt)
+	if err != nil {
+		return models.User{}, err
+	}
+
+	if passHash != "" {
+		user.PassHash = []byte(passHash)
+	}
+
+	user.SRP.Params = srpParams.String
+	user.DeletedAt = deletedAt.Time
+
+	return user, nil
+}
+
+// nextID reserves the next ID of the sequence
+func (s *Storage) nextID(ctx context.Context, sequence string) (int64, error) {
+	var id int64
+
+	err := s.db.QueryRowContext(ctx, "UPDATE sequences SET value = value + 1 WHERE name = $1 RETURNING value",
+		sequence).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("failed to execute query: %w", err)
+	}
+
+	return id, nil
+}
+
+// NextDataID reserves ID for a new data record
+func (s *Storage) NextDataID(ctx context.Context) (int64, error) {
+	return s.nextID(ctx, "users_data")
+}
+
+// SaveData saves a new record of the user with ID reserved by NextDataID
+func (s *Storage) SaveData(ctx context.Context, userID int64, record models.Data) error {
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO users_data(id, user_id, data_type, data, created_at, updated_at) VALUES ($1, $2, $3, $4, $5, $6)`,
+		record.ID, userID, record.DataType, record.Content, record.CreatedAt.UTC(), record.UpdatedAt.UTC())
+	if err != nil {
+		return fmt.Errorf("failed to execute statement: %w", err)
+	}
+
+	return nil
+}
+
+// UpdateData replaces type and content of the record of the user, ErrDataNotFound is returned
+// if the user has no record with the ID
+func (s *Storage) UpdateData(ctx context.Context, userID int64, record models.Data) error {
+	res, err := s.db.ExecContext(ctx, `
+		UPDATE users_data SET data_type = $3, data = $4, updated_at = $5, legacy = false
+		WHERE id = $1 AND user_id = $2`, record.ID, userID, record.DataType, record.Content, record.UpdatedAt.UTC())
+	if err != nil {
+		return fmt.Errorf("failed to execute statement: %w", err)
+	}
+
+	return affected(res, storage.ErrDataNotFound)
+}
+
+// DeleteData deletes the record of the user, ErrDataNotFound is returned if the user has no record with the ID
+func (s *Storage) DeleteData(ctx context.Context, userID, id int64) error {
+	res, err := s.db.ExecContext(ctx, "DELETE FROM users_data WHERE id = $1 AND user_id = $2", id, userID)
+	if err != nil {
+		return fmt.Errorf("failed to execute statement: %w", err)
+	}
+
+	return affected(res, storage.ErrDataNotFound)
+}
+
+func (s *Storage) GetData(ctx context.Context, userID int64) ([]models.Data, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, data_type, data, created_at, updated_at, legacy
+		FROM users_data WHERE user_id = $1 ORDER BY id`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute query: %w", err)
+	}
+	defer rows.Close()
+
+	var allData []models.Data
+
+	for rows.Next() {
+		var data models.Data
+
+		err := rows.Scan(&data.ID, &data.DataType, &data.Content, &data.CreatedAt, &data.UpdatedAt, &data.Legacy)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+
+		allData = append(allData, data)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("row iteration error: %w", err)
+	}
+
+	if len(allData) == 0 {
+		return nil, storage.ErrDataNotFound
+	}
+
+	files, err := s.files(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range allData {
+		allData[i].Files = files[allData[i].ID]
+	}
+
+	return allData, nil
+}
+
+// LegacyRecords returns records sealed before they were bound to their owner, ID and type
+// with IDs greater than afterID ordered by ID
+func (s *Storage) LegacyRecords(ctx context.Context, afterID int64, limit int) ([]models.LegacyRecord, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, user_id, data_type, data FROM users_data
+		WHERE legacy AND id > $1 ORDER BY id LIMIT $2`, afterID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute query: %w", err)
+	}
+	defer rows.Close()
+
+	var records []models.LegacyRecord
+
+	for rows.Next() {
+		var r models.LegacyRecord
+
+		if err := rows.Scan(&r.ID, &r.UserID, &r.DataType, &r.Content); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+
+		records = append(records, r)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("row iteration error: %w", err)
+	}
+
+	return records, nil
+}
+
+// ResealRecord replaces content of the legacy record with the sealed one, ErrDataNotFound is returned
+// if the record was deleted or changed meanwhile
+func (s *Storage) ResealRecord(ctx context.Context, userID, id int64, content string) error {
+	res, err := s.db.ExecContext(ctx, `
+		UPDATE users_data SET data = $3, legacy = false
+		WHERE id = $1 AND user_id = $2 AND legacy`, id, userID, content)
+	if err != nil {
+		return fmt.Errorf("failed to execute statement: %w", err)
+	}
+
+	return affected(res, storage.ErrDataNotFound)
+}
+
+// files returns files of the user by IDs of records they are attached to
+func (s *Storage) files(ctx context.Context, userID int64) (map[int64][]models.File, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, record_id, meta, chunk_count, uploaded_chunks, created_at, completed_at
+		FROM files WHERE user_id = $1 ORDER BY id`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute query: %w", err)
+	}
+	defer rows.Close()
+
+	files := make(map[int64][]models.File)
+
+	for rows.Next() {
+		file, err := scanFile(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+
+		files[file.RecordID] = append(files[file.RecordID], file)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("row iteration error: %w", err)
+	}
+
+	return files, nil
+}
+
+// NextFileID reserves ID for a new file
+func (s *Storage) NextFileID(ctx context.Context) (int64, error) {
+	return s.nextID(ctx, "files")
+}
+
+// SaveFile saves a new file of the user with ID reserved by NextFileID and no chunks uploaded,
+// ErrDataNotFound is returned if the user has no record the file is attached to
+func (s *Storage) SaveFile(ctx context.Context, userID int64, file models.File) error {
+	res, err := s.db.ExecContext(ctx, `
+		INSERT INTO files(id, user_id, record_id, meta, chunk_count, created_at)
+		SELECT $1, user_id, id, $4, $5, $6 FROM users_data WHERE id = $3 AND user_id = $2`,
+		file.ID, userID, file.RecordID, file.Meta, file.ChunkCount, file.CreatedAt.UTC())
+	if err != nil {
+		return fmt.Errorf("failed to execute statement: %w", err)
+	}
+
+	return affected(res, storage.ErrDataNotFound)
+}
+
+// File returns the file of the user, ErrFileNotFound is returned if the user has no file with the ID
+func (s *Storage) File(ctx context.Context, userID, id int64) (models.File, error) {
+	file, err := scanFile(s.db.QueryRowContext(ctx, `
+		SELECT id, record_id, meta, chunk_count, uploaded_chunks, created_at, completed_at
+		FROM files WHERE id = $1 AND user_id = $2`, id, userID))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.File{}, storage.ErrFileNotFound
+		}
+
+		return models.File{}, fmt.Errorf("failed to execute statement: %w", err)
+	}
+
+	return file, nil
+}
+
+// SaveFileChunk saves the next chunk of the file of the user and returns the file with the chunk counted,
+// the upload is completed at uploadedAt with the last chunk. ErrFileNotFound is returned if the user has
+// no file with the ID, ErrChunkOutOfOrder with the file as is if the chunk is not the next one.
+func (s *Storage) SaveFileChunk(
+	ctx context.Context,
+	userID int64,
+	chunk models.FileChunk,
+	uploadedAt time.Time,
+) (models.File, error) {
+	// the transaction holds the write lock from the start,
+	// so concurrent uploads of the same file can not save the same chunk
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return models.File{}, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	file, err := scanFile(tx.QueryRowContext(ctx, `
+		SELECT id, record_id, meta, chunk_count, uploaded_chunks, created_at, completed_at
+		FROM files WHERE id = $1 AND user_id = $2`, chunk.FileID, userID))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.File{}, storage.ErrFileNotFound
+		}
+
+		return models.File{}, fmt.Errorf("failed to execute statement: %w", err)
+	}
+
+	if chunk.Index != file.UploadedChunks || file.Completed() {
+		return file, storage.ErrChunkOutOfOrder
+	}
+
+	_, err = tx.ExecContext(ctx, "INSERT INTO file_chunks(file_id, idx, data, checksum) VALUES ($1, $2, $3, $4)",
+		chunk.FileID, chunk.Index, chunk.Data, chunk.Checksum)
+	if err != nil {
+		return models.File{}, fmt.Errorf("failed to execute statement: %w", err)
+	}
+
+	file.UploadedChunks++
+	if file.Completed() {
+		file.CompletedAt = uploadedAt.UTC()
+	}
+
+	_, err = tx.ExecContext(ctx, "UPDATE files SET uploaded_chunks = $2, completed_at = $3 WHERE id = $1",
+		file.ID, file.UploadedChunks, sql.NullTime{Time: file.CompletedAt, Valid: file.Completed()})
+	if err != nil {
+		return models.File{}, fmt.Errorf("failed to execute statement: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return models.File{}, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return file, nil
+}
+
+// FileChunk returns the chunk of the file, ErrFileNotFound is returned if the chunk is not uploaded.
+// Data of chunks kept in the blob store is nil.
+func (s *Storage) FileChunk(ctx context.Context, fileID int64, index int) (models.FileChunk, error) {
+	chunk := models.FileChunk{FileID: fileID, Index: index}
+
+	err := s.db.QueryRowContext(ctx, "SELECT data, checksum FROM file_chunks WHERE file_id = $1 AND idx = $2",
+		fileID, index).Scan(&chunk.Data, &chunk.Checksum)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.FileChunk{}, storage.ErrFileNotFound
+		}
+
+		return models.FileChunk{}, fmt.Errorf("failed to execute statement: %w", err)
+	}
+
+	return chunk, nil
+}
+
+// ExistingFiles returns those of the IDs that belong to files not deleted yet
+func (s *Storage) ExistingFiles(ctx context.Context, ids []int64) (map[int64]bool, error) {
+	existing := make(map[int64]bool)
+
+	if len(ids) == 0 {
+		return existing, nil
+	}
+
+	placeholders := make([]string, len(ids))
+	args := make([]any, len(ids))
+
+	for i, id := range ids {
+		placeholders[i] = fmt.Sprintf("$%d", i+1)
+		args[i] = id
+	}
+
+	rows, err := s.db.QueryContext(ctx,
+		"SELECT id FROM files WHERE id IN ("+strings.Join(placeholders, ", ")+")", args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute query: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int64
+
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+
+		existing[id] = true
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("row iteration error: %w", err)
+	}
+
+	return existing, nil
+}
+
+// Blobs returns the blob store keeping blobs in the blobs table of the database
+func (s *Storage) Blobs() *Blobs {
+	return &Blobs{db: s.db}
+}
+
+// Blobs is storage.BlobStore in the database file
+type Blobs struct {
+	db *sql.DB
+}
+
+func (b *Blobs) Put(ctx context.Context, key string, data []byte) error {
+	_, err := b.db.ExecContext(ctx, `
+		INSERT INTO blobs(key, data) VALUES ($1, $2) ON CONFLICT (key) DO UPDATE SET data = excluded.data`,
+		key, data)
+	if err != nil {
+		return fmt.Errorf("failed to execute statement: %w", err)
+	}
+
+	return nil
+}
+
+func (b *Blobs) Get(ctx context.Context, key string) ([]byte, error) {
+	var data []byte
+
+	err := b.db.QueryRowContext(ctx, "SELECT data FROM blobs WHERE key = $1", key).Scan(&data)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, storage.ErrBlobNotFound
+		}
+
+		return nil, fmt.Errorf("failed to execute statement: %w", err)
+	}
+
+	return data, nil
+}
+
+func (b *Blobs) Delete(ctx context.Context, key string) error {
+	_, err := b.db.ExecContext(ctx, "DELETE FROM blobs WHERE key = $1", key)
+	if err != nil {
+		return fmt.Errorf("failed to execute statement: %w", err)
+	}
+
+	return nil
+}
+
+func (b *Blobs) List(ctx context.Context, prefix string) ([]string, error) {
+	rows, err := b.db.QueryContext(ctx,
+		"SELECT key FROM blobs WHERE substr(key, 1, length($1)) = $1 ORDER BY key", prefix)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute query: %w", err)
+	}
+	defer rows.Close()
+
+	var keys []string
+
+	for rows.Next() {
+		var key string
+
+		if err := rows.Scan(&key); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+
+		keys = append(keys, key)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("row iteration error: %w", err)
+	}
+
+	return keys, nil
+}
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanFile(row rowScanner) (models.File, error) {
+	var (
+		file        models.File
+		completedAt sql.NullTime
+	)
+
+	err := row.Scan(&file.ID, &file.RecordID, &file.Meta, &file.ChunkCount, &file.UploadedChunks,
+		&file.CreatedAt, &completedAt)
+	if err != nil {
+		return models.File{}, err
+	}
+
+	file.CompletedAt = completedAt.Time
+
+	return file, nil
+}
+
+// affected returns errNotFound if the statement changed no rows
+func affected(res sql.Result, errNotFound error) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get affected rows: %w", err)
+	}
+
+	if n == 0 {
+		return errNotFound
+	}
+
+	return nil
+}
+
+// SaveUserKey saves wrapped data key of the user, an already saved key is kept as is
+func (s *Storage) SaveUserKey(ctx context.Context, userID int64, wrappedKey string, keyID uint32) error {
+	_, err := s.db.ExecContext(ctx,
+		"INSERT INTO users_keys(user_id, data_key, key_id) VALUES ($1, $2, $3) ON CONFLICT (user_id) DO NOTHING",
+		userID, wrappedKey, int64(keyID))
+	if err != nil {
+		return fmt.Errorf("failed to execute statement: %w", err)
+	}
+
+	return nil
+}
+
+// UserKey returns wrapped data key of the user
+func (s *Storage) UserKey(ctx context.Context, userID int64) (string, error) {
+	var wrappedKey string
+
+	err := s.db.QueryRowContext(ctx, "SELECT data_key FROM users_keys WHERE user_id = $1", userID).Scan(&wrappedKey)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", storage.ErrKeyNotFound
+		}
+
+		return "", fmt.Errorf("failed to execute statement: %w", err)
+	}
+
+	return wrappedKey, nil
+}
+
+// UserKeysToRewrap returns up to limit data keys wrapped not by the master key keyID, ordered by user ID after afterUserID
+func (s *Storage) UserKeysToRewrap(ctx context.Context, keyID uint32, afterUserID int64, limit int) ([]models.UserKey, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT user_id, data_key FROM users_keys
+		WHERE key_id IS NOT $1 AND user_id > $2
+		ORDER BY user_id
+		LIMIT $3`, int64(keyID), afterUserID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute query: %w", err)
+	}
+	defer rows.Close()
+
+	var keys []models.UserKey
+
+	for rows.Next() {
+		var key models.UserKey
+
+		if err := rows.Scan(&key.UserID, &key.WrappedKey); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+
+		keys = append(keys, key)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("row iteration error: %w", err)
+	}
+
+	return keys, nil
+}
+
+// UpdateUserKey replaces wrapped data key of the user if it was not changed since oldWrappedKey was read
+func (s *Storage) UpdateUserKey(ctx context.Context, userID int64, oldWrappedKey, wrappedKey string, keyID uint32) error {
+	res, err := s.db.ExecContext(ctx, "UPDATE users_keys SET data_key = $3, key_id = $4 WHERE user_id = $1 AND data_key = $2",
+		userID, oldWrappedKey, wrappedKey, int64(keyID))
+	if err != nil {
+		return fmt.Errorf("failed to execute statement: %w", err)
+	}
+
+	return affected(res, storage.ErrKeyNotFound)
+}
+
+// SaveVaultSalt saves salt of the user vault key, an already saved salt is kept as is
+func (s *Storage) SaveVaultSalt(ctx context.Context, userID int64, salt []byte) error {
+	_, err := s.db.ExecContext(ctx, "UPDATE users SET vault_salt = $2 WHERE id = $1 AND vault_salt IS NULL", userID, salt)
+	if err != nil {
+		return fmt.Errorf("failed to execute statement: %w", err)
+	}
+
+	return nil
+}
+
+// VaultSalt returns salt of the user vault key
+func (s *Storage) VaultSalt(ctx context.Context, userID int64) ([]byte, error) {
+	var salt []byte
+
+	err := s.db.QueryRowContext(ctx, "SELECT vault_salt FROM users WHERE id = $1", userID).Scan(&salt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, storage.ErrUserNotFound
+		}
+
+		return nil, fmt.Errorf("failed to execute statement: %w", err)
+	}
+
+	if len(salt) == 0 {
+		return nil, storage.ErrSaltNotFound
+	}
+
+	return salt, nil
+}
@@ -0,0 +1,168 @@
+package sqlite
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/nglmq/password-keeper/internal/domain/models"
+	"github.com/nglmq/password-keeper/internal/storage"
+	"github.com/nglmq/password-keeper/internal/storage/migrate"
+	"github.com/nglmq/password-keeper/internal/storage/storagetest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newStorage returns the storage of a new database file in the temporary directory of the test
+func newStorage(t *testing.T) *Storage {
+	t.Helper()
+
+	s, err := New(Scheme + filepath.Join(t.TempDir(), "keeper.db"))
+	require.NoError(t, err)
+
+	t.Cleanup(func() {
+		s.Close()
+	})
+
+	return s
+}
+
+func TestStorage(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storagetest.Storage {
+		return newStorage(t)
+	})
+}
+
+func TestBlobs(t *testing.T) {
+	storagetest.RunBlobStore(t, func(t *testing.T) storage.BlobStore {
+		return newStorage(t).Blobs()
+	})
+}
+
+func TestMigrator(t *testing.T) {
+	ctx := context.Background()
+	s := newStorage(t)
+	m := s.Migrator()
+
+	statuses, err := m.Status(ctx)
+	require.NoError(t, err)
+	require.Len(t, statuses, m.Latest())
+
+	for _, status := range statuses {
+		assert.True(t, status.Applied(), "migration %d", status.Version)
+	}
+
+	reverted, err := m.Down(ctx, m.Latest())
+	require.NoError(t, err)
+	assert.Equal(t, m.Latest(), reverted)
+
+	applied, err := m.Up(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, m.Latest(), applied)
+
+	applied, err = m.Up(ctx)
+	require.NoError(t, err)
+	assert.Zero(t, applied)
+}
+
+func TestMigrator_Concurrent(t *testing.T) {
+	ctx := context.Background()
+	dsn := Scheme + filepath.Join(t.TempDir(), "keeper.db")
+
+	// storages of two servers starting on the same file, each has its own connection
+	var migrators []*migrate.Migrator
+	for range 2 {
+		s, err := Open(dsn)
+		require.NoError(t, err)
+		t.Cleanup(func() {
+			s.Close()
+		})
+
+		migrators = append(migrators, s.Migrator())
+	}
+
+	applied := make([]int, len(migrators))
+	errs := make([]error, len(migrators))
+
+	var wg sync.WaitGroup
+	for i, m := range migrators {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			applied[i], errs[i] = m.Up(ctx)
+		}()
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		require.NoError(t, err)
+	}
+
+	assert.Equal(t, migrators[0].Latest(), applied[0]+applied[1], "every migration is applied once")
+
+	statuses, err := migrators[0].Status(ctx)
+	require.NoError(t, err)
+	require.Len(t, statuses, migrators[0].Latest())
+}
+
+func TestOpen(t *testing.T) {
+	s, err := New(Scheme + ":memory:")
+	require.NoError(t, err)
+	defer s.Close()
+
+	_, err = s.SaveUser(context.Background(), "user@example.com", models.SRPVerifier{})
+	require.NoError(t, err)
+
+	_, err = Open("postgres://localhost/keeper")
+	assert.Error(t, err)
+
+	_, err = Open(Scheme)
+	assert.Error(t, err)
+}
+
+func TestLegacyRecords(t *testing.T) {
+	ctx := context.Background()
+	s := newStorage(t)
+
+	user, err := s.SaveUser(ctx, "user@example.com", models.SRPVerifier{})
+	require.NoError(t, err)
+
+	var ids []int64
+	for _, content := range []string{"first", "second", "third"} {
+		id, err := s.NextDataID(ctx)
+		require.NoError(t, err)
+
+		require.NoError(t, s.SaveData(ctx, user.ID, models.Data{ID: id, DataType: "note", Content: content}))
+
+		ids = append(ids, id)
+	}
+
+	// records written before binding are marked by the migration
+	_, err = s.db.ExecContext(ctx, "UPDATE users_data SET legacy = true WHERE id <> ?", ids[1])
+	require.NoError(t, err)
+
+	records, err := s.LegacyRecords(ctx, 0, 1)
+	require.NoError(t, err)
+	assert.Equal(t, []models.LegacyRecord{{ID: ids[0], UserID: user.ID, DataType: "note", Content: "first"}}, records)
+
+	records, err = s.LegacyRecords(ctx, ids[0], 10)
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+	assert.Equal(t, ids[2], records[0].ID)
+
+	err = s.ResealRecord(ctx, user.ID+1, ids[0], "sealed")
+	assert.ErrorIs(t, err, storage.ErrDataNotFound)
+
+	require.NoError(t, s.ResealRecord(ctx, user.ID, ids[0], "sealed"))
+
+	err = s.ResealRecord(ctx, user.ID, ids[0], "sealed")
+	assert.ErrorIs(t, err, storage.ErrDataNotFound, "the record is resealed already")
+
+	data, err := s.GetData(ctx, user.ID)
+	require.NoError(t, err)
+	require.Len(t, data, 3)
+	assert.Equal(t, "sealed", data[0].Content)
+	assert.False(t, data[0].Legacy)
+	assert.True(t, data[2].Legacy)
+}
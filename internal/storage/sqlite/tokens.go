@@ -0,0 +1,548 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/nglmq/password-keeper/internal/domain/models"
+	"github.com/nglmq/password-keeper/internal/storage"
+)
+
+// SaveRefreshToken saves hash of the issued refresh token
+func (s *Storage) SaveRefreshToken(ctx context.Context, token models.RefreshToken) error {
+	_, err := s.db.ExecContext(ctx,
+		"INSERT INTO refresh_tokens(token_hash, user_id, family_id, expires_at) VALUES ($1, $2, $3, $4)",
+		token.Hash, token.UserID, token.FamilyID, token.ExpiresAt.UTC())
+	if err != nil {
+		return fmt.Errorf("failed to execute statement: %w", err)
+	}
+
+	return nil
+}
+
+// UseRefreshToken marks refresh token as used and returns it.
+// ErrTokenReused is returned together with the token if it was already used.
+func (s *Storage) UseRefreshToken(ctx context.Context, hash string) (models.RefreshToken, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return models.RefreshToken{}, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	token := models.RefreshToken{Hash: hash}
+
+	var usedAt, revokedAt sql.NullTime
+
+	err = tx.QueryRowContext(ctx, `
+		SELECT user_id, family_id, expires_at, used_at, revoked_at FROM refresh_tokens
+		WHERE token_hash = $1`, hash).Scan(&token.UserID, &token.FamilyID, &token.ExpiresAt, &usedAt, &revokedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.RefreshToken{}, storage.ErrTokenNotFound
+		}
+
+		return models.RefreshToken{}, fmt.Errorf("failed to execute statement: %w", err)
+	}
+
+	if revokedAt.Valid {
+		return token, storage.ErrTokenRevoked
+	}
+
+	if usedAt.Valid {
+		return token, storage.ErrTokenReused
+	}
+
+	_, err = tx.ExecContext(ctx, "UPDATE refresh_tokens SET used_at = $2 WHERE token_hash = $1", hash, now())
+	if err != nil {
+		return models.RefreshToken{}, fmt.Errorf("failed to execute statement: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return models.RefreshToken{}, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return token, nil
+}
+
+// RevokeTokenFamily revokes all refresh tokens of the family
+func (s *Storage) RevokeTokenFamily(ctx context.Context, familyID string) error {
+	_, err := s.db.ExecContext(ctx,
+		"UPDATE refresh_tokens SET revoked_at = $2 WHERE family_id = $1 AND revoked_at IS NULL", familyID, now())
+	if err != nil {
+		return fmt.Errorf("failed to execute statement: %w", err)
+	}
+
+	return nil
+}
+
+// RefreshToken returns refresh token by hash
+func (s *Storage) RefreshToken(ctx context.Context, hash string) (models.RefreshToken, error) {
+	token := models.RefreshToken{Hash: hash}
+
+	err := s.db.QueryRowContext(ctx, "SELECT user_id, family_id, expires_at FROM refresh_tokens WHERE token_hash = $1", hash).
+		Scan(&token.UserID, &token.FamilyID, &token.ExpiresAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.RefreshToken{}, storage.ErrTokenNotFound
+		}
+
+		return models.RefreshToken{}, fmt.Errorf("failed to execute statement: %w", err)
+	}
+
+	return token, nil
+}
+
+// RevokeToken adds access token to the revocation list until it expires
+func (s *Storage) RevokeToken(ctx context.Context, jti string, userID int64, expiresAt time.Time) error {
+	_, err := s.db.ExecContext(ctx,
+		"INSERT INTO revoked_tokens(jti, user_id, expires_at) VALUES ($1, $2, $3) ON CONFLICT (jti) DO NOTHING",
+		jti, userID, expiresAt.UTC())
+	if err != nil {
+		return fmt.Errorf("failed to execute statement: %w", err)
+	}
+
+	return nil
+}
+
+// RevokeUserTokens revokes all refresh tokens of the user and access tokens issued before the given time
+func (s *Storage) RevokeUserTokens(ctx context.Context, userID int64, before time.Time) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, "UPDATE users SET tokens_valid_after = $2 WHERE id = $1", userID, before.UTC())
+	if err != nil {
+		return fmt.Errorf("failed to execute statement: %w", err)
+	}
+
+	if err := revokeRefreshTokens(ctx, tx, userID); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// revokeRefreshTokens revokes all refresh tokens of the user
+func revokeRefreshTokens(ctx context.Context, tx *sql.Tx, userID int64) error {
+	_, err := tx.ExecContext(ctx,
+		"UPDATE refresh_tokens SET revoked_at = $2 WHERE user_id = $1 AND revoked_at IS NULL", userID, now())
+	if err != nil {
+		return fmt.Errorf("failed to execute statement: %w", err)
+	}
+
+	return nil
+}
+
+// IsTokenRevoked reports whether access token was revoked by itself or with all tokens of the user
+func (s *Storage) IsTokenRevoked(ctx context.Context, jti string, userID int64, issuedAt time.Time) (bool, error) {
+	var revoked bool
+
+	err := s.db.QueryRowContext(ctx, `
+		SELECT EXISTS(SELECT 1 FROM revoked_tokens WHERE jti = $1)
+		OR EXISTS(SELECT 1 FROM users WHERE id = $2 AND tokens_valid_after > $3)`,
+		jti, userID, issuedAt.UTC()).Scan(&revoked)
+	if err != nil {
+		return false, fmt.Errorf("failed to execute statement: %w", err)
+	}
+
+	return revoked, nil
+}
+
+// DeleteExpiredTokens deletes revoked access tokens and refresh tokens which are expired anyway
+func (s *Storage) DeleteExpiredTokens(ctx context.Context) (int64, error) {
+	var deleted int64
+
+	for _, query := range []string{
+		"DELETE FROM revoked_tokens WHERE expires_at < $1",
+		"DELETE FROM refresh_tokens WHERE expires_at < $1",
+		"DELETE FROM login_challenges WHERE expires_at < $1",
+		"DELETE FROM email_tokens WHERE expires_at < $1",
+		"DELETE FROM srp_sessions WHERE expires_at < $1",
+	} {
+		res, err := s.db.ExecContext(ctx, query, now())
+		if err != nil {
+			return deleted, fmt.Errorf("failed to execute statement: %w", err)
+		}
+
+		n, err := res.RowsAffected()
+		if err != nil {
+			return deleted, fmt.Errorf("failed to get affected rows: %w", err)
+		}
+
+		deleted += n
+	}
+
+	return deleted, nil
+}
+
+// SaveTOTP saves not yet confirmed TOTP secret of the user replacing the previous unconfirmed one.
+// Secret of the user with enabled TOTP is not replaced.
+func (s *Storage) SaveTOTP(ctx context.Context, userID int64, secret string) error {
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO users_totp(user_id, secret, created_at) VALUES ($1, $2, $3)
+		ON CONFLICT (user_id) DO UPDATE SET secret = excluded.secret, last_step = NULL, created_at = excluded.created_at
+		WHERE users_totp.enabled = false`, userID, secret, now())
+	if err != nil {
+		return fmt.Errorf("failed to execute statement: %w", err)
+	}
+
+	return nil
+}
+
+// TOTP returns TOTP secret of the user
+func (s *Storage) TOTP(ctx context.Context, userID int64) (models.TOTP, error) {
+	totp := models.TOTP{UserID: userID}
+
+	err := s.db.QueryRowContext(ctx, "SELECT secret, enabled FROM users_totp WHERE user_id = $1", userID).
+		Scan(&totp.Secret, &totp.Enabled)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.TOTP{}, storage.ErrTOTPNotFound
+		}
+
+		return models.TOTP{}, fmt.Errorf("failed to execute statement: %w", err)
+	}
+
+	return totp, nil
+}
+
+// EnableTOTP enables confirmed TOTP secret, marks the confirming step as used
+// and replaces recovery codes of the user with the given hashes
+func (s *Storage) EnableTOTP(ctx context.Context, userID int64, step int64, recoveryHashes []string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx,
+		"UPDATE users_totp SET enabled = true, last_step = $2 WHERE user_id = $1 AND enabled = false", userID, step)
+	if err != nil {
+		return fmt.Errorf("failed to execute statement: %w", err)
+	}
+
+	if err := affected(res, storage.ErrTOTPNotFound); err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, "DELETE FROM recovery_codes WHERE user_id = $1", userID)
+	if err != nil {
+		return fmt.Errorf("failed to execute statement: %w", err)
+	}
+
+	for _, hash := range recoveryHashes {
+		_, err = tx.ExecContext(ctx, "INSERT INTO recovery_codes(user_id, code_hash) VALUES ($1, $2)", userID, hash)
+		if err != nil {
+			return fmt.Errorf("failed to execute statement: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// UseTOTPStep marks time step of the code as used, codes of the same or earlier steps can not be used again
+func (s *Storage) UseTOTPStep(ctx context.Context, userID int64, step int64) error {
+	res, err := s.db.ExecContext(ctx, `
+		UPDATE users_totp SET last_step = $2
+		WHERE user_id = $1 AND enabled = true AND (last_step IS NULL OR last_step < $2)`, userID, step)
+	if err != nil {
+		return fmt.Errorf("failed to execute statement: %w", err)
+	}
+
+	return affected(res, storage.ErrTOTPCodeUsed)
+}
+
+// UseRecoveryCode marks recovery code of the user as used
+func (s *Storage) UseRecoveryCode(ctx context.Context, userID int64, hash string) error {
+	res, err := s.db.ExecContext(ctx, `
+		UPDATE recovery_codes SET used_at = $3
+		WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL`, userID, hash, now())
+	if err != nil {
+		return fmt.Errorf("failed to execute statement: %w", err)
+	}
+
+	return affected(res, storage.ErrRecoveryCodeNotFound)
+}
+
+// SaveLoginChallenge saves hash of the issued login challenge
+func (s *Storage) SaveLoginChallenge(ctx context.Context, challenge models.LoginChallenge) error {
+	_, err := s.db.ExecContext(ctx,
+		"INSERT INTO login_challenges(challenge_hash, user_id, expires_at) VALUES ($1, $2, $3)",
+		challenge.Hash, challenge.UserID, challenge.ExpiresAt.UTC())
+	if err != nil {
+		return fmt.Errorf("failed to execute statement: %w", err)
+	}
+
+	return nil
+}
+
+// UseLoginChallenge counts an attempt to complete the challenge and returns it.
+// ErrChallengeNotFound is returned if the challenge expired or has no attempts left.
+func (s *Storage) UseLoginChallenge(ctx context.Context, hash string, maxAttempts int) (models.LoginChallenge, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return models.LoginChallenge{}, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	challenge := models.LoginChallenge{Hash: hash}
+
+	err = tx.QueryRowContext(ctx, `
+		SELECT user_id, expires_at FROM login_challenges
+		WHERE challenge_hash = $1 AND attempts < $2 AND expires_at > $3`, hash, maxAttempts, now()).
+		Scan(&challenge.UserID, &challenge.ExpiresAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.LoginChallenge{}, storage.ErrChallengeNotFound
+		}
+
+		return models.LoginChallenge{}, fmt.Errorf("failed to execute statement: %w", err)
+	}
+
+	_, err = tx.ExecContext(ctx, "UPDATE login_challenges SET attempts = attempts + 1 WHERE challenge_hash = $1", hash)
+	if err != nil {
+		return models.LoginChallenge{}, fmt.Errorf("failed to execute statement: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return models.LoginChallenge{}, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return challenge, nil
+}
+
+// DeleteLoginChallenge deletes completed login challenge,
+// ErrChallengeNotFound is returned if the challenge was already completed by a concurrent request
+func (s *Storage) DeleteLoginChallenge(ctx context.Context, hash string) error {
+	res, err := s.db.ExecContext(ctx, "DELETE FROM login_challenges WHERE challenge_hash = $1", hash)
+	if err != nil {
+		return fmt.Errorf("failed to execute statement: %w", err)
+	}
+
+	return affected(res, storage.ErrChallengeNotFound)
+}
+
+// LoginAttempts returns failed login attempts of the key, zero attempts are returned for unknown keys
+func (s *Storage) LoginAttempts(ctx context.Context, key string) (models.LoginAttempts, error) {
+	attempts := models.LoginAttempts{Key: key}
+
+	var blockedUntil sql.NullTime
+
+	err := s.db.QueryRowContext(ctx, "SELECT failures, blocked_until FROM login_attempts WHERE attempt_key = $1", key).
+		Scan(&attempts.Failures, &blockedUntil)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return attempts, nil
+		}
+
+		return models.LoginAttempts{}, fmt.Errorf("failed to execute statement: %w", err)
+	}
+
+	if blockedUntil.Valid {
+		attempts.BlockedUntil = blockedUntil.Time
+	}
+
+	return attempts, nil
+}
+
+// RecordLoginFailure counts failed login attempt of the key and returns the number of failures in a row.
+// Failures are counted from scratch if the previous one was before resetBefore.
+func (s *Storage) RecordLoginFailure(ctx context.Context, key string, resetBefore time.Time) (int, error) {
+	var failures int
+
+	err := s.db.QueryRowContext(ctx, `
+		INSERT INTO login_attempts(attempt_key, failures, last_failure_at) VALUES ($1, 1, $3)
+		ON CONFLICT (attempt_key) DO UPDATE SET
+		failures = CASE WHEN login_attempts.last_failure_at < $2 THEN 1 ELSE login_attempts.failures + 1 END,
+		last_failure_at = excluded.last_failure_at
+		RETURNING failures`, key, resetBefore.UTC(), now()).Scan(&failures)
+	if err != nil {
+		return 0, fmt.Errorf("failed to execute statement: %w", err)
+	}
+
+	return failures, nil
+}
+
+// BlockLogin rejects login attempts of the key until the given time
+func (s *Storage) BlockLogin(ctx context.Context, key string, until time.Time) error {
+	_, err := s.db.ExecContext(ctx, "UPDATE login_attempts SET blocked_until = $2 WHERE attempt_key = $1", key, until.UTC())
+	if err != nil {
+		return fmt.Errorf("failed to execute statement: %w", err)
+	}
+
+	return nil
+}
+
+// ResetLoginAttempts forgets failed login attempts of the key
+func (s *Storage) ResetLoginAttempts(ctx context.Context, key string) error {
+	_, err := s.db.ExecContext(ctx, "DELETE FROM login_attempts WHERE attempt_key = $1", key)
+	if err != nil {
+		return fmt.Errorf("failed to execute statement: %w", err)
+	}
+
+	return nil
+}
+
+// DeleteStaleLoginAttempts deletes not blocked attempts with the last failure before the given time
+func (s *Storage) DeleteStaleLoginAttempts(ctx context.Context, before time.Time) (int64, error) {
+	res, err := s.db.ExecContext(ctx, `
+		DELETE FROM login_attempts
+		WHERE last_failure_at < $1 AND (blocked_until IS NULL OR blocked_until < $2)`, before.UTC(), now())
+	if err != nil {
+		return 0, fmt.Errorf("failed to execute statement: %w", err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get affected rows: %w", err)
+	}
+
+	return n, nil
+}
+
+// SaveEmailToken saves hash of the token sent to the user, unused tokens of the same purpose are deleted,
+// so only the latest email works
+func (s *Storage) SaveEmailToken(ctx context.Context, token models.EmailToken) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, "DELETE FROM email_tokens WHERE user_id = $1 AND purpose = $2 AND used_at IS NULL",
+		token.UserID, token.Purpose)
+	if err != nil {
+		return fmt.Errorf("failed to execute statement: %w", err)
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO email_tokens(token_hash, user_id, purpose, expires_at, created_at) VALUES ($1, $2, $3, $4, $5)`,
+		token.Hash, token.UserID, token.Purpose, token.ExpiresAt.UTC(), token.CreatedAt.UTC())
+	if err != nil {
+		return fmt.Errorf("failed to execute statement: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// LatestEmailToken returns the last token of the purpose sent to the user, used or not
+func (s *Storage) LatestEmailToken(ctx context.Context, userID int64, purpose string) (models.EmailToken, error) {
+	token := models.EmailToken{UserID: userID, Purpose: purpose}
+
+	err := s.db.QueryRowContext(ctx, `
+		SELECT token_hash, expires_at, created_at FROM email_tokens
+		WHERE user_id = $1 AND purpose = $2 ORDER BY created_at DESC LIMIT 1`,
+		userID, purpose).Scan(&token.Hash, &token.ExpiresAt, &token.CreatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.EmailToken{}, storage.ErrTokenNotFound
+		}
+
+		return models.EmailToken{}, fmt.Errorf("failed to execute statement: %w", err)
+	}
+
+	return token, nil
+}
+
+// EmailToken returns unused and not expired token of the purpose
+func (s *Storage) EmailToken(ctx context.Context, hash, purpose string) (models.EmailToken, error) {
+	token := models.EmailToken{Hash: hash, Purpose: purpose}
+
+	err := s.db.QueryRowContext(ctx, `
+		SELECT user_id, expires_at, created_at FROM email_tokens
+		WHERE token_hash = $1 AND purpose = $2 AND used_at IS NULL AND expires_at > $3`,
+		hash, purpose, now()).Scan(&token.UserID, &token.ExpiresAt, &token.CreatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.EmailToken{}, storage.ErrTokenNotFound
+		}
+
+		return models.EmailToken{}, fmt.Errorf("failed to execute statement: %w", err)
+	}
+
+	return token, nil
+}
+
+// useEmailToken marks unused and not expired token of the purpose as used and returns its user
+func useEmailToken(ctx context.Context, tx *sql.Tx, hash, purpose string) (int64, error) {
+	var userID int64
+
+	err := tx.QueryRowContext(ctx, `
+		UPDATE email_tokens SET used_at = $3
+		WHERE token_hash = $1 AND purpose = $2 AND used_at IS NULL AND expires_at > $3
+		RETURNING user_id`, hash, purpose, now()).Scan(&userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, storage.ErrTokenNotFound
+		}
+
+		return 0, fmt.Errorf("failed to execute statement: %w", err)
+	}
+
+	return userID, nil
+}
+
+// SaveSRPSession saves the first round of SRP login
+func (s *Storage) SaveSRPSession(ctx context.Context, session models.SRPSession) error {
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO srp_sessions(session_hash, user_id, client_public, server_secret, expires_at)
+		VALUES ($1, $2, $3, $4, $5)`,
+		session.Hash, session.UserID, session.ClientPublic, session.ServerSecret, session.ExpiresAt.UTC())
+	if err != nil {
+		return fmt.Errorf("failed to execute statement: %w", err)
+	}
+
+	return nil
+}
+
+// UseSRPSession deletes and returns the session, so a proof can be checked against it once.
+// ErrSessionNotFound is returned if the session expired or was already used.
+func (s *Storage) UseSRPSession(ctx context.Context, hash string) (models.SRPSession, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return models.SRPSession{}, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	session := models.SRPSession{Hash: hash}
+
+	err = tx.QueryRowContext(ctx, `
+		SELECT user_id, client_public, server_secret, expires_at FROM srp_sessions
+		WHERE session_hash = $1 AND expires_at > $2`, hash, now()).
+		Scan(&session.UserID, &session.ClientPublic, &session.ServerSecret, &session.ExpiresAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.SRPSession{}, storage.ErrSessionNotFound
+		}
+
+		return models.SRPSession{}, fmt.Errorf("failed to execute statement: %w", err)
+	}
+
+	_, err = tx.ExecContext(ctx, "DELETE FROM srp_sessions WHERE session_hash = $1", hash)
+	if err != nil {
+		return models.SRPSession{}, fmt.Errorf("failed to execute statement: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return models.SRPSession{}, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return session, nil
+}
@@ -1,9 +1,42 @@
 package storage
 
-import "errors"
+import (
+	"context"
+	"errors"
+)
 
 var (
 	ErrUserExists   = errors.New("user already exists")
 	ErrUserNotFound = errors.New("user not found")
 	ErrDataNotFound = errors.New("data not found")
+	ErrDataChanged  = errors.New("data changed concurrently")
+	ErrKeyNotFound  = errors.New("key not found")
+	ErrSaltNotFound = errors.New("salt not found")
+
+	ErrFileNotFound    = errors.New("file not found")
+	ErrChunkOutOfOrder = errors.New("chunk out of order")
+	ErrBlobNotFound    = errors.New("blob not found")
+
+	ErrTokenNotFound = errors.New("token not found")
+	ErrTokenRevoked  = errors.New("token revoked")
+	ErrTokenReused   = errors.New("token already used")
+
+	ErrTOTPNotFound         = errors.New("totp not found")
+	ErrTOTPCodeUsed         = errors.New("totp code already used")
+	ErrRecoveryCodeNotFound = errors.New("recovery code not found")
+	ErrChallengeNotFound    = errors.New("challenge not found")
+	ErrSessionNotFound      = errors.New("login session not found")
 )
+
+// BlobStore keeps content of files attached to records outside of the tables of the storage.
+// Keys are slash separated paths like "files/1/0-abcd".
+type BlobStore interface {
+	// Put saves the blob, an existing blob with the key is replaced
+	Put(ctx context.Context, key string, data []byte) error
+	// Get returns the blob, ErrBlobNotFound is returned if there is no blob with the key
+	Get(ctx context.Context, key string) ([]byte, error)
+	// Delete deletes the blob, deleting a missing blob is not an error
+	Delete(ctx context.Context, key string) error
+	// List returns keys of all blobs starting with the prefix
+	List(ctx context.Context, prefix string) ([]string, error)
+}
@@ -0,0 +1,977 @@
+// Package storagetest is the conformance suite of storages, every storage backend must pass it
+// to be used by the services.
+package storagetest
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nglmq/password-keeper/internal/domain/models"
+	"github.com/nglmq/password-keeper/internal/lib/jwt"
+	"github.com/nglmq/password-keeper/internal/services/auth"
+	"github.com/nglmq/password-keeper/internal/services/rotation"
+	"github.com/nglmq/password-keeper/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Storage is everything the services need from a storage
+type Storage interface {
+	auth.AuthStorage
+	auth.DataStorage
+	auth.KeyStorage
+	auth.ResealStorage
+	rotation.KeyStorage
+	jwt.RevocationList
+}
+
+// Run runs the suite, newStorage returns an empty storage for every test
+func Run(t *testing.T, newStorage func(t *testing.T) Storage) {
+	tests := []struct {
+		name string
+		run  func(t *testing.T, s Storage)
+	}{
+		{name: "Users", run: testUsers},
+		{name: "Data", run: testData},
+		{name: "LegacyRecords", run: testLegacyRecords},
+		{name: "Files", run: testFiles},
+		{name: "ConcurrentChunks", run: testConcurrentChunks},
+		{name: "Keys", run: testKeys},
+		{name: "RefreshTokens", run: testRefreshTokens},
+		{name: "Revocation", run: testRevocation},
+		{name: "TOTP", run: testTOTP},
+		{name: "LoginChallenges", run: testLoginChallenges},
+		{name: "LoginAttempts", run: testLoginAttempts},
+		{name: "ConcurrentLoginFailures", run: testConcurrentLoginFailures},
+		{name: "EmailTokens", run: testEmailTokens},
+		{name: "ResetPassword", run: testResetPassword},
+		{name: "Account", run: testAccount},
+		{name: "ChangePassword", run: testChangePassword},
+		{name: "SRPSessions", run: testSRPSessions},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.run(t, newStorage(t))
+		})
+	}
+}
+
+// RunBlobStore runs the suite of blob stores, newStore returns an empty store for every test
+func RunBlobStore(t *testing.T, newStore func(t *testing.T) storage.BlobStore) {
+	ctx := context.Background()
+	s := newStore(t)
+
+	require.NoError(t, s.Put(ctx, "files/1/0-aa", []byte("first")))
+	require.NoError(t, s.Put(ctx, "files/1/1-bb", []byte("second")))
+	require.NoError(t, s.Put(ctx, "files/2/0-cc", []byte("other")))
+
+	got, err := s.Get(ctx, "files/1/0-aa")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("first"), got)
+
+	require.NoError(t, s.Put(ctx, "files/1/0-aa", []byte("replaced")))
+
+	got, err = s.Get(ctx, "files/1/0-aa")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("replaced"), got)
+
+	keys, err := s.List(ctx, "files/1/")
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"files/1/0-aa", "files/1/1-bb"}, keys)
+
+	require.NoError(t, s.Delete(ctx, "files/1/0-aa"))
+	require.NoError(t, s.Delete(ctx, "files/1/0-aa"), "deleting a missing blob is not an error")
+
+	_, err = s.Get(ctx, "files/1/0-aa")
+	assert.ErrorIs(t, err, storage.ErrBlobNotFound)
+
+	keys, err = s.List(ctx, "files/")
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"files/1/1-bb", "files/2/0-cc"}, keys)
+}
+
+// now is the current time with precision every storage keeps
+func now() time.Time {
+	return time.Now().UTC().Truncate(time.Second)
+}
+
+func verifier(seed string) models.SRPVerifier {
+	return models.SRPVerifier{
+		Salt:     []byte("salt-" + seed),
+		Params:   "m=65536,t=3,p=4",
+		Verifier: []byte("verifier-" + seed),
+	}
+}
+
+func newUser(t *testing.T, s Storage, email string) models.User {
+	t.Helper()
+
+	user, err := s.SaveUser(context.Background(), email, verifier(email))
+	require.NoError(t, err)
+
+	return user
+}
+
+func newRecord(t *testing.T, s Storage, userID int64, content string) models.Data {
+	t.Helper()
+
+	ctx := context.Background()
+
+	id, err := s.NextDataID(ctx)
+	require.NoError(t, err)
+
+	record := models.Data{
+		ID:        id,
+		DataType:  "note",
+		Content:   content,
+		CreatedAt: now(),
+		UpdatedAt: now(),
+	}
+
+	require.NoError(t, s.SaveData(ctx, userID, record))
+
+	return record
+}
+
+func newFile(t *testing.T, s Storage, userID, recordID int64, chunkCount int) models.File {
+	t.Helper()
+
+	ctx := context.Background()
+
+	id, err := s.NextFileID(ctx)
+	require.NoError(t, err)
+
+	file := models.File{
+		ID:         id,
+		RecordID:   recordID,
+		Meta:       "meta",
+		ChunkCount: chunkCount,
+		CreatedAt:  now(),
+	}
+
+	require.NoError(t, s.SaveFile(ctx, userID, file))
+
+	return file
+}
+
+// contents returns contents of the records, storage/pg keeps them as JSON strings and returns them quoted
+func contents(records []models.Data) []string {
+	res := make([]string, 0, len(records))
+	for _, r := range records {
+		res = append(res, strings.Trim(r.Content, `"`))
+	}
+
+	return res
+}
+
+func testUsers(t *testing.T, s Storage) {
+	ctx := context.Background()
+
+	user := newUser(t, s, "user@example.com")
+	assert.NotZero(t, user.ID)
+	assert.Equal(t, "user@example.com", user.Email)
+	assert.Equal(t, verifier("user@example.com"), user.SRP)
+	assert.Empty(t, user.PassHash)
+	assert.False(t, user.EmailVerified)
+	assert.False(t, user.Deleted)
+
+	_, err := s.SaveUser(ctx, "user@example.com", verifier("other"))
+	assert.ErrorIs(t, err, storage.ErrUserExists)
+
+	got, err := s.User(ctx, "user@example.com")
+	require.NoError(t, err)
+	assert.Equal(t, user, got)
+
+	got, err = s.UserByID(ctx, user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, user, got)
+
+	_, err = s.User(ctx, "missing@example.com")
+	assert.ErrorIs(t, err, storage.ErrUserNotFound)
+
+	_, err = s.UserByID(ctx, user.ID+100)
+	assert.ErrorIs(t, err, storage.ErrUserNotFound)
+
+	_, err = s.VaultSalt(ctx, user.ID)
+	assert.ErrorIs(t, err, storage.ErrSaltNotFound)
+
+	require.NoError(t, s.SaveVaultSalt(ctx, user.ID, []byte("vault salt")))
+	require.NoError(t, s.SaveVaultSalt(ctx, user.ID, []byte("other salt")))
+
+	salt, err := s.VaultSalt(ctx, user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("vault salt"), salt, "saved salt is kept")
+
+	_, err = s.VaultSalt(ctx, user.ID+100)
+	assert.ErrorIs(t, err, storage.ErrUserNotFound)
+
+	// users without password hash are not migrated again
+	require.NoError(t, s.MigrateToVerifier(ctx, user.ID, nil, verifier("other")))
+
+	got, err = s.UserByID(ctx, user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, verifier("user@example.com"), got.SRP)
+
+	upgraded := verifier("upgraded")
+	upgraded.Params = "m=131072,t=4,p=4"
+
+	require.NoError(t, s.UpgradeVerifier(ctx, user.ID, got.SRP.Verifier, upgraded))
+
+	got, err = s.UserByID(ctx, user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, upgraded, got.SRP)
+
+	// the verifier changed meanwhile is not replaced
+	require.NoError(t, s.UpgradeVerifier(ctx, user.ID, verifier("user@example.com").Verifier, verifier("other")))
+
+	got, err = s.UserByID(ctx, user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, upgraded, got.SRP)
+}
+
+func testData(t *testing.T, s Storage) {
+	ctx := context.Background()
+
+	user := newUser(t, s, "user@example.com")
+	other := newUser(t, s, "other@example.com")
+
+	_, err := s.GetData(ctx, user.ID)
+	assert.ErrorIs(t, err, storage.ErrDataNotFound)
+
+	first := newRecord(t, s, user.ID, "first")
+	second := newRecord(t, s, user.ID, "second")
+	assert.Greater(t, second.ID, first.ID)
+
+	data, err := s.GetData(ctx, user.ID)
+	require.NoError(t, err)
+	require.Len(t, data, 2)
+	assert.Equal(t, []string{"first", "second"}, contents(data))
+	assert.Equal(t, first.ID, data[0].ID)
+	assert.Equal(t, "note", data[0].DataType)
+	assert.Equal(t, first.CreatedAt, data[0].CreatedAt)
+	assert.Equal(t, first.UpdatedAt, data[0].UpdatedAt)
+	assert.Empty(t, data[0].Files)
+
+	_, err = s.GetData(ctx, other.ID)
+	assert.ErrorIs(t, err, storage.ErrDataNotFound, "records of other users are not returned")
+
+	updated := first
+	updated.DataType = "login"
+	updated.Content = "updated"
+	updated.UpdatedAt = now().Add(time.Minute)
+
+	err = s.UpdateData(ctx, other.ID, updated)
+	assert.ErrorIs(t, err, storage.ErrDataNotFound)
+
+	require.NoError(t, s.UpdateData(ctx, user.ID, updated))
+
+	data, err = s.GetData(ctx, user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"updated", "second"}, contents(data))
+	assert.Equal(t, "login", data[0].DataType)
+	assert.Equal(t, first.CreatedAt, data[0].CreatedAt)
+	assert.Equal(t, updated.UpdatedAt, data[0].UpdatedAt)
+
+	err = s.DeleteData(ctx, other.ID, first.ID)
+	assert.ErrorIs(t, err, storage.ErrDataNotFound)
+
+	require.NoError(t, s.DeleteData(ctx, user.ID, first.ID))
+
+	err = s.DeleteData(ctx, user.ID, first.ID)
+	assert.ErrorIs(t, err, storage.ErrDataNotFound)
+
+	data, err = s.GetData(ctx, user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"second"}, contents(data))
+}
+
+// testLegacyRecords checks records saved by the services are never legacy,
+// backends mark legacy records in their own tests
+func testLegacyRecords(t *testing.T, s Storage) {
+	ctx := context.Background()
+
+	user := newUser(t, s, "user@example.com")
+	record := newRecord(t, s, user.ID, "first")
+
+	records, err := s.LegacyRecords(ctx, 0, 10)
+	require.NoError(t, err)
+	assert.Empty(t, records)
+
+	err = s.ResealRecord(ctx, user.ID, record.ID, "sealed")
+	assert.ErrorIs(t, err, storage.ErrDataNotFound, "only legacy records are resealed")
+
+	data, err := s.GetData(ctx, user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"first"}, contents(data))
+	assert.False(t, data[0].Legacy)
+}
+
+func testFiles(t *testing.T, s Storage) {
+	ctx := context.Background()
+
+	user := newUser(t, s, "user@example.com")
+	other := newUser(t, s, "other@example.com")
+	record := newRecord(t, s, user.ID, "content")
+
+	id, err := s.NextFileID(ctx)
+	require.NoError(t, err)
+
+	err = s.SaveFile(ctx, other.ID, models.File{ID: id, RecordID: record.ID, Meta: "meta", ChunkCount: 2, CreatedAt: now()})
+	assert.ErrorIs(t, err, storage.ErrDataNotFound, "files are attached only to own records")
+
+	file := newFile(t, s, user.ID, record.ID, 2)
+
+	got, err := s.File(ctx, user.ID, file.ID)
+	require.NoError(t, err)
+	assert.Equal(t, file, got)
+	assert.False(t, got.Completed())
+
+	_, err = s.File(ctx, other.ID, file.ID)
+	assert.ErrorIs(t, err, storage.ErrFileNotFound)
+
+	uploadedAt := now()
+
+	got, err = s.SaveFileChunk(ctx, user.ID, models.FileChunk{FileID: file.ID, Index: 1, Checksum: []byte("sum1")}, uploadedAt)
+	assert.ErrorIs(t, err, storage.ErrChunkOutOfOrder)
+	assert.Equal(t, 0, got.UploadedChunks, "the file is returned with the error")
+
+	_, err = s.SaveFileChunk(ctx, other.ID, models.FileChunk{FileID: file.ID, Index: 0, Checksum: []byte("sum0")}, uploadedAt)
+	assert.ErrorIs(t, err, storage.ErrFileNotFound)
+
+	got, err = s.SaveFileChunk(ctx, user.ID,
+		models.FileChunk{FileID: file.ID, Index: 0, Data: []byte("data0"), Checksum: []byte("sum0")}, uploadedAt)
+	require.NoError(t, err)
+	assert.Equal(t, 1, got.UploadedChunks)
+	assert.True(t, got.CompletedAt.IsZero())
+
+	_, err = s.SaveFileChunk(ctx, user.ID, models.FileChunk{FileID: file.ID, Index: 0, Checksum: []byte("sum0")}, uploadedAt)
+	assert.ErrorIs(t, err, storage.ErrChunkOutOfOrder)
+
+	// data of chunks kept in the blob store is not saved
+	got, err = s.SaveFileChunk(ctx, user.ID, models.FileChunk{FileID: file.ID, Index: 1, Checksum: []byte("sum1")}, uploadedAt)
+	require.NoError(t, err)
+	assert.Equal(t, 2, got.UploadedChunks)
+	assert.True(t, got.Completed())
+	assert.Equal(t, uploadedAt, got.CompletedAt)
+
+	_, err = s.SaveFileChunk(ctx, user.ID, models.FileChunk{FileID: file.ID, Index: 2, Checksum: []byte("sum2")}, uploadedAt)
+	assert.ErrorIs(t, err, storage.ErrChunkOutOfOrder, "completed uploads take no chunks")
+
+	chunk, err := s.FileChunk(ctx, file.ID, 0)
+	require.NoError(t, err)
+	assert.Equal(t, models.FileChunk{FileID: file.ID, Index: 0, Data: []byte("data0"), Checksum: []byte("sum0")}, chunk)
+
+	chunk, err = s.FileChunk(ctx, file.ID, 1)
+	require.NoError(t, err)
+	assert.Nil(t, chunk.Data)
+	assert.Equal(t, []byte("sum1"), chunk.Checksum)
+
+	_, err = s.FileChunk(ctx, file.ID, 2)
+	assert.ErrorIs(t, err, storage.ErrFileNotFound)
+
+	data, err := s.GetData(ctx, user.ID)
+	require.NoError(t, err)
+	require.Len(t, data, 1)
+	require.Len(t, data[0].Files, 1)
+	assert.Equal(t, file.ID, data[0].Files[0].ID)
+	assert.True(t, data[0].Files[0].Completed())
+
+	existing, err := s.ExistingFiles(ctx, []int64{file.ID, file.ID + 100})
+	require.NoError(t, err)
+	assert.Equal(t, map[int64]bool{file.ID: true}, existing)
+
+	// files are deleted with their record
+	require.NoError(t, s.DeleteData(ctx, user.ID, record.ID))
+
+	_, err = s.File(ctx, user.ID, file.ID)
+	assert.ErrorIs(t, err, storage.ErrFileNotFound)
+
+	_, err = s.FileChunk(ctx, file.ID, 0)
+	assert.ErrorIs(t, err, storage.ErrFileNotFound)
+
+	existing, err = s.ExistingFiles(ctx, []int64{file.ID})
+	require.NoError(t, err)
+	assert.Empty(t, existing)
+}
+
+func testConcurrentChunks(t *testing.T, s Storage) {
+	ctx := context.Background()
+
+	user := newUser(t, s, "user@example.com")
+	record := newRecord(t, s, user.ID, "content")
+	file := newFile(t, s, user.ID, record.ID, 3)
+
+	const uploads = 10
+
+	var (
+		wg    sync.WaitGroup
+		mu    sync.Mutex
+		saved int
+	)
+
+	for range uploads {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			_, err := s.SaveFileChunk(ctx, user.ID, models.FileChunk{FileID: file.ID, Index: 0, Checksum: []byte("sum")}, now())
+			if err == nil {
+				mu.Lock()
+				saved++
+				mu.Unlock()
+
+				return
+			}
+
+			assert.ErrorIs(t, err, storage.ErrChunkOutOfOrder)
+		}()
+	}
+
+	wg.Wait()
+
+	assert.Equal(t, 1, saved, "the chunk is saved once")
+
+	got, err := s.File(ctx, user.ID, file.ID)
+	require.NoError(t, err)
+	assert.Equal(t, 1, got.UploadedChunks)
+}
+
+func testKeys(t *testing.T, s Storage) {
+	ctx := context.Background()
+
+	first := newUser(t, s, "first@example.com")
+	second := newUser(t, s, "second@example.com")
+
+	_, err := s.UserKey(ctx, first.ID)
+	assert.ErrorIs(t, err, storage.ErrKeyNotFound)
+
+	require.NoError(t, s.SaveUserKey(ctx, first.ID, "wrapped-1", 1))
+	require.NoError(t, s.SaveUserKey(ctx, first.ID, "other", 1))
+	require.NoError(t, s.SaveUserKey(ctx, second.ID, "wrapped-2", 1))
+
+	key, err := s.UserKey(ctx, first.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "wrapped-1", key, "saved key is kept")
+
+	keys, err := s.UserKeysToRewrap(ctx, 2, 0, 1)
+	require.NoError(t, err)
+	assert.Equal(t, []models.UserKey{{UserID: first.ID, WrappedKey: "wrapped-1"}}, keys)
+
+	err = s.UpdateUserKey(ctx, first.ID, "stale", "rewrapped-1", 2)
+	assert.ErrorIs(t, err, storage.ErrKeyNotFound)
+
+	require.NoError(t, s.UpdateUserKey(ctx, first.ID, "wrapped-1", "rewrapped-1", 2))
+
+	keys, err = s.UserKeysToRewrap(ctx, 2, 0, 10)
+	require.NoError(t, err)
+	assert.Equal(t, []models.UserKey{{UserID: second.ID, WrappedKey: "wrapped-2"}}, keys)
+
+	keys, err = s.UserKeysToRewrap(ctx, 2, second.ID, 10)
+	require.NoError(t, err)
+	assert.Empty(t, keys)
+}
+
+func testRefreshTokens(t *testing.T, s Storage) {
+	ctx := context.Background()
+
+	user := newUser(t, s, "user@example.com")
+
+	token := models.RefreshToken{Hash: "hash-1", UserID: user.ID, FamilyID: "family", ExpiresAt: now().Add(time.Hour)}
+	require.NoError(t, s.SaveRefreshToken(ctx, token))
+
+	got, err := s.RefreshToken(ctx, token.Hash)
+	require.NoError(t, err)
+	assert.Equal(t, token, got)
+
+	got, err = s.UseRefreshToken(ctx, token.Hash)
+	require.NoError(t, err)
+	assert.Equal(t, token, got)
+
+	got, err = s.UseRefreshToken(ctx, token.Hash)
+	assert.ErrorIs(t, err, storage.ErrTokenReused)
+	assert.Equal(t, token, got, "reused token is returned to revoke its family")
+
+	next := models.RefreshToken{Hash: "hash-2", UserID: user.ID, FamilyID: "family", ExpiresAt: now().Add(time.Hour)}
+	require.NoError(t, s.SaveRefreshToken(ctx, next))
+	require.NoError(t, s.RevokeTokenFamily(ctx, "family"))
+
+	got, err = s.UseRefreshToken(ctx, next.Hash)
+	assert.ErrorIs(t, err, storage.ErrTokenRevoked)
+	assert.Equal(t, next, got)
+
+	_, err = s.UseRefreshToken(ctx, "missing")
+	assert.ErrorIs(t, err, storage.ErrTokenNotFound)
+
+	_, err = s.RefreshToken(ctx, "missing")
+	assert.ErrorIs(t, err, storage.ErrTokenNotFound)
+}
+
+func testRevocation(t *testing.T, s Storage) {
+	ctx := context.Background()
+
+	user := newUser(t, s, "user@example.com")
+	issuedAt := now().Add(-time.Minute)
+
+	require.NoError(t, s.RevokeToken(ctx, "jti-1", user.ID, now().Add(time.Hour)))
+	require.NoError(t, s.RevokeToken(ctx, "jti-1", user.ID, now().Add(time.Hour)))
+
+	revoked, err := s.IsTokenRevoked(ctx, "jti-1", user.ID, issuedAt)
+	require.NoError(t, err)
+	assert.True(t, revoked)
+
+	revoked, err = s.IsTokenRevoked(ctx, "jti-2", user.ID, issuedAt)
+	require.NoError(t, err)
+	assert.False(t, revoked)
+
+	token := models.RefreshToken{Hash: "hash", UserID: user.ID, FamilyID: "family", ExpiresAt: now().Add(time.Hour)}
+	require.NoError(t, s.SaveRefreshToken(ctx, token))
+
+	require.NoError(t, s.RevokeUserTokens(ctx, user.ID, now()))
+
+	revoked, err = s.IsTokenRevoked(ctx, "jti-2", user.ID, issuedAt)
+	require.NoError(t, err)
+	assert.True(t, revoked, "tokens issued before are revoked")
+
+	revoked, err = s.IsTokenRevoked(ctx, "jti-3", user.ID, now().Add(time.Minute))
+	require.NoError(t, err)
+	assert.False(t, revoked, "tokens issued after are valid")
+
+	// iat has milliseconds, tokens issued earlier in the same second are revoked
+	before := now().Add(500 * time.Millisecond)
+	require.NoError(t, s.RevokeUserTokens(ctx, user.ID, before))
+
+	revoked, err = s.IsTokenRevoked(ctx, "jti-4", user.ID, before.Add(-time.Millisecond))
+	require.NoError(t, err)
+	assert.True(t, revoked, "tokens issued a millisecond before are revoked")
+
+	revoked, err = s.IsTokenRevoked(ctx, "jti-5", user.ID, before)
+	require.NoError(t, err)
+	assert.False(t, revoked, "tokens issued at the time are valid")
+
+	_, err = s.UseRefreshToken(ctx, token.Hash)
+	assert.ErrorIs(t, err, storage.ErrTokenRevoked)
+
+	// expired tokens are deleted, the others are kept
+	require.NoError(t, s.RevokeToken(ctx, "expired", user.ID, now().Add(-time.Hour)))
+	require.NoError(t, s.SaveRefreshToken(ctx,
+		models.RefreshToken{Hash: "expired", UserID: user.ID, FamilyID: "old", ExpiresAt: now().Add(-time.Hour)}))
+
+	deleted, err := s.DeleteExpiredTokens(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), deleted)
+
+	_, err = s.RefreshToken(ctx, "expired")
+	assert.ErrorIs(t, err, storage.ErrTokenNotFound)
+
+	_, err = s.RefreshToken(ctx, token.Hash)
+	require.NoError(t, err)
+
+	revoked, err = s.IsTokenRevoked(ctx, "jti-1", user.ID, now().Add(time.Minute))
+	require.NoError(t, err)
+	assert.True(t, revoked)
+}
+
+func testTOTP(t *testing.T, s Storage) {
+	ctx := context.Background()
+
+	user := newUser(t, s, "user@example.com")
+
+	_, err := s.TOTP(ctx, user.ID)
+	assert.ErrorIs(t, err, storage.ErrTOTPNotFound)
+
+	require.NoError(t, s.SaveTOTP(ctx, user.ID, "secret-1"))
+	require.NoError(t, s.SaveTOTP(ctx, user.ID, "secret-2"))
+
+	totp, err := s.TOTP(ctx, user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, models.TOTP{UserID: user.ID, Secret: "secret-2"}, totp, "unconfirmed secret is replaced")
+
+	err = s.UseTOTPStep(ctx, user.ID, 5)
+	assert.ErrorIs(t, err, storage.ErrTOTPCodeUsed, "codes of not enabled secrets are not accepted")
+
+	require.NoError(t, s.EnableTOTP(ctx, user.ID, 10, []string{"code-1", "code-2"}))
+
+	err = s.EnableTOTP(ctx, user.ID, 11, nil)
+	assert.ErrorIs(t, err, storage.ErrTOTPNotFound)
+
+	require.NoError(t, s.SaveTOTP(ctx, user.ID, "secret-3"))
+
+	totp, err = s.TOTP(ctx, user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, models.TOTP{UserID: user.ID, Secret: "secret-2", Enabled: true}, totp, "enabled secret is kept")
+
+	err = s.UseTOTPStep(ctx, user.ID, 10)
+	assert.ErrorIs(t, err, storage.ErrTOTPCodeUsed, "the confirming step is used")
+
+	require.NoError(t, s.UseTOTPStep(ctx, user.ID, 11))
+
+	err = s.UseTOTPStep(ctx, user.ID, 11)
+	assert.ErrorIs(t, err, storage.ErrTOTPCodeUsed)
+
+	require.NoError(t, s.UseRecoveryCode(ctx, user.ID, "code-1"))
+
+	err = s.UseRecoveryCode(ctx, user.ID, "code-1")
+	assert.ErrorIs(t, err, storage.ErrRecoveryCodeNotFound)
+
+	err = s.UseRecoveryCode(ctx, user.ID, "missing")
+	assert.ErrorIs(t, err, storage.ErrRecoveryCodeNotFound)
+}
+
+func testLoginChallenges(t *testing.T, s Storage) {
+	ctx := context.Background()
+
+	user := newUser(t, s, "user@example.com")
+
+	challenge := models.LoginChallenge{Hash: "hash", UserID: user.ID, ExpiresAt: now().Add(time.Minute)}
+	require.NoError(t, s.SaveLoginChallenge(ctx, challenge))
+
+	for range 2 {
+		got, err := s.UseLoginChallenge(ctx, challenge.Hash, 2)
+		require.NoError(t, err)
+		assert.Equal(t, challenge, got)
+	}
+
+	_, err := s.UseLoginChallenge(ctx, challenge.Hash, 2)
+	assert.ErrorIs(t, err, storage.ErrChallengeNotFound, "no attempts left")
+
+	require.NoError(t, s.DeleteLoginChallenge(ctx, challenge.Hash))
+
+	err = s.DeleteLoginChallenge(ctx, challenge.Hash)
+	assert.ErrorIs(t, err, storage.ErrChallengeNotFound)
+
+	expired := models.LoginChallenge{Hash: "expired", UserID: user.ID, ExpiresAt: now().Add(-time.Minute)}
+	require.NoError(t, s.SaveLoginChallenge(ctx, expired))
+
+	_, err = s.UseLoginChallenge(ctx, expired.Hash, 2)
+	assert.ErrorIs(t, err, storage.ErrChallengeNotFound)
+}
+
+func testLoginAttempts(t *testing.T, s Storage) {
+	ctx := context.Background()
+
+	attempts, err := s.LoginAttempts(ctx, "email:user@example.com")
+	require.NoError(t, err)
+	assert.Equal(t, models.LoginAttempts{Key: "email:user@example.com"}, attempts)
+
+	resetBefore := now().Add(-time.Hour)
+
+	for want := 1; want <= 2; want++ {
+		failures, err := s.RecordLoginFailure(ctx, "email:user@example.com", resetBefore)
+		require.NoError(t, err)
+		assert.Equal(t, want, failures)
+	}
+
+	failures, err := s.RecordLoginFailure(ctx, "email:user@example.com", now().Add(time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, 1, failures, "failures before resetBefore are forgotten")
+
+	blockedUntil := now().Add(time.Hour)
+	require.NoError(t, s.BlockLogin(ctx, "email:user@example.com", blockedUntil))
+
+	attempts, err = s.LoginAttempts(ctx, "email:user@example.com")
+	require.NoError(t, err)
+	assert.Equal(t, 1, attempts.Failures)
+	assert.Equal(t, blockedUntil, attempts.BlockedUntil)
+
+	_, err = s.RecordLoginFailure(ctx, "ip:127.0.0.1", resetBefore)
+	require.NoError(t, err)
+
+	// blocked keys are kept until the block ends
+	deleted, err := s.DeleteStaleLoginAttempts(ctx, now().Add(time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), deleted)
+
+	attempts, err = s.LoginAttempts(ctx, "ip:127.0.0.1")
+	require.NoError(t, err)
+	assert.Zero(t, attempts.Failures)
+
+	require.NoError(t, s.ResetLoginAttempts(ctx, "email:user@example.com"))
+
+	attempts, err = s.LoginAttempts(ctx, "email:user@example.com")
+	require.NoError(t, err)
+	assert.Equal(t, models.LoginAttempts{Key: "email:user@example.com"}, attempts)
+}
+
+func testConcurrentLoginFailures(t *testing.T, s Storage) {
+	ctx := context.Background()
+
+	const failures = 20
+
+	var wg sync.WaitGroup
+
+	for range failures {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			_, err := s.RecordLoginFailure(ctx, "email:user@example.com", time.Time{})
+			assert.NoError(t, err)
+		}()
+	}
+
+	wg.Wait()
+
+	attempts, err := s.LoginAttempts(ctx, "email:user@example.com")
+	require.NoError(t, err)
+	assert.Equal(t, failures, attempts.Failures, "every failure is counted")
+}
+
+func testEmailTokens(t *testing.T, s Storage) {
+	ctx := context.Background()
+
+	user := newUser(t, s, "user@example.com")
+
+	_, err := s.LatestEmailToken(ctx, user.ID, models.EmailTokenVerify)
+	assert.ErrorIs(t, err, storage.ErrTokenNotFound)
+
+	first := models.EmailToken{
+		Hash:      "hash-1",
+		UserID:    user.ID,
+		Purpose:   models.EmailTokenVerify,
+		ExpiresAt: now().Add(time.Hour),
+		CreatedAt: now().Add(-time.Minute),
+	}
+	require.NoError(t, s.SaveEmailToken(ctx, first))
+
+	second := first
+	second.Hash = "hash-2"
+	second.CreatedAt = now()
+	require.NoError(t, s.SaveEmailToken(ctx, second))
+
+	_, err = s.EmailToken(ctx, first.Hash, models.EmailTokenVerify)
+	assert.ErrorIs(t, err, storage.ErrTokenNotFound, "only the latest token works")
+
+	got, err := s.EmailToken(ctx, second.Hash, models.EmailTokenVerify)
+	require.NoError(t, err)
+	assert.Equal(t, second, got)
+
+	_, err = s.EmailToken(ctx, second.Hash, models.EmailTokenReset)
+	assert.ErrorIs(t, err, storage.ErrTokenNotFound)
+
+	userID, err := s.VerifyEmail(ctx, second.Hash)
+	require.NoError(t, err)
+	assert.Equal(t, user.ID, userID)
+
+	_, err = s.VerifyEmail(ctx, second.Hash)
+	assert.ErrorIs(t, err, storage.ErrTokenNotFound)
+
+	verified, err := s.UserByID(ctx, user.ID)
+	require.NoError(t, err)
+	assert.True(t, verified.EmailVerified)
+
+	got, err = s.LatestEmailToken(ctx, user.ID, models.EmailTokenVerify)
+	require.NoError(t, err)
+	assert.Equal(t, second, got, "used tokens are latest as well")
+
+	expired := first
+	expired.Hash = "expired"
+	expired.Purpose = models.EmailTokenReset
+	expired.ExpiresAt = now().Add(-time.Minute)
+	require.NoError(t, s.SaveEmailToken(ctx, expired))
+
+	_, err = s.EmailToken(ctx, expired.Hash, models.EmailTokenReset)
+	assert.ErrorIs(t, err, storage.ErrTokenNotFound)
+}
+
+func testResetPassword(t *testing.T, s Storage) {
+	ctx := context.Background()
+
+	user := newUser(t, s, "user@example.com")
+	record := newRecord(t, s, user.ID, "content")
+	newFile(t, s, user.ID, record.ID, 1)
+
+	require.NoError(t, s.SaveVaultSalt(ctx, user.ID, []byte("vault salt")))
+	require.NoError(t, s.SaveRefreshToken(ctx,
+		models.RefreshToken{Hash: "refresh", UserID: user.ID, FamilyID: "family", ExpiresAt: now().Add(time.Hour)}))
+	require.NoError(t, s.SaveEmailToken(ctx, models.EmailToken{
+		Hash:      "reset",
+		UserID:    user.ID,
+		Purpose:   models.EmailTokenReset,
+		ExpiresAt: now().Add(time.Hour),
+		CreatedAt: now(),
+	}))
+
+	_, err := s.ResetPassword(ctx, "missing", verifier("new"), now())
+	assert.ErrorIs(t, err, storage.ErrTokenNotFound)
+
+	validAfter := now()
+
+	userID, err := s.ResetPassword(ctx, "reset", verifier("new"), validAfter)
+	require.NoError(t, err)
+	assert.Equal(t, user.ID, userID)
+
+	_, err = s.ResetPassword(ctx, "reset", verifier("other"), now())
+	assert.ErrorIs(t, err, storage.ErrTokenNotFound, "the token is used once")
+
+	got, err := s.UserByID(ctx, user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, verifier("new"), got.SRP)
+	assert.True(t, got.EmailVerified)
+
+	_, err = s.GetData(ctx, user.ID)
+	assert.ErrorIs(t, err, storage.ErrDataNotFound, "records of the old vault key are deleted")
+
+	_, err = s.VaultSalt(ctx, user.ID)
+	assert.ErrorIs(t, err, storage.ErrSaltNotFound)
+
+	_, err = s.UseRefreshToken(ctx, "refresh")
+	assert.ErrorIs(t, err, storage.ErrTokenRevoked)
+
+	revoked, err := s.IsTokenRevoked(ctx, "jti", user.ID, validAfter.Add(-time.Minute))
+	require.NoError(t, err)
+	assert.True(t, revoked)
+}
+
+func testAccount(t *testing.T, s Storage) {
+	ctx := context.Background()
+
+	user := newUser(t, s, "user@example.com")
+	newRecord(t, s, user.ID, "content")
+	require.NoError(t, s.SaveRefreshToken(ctx,
+		models.RefreshToken{Hash: "refresh", UserID: user.ID, FamilyID: "family", ExpiresAt: now().Add(time.Hour)}))
+
+	deletedAt := now()
+	require.NoError(t, s.DeleteUser(ctx, user.ID, deletedAt))
+
+	err := s.DeleteUser(ctx, user.ID, now())
+	assert.ErrorIs(t, err, storage.ErrUserNotFound, "the user is already deleted")
+
+	got, err := s.UserByID(ctx, user.ID)
+	require.NoError(t, err)
+	assert.True(t, got.Deleted)
+	assert.Equal(t, deletedAt, got.DeletedAt)
+
+	_, err = s.UseRefreshToken(ctx, "refresh")
+	assert.ErrorIs(t, err, storage.ErrTokenRevoked)
+
+	err = s.RestoreUser(ctx, user.ID, deletedAt.Add(time.Minute))
+	assert.ErrorIs(t, err, storage.ErrUserNotFound, "the grace period is over")
+
+	require.NoError(t, s.RestoreUser(ctx, user.ID, deletedAt.Add(-time.Minute)))
+
+	got, err = s.UserByID(ctx, user.ID)
+	require.NoError(t, err)
+	assert.False(t, got.Deleted)
+
+	err = s.RestoreUser(ctx, user.ID, deletedAt.Add(-time.Minute))
+	assert.ErrorIs(t, err, storage.ErrUserNotFound, "the user is not deleted")
+
+	require.NoError(t, s.DeleteUser(ctx, user.ID, deletedAt))
+
+	purged, err := s.PurgeDeletedUsers(ctx, deletedAt.Add(-time.Minute))
+	require.NoError(t, err)
+	assert.Zero(t, purged)
+
+	purged, err = s.PurgeDeletedUsers(ctx, deletedAt.Add(time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), purged)
+
+	_, err = s.UserByID(ctx, user.ID)
+	assert.ErrorIs(t, err, storage.ErrUserNotFound)
+
+	_, err = s.GetData(ctx, user.ID)
+	assert.ErrorIs(t, err, storage.ErrDataNotFound)
+
+	// the email of the purged user is free
+	newUser(t, s, "user@example.com")
+}
+
+func testChangePassword(t *testing.T, s Storage) {
+	ctx := context.Background()
+
+	user := newUser(t, s, "user@example.com")
+	first := newRecord(t, s, user.ID, "first")
+	second := newRecord(t, s, user.ID, "second")
+	file := newFile(t, s, user.ID, first.ID, 1)
+
+	require.NoError(t, s.SaveVaultSalt(ctx, user.ID, []byte("old salt")))
+	require.NoError(t, s.SaveRefreshToken(ctx,
+		models.RefreshToken{Hash: "refresh", UserID: user.ID, FamilyID: "family", ExpiresAt: now().Add(time.Hour)}))
+
+	rekeyed := []models.Data{
+		{ID: first.ID, Content: "first rekeyed", Files: []models.File{{ID: file.ID, RecordID: first.ID, Meta: "meta rekeyed"}}},
+		{ID: second.ID, Content: "second rekeyed"},
+	}
+
+	for name, records := range map[string][]models.Data{
+		"missing record": rekeyed[:1],
+		"missing file":   {{ID: first.ID, Content: "first rekeyed"}, rekeyed[1]},
+		"file of other record": {
+			{ID: first.ID, Content: "first rekeyed"},
+			{ID: second.ID, Content: "second rekeyed", Files: []models.File{{ID: file.ID, RecordID: second.ID}}},
+		},
+		"unknown record": {rekeyed[0], {ID: second.ID + 100, Content: "unknown"}},
+	} {
+		err := s.ChangePassword(ctx, user.ID, verifier("new"), []byte("new salt"), records, now())
+		assert.ErrorIs(t, err, storage.ErrDataChanged, name)
+	}
+
+	// nothing is changed by failed attempts
+	data, err := s.GetData(ctx, user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"first", "second"}, contents(data))
+
+	got, err := s.UserByID(ctx, user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, verifier("user@example.com"), got.SRP)
+
+	validAfter := now()
+	require.NoError(t, s.ChangePassword(ctx, user.ID, verifier("new"), []byte("new salt"), rekeyed, validAfter))
+
+	data, err = s.GetData(ctx, user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"first rekeyed", "second rekeyed"}, contents(data))
+	require.Len(t, data[0].Files, 1)
+	assert.Equal(t, "meta rekeyed", data[0].Files[0].Meta)
+
+	got, err = s.UserByID(ctx, user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, verifier("new"), got.SRP)
+
+	salt, err := s.VaultSalt(ctx, user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("new salt"), salt)
+
+	_, err = s.UseRefreshToken(ctx, "refresh")
+	assert.ErrorIs(t, err, storage.ErrTokenRevoked)
+
+	revoked, err := s.IsTokenRevoked(ctx, "jti", user.ID, validAfter.Add(-time.Minute))
+	require.NoError(t, err)
+	assert.True(t, revoked)
+}
+
+func testSRPSessions(t *testing.T, s Storage) {
+	ctx := context.Background()
+
+	user := newUser(t, s, "user@example.com")
+
+	session := models.SRPSession{
+		Hash:         "hash",
+		UserID:       user.ID,
+		ClientPublic: []byte("A"),
+		ServerSecret: "sealed b",
+		ExpiresAt:    now().Add(time.Minute),
+	}
+	require.NoError(t, s.SaveSRPSession(ctx, session))
+
+	got, err := s.UseSRPSession(ctx, session.Hash)
+	require.NoError(t, err)
+	assert.Equal(t, session, got)
+
+	_, err = s.UseSRPSession(ctx, session.Hash)
+	assert.ErrorIs(t, err, storage.ErrSessionNotFound, "the session is used once")
+
+	expired := session
+	expired.Hash = "expired"
+	expired.ExpiresAt = now().Add(-time.Minute)
+	require.NoError(t, s.SaveSRPSession(ctx, expired))
+
+	_, err = s.UseSRPSession(ctx, expired.Hash)
+	assert.ErrorIs(t, err, storage.ErrSessionNotFound)
+}